@@ -0,0 +1,531 @@
+package xedb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/seefs001/xox/xerror"
+)
+
+// ErrCorrupted is returned when a log record or snapshot fails its checksum
+var ErrCorrupted = xerror.New("corrupted record")
+
+// ErrAOFDisabled is returned by RewriteAOF when the append-only file is not enabled
+var ErrAOFDisabled = xerror.New("append-only file is disabled")
+
+// recordHeaderSize is the size of the length and CRC-32 prefix of a record
+const recordHeaderSize = 8
+
+// rewriteChunkSize is the number of commands per record written by a rewrite
+const rewriteChunkSize = 128
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTornRecord reports a record cut short by the end of the log
+var errTornRecord = xerror.New("torn record")
+
+// WithAOF enables the append-only file
+func WithAOF(enable bool) Option {
+	return func(o *Options) {
+		o.EnableAOF = enable
+	}
+}
+
+// WithAOFRewriteMinSize sets the size the append-only file must reach before it is rewritten
+func WithAOFRewriteMinSize(size int64) Option {
+	return func(o *Options) {
+		o.AOFRewriteMinSize = size
+	}
+}
+
+// WithAOFRewritePercentage sets how much the append-only file must grow, relative to
+// its size after the last rewrite, before it is rewritten again. Zero disables automatic rewrites.
+func WithAOFRewritePercentage(percentage int) Option {
+	return func(o *Options) {
+		o.AOFRewritePercentage = percentage
+	}
+}
+
+// encodeRecord gob-encodes v into a record prefixed with its length and checksum.
+// Each record is a self-contained gob stream, since appends from separate
+// encoders cannot be read by a single decoder.
+func encodeRecord(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, recordHeaderSize))
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	record := buf.Bytes()
+	payload := record[recordHeaderSize:]
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	return record, nil
+}
+
+// readRecord decodes the next record into v and returns its size. remaining bounds
+// the record size so a damaged length cannot trigger a huge allocation. It returns
+// io.EOF at a record boundary, errTornRecord if the input ends inside a record and
+// ErrCorrupted if the checksum does not match.
+func readRecord(r io.Reader, v interface{}, remaining int64) (int64, error) {
+	var header [recordHeaderSize]byte
+	if n, err := io.ReadFull(r, header[:]); err != nil {
+		if n == 0 && err == io.EOF {
+			return 0, io.EOF
+		}
+		return int64(n), errTornRecord
+	}
+
+	size := int64(binary.BigEndian.Uint32(header[0:4])) + recordHeaderSize
+	if size > remaining {
+		return remaining, errTornRecord
+	}
+
+	payload := make([]byte, size-recordHeaderSize)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return size, errTornRecord
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return size, ErrCorrupted
+	}
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(v); err != nil {
+		return size, fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	return size, nil
+}
+
+// replayLog applies the entries of a log file that are newer than the current
+// transaction counter. A torn record at the tail, left by a crash in the middle
+// of an append, is truncated away; corruption anywhere else is an error.
+// Caller must own db exclusively.
+func (db *DB) replayLog(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	r := bufio.NewReader(f)
+	applied := db.txCounter
+	var offset int64
+	for {
+		var entry WALEntry
+		n, err := readRecord(r, &entry, stat.Size()-offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Only the last record can be damaged by an interrupted append
+			if err == errTornRecord || offset+n == stat.Size() {
+				if err := f.Truncate(offset); err != nil {
+					return fmt.Errorf("failed to truncate %s: %w", path, err)
+				}
+				break
+			}
+			return fmt.Errorf("failed to read %s at offset %d: %w", path, offset, err)
+		}
+		offset += n
+
+		// Skip entries already reflected in the loaded state
+		if entry.TxID <= applied {
+			continue
+		}
+		for _, cmd := range entry.Commands {
+			if err := db.applyCommand(cmd, entry.TxID); err != nil {
+				return fmt.Errorf("failed to replay %s: %w", path, err)
+			}
+		}
+		db.txCounter = max(db.txCounter, entry.TxID)
+	}
+	return nil
+}
+
+// openAOF replays the append-only file and opens it for appending. An AOF that
+// is missing while the database holds data is rebuilt from the dataset.
+// Caller must own db exclusively.
+func (db *DB) openAOF() error {
+	if err := db.replayLog(db.aofFile); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(db.aofFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open AOF file: %w", err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat AOF file: %w", err)
+	}
+
+	db.aof = f
+	db.aofSize = stat.Size()
+	db.aofBaseSize = stat.Size()
+	if stat.Size() == 0 && db.keyspace.lex.length > 0 {
+		return db.rewriteAOF()
+	}
+	return nil
+}
+
+// appendAOF appends an encoded record to the append-only file, buffering it as
+// well while a rewrite is in progress
+func (db *DB) appendAOF(record []byte) error {
+	db.aofMutex.Lock()
+	defer db.aofMutex.Unlock()
+
+	if db.aof == nil {
+		return nil
+	}
+	if _, err := db.aof.Write(record); err != nil {
+		return fmt.Errorf("failed to append to AOF: %w", err)
+	}
+	if db.options.SyncWrite {
+		if err := db.aof.Sync(); err != nil {
+			return fmt.Errorf("failed to sync AOF file: %w", err)
+		}
+	}
+	db.aofSize += int64(len(record))
+	if db.aofRewriting {
+		db.aofRewriteBuf = append(db.aofRewriteBuf, record)
+	}
+
+	if db.aofNeedsRewrite() && atomic.CompareAndSwapInt32(&db.aofRewritePending, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&db.aofRewritePending, 0)
+			db.RewriteAOF()
+		}()
+	}
+	return nil
+}
+
+// aofNeedsRewrite reports whether the AOF has grown enough to be rewritten.
+// Caller must hold db.aofMutex.
+func (db *DB) aofNeedsRewrite() bool {
+	pct := int64(db.options.AOFRewritePercentage)
+	if pct <= 0 || db.aofRewriting || db.aofSize < db.options.AOFRewriteMinSize {
+		return false
+	}
+	return db.aofSize >= db.aofBaseSize+db.aofBaseSize*pct/100
+}
+
+// AOFSize returns the current size of the append-only file in bytes
+func (db *DB) AOFSize() int64 {
+	db.aofMutex.Lock()
+	defer db.aofMutex.Unlock()
+	return db.aofSize
+}
+
+// RewriteAOF compacts the append-only file to the minimal set of commands that
+// rebuild the current dataset. Writers are only blocked while the dataset is
+// copied and while the commands logged in the meantime are appended.
+func (db *DB) RewriteAOF() error {
+	if !db.options.EnableAOF {
+		return ErrAOFDisabled
+	}
+
+	db.rewriteMutex.Lock()
+	defer db.rewriteMutex.Unlock()
+
+	select {
+	case <-db.stopChan:
+		return ErrClosed
+	default:
+	}
+	return db.rewriteAOF()
+}
+
+// rewriteAOF performs the rewrite. Caller must hold db.rewriteMutex or own db exclusively.
+func (db *DB) rewriteAOF() error {
+	// Capture the dataset and start buffering appends at the same point in time
+	db.mutex.Lock()
+	db.aofMutex.Lock()
+	db.aofRewriting = true
+	db.aofRewriteBuf = nil
+	db.aofMutex.Unlock()
+	snap := db.capture()
+	db.mutex.Unlock()
+
+	tmpFile := db.aofFile + ".rewrite"
+	err := db.writeRewrite(tmpFile, snap)
+	snap.release()
+
+	db.aofMutex.Lock()
+	defer db.aofMutex.Unlock()
+	buffered := db.aofRewriteBuf
+	db.aofRewriting = false
+	db.aofRewriteBuf = nil
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	// Append what was logged during the rewrite and swap the files
+	f, err := os.OpenFile(tmpFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to open rewritten AOF: %w", err)
+	}
+	for _, record := range buffered {
+		if _, err = f.Write(record); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpFile, db.aofFile)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to finish AOF rewrite: %w", err)
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat AOF file: %w", err)
+	}
+	if db.aof != nil {
+		db.aof.Close()
+	}
+	db.aof = f
+	db.aofSize = stat.Size()
+	db.aofBaseSize = stat.Size()
+	return nil
+}
+
+// writeRewrite writes the commands that rebuild snap to path
+func (db *DB) writeRewrite(path string, snap *snapshot) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create AOF rewrite file: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := snap.writeRecords(w); err != nil {
+		return fmt.Errorf("failed to write AOF rewrite: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write AOF rewrite: %w", err)
+	}
+	return f.Close()
+}
+
+// snapshot is a consistent copy of the dataset. The entries of an engine that
+// keeps them on disk are not copied but read from a view of the engine.
+type snapshot struct {
+	txID    uint64
+	entries map[string]Entry
+	expires map[string]time.Time
+	indexes []IndexInfo
+	// err is set when the engine failed to read the whole dataset
+	err error
+
+	// view replaces entries for engines that hand out views; keys is the
+	// number of live keys it holds, and keys expired as of now are skipped
+	view engineView
+	keys int
+	now  time.Time
+}
+
+// capture copies the live dataset so it can be encoded without holding db.mutex.
+// The snapshot must be released once written.
+// Caller must hold db.mutex for reading or writing.
+func (db *DB) capture() *snapshot {
+	snap := &snapshot{
+		txID:    atomic.LoadUint64(&db.txCounter),
+		expires: make(map[string]time.Time, len(db.expires)),
+		indexes: db.indexInfos(),
+	}
+	now := time.Now()
+
+	if v, ok := db.engine.(viewer); ok {
+		snap.view = v.view()
+		snap.keys = db.keyspace.lex.length
+		snap.now = now
+		for key, deadline := range db.expires {
+			snap.expires[key] = deadline
+			if !deadline.After(now) {
+				snap.keys--
+			}
+		}
+		return snap
+	}
+
+	snap.entries = make(map[string]Entry, db.keyspace.lex.length)
+	snap.err = db.engine.Range(func(key string, entry Entry) bool {
+		if db.isExpired(key, now) {
+			return true
+		}
+		entry.Value = copyValue(entry.Value)
+		entry.Versions = nil
+		snap.entries[key] = entry
+		if deadline, ok := db.expires[key]; ok {
+			snap.expires[key] = deadline
+		}
+		return true
+	})
+	return snap
+}
+
+// copyValue returns a deep copy of a stored value in its persisted form
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []string:
+		return append([]string(nil), v...)
+	case map[string]string:
+		return copyHash(v)
+	case map[string]struct{}:
+		return copySet(v)
+	case *sortedSet:
+		return v.members()
+	case []ZSetMember:
+		return append([]ZSetMember(nil), v...)
+	}
+	return value
+}
+
+// copyEntry returns a deep copy of entry and its version history in their
+// persisted form. Writes modify stored values in place, so values read under
+// db.mutex are copied before they are handed to callers.
+func copyEntry(entry Entry) Entry {
+	entry.Value = copyValue(entry.Value)
+	if len(entry.Versions) > 0 {
+		versions := make([]VersionedEntry, len(entry.Versions))
+		for i, v := range entry.Versions {
+			versions[i] = copyVersion(v)
+		}
+		entry.Versions = versions
+	}
+	return entry
+}
+
+// copyVersion returns v with a deep copy of its value
+func copyVersion(v VersionedEntry) VersionedEntry {
+	v.Value = copyValue(v.Value)
+	return v
+}
+
+// len returns the number of keys in the snapshot
+func (snap *snapshot) len() int {
+	if snap.view != nil {
+		return snap.keys
+	}
+	return len(snap.entries)
+}
+
+// each calls fn for every key of the snapshot in key order, with values in
+// their persisted form
+func (snap *snapshot) each(fn func(key string, entry Entry) error) error {
+	if snap.err != nil {
+		return fmt.Errorf("failed to read dataset: %w", snap.err)
+	}
+	if snap.view == nil {
+		for _, key := range sortedKeys(snap.entries) {
+			if err := fn(key, snap.entries[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	keys := 0
+	rangeErr := snap.view.each(func(key string, entry Entry) bool {
+		if deadline, ok := snap.expires[key]; ok && !deadline.After(snap.now) {
+			return true
+		}
+		keys++
+		entry.Versions = nil
+		err = fn(key, entry)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if rangeErr != nil {
+		return fmt.Errorf("failed to read dataset: %w", rangeErr)
+	}
+	if keys != snap.keys {
+		return fmt.Errorf("%w: read %d keys of %d", ErrCorrupted, keys, snap.keys)
+	}
+	return nil
+}
+
+// release lets the engine reclaim the files a view of it kept alive
+func (snap *snapshot) release() {
+	if snap.view != nil {
+		snap.view.release()
+	}
+}
+
+// writeRecords writes one command per key that recreates the snapshot, in key
+// order, followed by one command per index, as WAL entries of up to
+// rewriteChunkSize commands
+func (snap *snapshot) writeRecords(w io.Writer) error {
+	cmds := make([]Command, 0, rewriteChunkSize)
+	flush := func() error {
+		if len(cmds) == 0 {
+			return nil
+		}
+		record, err := encodeRecord(WALEntry{TxID: snap.txID, Commands: cmds})
+		if err != nil {
+			return err
+		}
+		cmds = cmds[:0]
+		_, err = w.Write(record)
+		return err
+	}
+	add := func(cmd Command) error {
+		cmds = append(cmds, cmd)
+		if len(cmds) < rewriteChunkSize {
+			return nil
+		}
+		return flush()
+	}
+
+	err := snap.each(func(key string, entry Entry) error {
+		return add(Command{
+			Op:       opFromType(entry.Type),
+			Key:      key,
+			Value:    entry.Value,
+			Version:  snap.txID,
+			Type:     entry.Type,
+			ExpireAt: snap.expires[key],
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, idx := range snap.indexes {
+		if err := add(Command{
+			Op:      opCreateIndex,
+			Key:     idx.Name,
+			Value:   idx.KeyPrefix,
+			Version: snap.txID,
+			Field:   idx.Field,
+		}); err != nil {
+			return err
+		}
+	}
+	return flush()
+}
@@ -0,0 +1,411 @@
+package xedb_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openAOFDB(t *testing.T, dir string, opts ...xedb.Option) *xedb.DB {
+	t.Helper()
+	opts = append([]xedb.Option{
+		xedb.WithDataDir(dir),
+		xedb.WithSyncWrite(false),
+		xedb.WithAOF(true),
+		xedb.WithAOFRewritePercentage(0),
+	}, opts...)
+	db, err := xedb.New(opts...)
+	require.NoError(t, err)
+	return db
+}
+
+// crash closes db but puts back the WAL it had, as if the process had died
+// after its last write
+func crash(t *testing.T, db *xedb.DB, dir string) {
+	t.Helper()
+	walFile := filepath.Join(dir, "wal.db")
+	wal, err := os.ReadFile(walFile)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	require.NoError(t, os.WriteFile(walFile, wal, 0644))
+}
+
+// dropSnapshot removes the data file and WAL so the next open rebuilds from the AOF alone
+func dropSnapshot(t *testing.T, dir string) {
+	t.Helper()
+	require.NoError(t, os.Remove(filepath.Join(dir, "data.db")))
+	os.Remove(filepath.Join(dir, "wal.db"))
+}
+
+func TestDB_AOFRewrite(t *testing.T) {
+	dir := t.TempDir()
+	db := openAOFDB(t, dir)
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.String("counter").Set(fmt.Sprint(i)))
+	}
+	require.NoError(t, db.Hash("user").Set("name", "alice"))
+	require.NoError(t, db.ZSet("board").Add(3, "c"))
+	require.NoError(t, db.String("session").SetWithTTL("token", time.Hour))
+	require.NoError(t, db.String("gone").Set("x"))
+	_, err := db.Delete("gone")
+	require.NoError(t, err)
+
+	before := db.AOFSize()
+	require.NoError(t, db.RewriteAOF())
+	assert.Less(t, db.AOFSize(), before/4)
+
+	// Writes after the rewrite are appended to the compacted file
+	require.NoError(t, db.List("queue").Push("a", "b"))
+	require.NoError(t, db.Close())
+
+	dropSnapshot(t, dir)
+	db = openAOFDB(t, dir)
+	defer db.Close()
+
+	value, ok := db.String("counter").Get()
+	assert.True(t, ok)
+	assert.Equal(t, "49", value)
+	value, _ = db.Hash("user").Get("name")
+	assert.Equal(t, "alice", value)
+	assert.Equal(t, []xedb.ZSetMember{{Member: "c", Score: 3}}, db.ZSet("board").Range(0, -1))
+	assert.Equal(t, []string{"a", "b"}, db.List("queue").Range(0, -1))
+	ttl, ok := db.String("session").TTL()
+	assert.True(t, ok)
+	assert.Greater(t, ttl, 59*time.Minute)
+	assert.Equal(t, 0, db.Exists("gone"))
+
+	t.Run("Disabled", func(t *testing.T) {
+		db, cleanup := setupPubSubDB(t)
+		defer cleanup()
+		assert.ErrorIs(t, db.RewriteAOF(), xedb.ErrAOFDisabled)
+	})
+}
+
+func TestDB_AOFBackgroundRewrite(t *testing.T) {
+	dir := t.TempDir()
+	db := openAOFDB(t, dir,
+		xedb.WithAOFRewriteMinSize(4<<10),
+		xedb.WithAOFRewritePercentage(100),
+	)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				assert.NoError(t, db.String(fmt.Sprintf("key:%d", w)).Set(fmt.Sprint(i)))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Without rewrites the file would hold all 800 writes. Writes can outrun a
+	// rewrite that has not been scheduled yet, so keep writing until one lands.
+	require.Eventually(t, func() bool {
+		assert.NoError(t, db.String("key:0").Set("199"))
+		return db.AOFSize() < 32<<10
+	}, 2*time.Second, 5*time.Millisecond)
+	require.NoError(t, db.Close())
+
+	dropSnapshot(t, dir)
+	db = openAOFDB(t, dir)
+	defer db.Close()
+	for w := 0; w < 4; w++ {
+		value, ok := db.String(fmt.Sprintf("key:%d", w)).Get()
+		assert.True(t, ok)
+		assert.Equal(t, "199", value)
+	}
+}
+
+func TestDB_AOFTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	db := openAOFDB(t, dir)
+	require.NoError(t, db.String("a").Set("1"))
+	require.NoError(t, db.String("b").Set("2"))
+	var sizeAfterA int64
+	require.NoError(t, db.Close())
+
+	aofFile := filepath.Join(dir, "appendonly.aof")
+	stat, err := os.Stat(aofFile)
+	require.NoError(t, err)
+
+	t.Run("Torn Record", func(t *testing.T) {
+		// Simulate a crash in the middle of appending the last record
+		require.NoError(t, os.Truncate(aofFile, stat.Size()-3))
+		dropSnapshot(t, dir)
+
+		db := openAOFDB(t, dir)
+		value, ok := db.String("a").Get()
+		assert.True(t, ok)
+		assert.Equal(t, "1", value)
+		assert.Equal(t, 0, db.Exists("b"))
+		sizeAfterA = db.AOFSize()
+		assert.Less(t, sizeAfterA, stat.Size()-3)
+
+		// The log stays appendable after recovery
+		require.NoError(t, db.String("c").Set("3"))
+		require.NoError(t, db.Close())
+
+		dropSnapshot(t, dir)
+		db = openAOFDB(t, dir)
+		defer db.Close()
+		value, _ = db.String("c").Get()
+		assert.Equal(t, "3", value)
+	})
+
+	t.Run("Checksum Mismatch At Tail", func(t *testing.T) {
+		data, err := os.ReadFile(aofFile)
+		require.NoError(t, err)
+		data[len(data)-1] ^= 0xff
+		require.NoError(t, os.WriteFile(aofFile, data, 0644))
+		dropSnapshot(t, dir)
+
+		db := openAOFDB(t, dir)
+		defer db.Close()
+		assert.Equal(t, 1, db.Exists("a"))
+		assert.Equal(t, 0, db.Exists("c"))
+		assert.Equal(t, sizeAfterA, db.AOFSize())
+	})
+
+	t.Run("Corruption Before Tail", func(t *testing.T) {
+		data, err := os.ReadFile(aofFile)
+		require.NoError(t, err)
+		corrupted := append([]byte(nil), data...)
+		corrupted[len(corrupted)-1] ^= 0xff
+		require.NoError(t, os.WriteFile(aofFile, append(corrupted, data...), 0644))
+		dropSnapshot(t, dir)
+
+		_, err = xedb.New(xedb.WithDataDir(dir), xedb.WithAOF(true))
+		assert.ErrorIs(t, err, xedb.ErrCorrupted)
+	})
+}
+
+func TestDB_WALTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	db, err := xedb.New(xedb.WithDataDir(dir), xedb.WithSyncWrite(false))
+	require.NoError(t, err)
+
+	require.NoError(t, db.String("a").Set("1"))
+	snapshot, err := os.ReadFile(filepath.Join(dir, "data.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.String("b").Set("2"))
+	crash(t, db, dir)
+
+	// Restore the stale data file and tear the last WAL record
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.db"), snapshot, 0644))
+	walFile := filepath.Join(dir, "wal.db")
+	stat, err := os.Stat(walFile)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(walFile, stat.Size()-1))
+
+	db, err = xedb.New(xedb.WithDataDir(dir), xedb.WithSyncWrite(false))
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, 1, db.Exists("a"))
+	assert.Equal(t, 0, db.Exists("b"))
+}
+
+func TestDB_ZSetWALReplay(t *testing.T) {
+	dir := t.TempDir()
+	db, err := xedb.New(xedb.WithDataDir(dir), xedb.WithSyncWrite(false))
+	require.NoError(t, err)
+
+	board := db.ZSet("board")
+	require.NoError(t, board.SetWithTTL([]xedb.ZSetMember{{Member: "a", Score: 1}, {Member: "b", Score: 2}}, time.Hour))
+	walFile := filepath.Join(dir, "wal.db")
+	before, err := os.Stat(walFile)
+	require.NoError(t, err)
+
+	// Member changes log the member, not the whole set
+	require.NoError(t, board.Add(3, "c"))
+	after, err := os.Stat(walFile)
+	require.NoError(t, err)
+	assert.Less(t, after.Size()-before.Size(), before.Size())
+
+	_, err = board.ZIncrBy(5, "a")
+	require.NoError(t, err)
+	_, err = board.ZRem("b", "missing")
+	require.NoError(t, err)
+	crash(t, db, dir)
+
+	db, err = xedb.New(xedb.WithDataDir(dir), xedb.WithSyncWrite(false))
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, []xedb.ZSetMember{{Member: "c", Score: 3}, {Member: "a", Score: 6}}, db.ZSet("board").Range(0, -1))
+	ttl, ok := db.ZSet("board").TTL()
+	require.True(t, ok)
+	assert.Greater(t, ttl, 59*time.Minute)
+}
+
+func TestDB_CollectionWALReplay(t *testing.T) {
+	dir := t.TempDir()
+	db, err := xedb.New(xedb.WithDataDir(dir), xedb.WithSyncWrite(false))
+	require.NoError(t, err)
+
+	fields := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		fields[fmt.Sprint("field", i)] = "0123456789"
+	}
+	require.NoError(t, db.Hash("user").SetWithTTL(fields, time.Hour))
+	walFile := filepath.Join(dir, "wal.db")
+	before, err := os.Stat(walFile)
+	require.NoError(t, err)
+
+	// Element changes log the elements, not the whole collection
+	require.NoError(t, db.Hash("user").Set("name", "alice"))
+	after, err := os.Stat(walFile)
+	require.NoError(t, err)
+	assert.Less(t, after.Size()-before.Size(), before.Size()/10)
+
+	_, err = db.Hash("user").HIncrBy("visits", 3)
+	require.NoError(t, err)
+	_, err = db.Hash("user").HDel("field0", "missing")
+	require.NoError(t, err)
+
+	list := db.List("queue")
+	require.NoError(t, list.Push("c", "d", "x", "e"))
+	require.NoError(t, list.LPush("a", "b"))
+	_, err = list.LInsert(true, "c", "b")
+	require.NoError(t, err)
+	_, err = list.LRem(1, "b")
+	require.NoError(t, err)
+	_, ok := list.Pop()
+	require.True(t, ok)
+	require.NoError(t, list.Push("x", "f", "g"))
+	_, err = list.LRem(-1, "x")
+	require.NoError(t, err)
+	require.NoError(t, list.LTrim(0, -2))
+	value, ok := list.LPop()
+	require.True(t, ok)
+	require.Equal(t, "a", value)
+
+	require.NoError(t, db.Set("tags").Add("go", "db", "kv"))
+	_, err = db.Set("tags").SRem("db")
+	require.NoError(t, err)
+	require.NoError(t, db.Set("tags").Add("cache"))
+	crash(t, db, dir)
+
+	db, err = xedb.New(xedb.WithDataDir(dir), xedb.WithSyncWrite(false))
+	require.NoError(t, err)
+	defer db.Close()
+
+	hash := db.Hash("user").HGetAll()
+	assert.Len(t, hash, 201)
+	assert.Equal(t, "alice", hash["name"])
+	assert.Equal(t, "3", hash["visits"])
+	assert.NotContains(t, hash, "field0")
+	ttl, ok := db.Hash("user").TTL()
+	require.True(t, ok)
+	assert.Greater(t, ttl, 59*time.Minute)
+
+	assert.Equal(t, []string{"b", "c", "d", "x", "f"}, db.List("queue").Range(0, -1))
+	assert.Equal(t, []string{"cache", "go", "kv"}, db.Set("tags").SMembers())
+}
+
+func TestDB_WALCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	walFile := filepath.Join(dir, "wal.db")
+	walSize := func() int64 {
+		stat, err := os.Stat(walFile)
+		require.NoError(t, err)
+		return stat.Size()
+	}
+
+	db, err := xedb.New(xedb.WithDataDir(dir), xedb.WithSyncWrite(false), xedb.WithCompactionThreshold(0))
+	require.NoError(t, err)
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, db.String("counter").Set(fmt.Sprint(i)))
+	}
+	assert.Greater(t, walSize(), int64(100<<10))
+
+	// Save folds the WAL into the data file
+	require.NoError(t, db.Save())
+	assert.Zero(t, walSize())
+	require.NoError(t, db.String("after").Set("x"))
+	require.NoError(t, db.Close())
+	assert.Zero(t, walSize())
+
+	db, err = xedb.New(xedb.WithDataDir(dir), xedb.WithSyncWrite(false), xedb.WithCompactionThreshold(4<<10))
+	require.NoError(t, err)
+	val, ok := db.String("counter").Get()
+	assert.True(t, ok)
+	assert.Equal(t, "1999", val)
+	assert.Equal(t, 1, db.Exists("after"))
+
+	// Past the threshold the WAL is truncated while writing
+	for i := 0; i < 500; i++ {
+		require.NoError(t, db.String("counter").Set(fmt.Sprint(i)))
+	}
+	assert.Less(t, walSize(), int64(4<<10))
+	require.NoError(t, db.Close())
+}
+
+func TestDB_SnapshotRestore(t *testing.T) {
+	src, cleanup := setupPubSubDB(t)
+	defer cleanup()
+
+	require.NoError(t, src.String("name").Set("xedb"))
+	require.NoError(t, src.List("list").Push("a", "b"))
+	require.NoError(t, src.Hash("hash").Set("f", "v"))
+	require.NoError(t, src.Set("set").Add("m"))
+	require.NoError(t, src.ZSet("zset").Add(1.5, "z"))
+	require.NoError(t, src.String("ttl").SetWithTTL("v", time.Hour))
+
+	// Writers keep going while the snapshot is taken
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				src.String(fmt.Sprintf("concurrent:%d", i%10)).Set(fmt.Sprint(i))
+			}
+		}
+	}()
+	var buf bytes.Buffer
+	require.NoError(t, src.Snapshot(&buf))
+	close(stop)
+	<-done
+
+	dst, cleanupDst := setupPubSubDB(t, xedb.WithAOF(true))
+	defer cleanupDst()
+	require.NoError(t, dst.String("stale").Set("removed by restore"))
+	require.NoError(t, dst.Restore(bytes.NewReader(buf.Bytes())))
+
+	assert.Equal(t, 0, dst.Exists("stale"))
+	value, _ := dst.String("name").Get()
+	assert.Equal(t, "xedb", value)
+	assert.Equal(t, []string{"a", "b"}, dst.List("list").Range(0, -1))
+	value, _ = dst.Hash("hash").Get("f")
+	assert.Equal(t, "v", value)
+	assert.True(t, dst.Set("set").IsMember("m"))
+	assert.Equal(t, []xedb.ZSetMember{{Member: "z", Score: 1.5}}, dst.ZSet("zset").Range(0, -1))
+	ttl, ok := dst.String("ttl").TTL()
+	assert.True(t, ok)
+	assert.Greater(t, ttl, 59*time.Minute)
+
+	t.Run("Truncated", func(t *testing.T) {
+		data := buf.Bytes()
+		err := dst.Restore(bytes.NewReader(data[:len(data)-10]))
+		assert.ErrorIs(t, err, xedb.ErrCorrupted)
+		// A failed restore leaves the database untouched
+		assert.Equal(t, 1, dst.Exists("name"))
+	})
+
+	t.Run("Not A Snapshot", func(t *testing.T) {
+		assert.ErrorIs(t, dst.Restore(bytes.NewReader([]byte("garbage"))), xedb.ErrCorrupted)
+	})
+}
@@ -0,0 +1,260 @@
+// Command xedb inspects and maintains an xedb data directory
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/seefs001/xox/xcli"
+	"github.com/seefs001/xox/xedb"
+)
+
+func main() {
+	app := newApp(os.Stdin, os.Stdout)
+	if err := app.Run(context.Background(), os.Args); err != nil {
+		os.Exit(1)
+	}
+}
+
+// newApp returns the CLI application reading from in and printing to out
+func newApp(in io.Reader, out io.Writer) *xcli.App {
+	app := xcli.NewApp("xedb", "Inspect and maintain an xedb data directory", "1.0.0")
+	dir := app.Flags.String("dir", "data", "Data directory")
+	engine := app.Flags.String("engine", string(xedb.MemoryStorage), "Storage engine: memory or lsm")
+
+	// withDB opens the database for the duration of fn
+	withDB := func(fn func(db *xedb.DB) error) error {
+		db, err := xedb.New(
+			xedb.WithDataDir(*dir),
+			xedb.WithStorageEngine(xedb.StorageEngine(*engine)),
+			xedb.WithMaxMemory(0),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", *dir, err)
+		}
+		if err := fn(db); err != nil {
+			db.Close()
+			return err
+		}
+		return db.Close()
+	}
+
+	app.AddCommand(&xcli.Command{
+		Name:        "get",
+		Description: "Print the value of a key",
+		Run: func(ctx context.Context, cmd *xcli.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("usage: get <key>")
+			}
+			return withDB(func(db *xedb.DB) error {
+				return db.View(func(txn *xedb.Txn) error {
+					entry, err := txn.Get(args[0])
+					if err != nil {
+						return err
+					}
+					printValue(out, entry)
+					return nil
+				})
+			})
+		},
+	})
+
+	setFlags := flag.NewFlagSet("set", flag.ContinueOnError)
+	setFlags.SetOutput(io.Discard)
+	setType := setFlags.String("type", "string", "Value type: string or json")
+	setTTL := setFlags.Duration("ttl", 0, "Expire the key after this duration")
+	app.AddCommand(&xcli.Command{
+		Name:        "set",
+		Description: "Set a string or JSON value",
+		Flags:       setFlags,
+		Run: func(ctx context.Context, cmd *xcli.Command, args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("usage: set [-type string|json] [-ttl duration] <key> <value>")
+			}
+			key, value := args[0], args[1]
+			return withDB(func(db *xedb.DB) error {
+				switch *setType {
+				case "string":
+					if *setTTL > 0 {
+						return db.String(key).SetWithTTL(value, *setTTL)
+					}
+					return db.String(key).Set(value)
+				case "json":
+					if err := db.JSON(key).Set("", json.RawMessage(value)); err != nil {
+						return err
+					}
+					if *setTTL > 0 {
+						return db.JSON(key).Expire(*setTTL)
+					}
+					return nil
+				}
+				return fmt.Errorf("unsupported type %q", *setType)
+			})
+		},
+	})
+
+	scanFlags := flag.NewFlagSet("scan", flag.ContinueOnError)
+	scanFlags.SetOutput(io.Discard)
+	match := scanFlags.String("match", "", "Only list keys matching this glob pattern")
+	scanType := scanFlags.String("type", "", "Only list keys of this type")
+	app.AddCommand(&xcli.Command{
+		Name:        "scan",
+		Description: "List keys",
+		Flags:       scanFlags,
+		Run: func(ctx context.Context, cmd *xcli.Command, args []string) error {
+			var types []xedb.DataType
+			if *scanType != "" {
+				typ, ok := xedb.ParseDataType(*scanType)
+				if !ok {
+					return fmt.Errorf("unknown type %q", *scanType)
+				}
+				types = append(types, typ)
+			}
+			return withDB(func(db *xedb.DB) error {
+				var cursor uint64
+				for {
+					var keys []string
+					cursor, keys = db.Scan(cursor, *match, 1000, types...)
+					for _, key := range keys {
+						fmt.Fprintln(out, key)
+					}
+					if cursor == 0 {
+						return nil
+					}
+				}
+			})
+		},
+	})
+
+	dumpFlags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	dumpFlags.SetOutput(io.Discard)
+	dumpFormat := dumpFlags.String("format", string(xedb.JSONLines), "Dump format: jsonl or binary")
+	dumpOut := dumpFlags.String("o", "", "Write the dump to this file instead of stdout")
+	app.AddCommand(&xcli.Command{
+		Name:        "dump",
+		Description: "Export all keys",
+		Flags:       dumpFlags,
+		Run: func(ctx context.Context, cmd *xcli.Command, args []string) error {
+			return withDB(func(db *xedb.DB) error {
+				if *dumpOut == "" {
+					return db.Export(out, xedb.DumpFormat(*dumpFormat))
+				}
+				f, err := os.Create(*dumpOut)
+				if err != nil {
+					return err
+				}
+				if err := db.Export(f, xedb.DumpFormat(*dumpFormat)); err != nil {
+					f.Close()
+					return err
+				}
+				return f.Close()
+			})
+		},
+	})
+
+	restoreFlags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	restoreFlags.SetOutput(io.Discard)
+	restoreFormat := restoreFlags.String("format", string(xedb.JSONLines), "Dump format: jsonl or binary")
+	app.AddCommand(&xcli.Command{
+		Name:        "restore",
+		Description: "Import keys from a dump file, or stdin if none is given",
+		Flags:       restoreFlags,
+		Run: func(ctx context.Context, cmd *xcli.Command, args []string) error {
+			if len(args) > 1 {
+				return fmt.Errorf("usage: restore [-format jsonl|binary] [file]")
+			}
+			r := in
+			if len(args) == 1 {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			return withDB(func(db *xedb.DB) error {
+				return db.Import(r, xedb.DumpFormat(*restoreFormat))
+			})
+		},
+	})
+
+	app.AddCommand(&xcli.Command{
+		Name:        "stats",
+		Description: "Print key counts, memory usage and activity",
+		Run: func(ctx context.Context, cmd *xcli.Command, args []string) error {
+			return withDB(func(db *xedb.DB) error {
+				stats := db.Stats()
+				w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+				fmt.Fprintf(w, "keys\t%d\n", stats.Keys)
+				for typ := xedb.String; typ <= xedb.JSON; typ++ {
+					if n := stats.KeysByType[typ]; n > 0 {
+						fmt.Fprintf(w, "keys.%s\t%d\n", typ, n)
+					}
+				}
+				fmt.Fprintf(w, "expires\t%d\n", stats.Expires)
+				fmt.Fprintf(w, "memory\t%d\n", stats.MemoryUsage)
+				fmt.Fprintf(w, "wal\t%d\n", stats.WALSize)
+				fmt.Fprintf(w, "aof\t%d\n", stats.AOFSize)
+				return w.Flush()
+			})
+		},
+	})
+
+	app.AddCommand(&xcli.Command{
+		Name:        "compact",
+		Description: "Reclaim the space held by overwritten and deleted entries",
+		Run: func(ctx context.Context, cmd *xcli.Command, args []string) error {
+			return withDB(func(db *xedb.DB) error {
+				start := time.Now()
+				if err := db.Compact(); err != nil {
+					return err
+				}
+				fmt.Fprintf(out, "compacted in %v\n", time.Since(start).Round(time.Millisecond))
+				return nil
+			})
+		},
+	})
+
+	return app
+}
+
+// printValue prints an entry in a form that suits its type, one item per line
+func printValue(out io.Writer, entry xedb.Entry) {
+	switch v := entry.Value.(type) {
+	case []string:
+		for _, item := range v {
+			fmt.Fprintln(out, item)
+		}
+	case map[string]string:
+		fields := make([]string, 0, len(v))
+		for field := range v {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			fmt.Fprintf(out, "%s\t%s\n", field, v[field])
+		}
+	case map[string]struct{}:
+		members := make([]string, 0, len(v))
+		for member := range v {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		for _, member := range members {
+			fmt.Fprintln(out, member)
+		}
+	case []xedb.ZSetMember:
+		for _, m := range v {
+			fmt.Fprintf(out, "%s\t%g\n", m.Member, m.Score)
+		}
+	default:
+		fmt.Fprintln(out, v)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// run runs the CLI with args and returns what it printed
+func run(t *testing.T, stdin string, args ...string) string {
+	var out bytes.Buffer
+	app := newApp(strings.NewReader(stdin), &out)
+	require.NoError(t, app.Run(context.Background(), append([]string{"xedb"}, args...)))
+	return out.String()
+}
+
+func TestCLI(t *testing.T) {
+	dir := t.TempDir()
+	run(t, "", "-dir", dir, "set", "greeting", "hello")
+	run(t, "", "-dir", dir, "set", "-type", "json", "user:1", `{"name": "alice"}`)
+	run(t, "", "-dir", dir, "set", "-ttl", "1h", "session", "token")
+
+	assert.Equal(t, "hello\n", run(t, "", "-dir", dir, "get", "greeting"))
+	assert.Equal(t, `{"name":"alice"}`+"\n", run(t, "", "-dir", dir, "get", "user:1"))
+	assert.Equal(t, "user:1\n", run(t, "", "-dir", dir, "scan", "-type", "json"))
+	assert.Equal(t, "session\n", run(t, "", "-dir", dir, "scan", "-match", "sess*"))
+
+	stats := run(t, "", "-dir", dir, "stats")
+	assert.Regexp(t, `keys\s+3\n`, stats)
+	assert.Regexp(t, `keys.json\s+1\n`, stats)
+	assert.Regexp(t, `keys.string\s+2\n`, stats)
+
+	// Dump to a file and restore it into an empty directory
+	dump := filepath.Join(t.TempDir(), "dump.bin")
+	run(t, "", "-dir", dir, "dump", "-format", "binary", "-o", dump)
+	restored := t.TempDir()
+	run(t, "", "-dir", restored, "restore", "-format", "binary", dump)
+	assert.Equal(t, "hello\n", run(t, "", "-dir", restored, "get", "greeting"))
+
+	// And through stdout and stdin as JSON lines
+	lines := run(t, "", "-dir", dir, "dump")
+	assert.Contains(t, lines, `{"key":"greeting","type":"string","value":"hello"}`)
+	piped := t.TempDir()
+	run(t, lines, "-dir", piped, "restore")
+	assert.Equal(t, `{"name":"alice"}`+"\n", run(t, "", "-dir", piped, "get", "user:1"))
+
+	lsm := t.TempDir()
+	run(t, lines, "-dir", lsm, "-engine", "lsm", "restore")
+	assert.Contains(t, run(t, "", "-dir", lsm, "-engine", "lsm", "compact"), "compacted")
+	assert.Equal(t, "token\n", run(t, "", "-dir", lsm, "-engine", "lsm", "get", "session"))
+}
@@ -0,0 +1,1212 @@
+package xedb
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// lookup expires key if needed and returns its entry, checking that it holds typ.
+// Caller must hold db.mutex for writing.
+func (db *DB) lookup(key string, typ DataType) (Entry, bool, error) {
+	db.expireIfNeeded(key)
+	entry, ok := db.engine.Get(key)
+	if !ok {
+		return Entry{}, false, nil
+	}
+	if entry.Type != typ {
+		return Entry{}, false, ErrTypeMismatch
+	}
+	db.touch(key)
+	return entry, true, nil
+}
+
+// read returns the live entry for key if it holds typ.
+// Caller must hold db.mutex for reading or writing.
+func (db *DB) read(key string, typ DataType) (Entry, bool) {
+	entry, ok := db.get(key)
+	if !ok || entry.Type != typ {
+		return Entry{}, false
+	}
+	return entry, true
+}
+
+// writeValue stores value under key, recording the previous value in the
+// version history and logging the write to the WAL. The key keeps its deadline.
+// Caller must hold db.mutex for writing.
+func (db *DB) writeValue(key string, typ DataType, value interface{}) error {
+	if err := db.putEntry(key, Entry{
+		Type:    typ,
+		Value:   value,
+		Version: atomic.LoadUint64(&db.txCounter) + 1,
+	}); err != nil {
+		return err
+	}
+	return db.logValue(key, typ, value)
+}
+
+// logValue logs value as the whole new value of key, keeping its deadline.
+// Caller must hold db.mutex for writing.
+func (db *DB) logValue(key string, typ DataType, value interface{}) error {
+	return db.logCommand(Command{
+		Op:       opFromType(typ),
+		Key:      key,
+		Value:    value,
+		Type:     typ,
+		ExpireAt: db.expires[key],
+	})
+}
+
+// deleteKey removes key and logs the deletion to the WAL.
+// Caller must hold db.mutex for writing.
+func (db *DB) deleteKey(key string) error {
+	db.removeKey(key)
+	db.notifyWrite(EventDel, key)
+	return db.logCommand(Command{Op: "DEL", Key: key})
+}
+
+// logChange logs cmd, a change to some elements of the collection at its key,
+// keeping the key's deadline. Caller must hold db.mutex for writing.
+func (db *DB) logChange(cmd Command) error {
+	cmd.ExpireAt = db.expires[cmd.Key]
+	return db.logCommand(cmd)
+}
+
+// putChanged stores value, the result of replaying the logged change cmd, and
+// restores the deadline the command carries
+func (db *DB) putChanged(cmd Command, value interface{}, txID uint64) error {
+	if err := db.putEntry(cmd.Key, Entry{
+		Type:    cmd.Type,
+		Value:   value,
+		Version: txID,
+		Created: time.Now(),
+	}); err != nil {
+		return err
+	}
+	if cmd.ExpireAt.IsZero() {
+		delete(db.expires, cmd.Key)
+	} else {
+		db.expires[cmd.Key] = cmd.ExpireAt
+	}
+	return nil
+}
+
+// versionHistory returns the version history of an entry about to be replaced
+func (db *DB) versionHistory(existing Entry) []VersionedEntry {
+	versions := make([]VersionedEntry, 0, len(existing.Versions)+1)
+	versions = append(versions, VersionedEntry{
+		Value:       existing.Value,
+		Version:     existing.Version,
+		Created:     existing.Created,
+		LastUpdated: existing.LastUpdated,
+	})
+	versions = append(versions, existing.Versions...)
+
+	if db.options.MaxVersions > 0 && len(versions) > db.options.MaxVersions {
+		versions = versions[:db.options.MaxVersions]
+	}
+	return versions
+}
+
+// opFromType returns the command name used to log a whole value of type t
+func opFromType(t DataType) string {
+	switch t {
+	case List:
+		return "LIST"
+	case Hash:
+		return "HASH"
+	case Set:
+		return "SET"
+	case ZSet:
+		return "ZSET"
+	case JSON:
+		return "JSON"
+	default:
+		return "STRING"
+	}
+}
+
+// normalizeRange converts inclusive, possibly negative indices into slice bounds
+func normalizeRange(start, stop, length int) (int, int, bool) {
+	if start < 0 {
+		start = max(length+start, 0)
+	}
+	if stop < 0 {
+		stop = length + stop
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start >= length || stop < 0 || start > stop {
+		return 0, 0, false
+	}
+	return start, stop + 1, true
+}
+
+// String operations
+
+// Incr increments the integer value of the key by one
+func (op *StringOp) Incr() (int64, error) {
+	return op.IncrBy(1)
+}
+
+// IncrBy increments the integer value of the key by delta, treating a missing key as 0
+func (op *StringOp) IncrBy(delta int64) (int64, error) {
+	defer op.db.observe("String.IncrBy", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	entry, exists, err := op.db.lookup(op.key, String)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	if exists {
+		n, err = strconv.ParseInt(entry.Value.(string), 10, 64)
+		if err != nil {
+			return 0, ErrInvalidValue
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrInvalidValue
+	}
+	n += delta
+
+	value := strconv.FormatInt(n, 10)
+	if err := op.db.checkMemoryLimit(int64(len(value))); err != nil {
+		return 0, err
+	}
+	return n, op.db.writeValue(op.key, String, value)
+}
+
+// Append appends value to the string and returns its new length
+func (op *StringOp) Append(value string) (int, error) {
+	defer op.db.observe("String.Append", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	entry, exists, err := op.db.lookup(op.key, String)
+	if err != nil {
+		return 0, err
+	}
+	if err := op.db.checkMemoryLimit(int64(len(value))); err != nil {
+		return 0, err
+	}
+
+	current := ""
+	if exists {
+		current = entry.Value.(string)
+	}
+	result := current + value
+	return len(result), op.db.writeValue(op.key, String, result)
+}
+
+// GetSet sets a new value and returns the old one. Like Set, it clears any TTL.
+func (op *StringOp) GetSet(value string) (string, bool, error) {
+	defer op.db.observe("String.GetSet", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	entry, exists, err := op.db.lookup(op.key, String)
+	if err != nil {
+		return "", false, err
+	}
+	if err := op.db.checkMemoryLimit(int64(len(value))); err != nil {
+		return "", false, err
+	}
+
+	old := ""
+	if exists {
+		old = entry.Value.(string)
+	}
+	delete(op.db.expires, op.key)
+	return old, exists, op.db.writeValue(op.key, String, value)
+}
+
+// SetNX sets the value only if the key does not exist and reports whether it was set
+func (op *StringOp) SetNX(value string) (bool, error) {
+	defer op.db.observe("String.SetNX", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	op.db.expireIfNeeded(op.key)
+	if _, exists := op.db.engine.Get(op.key); exists {
+		return false, nil
+	}
+	if err := op.db.checkMemoryLimit(int64(len(value))); err != nil {
+		return false, err
+	}
+	return true, op.db.writeValue(op.key, String, value)
+}
+
+// List operations
+
+// Logged list changes, so a write logs the elements it touched rather than the
+// whole list. LPUSH prepends and RPUSH appends the values in the value, LPOP
+// and RPOP remove one element, LREM removes up to the count in the value of
+// the element in the field, LTRIM keeps the slice bounds in the value and
+// LINSERT inserts the field at the index in the value.
+const (
+	opLPush   = "LPUSH"
+	opRPush   = "RPUSH"
+	opLPop    = "LPOP"
+	opRPop    = "RPOP"
+	opLRem    = "LREM"
+	opLTrim   = "LTRIM"
+	opLInsert = "LINSERT"
+)
+
+// applyListCommand applies a logged list change. A push to a key of another
+// type replaces it with a new list, as ListOp.Push does; the other changes
+// leave such keys alone.
+func (db *DB) applyListCommand(cmd Command, txID uint64) error {
+	entry, ok := db.engine.Get(cmd.Key)
+	var list []string
+	if ok && entry.Type == List {
+		list = entry.Value.([]string)
+	} else if cmd.Op != opLPush && cmd.Op != opRPush {
+		return nil
+	}
+
+	switch cmd.Op {
+	case opLPush:
+		values, _ := cmd.Value.([]string)
+		list = prependValues(list, values)
+	case opRPush:
+		values, _ := cmd.Value.([]string)
+		list = append(list[:len(list):len(list)], values...)
+	case opLPop, opRPop:
+		if len(list) == 0 {
+			return nil
+		}
+		if cmd.Op == opLPop {
+			list = list[1:]
+		} else {
+			list = list[:len(list)-1]
+		}
+	case opLRem:
+		count, _ := cmd.Value.(int)
+		list, _ = removeElements(list, count, cmd.Field)
+	case opLTrim:
+		bounds, _ := cmd.Value.([]int)
+		if len(bounds) != 2 || bounds[0] < 0 || bounds[0] > bounds[1] || bounds[1] > len(list) {
+			return ErrCorrupted
+		}
+		list = append([]string(nil), list[bounds[0]:bounds[1]]...)
+	case opLInsert:
+		pos, _ := cmd.Value.(int)
+		if pos < 0 || pos > len(list) {
+			return ErrCorrupted
+		}
+		list = insertElement(list, pos, cmd.Field)
+	}
+	return db.putChanged(cmd, list, txID)
+}
+
+// prependValues returns a new list holding values followed by list
+func prependValues(list, values []string) []string {
+	result := make([]string, len(values)+len(list))
+	copy(result, values)
+	copy(result[len(values):], list)
+	return result
+}
+
+// insertElement returns a new list with value inserted at pos
+func insertElement(list []string, pos int, value string) []string {
+	result := make([]string, 0, len(list)+1)
+	result = append(result, list[:pos]...)
+	result = append(result, value)
+	return append(result, list[pos:]...)
+}
+
+// removeElements returns a new list without up to count occurrences of value
+// and how many it removed. count > 0 removes from head to tail, count < 0 from
+// tail to head, and 0 removes all.
+func removeElements(list []string, count int, value string) ([]string, int) {
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+
+	keep := make([]bool, len(list))
+	removed := 0
+	for i := range list {
+		// Walk from the tail when count is negative
+		idx := i
+		if count < 0 {
+			idx = len(list) - 1 - i
+		}
+		if list[idx] == value && (limit == 0 || removed < limit) {
+			removed++
+			continue
+		}
+		keep[idx] = true
+	}
+	if removed == 0 {
+		return list, 0
+	}
+
+	result := make([]string, 0, len(list)-removed)
+	for i, v := range list {
+		if keep[i] {
+			result = append(result, v)
+		}
+	}
+	return result, removed
+}
+
+// LRem removes occurrences of value and returns how many were removed.
+// count > 0 removes from head to tail, count < 0 from tail to head, and 0 removes all.
+func (op *ListOp) LRem(count int, value string) (int, error) {
+	defer op.db.observe("List.LRem", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	entry, exists, err := op.db.lookup(op.key, List)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	result, removed := removeElements(entry.Value.([]string), count, value)
+	if removed == 0 {
+		return 0, nil
+	}
+	if len(result) == 0 {
+		return removed, op.db.deleteKey(op.key)
+	}
+	if err := op.db.putEntry(op.key, Entry{
+		Type:    List,
+		Value:   result,
+		Version: atomic.LoadUint64(&op.db.txCounter) + 1,
+	}); err != nil {
+		return 0, err
+	}
+	return removed, op.db.logChange(Command{Op: opLRem, Key: op.key, Type: List, Field: value, Value: count})
+}
+
+// LTrim trims the list to the elements between start and stop, inclusive
+func (op *ListOp) LTrim(start, stop int) error {
+	defer op.db.observe("List.LTrim", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	entry, exists, err := op.db.lookup(op.key, List)
+	if err != nil || !exists {
+		return err
+	}
+
+	list := entry.Value.([]string)
+	lo, hi, ok := normalizeRange(start, stop, len(list))
+	if !ok {
+		return op.db.deleteKey(op.key)
+	}
+	if lo == 0 && hi == len(list) {
+		return nil
+	}
+
+	result := make([]string, hi-lo)
+	copy(result, list[lo:hi])
+	if err := op.db.putEntry(op.key, Entry{
+		Type:    List,
+		Value:   result,
+		Version: atomic.LoadUint64(&op.db.txCounter) + 1,
+	}); err != nil {
+		return err
+	}
+	return op.db.logChange(Command{Op: opLTrim, Key: op.key, Type: List, Value: []int{lo, hi}})
+}
+
+// LIndex returns the element at index; negative indices count from the tail
+func (op *ListOp) LIndex(index int) (string, bool) {
+	defer op.db.observe("List.LIndex", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.read(op.key, List)
+	if !ok {
+		return "", false
+	}
+
+	list := entry.Value.([]string)
+	if index < 0 {
+		index += len(list)
+	}
+	if index < 0 || index >= len(list) {
+		return "", false
+	}
+	return list[index], true
+}
+
+// LInsert inserts value before or after the first occurrence of pivot.
+// It returns the new length, -1 if pivot was not found, or 0 if the key does not exist.
+func (op *ListOp) LInsert(before bool, pivot, value string) (int, error) {
+	defer op.db.observe("List.LInsert", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	entry, exists, err := op.db.lookup(op.key, List)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	list := entry.Value.([]string)
+	pos := -1
+	for i, v := range list {
+		if v == pivot {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		return -1, nil
+	}
+	if !before {
+		pos++
+	}
+	if err := op.db.checkMemoryLimit(stringsSize([]string{value})); err != nil {
+		return 0, err
+	}
+
+	result := insertElement(list, pos, value)
+	if err := op.db.putEntry(op.key, Entry{
+		Type:    List,
+		Value:   result,
+		Version: atomic.LoadUint64(&op.db.txCounter) + 1,
+	}); err != nil {
+		return 0, err
+	}
+	return len(result), op.db.logChange(Command{Op: opLInsert, Key: op.key, Type: List, Field: value, Value: pos})
+}
+
+// BLPop removes and returns the first element of the list, waiting up to
+// timeout for one to be pushed. A non-positive timeout waits indefinitely.
+// It returns false if the timeout expires.
+func (op *ListOp) BLPop(timeout time.Duration) (string, bool, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	_, value, err := op.db.BLPop(ctx, op.key)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// BLPop removes and returns the first element of the first non-empty list
+// among keys, waiting until an element is pushed, ctx is done, or the
+// database is closed. It returns the key the element was popped from.
+func (db *DB) BLPop(ctx context.Context, keys ...string) (string, string, error) {
+	for {
+		db.lock()
+		for _, key := range keys {
+			value, ok, err := db.lpop(key)
+			if err != nil || ok {
+				db.mutex.Unlock()
+				return key, value, err
+			}
+		}
+
+		wake := make(chan struct{}, 1)
+		for _, key := range keys {
+			db.listWaiters[key] = append(db.listWaiters[key], wake)
+		}
+		db.mutex.Unlock()
+
+		var err error
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-db.stopChan:
+			err = ErrClosed
+		}
+
+		db.mutex.Lock()
+		db.removeListWaiter(keys, wake)
+		db.mutex.Unlock()
+		if err != nil {
+			return "", "", err
+		}
+	}
+}
+
+// lpop pops the head of the list at key through the WAL.
+// Caller must hold db.mutex for writing.
+func (db *DB) lpop(key string) (string, bool, error) {
+	entry, exists, err := db.lookup(key, List)
+	if err != nil || !exists {
+		return "", false, err
+	}
+
+	list := entry.Value.([]string)
+	if len(list) == 0 {
+		return "", false, nil
+	}
+	if len(list) == 1 {
+		return list[0], true, db.deleteKey(key)
+	}
+
+	if err := db.putEntry(key, Entry{
+		Type:    List,
+		Value:   list[1:],
+		Version: atomic.LoadUint64(&db.txCounter) + 1,
+	}); err != nil {
+		return "", false, err
+	}
+	return list[0], true, db.logChange(Command{Op: opLPop, Key: key, Type: List})
+}
+
+// wakeListWaiters signals clients blocked on key.
+// Caller must hold db.mutex for writing.
+func (db *DB) wakeListWaiters(key string) {
+	for _, wake := range db.listWaiters[key] {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	delete(db.listWaiters, key)
+}
+
+// removeListWaiter unregisters wake from keys.
+// Caller must hold db.mutex for writing.
+func (db *DB) removeListWaiter(keys []string, wake chan struct{}) {
+	for _, key := range keys {
+		waiters := db.listWaiters[key]
+		for i, w := range waiters {
+			if w == wake {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(db.listWaiters, key)
+		} else {
+			db.listWaiters[key] = waiters
+		}
+	}
+}
+
+// Hash operations
+
+// copyHash returns a copy of a stored hash so writes never alias older versions
+func copyHash(hash map[string]string) map[string]string {
+	result := make(map[string]string, len(hash))
+	for k, v := range hash {
+		result[k] = v
+	}
+	return result
+}
+
+// hashForWrite returns the hash held by entry, ready to be modified in place.
+// With KeepHistory set the hash is copied so the version history keeps the old fields.
+func (db *DB) hashForWrite(entry Entry, exists bool) map[string]string {
+	if !exists {
+		return make(map[string]string)
+	}
+	hash := entry.Value.(map[string]string)
+	if db.options.KeepHistory {
+		return copyHash(hash)
+	}
+	return hash
+}
+
+// Logged hash changes, so a write logs the fields it touched rather than the
+// whole hash. HSET sets the field to the value and HDEL removes the fields in
+// the value.
+const (
+	opHSet = "HSET"
+	opHDel = "HDEL"
+)
+
+// hset sets field of the hash at key to value and logs the change. entry and
+// exists are the result of looking up key; a key of another type is replaced.
+// Caller must hold db.mutex for writing.
+func (db *DB) hset(key string, entry Entry, exists bool, field, value string) error {
+	hash := db.hashForWrite(entry, exists)
+	hash[field] = value
+	if err := db.putEntry(key, Entry{
+		Type:    Hash,
+		Value:   hash,
+		Version: atomic.LoadUint64(&db.txCounter) + 1,
+	}); err != nil {
+		return err
+	}
+	return db.logChange(Command{Op: opHSet, Key: key, Type: Hash, Field: field, Value: value})
+}
+
+// applyHashCommand applies a logged HSET or HDEL. An HSET to a key of another
+// type replaces it with a new hash, as HashOp.Set does.
+func (db *DB) applyHashCommand(cmd Command, txID uint64) error {
+	entry, ok := db.engine.Get(cmd.Key)
+	exists := ok && entry.Type == Hash
+	if !exists && cmd.Op == opHDel {
+		return nil
+	}
+
+	hash := db.hashForWrite(entry, exists)
+	if cmd.Op == opHSet {
+		value, _ := cmd.Value.(string)
+		hash[cmd.Field] = value
+	} else {
+		fields, _ := cmd.Value.([]string)
+		for _, field := range fields {
+			delete(hash, field)
+		}
+		if len(hash) == 0 {
+			db.removeKey(cmd.Key)
+			db.notifyWrite(EventDel, cmd.Key)
+			return nil
+		}
+	}
+	return db.putChanged(cmd, hash, txID)
+}
+
+// HDel removes fields from the hash and returns how many existed
+func (op *HashOp) HDel(fields ...string) (int, error) {
+	defer op.db.observe("Hash.HDel", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	entry, exists, err := op.db.lookup(op.key, Hash)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	hash := op.db.hashForWrite(entry, exists)
+	removed := 0
+	for _, field := range fields {
+		if _, ok := hash[field]; ok {
+			delete(hash, field)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if len(hash) == 0 {
+		return removed, op.db.deleteKey(op.key)
+	}
+	if err := op.db.putEntry(op.key, Entry{
+		Type:    Hash,
+		Value:   hash,
+		Version: atomic.LoadUint64(&op.db.txCounter) + 1,
+	}); err != nil {
+		return 0, err
+	}
+	return removed, op.db.logChange(Command{Op: opHDel, Key: op.key, Type: Hash, Value: fields})
+}
+
+// HGetAll returns a copy of all fields and values in the hash
+func (op *HashOp) HGetAll() map[string]string {
+	defer op.db.observe("Hash.HGetAll", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.read(op.key, Hash)
+	if !ok {
+		return map[string]string{}
+	}
+	return copyHash(entry.Value.(map[string]string))
+}
+
+// HIncrBy increments the integer value of field by delta, treating a missing field as 0
+func (op *HashOp) HIncrBy(field string, delta int64) (int64, error) {
+	defer op.db.observe("Hash.HIncrBy", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	entry, exists, err := op.db.lookup(op.key, Hash)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	if exists {
+		if current, ok := entry.Value.(map[string]string)[field]; ok {
+			n, err = strconv.ParseInt(current, 10, 64)
+			if err != nil {
+				return 0, ErrInvalidValue
+			}
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrInvalidValue
+	}
+	n += delta
+
+	value := strconv.FormatInt(n, 10)
+	if err := op.db.checkMemoryLimit(int64(len(field) + len(value))); err != nil {
+		return 0, err
+	}
+	return n, op.db.hset(op.key, entry, exists, field, value)
+}
+
+// HKeys returns the sorted field names of the hash
+func (op *HashOp) HKeys() []string {
+	defer op.db.observe("Hash.HKeys", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.read(op.key, Hash)
+	if !ok {
+		return []string{}
+	}
+	return sortedKeys(entry.Value.(map[string]string))
+}
+
+// HLen returns the number of fields in the hash
+func (op *HashOp) HLen() int {
+	defer op.db.observe("Hash.HLen", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.read(op.key, Hash)
+	if !ok {
+		return 0
+	}
+	return len(entry.Value.(map[string]string))
+}
+
+// HScan iterates over the fields of the hash in sorted order. It returns up to
+// count fields starting at cursor, filtered by a glob-style match pattern, and
+// the cursor for the next call, which is 0 once iteration is complete.
+func (op *HashOp) HScan(cursor uint64, match string, count int) (uint64, map[string]string) {
+	defer op.db.observe("Hash.HScan", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	result := make(map[string]string)
+	entry, ok := op.db.read(op.key, Hash)
+	if !ok {
+		return 0, result
+	}
+	if count <= 0 {
+		count = 10
+	}
+
+	hash := entry.Value.(map[string]string)
+	fields := sortedKeys(hash)
+	if cursor >= uint64(len(fields)) {
+		return 0, result
+	}
+
+	end := cursor + uint64(count)
+	next := end
+	if end >= uint64(len(fields)) {
+		end, next = uint64(len(fields)), 0
+	}
+	for _, field := range fields[cursor:end] {
+		if match == "" || MatchPattern(match, field) {
+			result[field] = hash[field]
+		}
+	}
+	return next, result
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Set operations
+
+// SRem removes members from the set and returns how many existed
+func (op *SetOp) SRem(members ...string) (int, error) {
+	defer op.db.observe("Set.SRem", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	entry, exists, err := op.db.lookup(op.key, Set)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	return op.db.srem(op.key, entry, members...)
+}
+
+// SMembers returns the sorted members of the set
+func (op *SetOp) SMembers() []string {
+	defer op.db.observe("Set.SMembers", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.read(op.key, Set)
+	if !ok {
+		return []string{}
+	}
+	return sortedKeys(entry.Value.(map[string]struct{}))
+}
+
+// SInter returns the sorted members present in this set and every set at keys
+func (op *SetOp) SInter(keys ...string) ([]string, error) {
+	defer op.db.observe("Set.SInter", op.key, time.Now())
+
+	return op.combine(keys, func(result, other map[string]struct{}) {
+		for member := range result {
+			if _, ok := other[member]; !ok {
+				delete(result, member)
+			}
+		}
+	})
+}
+
+// SUnion returns the sorted members present in this set or any set at keys
+func (op *SetOp) SUnion(keys ...string) ([]string, error) {
+	defer op.db.observe("Set.SUnion", op.key, time.Now())
+
+	return op.combine(keys, func(result, other map[string]struct{}) {
+		for member := range other {
+			result[member] = struct{}{}
+		}
+	})
+}
+
+// SDiff returns the sorted members of this set that are not in any set at keys
+func (op *SetOp) SDiff(keys ...string) ([]string, error) {
+	defer op.db.observe("Set.SDiff", op.key, time.Now())
+
+	return op.combine(keys, func(result, other map[string]struct{}) {
+		for member := range other {
+			delete(result, member)
+		}
+	})
+}
+
+// combine folds the sets at keys into this set with fn. Missing keys are empty sets.
+func (op *SetOp) combine(keys []string, fn func(result, other map[string]struct{})) ([]string, error) {
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	load := func(key string) (map[string]struct{}, error) {
+		entry, ok := op.db.get(key)
+		if !ok {
+			return map[string]struct{}{}, nil
+		}
+		if entry.Type != Set {
+			return nil, ErrTypeMismatch
+		}
+		return entry.Value.(map[string]struct{}), nil
+	}
+
+	first, err := load(op.key)
+	if err != nil {
+		return nil, err
+	}
+	result := copySet(first)
+	for _, key := range keys {
+		other, err := load(key)
+		if err != nil {
+			return nil, err
+		}
+		fn(result, other)
+	}
+	return sortedKeys(result), nil
+}
+
+// SPop removes and returns a random member of the set
+func (op *SetOp) SPop() (string, bool, error) {
+	defer op.db.observe("Set.SPop", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	entry, exists, err := op.db.lookup(op.key, Set)
+	if err != nil || !exists {
+		return "", false, err
+	}
+
+	set := entry.Value.(map[string]struct{})
+	if len(set) == 0 {
+		return "", false, nil
+	}
+	member := randomMembers(set, 1)[0]
+	_, err = op.db.srem(op.key, entry, member)
+	return member, true, err
+}
+
+// SRandMember returns random members without removing them. A positive count
+// returns up to count distinct members; a negative count returns exactly
+// -count members that may repeat.
+func (op *SetOp) SRandMember(count int) []string {
+	defer op.db.observe("Set.SRandMember", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.read(op.key, Set)
+	if !ok || count == 0 {
+		return []string{}
+	}
+	set := entry.Value.(map[string]struct{})
+	if len(set) == 0 {
+		return []string{}
+	}
+
+	if count > 0 {
+		return randomMembers(set, count)
+	}
+
+	members := sortedKeys(set)
+	result := make([]string, -count)
+	for i := range result {
+		result[i] = members[rand.Intn(len(members))]
+	}
+	return result
+}
+
+func copySet(set map[string]struct{}) map[string]struct{} {
+	result := make(map[string]struct{}, len(set))
+	for member := range set {
+		result[member] = struct{}{}
+	}
+	return result
+}
+
+// setForWrite returns the set held by entry, ready to be modified in place.
+// With KeepHistory set the set is copied so the version history keeps the old members.
+func (db *DB) setForWrite(entry Entry, exists bool) map[string]struct{} {
+	if !exists {
+		return make(map[string]struct{})
+	}
+	set := entry.Value.(map[string]struct{})
+	if db.options.KeepHistory {
+		return copySet(set)
+	}
+	return set
+}
+
+// Logged set changes, so a write logs the members it touched rather than the
+// whole set. SADD adds and SREM removes the members in the value.
+const (
+	opSAdd = "SADD"
+	opSRem = "SREM"
+)
+
+// applySetCommand applies a logged SADD or SREM. An SADD to a key of another
+// type replaces it with a new set, as SetOp.Add does.
+func (db *DB) applySetCommand(cmd Command, txID uint64) error {
+	entry, ok := db.engine.Get(cmd.Key)
+	exists := ok && entry.Type == Set
+	if !exists && cmd.Op == opSRem {
+		return nil
+	}
+
+	set := db.setForWrite(entry, exists)
+	members, _ := cmd.Value.([]string)
+	for _, member := range members {
+		if cmd.Op == opSAdd {
+			set[member] = struct{}{}
+		} else {
+			delete(set, member)
+		}
+	}
+	if len(set) == 0 {
+		db.removeKey(cmd.Key)
+		db.notifyWrite(EventDel, cmd.Key)
+		return nil
+	}
+	return db.putChanged(cmd, set, txID)
+}
+
+// srem removes members from the set at key, deleting the key once it is empty,
+// and logs the change. Caller must hold db.mutex for writing.
+func (db *DB) srem(key string, entry Entry, members ...string) (int, error) {
+	set := db.setForWrite(entry, true)
+	removed := 0
+	for _, member := range members {
+		if _, ok := set[member]; ok {
+			delete(set, member)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if len(set) == 0 {
+		return removed, db.deleteKey(key)
+	}
+	if err := db.putEntry(key, Entry{
+		Type:    Set,
+		Value:   set,
+		Version: atomic.LoadUint64(&db.txCounter) + 1,
+	}); err != nil {
+		return 0, err
+	}
+	return removed, db.logChange(Command{Op: opSRem, Key: key, Type: Set, Value: members})
+}
+
+// randomMembers returns up to n distinct members chosen at random
+func randomMembers(set map[string]struct{}, n int) []string {
+	members := sortedKeys(set)
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	if n < len(members) {
+		members = members[:n]
+	}
+	return members
+}
+
+// Sorted set operations
+
+// ZRem removes members from the sorted set and returns how many existed
+func (op *ZSetOp) ZRem(members ...string) (int, error) {
+	defer op.db.observe("ZSet.ZRem", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	entry, exists, err := op.db.lookup(op.key, ZSet)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	zset := op.db.zsetForWrite(entry, exists)
+	removed := 0
+	for _, member := range members {
+		if zset.remove(member) {
+			removed++
+		}
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+	if zset.len() == 0 {
+		return removed, op.db.deleteKey(op.key)
+	}
+	if err := op.db.putEntry(op.key, Entry{
+		Type:    ZSet,
+		Value:   zset,
+		Version: atomic.LoadUint64(&op.db.txCounter) + 1,
+	}); err != nil {
+		return 0, err
+	}
+	return removed, op.db.logChange(Command{Op: opZRem, Key: op.key, Type: ZSet, Value: members})
+}
+
+// ZScore returns the score of member
+func (op *ZSetOp) ZScore(member string) (float64, bool) {
+	defer op.db.observe("ZSet.ZScore", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.read(op.key, ZSet)
+	if !ok {
+		return 0, false
+	}
+	return entry.Value.(*sortedSet).score(member)
+}
+
+// ZRank returns the 0-based rank of member, ordered by ascending score
+func (op *ZSetOp) ZRank(member string) (int, bool) {
+	defer op.db.observe("ZSet.ZRank", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.read(op.key, ZSet)
+	if !ok {
+		return 0, false
+	}
+	return entry.Value.(*sortedSet).rank(member)
+}
+
+// ZRangeByScore returns members with min <= score <= max, in ascending order
+func (op *ZSetOp) ZRangeByScore(min, max float64) []ZSetMember {
+	defer op.db.observe("ZSet.ZRangeByScore", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.read(op.key, ZSet)
+	if !ok {
+		return nil
+	}
+	return entry.Value.(*sortedSet).rangeByScore(min, max)
+}
+
+// ZIncrBy increments the score of member by delta, adding it with score delta if missing
+func (op *ZSetOp) ZIncrBy(delta float64, member string) (float64, error) {
+	defer op.db.observe("ZSet.ZIncrBy", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	entry, exists, err := op.db.lookup(op.key, ZSet)
+	if err != nil {
+		return 0, err
+	}
+
+	score := delta
+	current, found := 0.0, false
+	if exists {
+		current, found = entry.Value.(*sortedSet).score(member)
+	}
+	if found {
+		score = current + delta
+	}
+	if math.IsNaN(score) {
+		return 0, ErrInvalidValue
+	}
+	if !found {
+		if err := op.db.checkMemoryLimit(int64(len(member)) + 8); err != nil {
+			return 0, err
+		}
+	}
+
+	zset := op.db.zsetForWrite(entry, exists)
+	zset.add(member, score)
+	if err := op.db.putEntry(op.key, Entry{
+		Type:    ZSet,
+		Value:   zset,
+		Version: atomic.LoadUint64(&op.db.txCounter) + 1,
+	}); err != nil {
+		return 0, err
+	}
+	return score, op.db.logZAdd(op.key, member, score)
+}
+
+// ZRevRange returns members from start to stop, inclusive, ordered by descending score
+func (op *ZSetOp) ZRevRange(start, stop int) []ZSetMember {
+	defer op.db.observe("ZSet.ZRevRange", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.read(op.key, ZSet)
+	if !ok {
+		return nil
+	}
+
+	zset := entry.Value.(*sortedSet)
+	lo, hi, ok := normalizeRange(start, stop, zset.len())
+	if !ok {
+		return nil
+	}
+	return zset.rangeByRank(lo, hi, true)
+}
+
+// ZCount returns the number of members with min <= score <= max
+func (op *ZSetOp) ZCount(min, max float64) int {
+	defer op.db.observe("ZSet.ZCount", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.read(op.key, ZSet)
+	if !ok {
+		return 0
+	}
+	return entry.Value.(*sortedSet).count(min, max)
+}
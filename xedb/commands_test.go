@@ -0,0 +1,365 @@
+package xedb_test
+
+import (
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringOp_Commands(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	t.Run("Incr", func(t *testing.T) {
+		n, err := db.String("counter").Incr()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+
+		n, err = db.String("counter").IncrBy(41)
+		require.NoError(t, err)
+		assert.Equal(t, int64(42), n)
+
+		n, err = db.String("counter").IncrBy(-50)
+		require.NoError(t, err)
+		assert.Equal(t, int64(-8), n)
+
+		require.NoError(t, db.String("text").Set("abc"))
+		_, err = db.String("text").Incr()
+		assert.ErrorIs(t, err, xedb.ErrInvalidValue)
+
+		require.NoError(t, db.List("list").Push("a"))
+		_, err = db.String("list").Incr()
+		assert.ErrorIs(t, err, xedb.ErrTypeMismatch)
+	})
+
+	t.Run("Append", func(t *testing.T) {
+		n, err := db.String("greeting").Append("hello")
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+
+		n, err = db.String("greeting").Append(" world")
+		require.NoError(t, err)
+		assert.Equal(t, 11, n)
+
+		val, _ := db.String("greeting").Get()
+		assert.Equal(t, "hello world", val)
+	})
+
+	t.Run("GetSet", func(t *testing.T) {
+		old, existed, err := db.String("gs").GetSet("first")
+		require.NoError(t, err)
+		assert.False(t, existed)
+		assert.Empty(t, old)
+
+		require.NoError(t, db.String("gs").Expire(time.Hour))
+		old, existed, err = db.String("gs").GetSet("second")
+		require.NoError(t, err)
+		assert.True(t, existed)
+		assert.Equal(t, "first", old)
+
+		ttl, _ := db.String("gs").TTL()
+		assert.Equal(t, xedb.NoExpiration, ttl)
+	})
+
+	t.Run("SetNX", func(t *testing.T) {
+		set, err := db.String("lock").SetNX("owner1")
+		require.NoError(t, err)
+		assert.True(t, set)
+
+		set, err = db.String("lock").SetNX("owner2")
+		require.NoError(t, err)
+		assert.False(t, set)
+
+		val, _ := db.String("lock").Get()
+		assert.Equal(t, "owner1", val)
+	})
+
+	t.Run("Versioning", func(t *testing.T) {
+		db, cleanup := setupPubSubDB(t, xedb.WithHistory(true))
+		defer cleanup()
+
+		_, err := db.String("versioned").Incr()
+		require.NoError(t, err)
+		_, err = db.String("versioned").Incr()
+		require.NoError(t, err)
+
+		versions := db.String("versioned").ListVersions()
+		require.Len(t, versions, 2)
+		val, ok := db.String("versioned").GetVersion(versions[1])
+		assert.True(t, ok)
+		assert.Equal(t, "1", val)
+	})
+}
+
+func TestListOp_Commands(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	t.Run("LRem", func(t *testing.T) {
+		require.NoError(t, db.List("rem").Push("a", "b", "a", "c", "a"))
+
+		n, err := db.List("rem").LRem(-2, "a")
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, []string{"a", "b", "c"}, db.List("rem").Range(0, -1))
+
+		n, err = db.List("rem").LRem(0, "missing")
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+
+		n, err = db.List("rem").LRem(0, "a")
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, []string{"b", "c"}, db.List("rem").Range(0, -1))
+	})
+
+	t.Run("LTrim", func(t *testing.T) {
+		require.NoError(t, db.List("trim").Push("a", "b", "c", "d", "e"))
+		require.NoError(t, db.List("trim").LTrim(1, -2))
+		assert.Equal(t, []string{"b", "c", "d"}, db.List("trim").Range(0, -1))
+
+		// Trimming everything deletes the key
+		require.NoError(t, db.List("trim").LTrim(5, 10))
+		assert.Equal(t, 0, db.Exists("trim"))
+	})
+
+	t.Run("LIndex And LInsert", func(t *testing.T) {
+		require.NoError(t, db.List("ins").Push("a", "c"))
+
+		n, err := db.List("ins").LInsert(true, "c", "b")
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+
+		n, err = db.List("ins").LInsert(false, "c", "d")
+		require.NoError(t, err)
+		assert.Equal(t, 4, n)
+
+		n, err = db.List("ins").LInsert(false, "missing", "x")
+		require.NoError(t, err)
+		assert.Equal(t, -1, n)
+
+		val, ok := db.List("ins").LIndex(1)
+		assert.True(t, ok)
+		assert.Equal(t, "b", val)
+		val, ok = db.List("ins").LIndex(-1)
+		assert.True(t, ok)
+		assert.Equal(t, "d", val)
+		_, ok = db.List("ins").LIndex(10)
+		assert.False(t, ok)
+	})
+
+	t.Run("BLPop", func(t *testing.T) {
+		_, ok, err := db.List("queue").BLPop(20 * time.Millisecond)
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			db.List("queue").Push("job")
+		}()
+
+		val, ok, err := db.List("queue").BLPop(time.Second)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "job", val)
+		assert.Equal(t, 0, db.Exists("queue"))
+	})
+}
+
+func TestHashOp_Commands(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	op := db.Hash("user")
+	require.NoError(t, op.Set("name", "alice"))
+	require.NoError(t, op.Set("email", "alice@example.com"))
+	require.NoError(t, op.Set("age", "30"))
+
+	assert.Equal(t, 3, op.HLen())
+	assert.Equal(t, []string{"age", "email", "name"}, op.HKeys())
+	assert.Equal(t, map[string]string{"name": "alice", "email": "alice@example.com", "age": "30"}, op.HGetAll())
+
+	n, err := op.HIncrBy("age", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(31), n)
+	n, err = op.HIncrBy("visits", 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	_, err = op.HIncrBy("name", 1)
+	assert.ErrorIs(t, err, xedb.ErrInvalidValue)
+
+	removed, err := op.HDel("email", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	_, exists := op.Get("email")
+	assert.False(t, exists)
+
+	t.Run("HScan", func(t *testing.T) {
+		scan := db.Hash("scan")
+		for _, f := range []string{"f1", "f2", "f3", "f4", "f5", "g1"} {
+			require.NoError(t, scan.Set(f, "v"))
+		}
+
+		seen := make(map[string]string)
+		var cursor uint64
+		for {
+			var page map[string]string
+			cursor, page = scan.HScan(cursor, "f*", 2)
+			for k, v := range page {
+				seen[k] = v
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+		assert.Len(t, seen, 5)
+		assert.NotContains(t, seen, "g1")
+	})
+}
+
+func TestSetOp_Commands(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	require.NoError(t, db.Set("a").Add("1", "2", "3", "4"))
+	require.NoError(t, db.Set("b").Add("3", "4", "5"))
+	require.NoError(t, db.Set("c").Add("4", "6"))
+
+	assert.Equal(t, []string{"1", "2", "3", "4"}, db.Set("a").SMembers())
+
+	inter, err := db.Set("a").SInter("b", "c")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"4"}, inter)
+
+	union, err := db.Set("a").SUnion("b", "c")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2", "3", "4", "5", "6"}, union)
+
+	diff, err := db.Set("a").SDiff("b", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, diff)
+
+	require.NoError(t, db.String("str").Set("x"))
+	_, err = db.Set("a").SInter("str")
+	assert.ErrorIs(t, err, xedb.ErrTypeMismatch)
+
+	removed, err := db.Set("a").SRem("1", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.False(t, db.Set("a").IsMember("1"))
+
+	random := db.Set("a").SRandMember(2)
+	assert.Len(t, random, 2)
+	assert.NotEqual(t, random[0], random[1])
+	assert.Len(t, db.Set("a").SRandMember(10), 3)
+	assert.Len(t, db.Set("a").SRandMember(-5), 5)
+
+	popped := make([]string, 0, 3)
+	for {
+		member, ok, err := db.Set("a").SPop()
+		require.NoError(t, err)
+		if !ok {
+			break
+		}
+		popped = append(popped, member)
+	}
+	sort.Strings(popped)
+	assert.Equal(t, []string{"2", "3", "4"}, popped)
+	assert.Equal(t, 0, db.Exists("a"))
+}
+
+func TestZSetOp_Commands(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	op := db.ZSet("board")
+	require.NoError(t, op.Add(10, "alice"))
+	require.NoError(t, op.Add(20, "bob"))
+	require.NoError(t, op.Add(30, "carol"))
+	require.NoError(t, op.Add(20, "dave"))
+
+	score, ok := op.ZScore("bob")
+	assert.True(t, ok)
+	assert.Equal(t, 20.0, score)
+
+	// Ties are ordered by member
+	rank, ok := op.ZRank("dave")
+	assert.True(t, ok)
+	assert.Equal(t, 2, rank)
+	_, ok = op.ZRank("missing")
+	assert.False(t, ok)
+
+	assert.Equal(t, 3, op.ZCount(15, 30))
+	members := op.ZRangeByScore(15, 25)
+	require.Len(t, members, 2)
+	assert.Equal(t, "bob", members[0].Member)
+	assert.Equal(t, "dave", members[1].Member)
+
+	rev := op.ZRevRange(0, 1)
+	require.Len(t, rev, 2)
+	assert.Equal(t, "carol", rev[0].Member)
+	assert.Equal(t, "dave", rev[1].Member)
+
+	score, err := op.ZIncrBy(25, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 35.0, score)
+	rank, _ = op.ZRank("alice")
+	assert.Equal(t, 3, rank)
+
+	score, err = op.ZIncrBy(5, "eve")
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, score)
+
+	removed, err := op.ZRem("bob", "eve", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	_, ok = op.ZScore("bob")
+	assert.False(t, ok)
+	assert.Len(t, op.Range(0, -1), 3)
+}
+
+func TestCommands_WALRecovery(t *testing.T) {
+	dir, err := os.MkdirTemp("", "xedb-commands-wal-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := xedb.New(xedb.WithDataDir(dir))
+	require.NoError(t, err)
+	require.NoError(t, db.String("counter").Set("0"))
+	require.NoError(t, db.Hash("h").Set("f", "1"))
+	require.NoError(t, db.Save())
+
+	// Keep the snapshot taken before the commands so only the WAL can restore them
+	snapshot, err := os.ReadFile(dir + "/data.db")
+	require.NoError(t, err)
+
+	_, err = db.String("counter").IncrBy(5)
+	require.NoError(t, err)
+	_, err = db.Hash("h").HIncrBy("f", 2)
+	require.NoError(t, err)
+	_, err = db.List("l").LInsert(true, "x", "y")
+	require.NoError(t, err)
+	require.NoError(t, db.List("l").Push("a", "b", "c"))
+	_, err = db.List("l").LRem(1, "b")
+	require.NoError(t, err)
+	_, err = db.Hash("h").HDel("f")
+	require.NoError(t, err)
+	crash(t, db, dir)
+
+	require.NoError(t, os.WriteFile(dir+"/data.db", snapshot, 0644))
+
+	db, err = xedb.New(xedb.WithDataDir(dir))
+	require.NoError(t, err)
+	defer db.Close()
+
+	val, ok := db.String("counter").Get()
+	assert.True(t, ok)
+	assert.Equal(t, "5", val)
+	assert.Equal(t, 0, db.Exists("h"))
+	assert.Equal(t, []string{"a", "c"}, db.List("l").Range(0, -1))
+}
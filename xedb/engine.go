@@ -0,0 +1,217 @@
+package xedb
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// StorageEngine names a built-in storage engine
+type StorageEngine string
+
+const (
+	// MemoryStorage keeps every entry in memory and persists them in the data file
+	MemoryStorage StorageEngine = "memory"
+	// LSMStorage keeps entries on disk in a log-structured merge tree, so only
+	// keys and metadata have to fit in memory
+	LSMStorage StorageEngine = "lsm"
+)
+
+// Engine stores the entries of a DB. The DB serializes access to it: Put,
+// Delete and Reset are called while holding the write lock, Get and Range
+// under the read lock and so possibly concurrently with each other.
+//
+// Entries are exchanged as the DB holds them in memory, so a sorted set is
+// not a []ZSetMember; engines that serialize entries convert them on the way.
+type Engine interface {
+	// Get returns the entry stored under key
+	Get(key string) (Entry, bool)
+	// Put stores entry under key, replacing any previous entry
+	Put(key string, entry Entry) error
+	// Delete removes key if it exists
+	Delete(key string) error
+	// Range calls fn for every entry in unspecified order until fn returns false
+	Range(fn func(key string, entry Entry) bool) error
+	// Reset replaces all entries with entries
+	Reset(entries map[string]Entry) error
+	// Persistent reports whether the engine keeps entries on disk by itself.
+	// Their values are then left out of the data file and of MaxMemory.
+	Persistent() bool
+	// Close flushes and releases the engine
+	Close() error
+}
+
+// Syncer is implemented by persistent engines that can force their writes to
+// stable storage. The DB syncs the engine before truncating its WAL, which it
+// never truncates for a persistent engine that is not a Syncer.
+type Syncer interface {
+	Sync() error
+}
+
+// WithStorageEngine selects the built-in storage engine
+func WithStorageEngine(engine StorageEngine) Option {
+	return func(o *Options) {
+		o.StorageEngine = engine
+	}
+}
+
+// WithEngine sets a custom storage engine, overriding Options.StorageEngine.
+// The DB closes it on Close.
+func WithEngine(engine Engine) Option {
+	return func(o *Options) {
+		o.Engine = engine
+	}
+}
+
+// WithMemtableSize sets the size the LSM memtable reaches before it is flushed to disk
+func WithMemtableSize(size int64) Option {
+	return func(o *Options) {
+		o.MemtableSize = size
+	}
+}
+
+// WithCompactionL0Trigger sets the number of LSM tables that triggers a compaction
+func WithCompactionL0Trigger(tables int) Option {
+	return func(o *Options) {
+		o.CompactionL0Trigger = tables
+	}
+}
+
+// openEngine returns the engine configured by the options
+func (db *DB) openEngine() (Engine, error) {
+	if db.options.Engine != nil {
+		return db.options.Engine, nil
+	}
+
+	switch db.options.StorageEngine {
+	case "", MemoryStorage:
+		return NewMemoryEngine(), nil
+	case LSMStorage:
+		return openLSM(filepath.Join(db.options.DataDir, "lsm"), db.options)
+	}
+	return nil, fmt.Errorf("unknown storage engine %q", db.options.StorageEngine)
+}
+
+// residentSize is the memory accounted for an entry. Values an engine keeps on
+// disk do not count.
+func (db *DB) residentSize(key string, entry Entry) int64 {
+	if db.engine.Persistent() {
+		return int64(len(key)) + entryOverhead
+	}
+	return entrySize(key, entry)
+}
+
+// memEngine is the default engine, a plain map
+type memEngine struct {
+	data map[string]Entry
+}
+
+// NewMemoryEngine returns the in-memory engine, for custom engines to wrap
+func NewMemoryEngine() Engine {
+	return &memEngine{data: make(map[string]Entry)}
+}
+
+func (e *memEngine) Get(key string) (Entry, bool) {
+	entry, ok := e.data[key]
+	return entry, ok
+}
+
+func (e *memEngine) Put(key string, entry Entry) error {
+	e.data[key] = entry
+	return nil
+}
+
+func (e *memEngine) Delete(key string) error {
+	delete(e.data, key)
+	return nil
+}
+
+func (e *memEngine) Range(fn func(key string, entry Entry) bool) error {
+	for key, entry := range e.data {
+		if !fn(key, entry) {
+			break
+		}
+	}
+	return nil
+}
+
+func (e *memEngine) Reset(entries map[string]Entry) error {
+	if entries == nil {
+		entries = make(map[string]Entry)
+	}
+	e.data = entries
+	return nil
+}
+
+func (e *memEngine) Persistent() bool {
+	return false
+}
+
+func (e *memEngine) Close() error {
+	return nil
+}
+
+// Compacter is implemented by engines that can reclaim space on demand.
+// Compact is called while holding the write lock.
+type Compacter interface {
+	Compact() error
+}
+
+// compactionWaiter is implemented by engines whose Compact only starts a
+// compaction that runs in the background without the DB lock
+type compactionWaiter interface {
+	waitCompaction() error
+}
+
+// engineView is a consistent view of the entries of an engine that stays
+// readable without the DB lock until it is released
+type engineView interface {
+	// each calls fn for every entry in key order, with values in their
+	// persisted form, until fn returns false
+	each(fn func(key string, entry Entry) bool) error
+	release()
+}
+
+// viewer is implemented by engines that keep their entries on disk and can
+// hand out views of them, so snapshots stream the entries instead of copying
+// them. view is called while holding the DB lock.
+type viewer interface {
+	view() engineView
+}
+
+// Compact makes the storage engine reclaim the space held by overwritten and
+// deleted entries. It does nothing for engines that do not implement Compacter.
+// Reads and writes are only blocked while a compaction is started, not while
+// an engine that compacts in the background merges its files.
+func (db *DB) Compact() error {
+	c, ok := db.engine.(Compacter)
+	if !ok {
+		return nil
+	}
+
+	db.mutex.Lock()
+	err := c.Compact()
+	db.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if w, ok := c.(compactionWaiter); ok {
+		return w.waitCompaction()
+	}
+	return nil
+}
+
+// loadEntries hands the entries read from the data file to the engine. A
+// persistent engine already holds its entries; any found in the data file,
+// written before switching engines, are moved into it.
+// Caller must own db exclusively.
+func (db *DB) loadEntries(entries map[string]Entry) error {
+	if !db.engine.Persistent() {
+		return db.engine.Reset(entries)
+	}
+	for key, entry := range entries {
+		if err := db.engine.Put(key, entry); err != nil {
+			return fmt.Errorf("failed to move entries into storage engine: %w", err)
+		}
+	}
+	return nil
+}
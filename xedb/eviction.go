@@ -0,0 +1,329 @@
+package xedb
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// EvictionPolicy determines which keys are evicted once MaxMemory is reached
+type EvictionPolicy string
+
+const (
+	// NoEviction rejects writes with ErrMemoryLimit once the limit is reached
+	NoEviction EvictionPolicy = "noeviction"
+	// AllKeysLRU evicts the least recently used key
+	AllKeysLRU EvictionPolicy = "allkeys-lru"
+	// AllKeysLFU evicts the least frequently used key
+	AllKeysLFU EvictionPolicy = "allkeys-lfu"
+	// VolatileLRU evicts the least recently used key among keys with a TTL
+	VolatileLRU EvictionPolicy = "volatile-lru"
+	// VolatileTTL evicts the key with the nearest deadline
+	VolatileTTL EvictionPolicy = "volatile-ttl"
+	// AllKeysRandom evicts a random key
+	AllKeysRandom EvictionPolicy = "random"
+)
+
+const (
+	// entryOverhead approximates the bookkeeping cost of a single key
+	entryOverhead = 64
+
+	// LFU counters grow logarithmically and decay while a key sits idle,
+	// following the approach used by Redis
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuDecayTime = time.Minute
+)
+
+// EvictionStats reports memory usage and eviction counters
+type EvictionStats struct {
+	Policy         EvictionPolicy
+	MaxMemory      int64
+	MemoryUsage    int64
+	EvictedKeys    uint64
+	RejectedWrites uint64
+}
+
+// accessInfo tracks the size, recency and frequency of a single entry.
+// The map holding it is guarded by db.mutex; the access fields are updated
+// atomically so readers holding only the read lock can record accesses.
+type accessInfo struct {
+	typ        DataType
+	size       int64
+	lastAccess int64 // unix nanoseconds
+	freq       uint32
+}
+
+// WithEvictionPolicy sets the eviction policy used when MaxMemory is reached
+func WithEvictionPolicy(policy EvictionPolicy) Option {
+	return func(o *Options) {
+		o.EvictionPolicy = policy
+	}
+}
+
+// WithEvictionSamples sets the number of keys sampled per eviction
+func WithEvictionSamples(samples int) Option {
+	return func(o *Options) {
+		o.EvictionSamples = samples
+	}
+}
+
+// EvictionStats returns the current memory usage and eviction counters
+func (db *DB) EvictionStats() EvictionStats {
+	return EvictionStats{
+		Policy:         db.options.EvictionPolicy,
+		MaxMemory:      db.options.MaxMemory,
+		MemoryUsage:    atomic.LoadInt64(&db.memUsage),
+		EvictedKeys:    atomic.LoadUint64(&db.evictedKeys),
+		RejectedWrites: atomic.LoadUint64(&db.rejectedWrites),
+	}
+}
+
+// putEntry stores entry under key and updates memory accounting. With
+// KeepHistory set the replaced value of the same type is kept in the version
+// history, unless the caller already built it. Caller must hold db.mutex for writing.
+func (db *DB) putEntry(key string, entry Entry) error {
+	now := time.Now()
+	if entry.LastUpdated.IsZero() {
+		entry.LastUpdated = now
+	}
+	existing, exists := db.engine.Get(key)
+	if exists && existing.Type == entry.Type {
+		if !existing.Created.IsZero() {
+			entry.Created = existing.Created
+		}
+		if db.options.KeepHistory && entry.Versions == nil && existing.Version != entry.Version {
+			entry.Versions = db.versionHistory(existing)
+		}
+	}
+	if entry.Created.IsZero() {
+		entry.Created = now
+	}
+
+	entry.Value = storedValue(entry.Type, entry.Value)
+	for i, v := range entry.Versions {
+		if _, ok := v.Value.(*sortedSet); ok {
+			entry.Versions[i].Value = exportValue(v.Value)
+		}
+	}
+
+	if err := db.engine.Put(key, entry); err != nil {
+		return fmt.Errorf("failed to store entry: %w", err)
+	}
+
+	size := db.residentSize(key, entry)
+	info, ok := db.access[key]
+	if !ok {
+		info = &accessInfo{freq: lfuInitVal}
+		db.access[key] = info
+	} else {
+		db.keysByType[info.typ]--
+	}
+	db.keysByType[entry.Type]++
+	db.updateMemUsage(size - info.size)
+	info.typ = entry.Type
+	info.size = size
+	info.touch(now)
+
+	if !exists {
+		db.keyspace.add(key)
+	}
+	db.indexEntry(key, entry)
+	db.notifyWrite(EventSet, key)
+	if entry.Type == List && len(db.listWaiters) > 0 {
+		db.wakeListWaiters(key)
+	}
+	return nil
+}
+
+// removeKey deletes key, its deadline and its access metadata.
+// Caller must hold db.mutex for writing.
+func (db *DB) removeKey(key string) {
+	if info, ok := db.access[key]; ok {
+		db.updateMemUsage(-info.size)
+		db.keysByType[info.typ]--
+		delete(db.access, key)
+	}
+	if db.keyspace.contains(key) {
+		db.keyspace.remove(key)
+		db.unindexKey(key)
+		// A persistent engine that fails here reports the error on its next write
+		db.engine.Delete(key)
+	}
+	delete(db.expires, key)
+}
+
+// touch records a read of key. Caller must hold db.mutex for reading or writing.
+func (db *DB) touch(key string) {
+	if info, ok := db.access[key]; ok {
+		info.touch(time.Now())
+	}
+}
+
+// resetAccounting rebuilds access metadata and memory usage from the engine.
+// Caller must hold db.mutex for writing or own db exclusively.
+func (db *DB) resetAccounting() {
+	db.access = make(map[string]*accessInfo)
+	db.keysByType = [JSON + 1]int{}
+	var total int64
+	now := time.Now().UnixNano()
+	db.engine.Range(func(key string, entry Entry) bool {
+		size := db.residentSize(key, entry)
+		db.access[key] = &accessInfo{typ: entry.Type, size: size, lastAccess: now, freq: lfuInitVal}
+		db.keysByType[entry.Type]++
+		total += size
+		return true
+	})
+	atomic.StoreInt64(&db.memUsage, total)
+}
+
+// evictOne removes a single key chosen by the eviction policy.
+// It returns false if the policy forbids eviction or no candidate exists.
+// Caller must hold db.mutex for writing.
+func (db *DB) evictOne() bool {
+	samples := db.options.EvictionSamples
+	if samples <= 0 {
+		samples = 1
+	}
+
+	var candidates []string
+	switch db.options.EvictionPolicy {
+	case AllKeysLRU, AllKeysLFU, AllKeysRandom:
+		for key := range db.access {
+			if len(candidates) >= samples {
+				break
+			}
+			candidates = append(candidates, key)
+		}
+	case VolatileLRU, VolatileTTL:
+		for key := range db.expires {
+			if len(candidates) >= samples {
+				break
+			}
+			candidates = append(candidates, key)
+		}
+	default:
+		return false
+	}
+
+	if len(candidates) == 0 {
+		return false
+	}
+
+	now := time.Now()
+	victim := candidates[0]
+	switch db.options.EvictionPolicy {
+	case AllKeysRandom:
+		victim = candidates[rand.Intn(len(candidates))]
+	case AllKeysLRU, VolatileLRU:
+		oldest := int64(math.MaxInt64)
+		for _, key := range candidates {
+			if info, ok := db.access[key]; ok {
+				if last := atomic.LoadInt64(&info.lastAccess); last < oldest {
+					oldest, victim = last, key
+				}
+			}
+		}
+	case AllKeysLFU:
+		lowest := uint32(math.MaxUint32)
+		for _, key := range candidates {
+			if info, ok := db.access[key]; ok {
+				if freq := info.decayedFreq(now); freq < lowest {
+					lowest, victim = freq, key
+				}
+			}
+		}
+	case VolatileTTL:
+		var nearest time.Time
+		for _, key := range candidates {
+			if deadline := db.expires[key]; nearest.IsZero() || deadline.Before(nearest) {
+				nearest, victim = deadline, key
+			}
+		}
+	}
+
+	db.removeKey(victim)
+	db.notifyKeyspace(EventEvicted, victim)
+	atomic.AddUint64(&db.evictedKeys, 1)
+	return true
+}
+
+// touch records an access, decaying and then logarithmically incrementing the LFU counter
+func (a *accessInfo) touch(now time.Time) {
+	freq := a.decayedFreq(now)
+	if freq < math.MaxUint8 {
+		base := float64(0)
+		if freq > lfuInitVal {
+			base = float64(freq - lfuInitVal)
+		}
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			freq++
+		}
+	}
+	atomic.StoreUint32(&a.freq, freq)
+	atomic.StoreInt64(&a.lastAccess, now.UnixNano())
+}
+
+// decayedFreq returns the LFU counter after applying idle-time decay
+func (a *accessInfo) decayedFreq(now time.Time) uint32 {
+	freq := atomic.LoadUint32(&a.freq)
+	idle := now.Sub(time.Unix(0, atomic.LoadInt64(&a.lastAccess)))
+	if periods := uint32(idle / lfuDecayTime); periods > 0 {
+		if periods >= freq {
+			return 0
+		}
+		return freq - periods
+	}
+	return freq
+}
+
+// entrySize estimates the memory used by key and entry, including version history
+func entrySize(key string, entry Entry) int64 {
+	size := int64(len(key)) + entryOverhead + valueSize(entry.Value)
+	for _, v := range entry.Versions {
+		size += valueSize(v.Value)
+	}
+	return size
+}
+
+// valueSize estimates the memory used by a stored value
+func valueSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case string:
+		return int64(len(v))
+	case []string:
+		return stringsSize(v)
+	case map[string]string:
+		var size int64
+		for field, val := range v {
+			size += int64(len(field)+len(val)) + 32
+		}
+		return size
+	case map[string]struct{}:
+		var size int64
+		for member := range v {
+			size += int64(len(member)) + 16
+		}
+		return size
+	case *sortedSet:
+		return v.size
+	case []ZSetMember:
+		var size int64
+		for _, m := range v {
+			size += zsetMemberSize(m.Member)
+		}
+		return size
+	}
+	return 0
+}
+
+// stringsSize estimates the memory used by a slice of strings
+func stringsSize(values []string) int64 {
+	var size int64
+	for _, v := range values {
+		size += int64(len(v)) + 16
+	}
+	return size
+}
@@ -0,0 +1,160 @@
+package xedb_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupEvictionDB(t *testing.T, policy xedb.EvictionPolicy, maxMemory int64) (*xedb.DB, func()) {
+	dir, err := os.MkdirTemp("", "xedb-eviction-test-*")
+	require.NoError(t, err)
+
+	db, err := xedb.New(
+		xedb.WithDataDir(dir),
+		xedb.WithSyncWrite(false),
+		xedb.WithMaxMemory(maxMemory),
+		xedb.WithEvictionPolicy(policy),
+		xedb.WithEvictionSamples(16),
+	)
+	require.NoError(t, err)
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDB_EvictionNoEviction(t *testing.T) {
+	db, cleanup := setupEvictionDB(t, xedb.NoEviction, 1024)
+	defer cleanup()
+
+	value := strings.Repeat("x", 100)
+	var err error
+	for i := 0; i < 20 && err == nil; i++ {
+		err = db.String(fmt.Sprintf("key%d", i)).Set(value)
+	}
+	assert.ErrorIs(t, err, xedb.ErrMemoryLimit)
+
+	stats := db.EvictionStats()
+	assert.Equal(t, uint64(0), stats.EvictedKeys)
+	assert.Equal(t, uint64(1), stats.RejectedWrites)
+	assert.LessOrEqual(t, stats.MemoryUsage, stats.MaxMemory)
+}
+
+func TestDB_EvictionAllKeysLRU(t *testing.T) {
+	db, cleanup := setupEvictionDB(t, xedb.AllKeysLRU, 1024)
+	defer cleanup()
+
+	value := strings.Repeat("x", 100)
+	require.NoError(t, db.String("hot").Set(value))
+	for i := 0; i < 20; i++ {
+		// Keep "hot" recently used so it is never the LRU candidate
+		_, exists := db.String("hot").Get()
+		require.True(t, exists)
+		time.Sleep(time.Millisecond)
+		require.NoError(t, db.String(fmt.Sprintf("key%d", i)).Set(value))
+	}
+
+	_, exists := db.String("hot").Get()
+	assert.True(t, exists)
+
+	stats := db.EvictionStats()
+	assert.Greater(t, stats.EvictedKeys, uint64(0))
+	assert.LessOrEqual(t, stats.MemoryUsage, stats.MaxMemory)
+
+	_, exists = db.String("key0").Get()
+	assert.False(t, exists)
+}
+
+func TestDB_EvictionAllKeysLFU(t *testing.T) {
+	db, cleanup := setupEvictionDB(t, xedb.AllKeysLFU, 1024)
+	defer cleanup()
+
+	value := strings.Repeat("x", 100)
+	require.NoError(t, db.String("popular").Set(value))
+	for i := 0; i < 1000; i++ {
+		db.String("popular").Get()
+	}
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.String(fmt.Sprintf("key%d", i)).Set(value))
+	}
+
+	_, exists := db.String("popular").Get()
+	assert.True(t, exists)
+	assert.Greater(t, db.EvictionStats().EvictedKeys, uint64(0))
+}
+
+func TestDB_EvictionVolatile(t *testing.T) {
+	t.Run("VolatileTTL Evicts Nearest Deadline", func(t *testing.T) {
+		db, cleanup := setupEvictionDB(t, xedb.VolatileTTL, 1024)
+		defer cleanup()
+
+		value := strings.Repeat("x", 100)
+		require.NoError(t, db.String("persistent").Set(value))
+		require.NoError(t, db.String("soon").SetWithTTL(value, time.Minute))
+		require.NoError(t, db.String("later").SetWithTTL(value, time.Hour))
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, db.String(fmt.Sprintf("key%d", i)).Set(value))
+		}
+		require.NoError(t, db.String("big").Set(strings.Repeat("y", 300)))
+
+		_, exists := db.String("soon").Get()
+		assert.False(t, exists)
+		_, exists = db.String("persistent").Get()
+		assert.True(t, exists)
+	})
+
+	t.Run("VolatileLRU Without Candidates", func(t *testing.T) {
+		db, cleanup := setupEvictionDB(t, xedb.VolatileLRU, 512)
+		defer cleanup()
+
+		value := strings.Repeat("x", 100)
+		var err error
+		for i := 0; i < 10 && err == nil; i++ {
+			err = db.String(fmt.Sprintf("key%d", i)).Set(value)
+		}
+		assert.ErrorIs(t, err, xedb.ErrMemoryLimit)
+		assert.Equal(t, uint64(0), db.EvictionStats().EvictedKeys)
+	})
+}
+
+func TestDB_EvictionRandom(t *testing.T) {
+	db, cleanup := setupEvictionDB(t, xedb.AllKeysRandom, 1024)
+	defer cleanup()
+
+	value := strings.Repeat("x", 100)
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.List(fmt.Sprintf("list%d", i)).Push(value))
+	}
+
+	stats := db.EvictionStats()
+	assert.Equal(t, xedb.AllKeysRandom, stats.Policy)
+	assert.Greater(t, stats.EvictedKeys, uint64(0))
+	assert.LessOrEqual(t, stats.MemoryUsage, stats.MaxMemory)
+}
+
+func TestDB_MemoryAccounting(t *testing.T) {
+	db, cleanup := setupEvictionDB(t, xedb.NoEviction, 1<<20)
+	defer cleanup()
+
+	require.NoError(t, db.String("a").Set("value"))
+	afterSet := db.EvictionStats().MemoryUsage
+	assert.Greater(t, afterSet, int64(0))
+
+	// Overwriting with the same size keeps usage stable
+	require.NoError(t, db.String("a").Set("other"))
+	assert.Equal(t, afterSet, db.EvictionStats().MemoryUsage)
+
+	// Expired keys give their memory back
+	require.NoError(t, db.String("a").Expire(-time.Second))
+	assert.Equal(t, int64(0), db.EvictionStats().MemoryUsage)
+}
@@ -0,0 +1,280 @@
+package xedb
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// NoExpiration is returned by TTL for keys that exist but have no deadline
+const NoExpiration time.Duration = -1
+
+// WithExpireScanInterval sets how often expired keys are actively reclaimed
+func WithExpireScanInterval(interval time.Duration) Option {
+	return func(o *Options) {
+		o.ExpireScanInterval = interval
+	}
+}
+
+// WithExpireSampleSize sets the number of keys sampled per expiration round
+func WithExpireSampleSize(size int) Option {
+	return func(o *Options) {
+		o.ExpireSampleSize = size
+	}
+}
+
+// isExpired reports whether key has a deadline at or before now.
+// Caller must hold db.mutex.
+func (db *DB) isExpired(key string, now time.Time) bool {
+	deadline, ok := db.expires[key]
+	return ok && !deadline.After(now)
+}
+
+// get returns the live entry for key, hiding expired keys and recording the access.
+// Caller must hold db.mutex for reading or writing.
+func (db *DB) get(key string) (Entry, bool) {
+	entry, ok := db.engine.Get(key)
+	if !ok || db.isExpired(key, time.Now()) {
+		atomic.AddUint64(&db.metrics.misses, 1)
+		return Entry{}, false
+	}
+	atomic.AddUint64(&db.metrics.hits, 1)
+	db.touch(key)
+	return entry, true
+}
+
+// expireIfNeeded lazily removes key if its deadline has passed.
+// Caller must hold db.mutex for writing.
+func (db *DB) expireIfNeeded(key string) bool {
+	if !db.isExpired(key, time.Now()) {
+		return false
+	}
+	db.removeKey(key)
+	atomic.AddUint64(&db.metrics.expiredKeys, 1)
+	db.notifyKeyspace(EventExpired, key)
+	return true
+}
+
+// activeExpire samples keys with a deadline and removes the expired ones.
+// Like Redis, it keeps sampling while more than a quarter of the sample was expired.
+func (db *DB) activeExpire() {
+	sampleSize := db.options.ExpireSampleSize
+	if sampleSize <= 0 {
+		return
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	// Bound the work done while holding the lock
+	for round := 0; round < 16; round++ {
+		now := time.Now()
+		sampled, expired := 0, 0
+		for key, deadline := range db.expires {
+			if sampled >= sampleSize {
+				break
+			}
+			sampled++
+			if !deadline.After(now) {
+				db.removeKey(key)
+				atomic.AddUint64(&db.metrics.expiredKeys, 1)
+				db.notifyKeyspace(EventExpired, key)
+				expired++
+			}
+		}
+
+		if sampled == 0 || expired*4 <= sampled {
+			return
+		}
+	}
+}
+
+// logCommand writes a single command to the WAL under a new transaction id.
+// Caller must hold db.mutex for writing.
+func (db *DB) logCommand(cmd Command) error {
+	txID := atomic.AddUint64(&db.txCounter, 1)
+	cmd.Version = txID
+	if err := db.writeWAL(WALEntry{TxID: txID, Commands: []Command{cmd}}); err != nil {
+		return fmt.Errorf("failed to write WAL: %w", err)
+	}
+	return db.maybeCheckpoint()
+}
+
+// setWithTTL stores entry under key with a deadline ttl from now.
+// Caller must hold db.mutex for writing.
+func (db *DB) setWithTTL(key string, entry Entry, op string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidValue
+	}
+
+	now := time.Now()
+	entry.Version = atomic.LoadUint64(&db.txCounter) + 1
+	entry.Created = now
+	entry.LastUpdated = now
+	deadline := now.Add(ttl)
+
+	if err := db.putEntry(key, entry); err != nil {
+		return err
+	}
+	db.expires[key] = deadline
+
+	return db.logCommand(Command{
+		Op:       op,
+		Key:      key,
+		Value:    entry.Value,
+		Type:     entry.Type,
+		TTL:      ttl,
+		ExpireAt: deadline,
+	})
+}
+
+// Expire sets a timeout on the key. A non-positive ttl deletes the key immediately.
+func (op *keyOp) Expire(ttl time.Duration) error {
+	return op.ExpireAt(time.Now().Add(ttl))
+}
+
+// ExpireAt sets an absolute deadline on the key. A deadline in the past deletes the key immediately.
+func (op *keyOp) ExpireAt(deadline time.Time) error {
+	defer op.db.observe("Key.ExpireAt", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	op.db.expireIfNeeded(op.key)
+	if _, ok := op.db.engine.Get(op.key); !ok {
+		return ErrKeyNotFound
+	}
+
+	op.db.expires[op.key] = deadline
+	if !deadline.After(time.Now()) {
+		op.db.removeKey(op.key)
+		op.db.notifyWrite(EventDel, op.key)
+	}
+
+	return op.db.logCommand(Command{
+		Op:       "EXPIRE",
+		Key:      op.key,
+		ExpireAt: deadline,
+	})
+}
+
+// TTL returns the remaining time to live of the key.
+// It returns NoExpiration if the key has no deadline, and false if the key does not exist.
+func (op *keyOp) TTL() (time.Duration, bool) {
+	defer op.db.observe("Key.TTL", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	if _, ok := op.db.get(op.key); !ok {
+		return 0, false
+	}
+
+	deadline, ok := op.db.expires[op.key]
+	if !ok {
+		return NoExpiration, true
+	}
+	return time.Until(deadline), true
+}
+
+// Persist removes the timeout from the key
+func (op *keyOp) Persist() error {
+	defer op.db.observe("Key.Persist", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	op.db.expireIfNeeded(op.key)
+	if _, ok := op.db.engine.Get(op.key); !ok {
+		return ErrKeyNotFound
+	}
+	if _, ok := op.db.expires[op.key]; !ok {
+		return nil
+	}
+
+	delete(op.db.expires, op.key)
+	return op.db.logCommand(Command{
+		Op:  "PERSIST",
+		Key: op.key,
+	})
+}
+
+// SetWithTTL sets the string value and expires it after ttl
+func (op *StringOp) SetWithTTL(value string, ttl time.Duration) error {
+	defer op.db.observe("String.SetWithTTL", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	if err := op.db.checkMemoryLimit(int64(len(value))); err != nil {
+		return err
+	}
+
+	return op.db.setWithTTL(op.key, Entry{Type: String, Value: value}, "STRING", ttl)
+}
+
+// SetWithTTL replaces the list and expires it after ttl
+func (op *ListOp) SetWithTTL(values []string, ttl time.Duration) error {
+	defer op.db.observe("List.SetWithTTL", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	if err := op.db.checkMemoryLimit(stringsSize(values)); err != nil {
+		return err
+	}
+
+	list := make([]string, len(values))
+	copy(list, values)
+	return op.db.setWithTTL(op.key, Entry{Type: List, Value: list}, "LIST", ttl)
+}
+
+// SetWithTTL replaces the hash and expires it after ttl
+func (op *HashOp) SetWithTTL(fields map[string]string, ttl time.Duration) error {
+	defer op.db.observe("Hash.SetWithTTL", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	hash := make(map[string]string, len(fields))
+	for k, v := range fields {
+		hash[k] = v
+	}
+	if err := op.db.checkMemoryLimit(valueSize(hash)); err != nil {
+		return err
+	}
+	return op.db.setWithTTL(op.key, Entry{Type: Hash, Value: hash}, "HASH", ttl)
+}
+
+// SetWithTTL replaces the set and expires it after ttl
+func (op *SetOp) SetWithTTL(members []string, ttl time.Duration) error {
+	defer op.db.observe("Set.SetWithTTL", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	if err := op.db.checkMemoryLimit(stringsSize(members)); err != nil {
+		return err
+	}
+
+	set := make(map[string]struct{}, len(members))
+	for _, member := range members {
+		set[member] = struct{}{}
+	}
+	return op.db.setWithTTL(op.key, Entry{Type: Set, Value: set}, "SET", ttl)
+}
+
+// SetWithTTL replaces the sorted set and expires it after ttl
+func (op *ZSetOp) SetWithTTL(members []ZSetMember, ttl time.Duration) error {
+	defer op.db.observe("ZSet.SetWithTTL", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	for _, m := range members {
+		if math.IsNaN(m.Score) {
+			return ErrInvalidValue
+		}
+	}
+
+	// Duplicate members keep the last score
+	zset := newSortedSet(members)
+	if err := op.db.checkMemoryLimit(valueSize(zset)); err != nil {
+		return err
+	}
+
+	return op.db.setWithTTL(op.key, Entry{Type: ZSet, Value: zset}, "ZSET", ttl)
+}
@@ -0,0 +1,226 @@
+package xedb_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_Expire(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	t.Run("SetWithTTL Expires Lazily", func(t *testing.T) {
+		err := db.String("session").SetWithTTL("token", 50*time.Millisecond)
+		require.NoError(t, err)
+
+		val, exists := db.String("session").Get()
+		assert.True(t, exists)
+		assert.Equal(t, "token", val)
+
+		ttl, exists := db.String("session").TTL()
+		assert.True(t, exists)
+		assert.Greater(t, ttl, time.Duration(0))
+		assert.LessOrEqual(t, ttl, 50*time.Millisecond)
+
+		time.Sleep(80 * time.Millisecond)
+
+		_, exists = db.String("session").Get()
+		assert.False(t, exists)
+		_, exists = db.String("session").TTL()
+		assert.False(t, exists)
+	})
+
+	t.Run("Expire And Persist", func(t *testing.T) {
+		err := db.List("queue").Push("a", "b")
+		require.NoError(t, err)
+
+		ttl, exists := db.List("queue").TTL()
+		assert.True(t, exists)
+		assert.Equal(t, xedb.NoExpiration, ttl)
+
+		err = db.List("queue").Expire(time.Hour)
+		require.NoError(t, err)
+		ttl, _ = db.List("queue").TTL()
+		assert.Greater(t, ttl, 59*time.Minute)
+
+		err = db.List("queue").Persist()
+		require.NoError(t, err)
+		ttl, _ = db.List("queue").TTL()
+		assert.Equal(t, xedb.NoExpiration, ttl)
+	})
+
+	t.Run("Expire Missing Key", func(t *testing.T) {
+		err := db.Hash("missing").Expire(time.Second)
+		assert.ErrorIs(t, err, xedb.ErrKeyNotFound)
+	})
+
+	t.Run("ExpireAt In The Past Deletes", func(t *testing.T) {
+		err := db.Set("tags").Add("go")
+		require.NoError(t, err)
+
+		err = db.Set("tags").ExpireAt(time.Now().Add(-time.Second))
+		require.NoError(t, err)
+		assert.False(t, db.Set("tags").IsMember("go"))
+	})
+
+	t.Run("Set Clears TTL", func(t *testing.T) {
+		err := db.String("cache").SetWithTTL("v1", time.Hour)
+		require.NoError(t, err)
+
+		err = db.String("cache").Set("v2")
+		require.NoError(t, err)
+
+		ttl, exists := db.String("cache").TTL()
+		assert.True(t, exists)
+		assert.Equal(t, xedb.NoExpiration, ttl)
+	})
+
+	t.Run("Write After Expiry Starts Fresh", func(t *testing.T) {
+		err := db.List("stale").SetWithTTL([]string{"old"}, 20*time.Millisecond)
+		require.NoError(t, err)
+		time.Sleep(40 * time.Millisecond)
+
+		err = db.List("stale").Push("new")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"new"}, db.List("stale").Range(0, -1))
+
+		ttl, _ := db.List("stale").TTL()
+		assert.Equal(t, xedb.NoExpiration, ttl)
+	})
+
+	t.Run("SetWithTTL On Every Type", func(t *testing.T) {
+		require.NoError(t, db.Hash("h").SetWithTTL(map[string]string{"f": "v"}, time.Hour))
+		require.NoError(t, db.Set("s").SetWithTTL([]string{"m"}, time.Hour))
+		require.NoError(t, db.ZSet("z").SetWithTTL([]xedb.ZSetMember{{Member: "b", Score: 2}, {Member: "a", Score: 1}}, time.Hour))
+
+		val, exists := db.Hash("h").Get("f")
+		assert.True(t, exists)
+		assert.Equal(t, "v", val)
+		assert.True(t, db.Set("s").IsMember("m"))
+		members := db.ZSet("z").Range(0, -1)
+		require.Len(t, members, 2)
+		assert.Equal(t, "a", members[0].Member)
+
+		err := db.String("bad").SetWithTTL("v", 0)
+		assert.ErrorIs(t, err, xedb.ErrInvalidValue)
+	})
+}
+
+func TestDB_ExpireHiddenFromIteratorsAndExport(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	require.NoError(t, db.String("user:1").Set("Alice"))
+	require.NoError(t, db.String("user:2").SetWithTTL("Bob", 20*time.Millisecond))
+	require.NoError(t, db.String("user:3").SetWithTTL("Charlie", time.Hour))
+	time.Sleep(40 * time.Millisecond)
+
+	iter := db.NewIterator(xedb.IteratorOptions{Prefix: "user:"})
+	var names []string
+	for iter.Seek("user:"); iter.Valid(); iter.Next() {
+		if item := iter.Item(); item != nil {
+			names = append(names, item.Value.(string))
+		}
+	}
+	assert.Equal(t, []string{"Alice", "Charlie"}, names)
+
+	jsonData, err := db.ExportToJSON()
+	require.NoError(t, err)
+
+	var exported map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(jsonData), &exported))
+	assert.NotContains(t, exported, "user:2")
+	assert.NotContains(t, exported["user:1"], "expires_at")
+	assert.Contains(t, exported["user:3"], "expires_at")
+}
+
+func TestDB_ActiveExpire(t *testing.T) {
+	dir, err := os.MkdirTemp("", "xedb-expire-test-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := xedb.New(
+		xedb.WithDataDir(dir),
+		xedb.WithExpireScanInterval(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, db.String(key).SetWithTTL("v", 20*time.Millisecond))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Reclaimed keys no longer show up in the snapshot written by Save
+	require.NoError(t, db.Save())
+	jsonData, err := db.ExportToJSON()
+	require.NoError(t, err)
+	assert.Equal(t, "{}", jsonData)
+}
+
+func TestDB_ExpirePersistence(t *testing.T) {
+	dir, err := os.MkdirTemp("", "xedb-expire-persist-test-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := xedb.New(xedb.WithDataDir(dir))
+	require.NoError(t, err)
+
+	require.NoError(t, db.String("long").SetWithTTL("v", time.Hour))
+	require.NoError(t, db.String("short").SetWithTTL("v", 50*time.Millisecond))
+	require.NoError(t, db.Save())
+	require.NoError(t, db.Close())
+
+	time.Sleep(80 * time.Millisecond)
+
+	db2, err := xedb.New(xedb.WithDataDir(dir))
+	require.NoError(t, err)
+	defer db2.Close()
+
+	ttl, exists := db2.String("long").TTL()
+	assert.True(t, exists)
+	assert.Greater(t, ttl, 59*time.Minute)
+
+	_, exists = db2.String("short").Get()
+	assert.False(t, exists)
+}
+
+func TestDB_ExpireWALReplay(t *testing.T) {
+	dir, err := os.MkdirTemp("", "xedb-expire-wal-test-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := xedb.New(xedb.WithDataDir(dir))
+	require.NoError(t, err)
+	require.NoError(t, db.String("k").Set("v"))
+	require.NoError(t, db.Save())
+
+	// Keep the stale data file so the expiration only survives through the WAL
+	stale, err := os.ReadFile(dir + "/data.db")
+	require.NoError(t, err)
+
+	require.NoError(t, db.String("k").Expire(time.Hour))
+	require.NoError(t, db.String("t").SetWithTTL("v", time.Hour))
+	crash(t, db, dir)
+	require.NoError(t, os.WriteFile(dir+"/data.db", stale, 0644))
+
+	db2, err := xedb.New(xedb.WithDataDir(dir))
+	require.NoError(t, err)
+	defer db2.Close()
+
+	ttl, exists := db2.String("k").TTL()
+	assert.True(t, exists)
+	assert.Greater(t, ttl, 59*time.Minute)
+
+	val, exists := db2.String("t").Get()
+	assert.True(t, exists)
+	assert.Equal(t, "v", val)
+	ttl, _ = db2.String("t").TTL()
+	assert.Greater(t, ttl, 59*time.Minute)
+}
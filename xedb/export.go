@@ -0,0 +1,354 @@
+package xedb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DumpFormat selects the encoding used by Export and Import
+type DumpFormat string
+
+const (
+	// JSONLines writes one JSON object per key and line
+	JSONLines DumpFormat = "jsonl"
+	// BinaryDump writes checksummed gob records, compact and fast to load
+	BinaryDump DumpFormat = "binary"
+)
+
+// dumpMagic identifies a stream written by Export in BinaryDump format
+const dumpMagic = "XEDBDUMP"
+
+const (
+	// exportBatchSize is the number of keys read per read lock while exporting
+	exportBatchSize = 256
+	// importBatchSize is the number of keys written per WAL entry while importing
+	importBatchSize = 512
+)
+
+// dumpRecord is a key as written by Export. A binary dump ends with a record
+// that has End set, so a truncated dump is detected.
+type dumpRecord struct {
+	Key      string
+	Type     DataType
+	Value    interface{}
+	ExpireAt time.Time
+	End      bool
+}
+
+// jsonRecord is the JSON lines form of a dumpRecord
+type jsonRecord struct {
+	Key       string          `json:"key"`
+	Type      string          `json:"type"`
+	Value     json.RawMessage `json:"value"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+}
+
+// Export streams the live keys to w in key order. Keys are read a batch at a
+// time under short read locks, so every key is written as it was when read but
+// the export as a whole is not a point-in-time copy; use Snapshot for that.
+// Version history and indexes are not exported.
+func (db *DB) Export(w io.Writer, format DumpFormat) error {
+	var write func(rec dumpRecord) error
+	bw := bufio.NewWriter(w)
+	switch format {
+	case JSONLines:
+		enc := json.NewEncoder(bw)
+		write = func(rec dumpRecord) error {
+			line, err := toJSONRecord(rec)
+			if err != nil {
+				return err
+			}
+			return enc.Encode(line)
+		}
+	case BinaryDump:
+		if _, err := bw.WriteString(dumpMagic); err != nil {
+			return fmt.Errorf("failed to write dump: %w", err)
+		}
+		write = func(rec dumpRecord) error {
+			record, err := encodeRecord(rec)
+			if err != nil {
+				return err
+			}
+			_, err = bw.Write(record)
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: unknown dump format %q", ErrInvalidValue, format)
+	}
+
+	after, started := "", false
+	for {
+		db.mutex.RLock()
+		records, err := db.nextRecords(after, started, exportBatchSize)
+		db.mutex.RUnlock()
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			break
+		}
+
+		for _, rec := range records {
+			if err := write(rec); err != nil {
+				return fmt.Errorf("failed to write key %q: %w", rec.Key, err)
+			}
+		}
+		after, started = records[len(records)-1].Key, true
+	}
+
+	if format == BinaryDump {
+		if err := write(dumpRecord{End: true}); err != nil {
+			return fmt.Errorf("failed to write dump: %w", err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write dump: %w", err)
+	}
+	return nil
+}
+
+// nextRecords returns up to n live keys in key order, starting after the key
+// after once started. Values are copied in their persisted form.
+// Caller must hold db.mutex for reading.
+func (db *DB) nextRecords(after string, started bool, n int) ([]dumpRecord, error) {
+	x := db.keyspace.lex.seek(0, after)
+	if started && x != nil && x.member == after {
+		x = x.level[0].forward
+	}
+
+	records := make([]dumpRecord, 0, n)
+	now := time.Now()
+	for ; x != nil && len(records) < n; x = x.level[0].forward {
+		if db.isExpired(x.member, now) {
+			continue
+		}
+		entry, ok := db.engine.Get(x.member)
+		if !ok {
+			return nil, fmt.Errorf("failed to read key %q: %w", x.member, ErrKeyNotFound)
+		}
+		records = append(records, dumpRecord{
+			Key:      x.member,
+			Type:     entry.Type,
+			Value:    copyValue(entry.Value),
+			ExpireAt: db.expires[x.member],
+		})
+	}
+	return records, nil
+}
+
+// Import reads keys written by Export from r and stores them, replacing keys
+// that already exist and keeping all others. Keys whose deadline has passed
+// are skipped. Keys are written in batches, each logged as one WAL entry, so
+// an error leaves the keys read before it imported.
+func (db *DB) Import(r io.Reader, format DumpFormat) error {
+	var read func() (dumpRecord, error)
+	br := bufio.NewReader(r)
+	switch format {
+	case JSONLines:
+		dec := json.NewDecoder(br)
+		read = func() (dumpRecord, error) {
+			var line jsonRecord
+			if err := dec.Decode(&line); err != nil {
+				if err == io.EOF {
+					return dumpRecord{End: true}, nil
+				}
+				return dumpRecord{}, fmt.Errorf("%w: %v", ErrInvalidValue, err)
+			}
+			return fromJSONRecord(line)
+		}
+	case BinaryDump:
+		magic := make([]byte, len(dumpMagic))
+		if _, err := io.ReadFull(br, magic); err != nil || string(magic) != dumpMagic {
+			return fmt.Errorf("%w: not a dump", ErrCorrupted)
+		}
+		read = func() (dumpRecord, error) {
+			var rec dumpRecord
+			if _, err := readRecord(br, &rec, math.MaxInt64); err != nil {
+				if err == io.EOF || err == errTornRecord {
+					return dumpRecord{}, fmt.Errorf("%w: dump is truncated", ErrCorrupted)
+				}
+				return dumpRecord{}, fmt.Errorf("failed to read dump: %w", err)
+			}
+			if !rec.End {
+				if err := checkDumpValue(rec.Type, rec.Value); err != nil {
+					return dumpRecord{}, fmt.Errorf("key %q: %w", rec.Key, err)
+				}
+			}
+			return rec, nil
+		}
+	default:
+		return fmt.Errorf("%w: unknown dump format %q", ErrInvalidValue, format)
+	}
+
+	batch := make([]dumpRecord, 0, importBatchSize)
+	for {
+		rec, err := read()
+		if err != nil {
+			return err
+		}
+		if !rec.End {
+			batch = append(batch, rec)
+		}
+		if len(batch) == importBatchSize || (rec.End && len(batch) > 0) {
+			if err := db.importBatch(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+		if rec.End {
+			return nil
+		}
+	}
+}
+
+// importBatch stores records and logs them as a single WAL entry
+func (db *DB) importBatch(records []dumpRecord) error {
+	db.lock()
+	defer db.mutex.Unlock()
+
+	txID := atomic.AddUint64(&db.txCounter, 1)
+	now := time.Now()
+	cmds := make([]Command, 0, len(records))
+	var err error
+	for _, rec := range records {
+		if !rec.ExpireAt.IsZero() && !rec.ExpireAt.After(now) {
+			continue
+		}
+		if err = db.checkMemoryLimit(valueSize(rec.Value)); err != nil {
+			break
+		}
+		if err = db.putEntry(rec.Key, Entry{Type: rec.Type, Value: rec.Value, Version: txID}); err != nil {
+			break
+		}
+		if rec.ExpireAt.IsZero() {
+			delete(db.expires, rec.Key)
+		} else {
+			db.expires[rec.Key] = rec.ExpireAt
+		}
+		cmds = append(cmds, Command{
+			Op:       opFromType(rec.Type),
+			Key:      rec.Key,
+			Value:    rec.Value,
+			Version:  txID,
+			Type:     rec.Type,
+			ExpireAt: rec.ExpireAt,
+		})
+	}
+
+	// Log what was stored even if the batch stopped short
+	if len(cmds) > 0 {
+		if werr := db.writeWAL(WALEntry{TxID: txID, Commands: cmds}); werr != nil {
+			return fmt.Errorf("failed to write WAL: %w", werr)
+		}
+		if werr := db.maybeCheckpoint(); werr != nil {
+			return werr
+		}
+	}
+	return err
+}
+
+// checkDumpValue checks that value is the persisted form of a value of typ
+func checkDumpValue(typ DataType, value interface{}) error {
+	var ok bool
+	switch typ {
+	case String, JSON:
+		_, ok = value.(string)
+	case List:
+		_, ok = value.([]string)
+	case Hash:
+		_, ok = value.(map[string]string)
+	case Set:
+		_, ok = value.(map[string]struct{})
+	case ZSet:
+		_, ok = value.([]ZSetMember)
+	default:
+		return ErrInvalidType
+	}
+	if !ok {
+		return ErrInvalidValue
+	}
+	return nil
+}
+
+// toJSONRecord converts a record to its JSON lines form
+func toJSONRecord(rec dumpRecord) (jsonRecord, error) {
+	line := jsonRecord{Key: rec.Key, Type: rec.Type.String()}
+	if !rec.ExpireAt.IsZero() {
+		line.ExpiresAt = &rec.ExpireAt
+	}
+
+	var value interface{} = rec.Value
+	switch rec.Type {
+	case Set:
+		members := make([]string, 0, len(rec.Value.(map[string]struct{})))
+		for member := range rec.Value.(map[string]struct{}) {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		value = members
+	case JSON:
+		value = json.RawMessage(rec.Value.(string))
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return jsonRecord{}, err
+	}
+	line.Value = data
+	return line, nil
+}
+
+// fromJSONRecord converts a JSON lines record back to a record
+func fromJSONRecord(line jsonRecord) (dumpRecord, error) {
+	typ, ok := ParseDataType(line.Type)
+	if !ok {
+		return dumpRecord{}, fmt.Errorf("%w: key %q has type %q", ErrInvalidType, line.Key, line.Type)
+	}
+	rec := dumpRecord{Key: line.Key, Type: typ}
+	if line.ExpiresAt != nil {
+		rec.ExpireAt = *line.ExpiresAt
+	}
+
+	var err error
+	switch typ {
+	case String:
+		var s string
+		err = json.Unmarshal(line.Value, &s)
+		rec.Value = s
+	case List:
+		var list []string
+		err = json.Unmarshal(line.Value, &list)
+		rec.Value = list
+	case Hash:
+		hash := make(map[string]string)
+		err = json.Unmarshal(line.Value, &hash)
+		rec.Value = hash
+	case Set:
+		var members []string
+		err = json.Unmarshal(line.Value, &members)
+		set := make(map[string]struct{}, len(members))
+		for _, member := range members {
+			set[member] = struct{}{}
+		}
+		rec.Value = set
+	case ZSet:
+		var members []ZSetMember
+		err = json.Unmarshal(line.Value, &members)
+		rec.Value = members
+	case JSON:
+		var doc interface{}
+		if doc, err = decodeJSON(string(line.Value)); err == nil {
+			rec.Value, err = encodeJSON(doc)
+		}
+	}
+	if err != nil {
+		return dumpRecord{}, fmt.Errorf("%w: key %q: %v", ErrInvalidValue, line.Key, err)
+	}
+	return rec, nil
+}
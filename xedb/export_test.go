@@ -0,0 +1,98 @@
+package xedb_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_ExportImport(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	require.NoError(t, db.String("str").Set("hello"))
+	require.NoError(t, db.String("session").SetWithTTL("token", time.Hour))
+	require.NoError(t, db.List("list").Push("a", "b"))
+	require.NoError(t, db.List("empty").Push("x"))
+	db.List("empty").Pop()
+	require.NoError(t, db.Hash("hash").Set("f", "v"))
+	require.NoError(t, db.Set("set").Add("m1", "m2"))
+	require.NoError(t, db.ZSet("zset").Add(2.5, "alice"))
+	require.NoError(t, db.JSON("doc").Set("", map[string]interface{}{"n": 1, "tags": []string{"x"}}))
+	for i := 0; i < 600; i++ {
+		require.NoError(t, db.String(fmt.Sprintf("bulk:%03d", i)).Set(fmt.Sprint(i)))
+	}
+
+	check := func(t *testing.T, db *xedb.DB) {
+		assert.Equal(t, 608, db.Len())
+		val, _ := db.String("str").Get()
+		assert.Equal(t, "hello", val)
+		ttl, ok := db.String("session").TTL()
+		assert.True(t, ok)
+		assert.Greater(t, ttl, 59*time.Minute)
+		assert.Equal(t, []string{"a", "b"}, db.List("list").Range(0, -1))
+		typ, ok := db.Type("empty")
+		assert.True(t, ok)
+		assert.Equal(t, xedb.List, typ)
+		field, _ := db.Hash("hash").Get("f")
+		assert.Equal(t, "v", field)
+		assert.True(t, db.Set("set").IsMember("m2"))
+		assert.Equal(t, []xedb.ZSetMember{{Member: "alice", Score: 2.5}}, db.ZSet("zset").Range(0, -1))
+		tag, err := db.JSON("doc").Get("tags[0]")
+		require.NoError(t, err)
+		assert.Equal(t, "x", tag)
+		val, _ = db.String("bulk:599").Get()
+		assert.Equal(t, "599", val)
+	}
+
+	for _, format := range []xedb.DumpFormat{xedb.JSONLines, xedb.BinaryDump} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, db.Export(&buf, format))
+
+			target, cleanup := setupTestDB(t)
+			defer cleanup()
+			require.NoError(t, target.String("str").Set("old"))
+			require.NoError(t, target.String("other").Set("kept"))
+			require.NoError(t, target.Import(bytes.NewReader(buf.Bytes()), format))
+
+			_, err := target.Delete("other")
+			require.NoError(t, err)
+			check(t, target)
+		})
+	}
+
+	t.Run("JSON Lines", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, db.Export(&buf, xedb.JSONLines))
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		require.Len(t, lines, 608)
+
+		// Keys come in order, documents are embedded as they are
+		var first map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+		assert.Equal(t, "bulk:000", first["key"])
+		assert.Contains(t, lines, `{"key":"doc","type":"json","value":{"n":1,"tags":["x"]}}`)
+	})
+
+	t.Run("Invalid Input", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, db.Export(&buf, xedb.BinaryDump))
+		truncated := buf.Bytes()[:buf.Len()-10]
+		assert.ErrorIs(t, db.Import(bytes.NewReader(truncated), xedb.BinaryDump), xedb.ErrCorrupted)
+		assert.ErrorIs(t, db.Import(strings.NewReader("nope"), xedb.BinaryDump), xedb.ErrCorrupted)
+
+		err := db.Import(strings.NewReader(`{"key":"k","type":"bogus","value":1}`), xedb.JSONLines)
+		assert.ErrorIs(t, err, xedb.ErrInvalidType)
+		err = db.Import(strings.NewReader(`{"key":"k","type":"list","value":"x"}`), xedb.JSONLines)
+		assert.ErrorIs(t, err, xedb.ErrInvalidValue)
+		assert.ErrorIs(t, db.Export(&buf, "xml"), xedb.ErrInvalidValue)
+	})
+}
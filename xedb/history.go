@@ -0,0 +1,121 @@
+package xedb
+
+import (
+	"time"
+
+	"github.com/seefs001/xox/xerror"
+)
+
+// ErrVersionNotFound is returned when a version is not in the history of a key
+var ErrVersionNotFound = xerror.New("version not found")
+
+// versionsOf returns the current value of entry followed by its version
+// history, newest first. The values are shared with the stored entry, so
+// callers copy the ones they return.
+func versionsOf(entry Entry) []VersionedEntry {
+	versions := make([]VersionedEntry, 0, len(entry.Versions)+1)
+	versions = append(versions, VersionedEntry{
+		Value:       entry.Value,
+		Version:     entry.Version,
+		Created:     entry.Created,
+		LastUpdated: entry.LastUpdated,
+	})
+	return append(versions, entry.Versions...)
+}
+
+// versionAt returns the newest version of entry not newer than version
+func versionAt(entry Entry, version uint64) (VersionedEntry, bool) {
+	for _, v := range versionsOf(entry) {
+		if v.Version <= version {
+			return v, true
+		}
+	}
+	return VersionedEntry{}, false
+}
+
+// versionAsOf returns the version of entry that was current at t
+func versionAsOf(entry Entry, t time.Time) (VersionedEntry, bool) {
+	for _, v := range versionsOf(entry) {
+		if !v.LastUpdated.After(t) {
+			return v, true
+		}
+	}
+	return VersionedEntry{}, false
+}
+
+// GetAt returns the value the key held at version, that is the newest stored
+// version not newer than it. Versions older than the retained history and
+// versions of deleted keys are not found.
+func (op *keyOp) GetAt(version uint64) (VersionedEntry, bool) {
+	defer op.db.observe("Key.GetAt", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.get(op.key)
+	if !ok {
+		return VersionedEntry{}, false
+	}
+	v, ok := versionAt(entry, version)
+	return copyVersion(v), ok
+}
+
+// GetAsOf returns the value the key held at t
+func (op *keyOp) GetAsOf(t time.Time) (VersionedEntry, bool) {
+	defer op.db.observe("Key.GetAsOf", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.get(op.key)
+	if !ok {
+		return VersionedEntry{}, false
+	}
+	v, ok := versionAsOf(entry, t)
+	return copyVersion(v), ok
+}
+
+// History returns the current value of the key followed by its retained
+// versions, newest first, or nil if the key does not exist
+func (op *keyOp) History() []VersionedEntry {
+	defer op.db.observe("Key.History", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.get(op.key)
+	if !ok {
+		return nil
+	}
+	versions := versionsOf(entry)
+	for i, v := range versions {
+		versions[i] = copyVersion(v)
+	}
+	return versions
+}
+
+// Rollback writes the value the key held at version back as a new version,
+// keeping the history in between. The version must be in the history.
+func (op *keyOp) Rollback(version uint64) error {
+	defer op.db.observe("Key.Rollback", op.key, time.Now())
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	op.db.expireIfNeeded(op.key)
+	entry, ok := op.db.engine.Get(op.key)
+	if !ok {
+		return ErrKeyNotFound
+	}
+
+	for _, v := range versionsOf(entry) {
+		if v.Version != version {
+			continue
+		}
+		if v.Version == entry.Version {
+			return nil
+		}
+		value := copyValue(v.Value)
+		if err := op.db.checkMemoryLimit(valueSize(value)); err != nil {
+			return err
+		}
+		return op.db.writeValue(op.key, entry.Type, storedValue(entry.Type, value))
+	}
+	return ErrVersionNotFound
+}
@@ -0,0 +1,170 @@
+package xedb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tick makes sure consecutive writes get distinct timestamps and returns a
+// time between them
+func tick() time.Time {
+	time.Sleep(2 * time.Millisecond)
+	t := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	return t
+}
+
+func TestDB_History(t *testing.T) {
+	db, cleanup := setupPubSubDB(t, xedb.WithHistory(true))
+	defer cleanup()
+
+	beforeCreate := tick()
+	require.NoError(t, db.String("config").Set("v1"))
+	afterV1 := tick()
+	require.NoError(t, db.String("config").Set("v2"))
+	tick()
+	require.NoError(t, db.String("config").Set("v3"))
+
+	history := db.String("config").History()
+	require.Len(t, history, 3)
+	assert.Equal(t, "v3", history[0].Value)
+	assert.Equal(t, "v2", history[1].Value)
+	assert.Equal(t, "v1", history[2].Value)
+
+	t.Run("GetAt", func(t *testing.T) {
+		v, ok := db.String("config").GetAt(history[1].Version)
+		require.True(t, ok)
+		assert.Equal(t, "v2", v.Value)
+
+		// Versions in between resolve to the value current at that point
+		v, ok = db.String("config").GetAt(history[0].Version - 1)
+		require.True(t, ok)
+		assert.Equal(t, "v2", v.Value)
+
+		_, ok = db.String("config").GetAt(history[2].Version - 1)
+		assert.False(t, ok)
+		_, ok = db.String("missing").GetAt(history[0].Version)
+		assert.False(t, ok)
+	})
+
+	t.Run("GetAsOf", func(t *testing.T) {
+		v, ok := db.String("config").GetAsOf(afterV1)
+		require.True(t, ok)
+		assert.Equal(t, "v1", v.Value)
+
+		v, ok = db.String("config").GetAsOf(time.Now())
+		require.True(t, ok)
+		assert.Equal(t, "v3", v.Value)
+
+		_, ok = db.String("config").GetAsOf(beforeCreate)
+		assert.False(t, ok)
+	})
+
+	t.Run("Other Types", func(t *testing.T) {
+		require.NoError(t, db.Hash("user").Set("name", "alice"))
+		require.NoError(t, db.Hash("user").Set("name", "bob"))
+		history := db.Hash("user").History()
+		require.Len(t, history, 2)
+		assert.Equal(t, map[string]string{"name": "alice"}, history[1].Value)
+
+		require.NoError(t, db.List("queue").Push("a", "b"))
+		_, ok := db.List("queue").Pop()
+		require.True(t, ok)
+		require.NoError(t, db.List("queue").Push("c"))
+		history = db.List("queue").History()
+		require.Len(t, history, 3)
+		assert.Equal(t, []string{"a", "c"}, history[0].Value)
+		assert.Equal(t, []string{"a"}, history[1].Value)
+		assert.Equal(t, []string{"a", "b"}, history[2].Value)
+
+		require.NoError(t, db.Set("tags").Add("go"))
+		require.NoError(t, db.Set("tags").Add("db"))
+		history = db.Set("tags").History()
+		require.Len(t, history, 2)
+		assert.Len(t, history[1].Value, 1)
+
+		require.NoError(t, db.ZSet("scores").Add(1, "alice"))
+		require.NoError(t, db.ZSet("scores").Add(2, "alice"))
+		history = db.ZSet("scores").History()
+		require.Len(t, history, 2)
+		assert.Equal(t, []xedb.ZSetMember{{Member: "alice", Score: 1}}, history[1].Value)
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		require.NoError(t, db.String("balance").Set("100"))
+		require.NoError(t, db.Update(func(txn *xedb.Txn) error {
+			return txn.Set("balance", xedb.Entry{Type: xedb.String, Value: "80"})
+		}))
+		require.NoError(t, db.String("balance").Set("90"))
+
+		// Transactions and plain writes share one history
+		history := db.String("balance").History()
+		require.Len(t, history, 3)
+		assert.Equal(t, "90", history[0].Value)
+		assert.Equal(t, "80", history[1].Value)
+		assert.Equal(t, "100", history[2].Value)
+	})
+
+	t.Run("Type Change Starts A New History", func(t *testing.T) {
+		require.NoError(t, db.String("mixed").Set("x"))
+		require.NoError(t, db.List("mixed").Push("y"))
+		assert.Len(t, db.List("mixed").History(), 1)
+	})
+}
+
+func TestDB_HistoryDisabled(t *testing.T) {
+	db, cleanup := setupPubSubDB(t)
+	defer cleanup()
+
+	require.NoError(t, db.String("balance").Set("100"))
+	require.NoError(t, db.Update(func(txn *xedb.Txn) error {
+		return txn.Set("balance", xedb.Entry{Type: xedb.String, Value: "80"})
+	}))
+	require.Len(t, db.String("balance").History(), 1)
+}
+
+func TestDB_Rollback(t *testing.T) {
+	db, cleanup := setupPubSubDB(t, xedb.WithHistory(true))
+	defer cleanup()
+
+	require.NoError(t, db.Hash("settings").Set("mode", "safe"))
+	good := db.Hash("settings").History()[0].Version
+	require.NoError(t, db.Hash("settings").Set("mode", "broken"))
+
+	require.NoError(t, db.Hash("settings").Rollback(good))
+	mode, _ := db.Hash("settings").Get("mode")
+	assert.Equal(t, "safe", mode)
+
+	// The rollback is a new version, so the broken value stays auditable
+	history := db.Hash("settings").History()
+	require.Len(t, history, 3)
+	assert.Greater(t, history[0].Version, history[1].Version)
+	assert.Equal(t, map[string]string{"mode": "broken"}, history[1].Value)
+
+	assert.ErrorIs(t, db.Hash("settings").Rollback(good+1000), xedb.ErrVersionNotFound)
+	assert.ErrorIs(t, db.String("missing").Rollback(good), xedb.ErrKeyNotFound)
+}
+
+func TestDB_IteratorAsOf(t *testing.T) {
+	db, cleanup := setupPubSubDB(t, xedb.WithHistory(true))
+	defer cleanup()
+
+	require.NoError(t, db.String("acct:1").Set("100"))
+	require.NoError(t, db.String("acct:2").Set("200"))
+	audit := tick()
+	require.NoError(t, db.String("acct:1").Set("50"))
+	require.NoError(t, db.String("acct:3").Set("300"))
+
+	it := db.NewIterator(xedb.IteratorOptions{Prefix: "acct:", AsOf: audit})
+	var keys []string
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		require.NotNil(t, item)
+		keys = append(keys, item.Value.(string))
+	}
+	assert.Equal(t, []string{"100", "200"}, keys)
+}
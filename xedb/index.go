@@ -0,0 +1,228 @@
+package xedb
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seefs001/xox/xerror"
+)
+
+var (
+	ErrIndexExists   = xerror.New("index already exists")
+	ErrIndexNotFound = xerror.New("index not found")
+)
+
+// Logged operations that create and drop an index. The command key is the
+// index name, the field is the hash field and the value is the key prefix.
+const (
+	opCreateIndex = "CREATEINDEX"
+	opDropIndex   = "DROPINDEX"
+)
+
+// IndexInfo describes a secondary index on a hash field
+type IndexInfo struct {
+	Name      string
+	KeyPrefix string
+	Field     string
+	// Keys is the number of keys holding the field
+	Keys int
+}
+
+// hashIndex maps the values of a field to the hashes holding it, for hashes
+// whose key starts with prefix. It is guarded by db.mutex.
+type hashIndex struct {
+	prefix string
+	field  string
+	// values holds the indexed value of every key, so in-place updates of a
+	// hash can be detected when it is stored again
+	values map[string]string
+	// keys holds the keys for every indexed value
+	keys map[string]map[string]struct{}
+	// numbers orders the keys whose value is a number, scored by that number
+	numbers *sortedSet
+}
+
+// newHashIndex returns an empty index on field for keys starting with prefix
+func newHashIndex(prefix, field string) *hashIndex {
+	return &hashIndex{
+		prefix:  prefix,
+		field:   field,
+		values:  make(map[string]string),
+		keys:    make(map[string]map[string]struct{}),
+		numbers: newSortedSet(nil),
+	}
+}
+
+// update indexes key after entry was stored under it
+func (idx *hashIndex) update(key string, entry Entry) {
+	if !strings.HasPrefix(key, idx.prefix) {
+		return
+	}
+	var value string
+	var ok bool
+	if entry.Type == Hash {
+		value, ok = entry.Value.(map[string]string)[idx.field]
+	}
+	if old, indexed := idx.values[key]; indexed && ok && old == value {
+		return
+	}
+	idx.remove(key)
+	if !ok {
+		return
+	}
+
+	idx.values[key] = value
+	keys, exists := idx.keys[value]
+	if !exists {
+		keys = make(map[string]struct{})
+		idx.keys[value] = keys
+	}
+	keys[key] = struct{}{}
+	if n, err := strconv.ParseFloat(value, 64); err == nil && !math.IsNaN(n) {
+		idx.numbers.add(key, n)
+	}
+}
+
+// remove drops key from the index
+func (idx *hashIndex) remove(key string) {
+	value, ok := idx.values[key]
+	if !ok {
+		return
+	}
+	delete(idx.values, key)
+	delete(idx.keys[value], key)
+	if len(idx.keys[value]) == 0 {
+		delete(idx.keys, value)
+	}
+	idx.numbers.remove(key)
+}
+
+// CreateIndex creates an index named name on field of the hashes whose key starts
+// with keyPrefix. The index is built from the existing data and kept up to date
+// by every later write.
+func (db *DB) CreateIndex(name, keyPrefix, field string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, ok := db.indexes[name]; ok {
+		return ErrIndexExists
+	}
+	db.createIndex(name, keyPrefix, field)
+	return db.logCommand(Command{Op: opCreateIndex, Key: name, Field: field, Value: keyPrefix})
+}
+
+// DropIndex removes the index named name
+func (db *DB) DropIndex(name string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, ok := db.indexes[name]; !ok {
+		return ErrIndexNotFound
+	}
+	delete(db.indexes, name)
+	return db.logCommand(Command{Op: opDropIndex, Key: name})
+}
+
+// createIndex adds an index and builds it from the current data.
+// Caller must hold db.mutex for writing.
+func (db *DB) createIndex(name, keyPrefix, field string) {
+	idx := newHashIndex(keyPrefix, field)
+	db.engine.Range(func(key string, entry Entry) bool {
+		idx.update(key, entry)
+		return true
+	})
+	db.indexes[name] = idx
+}
+
+// indexEntry updates every index after entry was stored under key.
+// Caller must hold db.mutex for writing.
+func (db *DB) indexEntry(key string, entry Entry) {
+	for _, idx := range db.indexes {
+		idx.update(key, entry)
+	}
+}
+
+// unindexKey removes key from every index. Caller must hold db.mutex for writing.
+func (db *DB) unindexKey(key string) {
+	for _, idx := range db.indexes {
+		idx.remove(key)
+	}
+}
+
+// resetIndexes replaces the index definitions and rebuilds them from db.data.
+// Caller must hold db.mutex for writing or own db exclusively.
+func (db *DB) resetIndexes(defs []IndexInfo) {
+	db.indexes = make(map[string]*hashIndex, len(defs))
+	for _, def := range defs {
+		db.createIndex(def.Name, def.KeyPrefix, def.Field)
+	}
+}
+
+// indexInfos returns the definitions of every index ordered by name.
+// Caller must hold db.mutex.
+func (db *DB) indexInfos() []IndexInfo {
+	infos := make([]IndexInfo, 0, len(db.indexes))
+	for _, name := range sortedKeys(db.indexes) {
+		idx := db.indexes[name]
+		infos = append(infos, IndexInfo{
+			Name:      name,
+			KeyPrefix: idx.prefix,
+			Field:     idx.field,
+			Keys:      len(idx.values),
+		})
+	}
+	return infos
+}
+
+// Indexes returns every index ordered by name
+func (db *DB) Indexes() []IndexInfo {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.indexInfos()
+}
+
+// QueryIndex returns the keys, in lexical order, whose indexed field equals value
+func (db *DB) QueryIndex(name, value string) ([]string, error) {
+	defer db.observe("QueryIndex", "", time.Now())
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	idx, ok := db.indexes[name]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+	now := time.Now()
+	result := make([]string, 0, len(idx.keys[value]))
+	for key := range idx.keys[value] {
+		if !db.isExpired(key, now) {
+			result = append(result, key)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// QueryIndexRange returns the keys whose indexed field is a number with
+// min <= value <= max, ordered by value. Values that are not numbers never match.
+func (db *DB) QueryIndexRange(name string, min, max float64) ([]string, error) {
+	defer db.observe("QueryIndexRange", "", time.Now())
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	idx, ok := db.indexes[name]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+	now := time.Now()
+	members := idx.numbers.rangeByScore(min, max)
+	result := make([]string, 0, len(members))
+	for _, m := range members {
+		if !db.isExpired(m.Member, now) {
+			result = append(result, m.Member)
+		}
+	}
+	return result, nil
+}
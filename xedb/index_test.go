@@ -0,0 +1,156 @@
+package xedb_test
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queryIndex(t *testing.T, db *xedb.DB, name, value string) []string {
+	t.Helper()
+	keys, err := db.QueryIndex(name, value)
+	require.NoError(t, err)
+	return keys
+}
+
+func TestDB_Index(t *testing.T) {
+	db, cleanup := setupPubSubDB(t)
+	defer cleanup()
+
+	require.NoError(t, db.Hash("user:1").SetWithTTL(map[string]string{"status": "active", "age": "31"}, time.Hour))
+	require.NoError(t, db.Hash("user:2").Set("status", "inactive"))
+	require.NoError(t, db.Hash("order:1").Set("status", "active"))
+
+	// Existing hashes are indexed when the index is created
+	require.NoError(t, db.CreateIndex("by_status", "user:", "status"))
+	require.NoError(t, db.CreateIndex("by_age", "user:", "age"))
+	assert.ErrorIs(t, db.CreateIndex("by_status", "user:", "status"), xedb.ErrIndexExists)
+	assert.Equal(t, []string{"user:1"}, queryIndex(t, db, "by_status", "active"))
+
+	t.Run("Writes", func(t *testing.T) {
+		require.NoError(t, db.Hash("user:3").Set("status", "active"))
+		require.NoError(t, db.Hash("user:2").Set("status", "active"))
+		assert.Equal(t, []string{"user:1", "user:2", "user:3"}, queryIndex(t, db, "by_status", "active"))
+		assert.Empty(t, queryIndex(t, db, "by_status", "inactive"))
+
+		_, err := db.Hash("user:3").HDel("status")
+		require.NoError(t, err)
+		_, err = db.Delete("user:2")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"user:1"}, queryIndex(t, db, "by_status", "active"))
+
+		// Replacing a hash with another type drops it from the index
+		require.NoError(t, db.String("user:4").Set("x"))
+		require.NoError(t, db.Hash("user:5").Set("status", "active"))
+		require.NoError(t, db.String("user:5").Set("status=active"))
+		assert.Equal(t, []string{"user:1"}, queryIndex(t, db, "by_status", "active"))
+	})
+
+	t.Run("Numeric Range", func(t *testing.T) {
+		require.NoError(t, db.Hash("user:6").Set("age", "19"))
+		require.NoError(t, db.Hash("user:7").Set("age", "45.5"))
+		require.NoError(t, db.Hash("user:8").Set("age", "unknown"))
+		_, err := db.Hash("user:6").HIncrBy("age", 1)
+		require.NoError(t, err)
+
+		keys, err := db.QueryIndexRange("by_age", 20, 40)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"user:6", "user:1"}, keys)
+		keys, err = db.QueryIndexRange("by_age", math.Inf(-1), math.Inf(1))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"user:6", "user:1", "user:7"}, keys)
+		assert.Equal(t, []string{"user:8"}, queryIndex(t, db, "by_age", "unknown"))
+	})
+
+	t.Run("Expired Keys", func(t *testing.T) {
+		require.NoError(t, db.Hash("user:9").SetWithTTL(map[string]string{"status": "active"}, 20*time.Millisecond))
+		assert.Contains(t, queryIndex(t, db, "by_status", "active"), "user:9")
+		time.Sleep(30 * time.Millisecond)
+		assert.NotContains(t, queryIndex(t, db, "by_status", "active"), "user:9")
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		require.NoError(t, db.DropIndex("by_age"))
+		assert.ErrorIs(t, db.DropIndex("by_age"), xedb.ErrIndexNotFound)
+		_, err := db.QueryIndexRange("by_age", 0, 100)
+		assert.ErrorIs(t, err, xedb.ErrIndexNotFound)
+
+		indexes := db.Indexes()
+		require.Len(t, indexes, 1)
+		assert.Equal(t, "by_status", indexes[0].Name)
+		assert.Equal(t, "user:", indexes[0].KeyPrefix)
+		assert.Equal(t, "status", indexes[0].Field)
+	})
+}
+
+func TestDB_IndexTransaction(t *testing.T) {
+	db, cleanup := setupPubSubDB(t)
+	defer cleanup()
+	require.NoError(t, db.CreateIndex("by_status", "user:", "status"))
+
+	txn := db.NewTransaction(true)
+	require.NoError(t, txn.Set("user:1", xedb.Entry{Type: xedb.Hash, Value: map[string]string{"status": "active"}}))
+	require.NoError(t, txn.Set("user:2", xedb.Entry{Type: xedb.Hash, Value: map[string]string{"status": "pending"}}))
+	assert.Empty(t, queryIndex(t, db, "by_status", "active"))
+
+	require.NoError(t, txn.Commit())
+	assert.Equal(t, []string{"user:1"}, queryIndex(t, db, "by_status", "active"))
+	assert.Equal(t, []string{"user:2"}, queryIndex(t, db, "by_status", "pending"))
+}
+
+func TestDB_IndexRecovery(t *testing.T) {
+	dir := t.TempDir()
+	open := func(opts ...xedb.Option) *xedb.DB {
+		db, err := xedb.New(append([]xedb.Option{xedb.WithDataDir(dir), xedb.WithSyncWrite(false)}, opts...)...)
+		require.NoError(t, err)
+		return db
+	}
+
+	db := open()
+	require.NoError(t, db.Hash("user:1").Set("status", "active"))
+	require.NoError(t, db.CreateIndex("by_status", "user:", "status"))
+	stale, err := os.ReadFile(filepath.Join(dir, "data.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.Hash("user:1").Set("status", "inactive"))
+	require.NoError(t, db.Hash("user:2").Set("status", "active"))
+	crash(t, db, dir)
+
+	t.Run("WAL Replay", func(t *testing.T) {
+		// The data file predates the last writes, which are recovered from the WAL
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "data.db"), stale, 0644))
+		db := open()
+		defer db.Close()
+		assert.Equal(t, []string{"user:2"}, queryIndex(t, db, "by_status", "active"))
+		assert.Equal(t, []string{"user:1"}, queryIndex(t, db, "by_status", "inactive"))
+	})
+
+	t.Run("AOF Rewrite", func(t *testing.T) {
+		db := openAOFDB(t, dir)
+		require.NoError(t, db.RewriteAOF())
+		require.NoError(t, db.Close())
+
+		dropSnapshot(t, dir)
+		db = openAOFDB(t, dir)
+		defer db.Close()
+		assert.Equal(t, []string{"user:2"}, queryIndex(t, db, "by_status", "active"))
+	})
+
+	t.Run("Snapshot Restore", func(t *testing.T) {
+		src := open()
+		defer src.Close()
+		var buf bytes.Buffer
+		require.NoError(t, src.Snapshot(&buf))
+
+		dst, cleanup := setupPubSubDB(t)
+		defer cleanup()
+		require.NoError(t, dst.Restore(&buf))
+		assert.Equal(t, []string{"user:2"}, queryIndex(t, dst, "by_status", "active"))
+	})
+}
@@ -0,0 +1,227 @@
+package xedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/seefs001/xox/xerror"
+	"github.com/seefs001/xox/xjson"
+)
+
+// ErrInvalidPath is returned when a JSON path does not resolve in a document
+var ErrInvalidPath = xerror.New("invalid JSON path")
+
+// JSON documents are stored in their compact encoded form, so they persist,
+// replicate and keep their version history like strings. Paths follow xjson:
+// keys are separated by dots and array elements are addressed as "key[i]",
+// e.g. "user.tags[0]". The empty path is the whole document.
+
+// decodeJSON parses a stored document, keeping numbers exact
+func decodeJSON(doc string) (interface{}, error) {
+	d := json.NewDecoder(strings.NewReader(doc))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	return v, nil
+}
+
+// encodeJSON returns the stored form of a document
+func encodeJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	return string(data), nil
+}
+
+// toJSONValue converts a Go value into the generic form documents are made of
+func toJSONValue(v interface{}) (interface{}, error) {
+	doc, err := encodeJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(doc)
+}
+
+// lookupPath returns the value at path in doc
+func lookupPath(doc interface{}, path string) (interface{}, error) {
+	value, err := xjson.Lookup(doc, xjson.JSONPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPath, err)
+	}
+	return value, nil
+}
+
+// update applies fn to the document and stores the result. fn is given a nil
+// document if the key does not exist.
+func (op *JSONOp) update(fn func(doc interface{}) (interface{}, error)) error {
+	op.db.lock()
+	defer op.db.mutex.Unlock()
+
+	entry, exists, err := op.db.lookup(op.key, JSON)
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+	if exists {
+		if doc, err = decodeJSON(entry.Value.(string)); err != nil {
+			return err
+		}
+	}
+	if doc, err = fn(doc); err != nil {
+		return err
+	}
+
+	value, err := encodeJSON(doc)
+	if err != nil {
+		return err
+	}
+	if err := op.db.checkMemoryLimit(int64(len(value))); err != nil {
+		return err
+	}
+	return op.db.writeValue(op.key, JSON, value)
+}
+
+// Set sets the value at path to value encoded with encoding/json. The empty
+// path replaces the whole document, creating the key; any other path needs an
+// existing document, and only its last key is created if missing.
+func (op *JSONOp) Set(path string, value interface{}) error {
+	defer op.db.observe("JSON.Set", op.key, time.Now())
+
+	v, err := toJSONValue(value)
+	if err != nil {
+		return err
+	}
+	return op.update(func(doc interface{}) (interface{}, error) {
+		if doc == nil && path != "" {
+			return nil, ErrKeyNotFound
+		}
+		doc, err := xjson.Set(doc, xjson.JSONPath(path), v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPath, err)
+		}
+		return doc, nil
+	})
+}
+
+// Get returns the value at path as decoded by encoding/json into an interface{}
+func (op *JSONOp) Get(path string) (interface{}, error) {
+	var v interface{}
+	if err := op.GetInto(path, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// GetInto decodes the value at path into v with encoding/json
+func (op *JSONOp) GetInto(path string, v interface{}) error {
+	defer op.db.observe("JSON.Get", op.key, time.Now())
+	op.db.mutex.RLock()
+	defer op.db.mutex.RUnlock()
+
+	entry, ok := op.db.get(op.key)
+	if !ok {
+		return ErrKeyNotFound
+	}
+	if entry.Type != JSON {
+		return ErrTypeMismatch
+	}
+
+	doc, err := decodeJSON(entry.Value.(string))
+	if err != nil {
+		return err
+	}
+	value, err := lookupPath(doc, path)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ArrInsert inserts values into the array at path before index and returns
+// the new length of the array. A negative index counts from the end, and an
+// index equal to the length appends.
+func (op *JSONOp) ArrInsert(path string, index int, values ...interface{}) (int, error) {
+	defer op.db.observe("JSON.ArrInsert", op.key, time.Now())
+
+	elems := make([]interface{}, len(values))
+	for i, value := range values {
+		v, err := toJSONValue(value)
+		if err != nil {
+			return 0, err
+		}
+		elems[i] = v
+	}
+
+	var length int
+	err := op.update(func(doc interface{}) (interface{}, error) {
+		if doc == nil {
+			return nil, ErrKeyNotFound
+		}
+		value, err := lookupPath(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		arr, ok := value.([]interface{})
+		if !ok {
+			return nil, ErrTypeMismatch
+		}
+
+		i := index
+		if i < 0 {
+			i += len(arr)
+		}
+		if i < 0 || i > len(arr) {
+			return nil, fmt.Errorf("%w: index %d out of range", ErrInvalidValue, index)
+		}
+		result := make([]interface{}, 0, len(arr)+len(elems))
+		result = append(append(append(result, arr[:i]...), elems...), arr[i:]...)
+		length = len(result)
+		return xjson.Set(doc, xjson.JSONPath(path), result)
+	})
+	return length, err
+}
+
+// NumIncrBy adds delta to the number at path and returns the result
+func (op *JSONOp) NumIncrBy(path string, delta float64) (float64, error) {
+	defer op.db.observe("JSON.NumIncrBy", op.key, time.Now())
+
+	var n float64
+	err := op.update(func(doc interface{}) (interface{}, error) {
+		if doc == nil {
+			return nil, ErrKeyNotFound
+		}
+		value, err := lookupPath(doc, path)
+		if err != nil {
+			return nil, err
+		}
+
+		switch v := value.(type) {
+		case json.Number:
+			if n, err = v.Float64(); err != nil {
+				return nil, ErrInvalidValue
+			}
+		case float64:
+			// Array elements are looked up as float64
+			n = v
+		default:
+			return nil, ErrTypeMismatch
+		}
+		n += delta
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, ErrInvalidValue
+		}
+		return xjson.Set(doc, xjson.JSONPath(path), n)
+	})
+	return n, err
+}
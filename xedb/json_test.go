@@ -0,0 +1,139 @@
+package xedb_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_JSON(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc := db.JSON("user:1")
+	require.NoError(t, doc.Set("", map[string]interface{}{
+		"name": "alice",
+		"age":  30,
+		"tags": []string{"a", "c"},
+		"address": map[string]interface{}{
+			"city": "Paris",
+		},
+	}))
+
+	t.Run("Get", func(t *testing.T) {
+		name, err := doc.Get("name")
+		require.NoError(t, err)
+		assert.Equal(t, "alice", name)
+
+		tag, err := doc.Get("tags[1]")
+		require.NoError(t, err)
+		assert.Equal(t, "c", tag)
+
+		var address struct{ City string }
+		require.NoError(t, doc.GetInto("address", &address))
+		assert.Equal(t, "Paris", address.City)
+
+		_, err = doc.Get("address.zip")
+		assert.ErrorIs(t, err, xedb.ErrInvalidPath)
+		_, err = db.JSON("missing").Get("")
+		assert.ErrorIs(t, err, xedb.ErrKeyNotFound)
+	})
+
+	t.Run("Set Path", func(t *testing.T) {
+		require.NoError(t, doc.Set("address.zip", "75001"))
+		require.NoError(t, doc.Set("tags[0]", "b"))
+
+		zip, err := doc.Get("address.zip")
+		require.NoError(t, err)
+		assert.Equal(t, "75001", zip)
+		tags, err := doc.Get("tags")
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{"b", "c"}, tags)
+
+		assert.ErrorIs(t, doc.Set("tags[5]", "x"), xedb.ErrInvalidPath)
+		assert.ErrorIs(t, db.JSON("missing").Set("a", 1), xedb.ErrKeyNotFound)
+	})
+
+	t.Run("ArrInsert", func(t *testing.T) {
+		n, err := doc.ArrInsert("tags", 0, "a")
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		n, err = doc.ArrInsert("tags", -1, "x", "y")
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+		n, err = doc.ArrInsert("tags", 5, "z")
+		require.NoError(t, err)
+		assert.Equal(t, 6, n)
+
+		tags, err := doc.Get("tags")
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{"a", "b", "x", "y", "c", "z"}, tags)
+
+		_, err = doc.ArrInsert("tags", 7, "w")
+		assert.ErrorIs(t, err, xedb.ErrInvalidValue)
+		_, err = doc.ArrInsert("name", 0, "w")
+		assert.ErrorIs(t, err, xedb.ErrTypeMismatch)
+	})
+
+	t.Run("NumIncrBy", func(t *testing.T) {
+		n, err := doc.NumIncrBy("age", 1)
+		require.NoError(t, err)
+		assert.Equal(t, float64(31), n)
+		n, err = doc.NumIncrBy("age", -0.5)
+		require.NoError(t, err)
+		assert.Equal(t, 30.5, n)
+
+		_, err = doc.NumIncrBy("name", 1)
+		assert.ErrorIs(t, err, xedb.ErrTypeMismatch)
+	})
+
+	t.Run("Type", func(t *testing.T) {
+		typ, ok := db.Type("user:1")
+		assert.True(t, ok)
+		assert.Equal(t, xedb.JSON, typ)
+		assert.Equal(t, "json", typ.String())
+
+		require.NoError(t, db.String("plain").Set("v"))
+		assert.ErrorIs(t, db.JSON("plain").Set("", 1), xedb.ErrTypeMismatch)
+		_, err := db.JSON("plain").Get("")
+		assert.ErrorIs(t, err, xedb.ErrTypeMismatch)
+	})
+}
+
+func TestDB_JSONPersistence(t *testing.T) {
+	dir := t.TempDir()
+	db, err := xedb.New(xedb.WithDataDir(dir), xedb.WithHistory(true))
+	require.NoError(t, err)
+
+	require.NoError(t, db.JSON("cfg").Set("", map[string]interface{}{"limit": 1 << 60, "tags": []string{}}))
+	_, err = db.JSON("cfg").ArrInsert("tags", 0, "x")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = xedb.New(xedb.WithDataDir(dir), xedb.WithHistory(true))
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Integers beyond float64 precision survive updates
+	var cfg struct {
+		Limit int64
+		Tags  []string
+	}
+	require.NoError(t, db.JSON("cfg").GetInto("", &cfg))
+	assert.Equal(t, int64(1<<60), cfg.Limit)
+	assert.Equal(t, []string{"x"}, cfg.Tags)
+
+	data, err := db.ExportToJSON()
+	require.NoError(t, err)
+	var export map[string]struct {
+		Value    map[string]interface{}
+		Versions []struct{ Value map[string]interface{} }
+	}
+	require.NoError(t, json.Unmarshal([]byte(data), &export))
+	assert.Equal(t, []interface{}{"x"}, export["cfg"].Value["tags"])
+	require.Len(t, export["cfg"].Versions, 1)
+	assert.Equal(t, []interface{}{}, export["cfg"].Versions[0].Value["tags"])
+}
@@ -0,0 +1,208 @@
+package xedb
+
+import (
+	"strings"
+	"time"
+)
+
+// String returns the Redis-style name of the data type
+func (t DataType) String() string {
+	switch t {
+	case String:
+		return "string"
+	case List:
+		return "list"
+	case Hash:
+		return "hash"
+	case Set:
+		return "set"
+	case ZSet:
+		return "zset"
+	case JSON:
+		return "json"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseDataType returns the data type with the given Redis-style name
+func ParseDataType(name string) (DataType, bool) {
+	for t := String; t <= JSON; t++ {
+		if t.String() == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// Delete removes the given keys and returns how many existed
+func (db *DB) Delete(keys ...string) (int, error) {
+	defer db.observe("Delete", "", time.Now())
+	db.lock()
+	defer db.mutex.Unlock()
+
+	removed := 0
+	for _, key := range keys {
+		db.expireIfNeeded(key)
+		if !db.keyspace.contains(key) {
+			continue
+		}
+		if err := db.deleteKey(key); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Exists returns how many of the given keys exist
+func (db *DB) Exists(keys ...string) int {
+	defer db.observe("Exists", "", time.Now())
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	count := 0
+	for _, key := range keys {
+		if _, ok := db.get(key); ok {
+			count++
+		}
+	}
+	return count
+}
+
+// Type returns the data type stored at key
+func (db *DB) Type(key string) (DataType, bool) {
+	defer db.observe("Type", "", time.Now())
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	entry, ok := db.get(key)
+	return entry.Type, ok
+}
+
+// Len returns the number of live keys
+func (db *DB) Len() int {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	count := db.keyspace.lex.length
+	now := time.Now()
+	for key := range db.expires {
+		if db.isExpired(key, now) {
+			count--
+		}
+	}
+	return count
+}
+
+// Keys returns the sorted live keys matching a glob-style pattern.
+// An empty pattern matches every key.
+func (db *DB) Keys(pattern string) []string {
+	defer db.observe("Keys", "", time.Now())
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	// Only keys starting with the literal prefix of the pattern can match
+	prefix := patternPrefix(pattern)
+	keys := make([]string, 0)
+	now := time.Now()
+	for x := db.keyspace.lex.seek(0, prefix); x != nil && strings.HasPrefix(x.member, prefix); x = x.level[0].forward {
+		if db.isExpired(x.member, now) {
+			continue
+		}
+		if pattern == "" || MatchPattern(pattern, x.member) {
+			keys = append(keys, x.member)
+		}
+	}
+	return keys
+}
+
+// MatchPattern reports whether key matches a Redis-style glob pattern.
+// Supported syntax: '*' (any run), '?' (any byte), '[abc]', '[^a]', '[a-z]' and '\' escapes.
+func MatchPattern(pattern, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// Collapse consecutive stars
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if MatchPattern(pattern, key[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+
+		case '[':
+			if len(key) == 0 {
+				return false
+			}
+			end, ok := matchClass(pattern, key[0])
+			if !ok {
+				return false
+			}
+			pattern, key = pattern[end:], key[1:]
+
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(key) == 0 || pattern[0] != key[0] {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+		}
+	}
+	return len(key) == 0
+}
+
+// matchClass matches c against the character class at the start of pattern.
+// It returns the length of the class and whether c matched.
+func matchClass(pattern string, c byte) (int, bool) {
+	i := 1
+	negate := false
+	if i < len(pattern) && pattern[i] == '^' {
+		negate = true
+		i++
+	}
+
+	matched := false
+	for first := true; i < len(pattern) && (first || pattern[i] != ']'); first = false {
+		lo := pattern[i]
+		if lo == '\\' && i+1 < len(pattern) {
+			i++
+			lo = pattern[i]
+		}
+		hi := lo
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			hi = pattern[i+2]
+			i += 2
+		}
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if c >= lo && c <= hi {
+			matched = true
+		}
+		i++
+	}
+
+	// Skip the closing bracket; an unterminated class consumes the rest of the pattern
+	if i < len(pattern) {
+		i++
+	}
+	return i, matched != negate
+}
@@ -0,0 +1,62 @@
+package xedb_test
+
+import (
+	"testing"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_Keys(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	require.NoError(t, db.String("user:1").Set("alice"))
+	require.NoError(t, db.String("user:2").Set("bob"))
+	require.NoError(t, db.List("queue").Push("job"))
+	require.NoError(t, db.Hash("config").Set("mode", "fast"))
+
+	assert.Equal(t, 4, db.Len())
+	assert.Equal(t, []string{"config", "queue", "user:1", "user:2"}, db.Keys(""))
+	assert.Equal(t, []string{"user:1", "user:2"}, db.Keys("user:*"))
+	assert.Equal(t, 2, db.Exists("user:1", "queue", "missing"))
+
+	typ, exists := db.Type("queue")
+	assert.True(t, exists)
+	assert.Equal(t, xedb.List, typ)
+	assert.Equal(t, "list", typ.String())
+
+	removed, err := db.Delete("user:1", "queue", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.Equal(t, []string{"config", "user:2"}, db.Keys(""))
+	_, exists = db.Type("queue")
+	assert.False(t, exists)
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"*", "anything", true},
+		{"user:*", "user:42", true},
+		{"user:*", "session:42", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"*:*:end", "a:b:end", true},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, xedb.MatchPattern(tt.pattern, tt.key), "%s ~ %s", tt.pattern, tt.key)
+	}
+}
@@ -0,0 +1,799 @@
+package xedb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/seefs001/xox/xerror"
+)
+
+// errCompactionAborted stops a compaction when the engine closes
+var errCompactionAborted = xerror.New("compaction aborted")
+
+const (
+	lsmLogFile      = "memtable.log"
+	lsmManifestFile = "MANIFEST"
+	lsmTableExt     = ".sst"
+)
+
+// lsmManifest lists the live tables, newest first
+type lsmManifest struct {
+	Tables  []uint64
+	NextSeq uint64
+}
+
+// lsmEngine is a log-structured merge tree. Writes go to a memtable backed by
+// a log; a full memtable is flushed to a new SSTable, and once there are
+// Options.CompactionL0Trigger tables a background goroutine merges them into
+// one, dropping overwritten values and tombstones. Lookups check the memtable
+// and then the tables from newest to oldest, skipping tables whose bloom
+// filter rules the key out.
+//
+// The memtable is guarded by the DB lock like any engine state. The table list
+// is also changed by the compaction, which does not hold the DB lock, so it is
+// guarded by mutex as well.
+type lsmEngine struct {
+	dir          string
+	syncWrite    bool
+	memtableSize int64
+	trigger      int
+
+	memtable map[string]lsmRecord
+	memSize  int64
+	log      *os.File
+
+	// mutex guards tables, nextSeq and the compaction state
+	mutex   sync.RWMutex
+	tables  []*sstable
+	nextSeq uint64
+	// compacting is set while the compaction goroutine runs; idle is
+	// signalled when it stops
+	compacting bool
+	idle       *sync.Cond
+	// full asks the compaction to merge every table, however few there are
+	full bool
+	// compactErr is the error of the last failed compaction
+	compactErr error
+	closing    atomic.Bool
+
+	// errMutex guards err, the first read error met by Get, which cannot return it
+	errMutex sync.Mutex
+	err      error
+}
+
+// openLSM opens the engine in dir, replaying the memtable log
+func openLSM(dir string, options Options) (*lsmEngine, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create LSM directory: %w", err)
+	}
+
+	e := &lsmEngine{
+		dir:          dir,
+		syncWrite:    options.SyncWrite,
+		memtableSize: options.MemtableSize,
+		trigger:      options.CompactionL0Trigger,
+		memtable:     make(map[string]lsmRecord),
+		nextSeq:      1,
+	}
+	e.idle = sync.NewCond(&e.mutex)
+	if err := e.loadTables(); err != nil {
+		e.closeTables()
+		return nil, err
+	}
+	if err := e.replayLog(); err != nil {
+		e.closeTables()
+		return nil, err
+	}
+	return e, nil
+}
+
+// loadTables opens the tables in the manifest and removes any left behind by
+// an interrupted flush or compaction
+func (e *lsmEngine) loadTables() error {
+	var manifest lsmManifest
+	path := filepath.Join(e.dir, lsmManifestFile)
+	if f, err := os.Open(path); err == nil {
+		stat, err := f.Stat()
+		if err == nil {
+			_, err = readRecord(f, &manifest, stat.Size())
+		}
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read LSM manifest: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to open LSM manifest: %w", err)
+	}
+
+	live := make(map[string]bool, len(manifest.Tables))
+	for _, seq := range manifest.Tables {
+		t, err := openSSTable(e.tablePath(seq), seq)
+		if err != nil {
+			return err
+		}
+		e.tables = append(e.tables, t)
+		live[filepath.Base(t.path)] = true
+	}
+	e.nextSeq = max(manifest.NextSeq, 1)
+
+	files, err := os.ReadDir(e.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list LSM directory: %w", err)
+	}
+	for _, file := range files {
+		name := file.Name()
+		if (strings.HasSuffix(name, lsmTableExt) || strings.HasSuffix(name, ".tmp")) && !live[name] {
+			os.Remove(filepath.Join(e.dir, name))
+		}
+	}
+	return nil
+}
+
+// replayLog rebuilds the memtable from its log, truncating a torn tail, and
+// opens the log for appending
+func (e *lsmEngine) replayLog() error {
+	path := filepath.Join(e.dir, lsmLogFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open memtable log: %w", err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat memtable log: %w", err)
+	}
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		var rec lsmRecord
+		n, err := readRecord(r, &rec, stat.Size()-offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Only the last record can be damaged by an interrupted append
+			if err == errTornRecord || offset+n == stat.Size() {
+				if err := f.Truncate(offset); err != nil {
+					f.Close()
+					return fmt.Errorf("failed to truncate memtable log: %w", err)
+				}
+				break
+			}
+			f.Close()
+			return fmt.Errorf("failed to read memtable log at offset %d: %w", offset, err)
+		}
+		offset += n
+		e.applyRecord(rec)
+	}
+
+	e.log = f
+	return nil
+}
+
+func (e *lsmEngine) tablePath(seq uint64) string {
+	return filepath.Join(e.dir, fmt.Sprintf("%06d%s", seq, lsmTableExt))
+}
+
+// applyRecord puts rec into the memtable
+func (e *lsmEngine) applyRecord(rec lsmRecord) {
+	if old, ok := e.memtable[rec.Key]; ok {
+		e.memSize -= recordSize(old)
+	}
+	e.memtable[rec.Key] = rec
+	e.memSize += recordSize(rec)
+}
+
+// recordSize estimates the memory used by a memtable record
+func recordSize(rec lsmRecord) int64 {
+	if rec.Deleted {
+		return int64(len(rec.Key)) + entryOverhead
+	}
+	return entrySize(rec.Key, rec.Entry)
+}
+
+// fail records the first read error so the next write reports it
+func (e *lsmEngine) fail(err error) {
+	e.errMutex.Lock()
+	defer e.errMutex.Unlock()
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func (e *lsmEngine) readErr() error {
+	e.errMutex.Lock()
+	defer e.errMutex.Unlock()
+	return e.err
+}
+
+// Get looks key up in the memtable and then in the tables, newest first
+func (e *lsmEngine) Get(key string) (Entry, bool) {
+	rec, ok := e.memtable[key]
+	if !ok {
+		var err error
+		rec, ok, err = e.getTables(key)
+		if err != nil {
+			e.fail(err)
+			return Entry{}, false
+		}
+	}
+	if !ok || rec.Deleted {
+		return Entry{}, false
+	}
+	return importEntry(rec.Entry), true
+}
+
+// getTables looks key up in the tables, newest first. Holding mutex keeps a
+// finished compaction from closing them meanwhile.
+func (e *lsmEngine) getTables(key string) (lsmRecord, bool, error) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	for _, t := range e.tables {
+		rec, ok, err := t.get(key)
+		if err != nil {
+			return lsmRecord{}, false, fmt.Errorf("failed to read %s: %w", t.path, err)
+		}
+		if ok {
+			return rec, true, nil
+		}
+	}
+	return lsmRecord{}, false, nil
+}
+
+// importEntry converts an entry read from disk to its in-memory form
+func importEntry(entry Entry) Entry {
+	entry.Value = storedValue(entry.Type, entry.Value)
+	return entry
+}
+
+func (e *lsmEngine) Put(key string, entry Entry) error {
+	return e.write(lsmRecord{Key: key, Entry: exportEntry(entry)})
+}
+
+func (e *lsmEngine) Delete(key string) error {
+	return e.write(lsmRecord{Key: key, Deleted: true})
+}
+
+// write logs rec, applies it to the memtable and flushes the memtable once full
+func (e *lsmEngine) write(rec lsmRecord) error {
+	if err := e.readErr(); err != nil {
+		return err
+	}
+
+	record, err := encodeRecord(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+	if _, err := e.log.Write(record); err != nil {
+		return fmt.Errorf("failed to write memtable log: %w", err)
+	}
+	if e.syncWrite {
+		if err := e.log.Sync(); err != nil {
+			return fmt.Errorf("failed to sync memtable log: %w", err)
+		}
+	}
+
+	e.applyRecord(rec)
+	if e.memSize >= e.memtableSize {
+		return e.flush()
+	}
+	return nil
+}
+
+// flush writes the memtable to a new table and empties its log
+func (e *lsmEngine) flush() error {
+	if len(e.memtable) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(e.memtable))
+	for key := range e.memtable {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	t, err := e.writeTable(func(add func(lsmRecord) error) error {
+		for _, key := range keys {
+			if err := add(e.memtable[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	tables := append([]*sstable{t}, e.tables...)
+	if err := e.writeManifest(tables); err != nil {
+		e.mutex.Unlock()
+		t.close()
+		os.Remove(t.path)
+		return err
+	}
+	e.tables = tables
+	e.startCompaction()
+	e.mutex.Unlock()
+
+	// The records are in the table now
+	e.memtable = make(map[string]lsmRecord)
+	e.memSize = 0
+	if err := e.log.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate memtable log: %w", err)
+	}
+	return nil
+}
+
+// writeTable writes the records produced by fill, in key order, to a new table
+// and opens it. It returns nil if fill added nothing.
+func (e *lsmEngine) writeTable(fill func(add func(lsmRecord) error) error) (*sstable, error) {
+	e.mutex.Lock()
+	seq := e.nextSeq
+	e.nextSeq++
+	e.mutex.Unlock()
+	path := e.tablePath(seq)
+	tmp := path + ".tmp"
+
+	w, err := newSSTWriter(tmp)
+	if err != nil {
+		return nil, err
+	}
+	if err := fill(w.add); err != nil {
+		w.abort()
+		return nil, err
+	}
+	if w.meta.Count == 0 {
+		w.abort()
+		return nil, nil
+	}
+	if err := w.finish(e.syncWrite); err != nil {
+		w.abort()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to rename table: %w", err)
+	}
+	return openSSTable(path, seq)
+}
+
+// writeManifest atomically records tables as the live ones.
+// Caller must hold mutex for writing.
+func (e *lsmEngine) writeManifest(tables []*sstable) error {
+	manifest := lsmManifest{NextSeq: e.nextSeq}
+	for _, t := range tables {
+		if t != nil {
+			manifest.Tables = append(manifest.Tables, t.seq)
+		}
+	}
+
+	record, err := encodeRecord(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode LSM manifest: %w", err)
+	}
+	path := filepath.Join(e.dir, lsmManifestFile)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create LSM manifest: %w", err)
+	}
+	if _, err := f.Write(record); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write LSM manifest: %w", err)
+	}
+	if e.syncWrite {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to sync LSM manifest: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close LSM manifest: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to rename LSM manifest: %w", err)
+	}
+	return nil
+}
+
+// needsCompaction reports whether there are enough tables to merge.
+// Caller must hold mutex.
+func (e *lsmEngine) needsCompaction() bool {
+	return len(e.tables) >= 2 && (e.full || (e.trigger > 0 && len(e.tables) >= e.trigger))
+}
+
+// startCompaction starts the compaction goroutine if tables need merging and
+// it is not running yet. Caller must hold mutex for writing.
+func (e *lsmEngine) startCompaction() {
+	if e.compacting || e.closing.Load() || !e.needsCompaction() {
+		return
+	}
+	e.compacting = true
+	go e.compactLoop()
+}
+
+// compactLoop merges the live tables until no compaction is needed. Tables
+// flushed meanwhile are newer than the ones being merged, so they are left for
+// the next round.
+func (e *lsmEngine) compactLoop() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for !e.closing.Load() && e.needsCompaction() {
+		e.full = false
+		tables := append([]*sstable(nil), e.tables...)
+		for _, t := range tables {
+			t.ref()
+		}
+
+		e.mutex.Unlock()
+		err := e.compact(tables)
+		for _, t := range tables {
+			t.unref()
+		}
+		e.mutex.Lock()
+
+		if err != nil {
+			if err != errCompactionAborted {
+				e.compactErr = err
+			}
+			break
+		}
+	}
+	e.full = false
+	e.compacting = false
+	e.idle.Broadcast()
+}
+
+// compact merges tables, the oldest live ones, into one. As no older table
+// remains, tombstones and overwritten values are dropped.
+func (e *lsmEngine) compact(tables []*sstable) error {
+	sources := make([]lsmIterator, len(tables))
+	for i, t := range tables {
+		sources[i] = t.iterator(0, t.meta.DataEnd)
+	}
+	merged := newMergeIterator(sources)
+
+	t, err := e.writeTable(func(add func(lsmRecord) error) error {
+		for ; merged.valid(); merged.next() {
+			if e.closing.Load() {
+				return errCompactionAborted
+			}
+			if rec := merged.record(); !rec.Deleted {
+				if err := add(rec); err != nil {
+					return err
+				}
+			}
+		}
+		return merged.error()
+	})
+	if err == errCompactionAborted {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("compaction failed: %w", err)
+	}
+	return e.install(tables, t)
+}
+
+// install replaces the merged tables with t, which may be nil. If a Reset
+// replaced the merged tables meanwhile, t is discarded instead.
+func (e *lsmEngine) install(merged []*sstable, t *sstable) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	newer := len(e.tables) - len(merged)
+	if newer < 0 || !sameTables(e.tables[newer:], merged) {
+		if t != nil {
+			t.retire()
+		}
+		return nil
+	}
+
+	tables := append([]*sstable(nil), e.tables[:newer]...)
+	if t != nil {
+		tables = append(tables, t)
+	}
+	if err := e.writeManifest(tables); err != nil {
+		if t != nil {
+			t.retire()
+		}
+		return err
+	}
+	e.tables = tables
+	for _, old := range merged {
+		old.retire()
+	}
+	return nil
+}
+
+func sameTables(a, b []*sstable) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// replaceTables makes t, which may be nil, the only live table and removes the others
+func (e *lsmEngine) replaceTables(t *sstable) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var tables []*sstable
+	if t != nil {
+		tables = []*sstable{t}
+	}
+	if err := e.writeManifest(tables); err != nil {
+		if t != nil {
+			t.retire()
+		}
+		return err
+	}
+
+	old := e.tables
+	e.tables = tables
+	for _, t := range old {
+		t.retire()
+	}
+	return nil
+}
+
+// Compact flushes the memtable and starts merging all tables into one in the
+// background. waitCompaction waits for the merge to finish.
+func (e *lsmEngine) Compact() error {
+	if err := e.flush(); err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.full = true
+	e.startCompaction()
+	return nil
+}
+
+// waitCompaction waits until no compaction runs and returns the error of the
+// last one that failed since the previous call
+func (e *lsmEngine) waitCompaction() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for e.compacting {
+		e.idle.Wait()
+	}
+	err := e.compactErr
+	e.compactErr = nil
+	return err
+}
+
+// Range merges the memtable and the tables in key order
+func (e *lsmEngine) Range(fn func(key string, entry Entry) bool) error {
+	v := e.newView(false)
+	defer v.release()
+
+	return v.each(func(key string, entry Entry) bool {
+		return fn(key, importEntry(entry))
+	})
+}
+
+// view returns a consistent view of the entries that stays readable after the
+// DB lock is released, so snapshots can stream it
+func (e *lsmEngine) view() engineView {
+	return e.newView(true)
+}
+
+// newView copies the memtable, deeply if the view outlives the DB lock, and
+// references the live tables. Caller must hold the DB lock.
+func (e *lsmEngine) newView(copyValues bool) *lsmView {
+	records := make([]lsmRecord, 0, len(e.memtable))
+	for _, rec := range e.memtable {
+		if copyValues {
+			rec.Entry.Value = copyValue(rec.Entry.Value)
+		}
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+
+	e.mutex.RLock()
+	tables := append([]*sstable(nil), e.tables...)
+	for _, t := range tables {
+		t.ref()
+	}
+	e.mutex.RUnlock()
+	return &lsmView{records: records, tables: tables}
+}
+
+// lsmView is a copy of the memtable and references to the tables live when it was taken
+type lsmView struct {
+	records []lsmRecord
+	tables  []*sstable
+}
+
+// each calls fn for every live entry in key order, with values in their persisted form
+func (v *lsmView) each(fn func(key string, entry Entry) bool) error {
+	sources := make([]lsmIterator, 0, len(v.tables)+1)
+	sources = append(sources, &recordIterator{records: v.records})
+	for _, t := range v.tables {
+		sources = append(sources, t.iterator(0, t.meta.DataEnd))
+	}
+
+	merged := newMergeIterator(sources)
+	for ; merged.valid(); merged.next() {
+		rec := merged.record()
+		if rec.Deleted {
+			continue
+		}
+		if !fn(rec.Key, rec.Entry) {
+			return nil
+		}
+	}
+	return merged.error()
+}
+
+func (v *lsmView) release() {
+	for _, t := range v.tables {
+		t.unref()
+	}
+	v.tables = nil
+}
+
+// Reset replaces everything with entries, written as a single table
+func (e *lsmEngine) Reset(entries map[string]Entry) error {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	t, err := e.writeTable(func(add func(lsmRecord) error) error {
+		for _, key := range keys {
+			if err := add(lsmRecord{Key: key, Entry: exportEntry(entries[key])}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := e.replaceTables(t); err != nil {
+		return err
+	}
+
+	e.memtable = make(map[string]lsmRecord)
+	e.memSize = 0
+	if err := e.log.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate memtable log: %w", err)
+	}
+	return nil
+}
+
+func (e *lsmEngine) Persistent() bool {
+	return true
+}
+
+// Sync forces the memtable log to disk
+func (e *lsmEngine) Sync() error {
+	if err := e.log.Sync(); err != nil {
+		return fmt.Errorf("failed to sync memtable log: %w", err)
+	}
+	return nil
+}
+
+// Close flushes the memtable, stops the compaction and closes all files
+func (e *lsmEngine) Close() error {
+	err := e.readErr()
+	if err == nil {
+		err = e.flush()
+	}
+	e.closing.Store(true)
+	if compactErr := e.waitCompaction(); err == nil {
+		err = compactErr
+	}
+	if closeErr := e.log.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close memtable log: %w", closeErr)
+	}
+	e.closeTables()
+	return err
+}
+
+func (e *lsmEngine) closeTables() {
+	for _, t := range e.tables {
+		t.unref()
+	}
+	e.tables = nil
+}
+
+// lsmIterator walks records in key order
+type lsmIterator interface {
+	valid() bool
+	record() lsmRecord
+	next()
+}
+
+// recordIterator walks records sorted by key
+type recordIterator struct {
+	records []lsmRecord
+}
+
+func (it *recordIterator) valid() bool {
+	return len(it.records) > 0
+}
+
+func (it *recordIterator) record() lsmRecord {
+	return it.records[0]
+}
+
+func (it *recordIterator) next() {
+	it.records = it.records[1:]
+}
+
+// mergeIterator merges sources ordered from newest to oldest. Of the records
+// sharing a key only the one from the newest source is returned.
+type mergeIterator struct {
+	sources []lsmIterator
+	current int
+}
+
+func newMergeIterator(sources []lsmIterator) *mergeIterator {
+	it := &mergeIterator{sources: sources}
+	it.pick()
+	return it
+}
+
+// pick selects the source holding the smallest key, preferring newer sources
+func (it *mergeIterator) pick() {
+	it.current = -1
+	for i, src := range it.sources {
+		if !src.valid() {
+			continue
+		}
+		if it.current < 0 || src.record().Key < it.sources[it.current].record().Key {
+			it.current = i
+		}
+	}
+}
+
+func (it *mergeIterator) valid() bool {
+	return it.current >= 0
+}
+
+func (it *mergeIterator) record() lsmRecord {
+	return it.sources[it.current].record()
+}
+
+// next moves past the current key in every source
+func (it *mergeIterator) next() {
+	key := it.record().Key
+	for _, src := range it.sources {
+		for src.valid() && src.record().Key == key {
+			src.next()
+		}
+	}
+	it.pick()
+}
+
+// error returns the first read error of a table source
+func (it *mergeIterator) error() error {
+	for _, src := range it.sources {
+		if sst, ok := src.(*sstIterator); ok && sst.err != nil {
+			return sst.err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package xedb_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lsmOptions returns options for an LSM database with a memtable small enough
+// to flush and compact often
+func lsmOptions(dir string, opts ...xedb.Option) []xedb.Option {
+	return append([]xedb.Option{
+		xedb.WithDataDir(dir),
+		xedb.WithSyncWrite(false),
+		xedb.WithStorageEngine(xedb.LSMStorage),
+		xedb.WithMemtableSize(4 << 10),
+		xedb.WithCompactionL0Trigger(4),
+	}, opts...)
+}
+
+// lsmTables returns the table files of the LSM database in dir
+func lsmTables(t *testing.T, dir string) []string {
+	tables, err := filepath.Glob(filepath.Join(dir, "lsm", "*.sst"))
+	require.NoError(t, err)
+	return tables
+}
+
+// copyDir copies the files of a database directory, as a crash would leave them
+func copyDir(t *testing.T, src, dst string) {
+	require.NoError(t, filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), data, 0644)
+	}))
+}
+
+func TestDB_LSMEngine(t *testing.T) {
+	dir := t.TempDir()
+	db, err := xedb.New(lsmOptions(dir)...)
+	require.NoError(t, err)
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 500; i++ {
+		require.NoError(t, db.String(fmt.Sprintf("key:%03d", i)).Set(value+fmt.Sprint(i)))
+	}
+	for i := 0; i < 500; i += 2 {
+		require.NoError(t, db.String(fmt.Sprintf("key:%03d", i)).Set("updated"))
+	}
+	_, err = db.Delete("key:001", "key:003")
+	require.NoError(t, err)
+
+	require.NoError(t, db.Hash("user:1").Set("name", "alice"))
+	require.NoError(t, db.List("queue").Push("a", "b"))
+	require.NoError(t, db.Set("tags").Add("go"))
+	require.NoError(t, db.ZSet("scores").Add(1.5, "alice"))
+	require.NoError(t, db.CreateIndex("by_name", "user:", "name"))
+
+	check := func(t *testing.T, db *xedb.DB) {
+		val, ok := db.String("key:000").Get()
+		assert.True(t, ok)
+		assert.Equal(t, "updated", val)
+		val, ok = db.String("key:499").Get()
+		assert.True(t, ok)
+		assert.Equal(t, value+"499", val)
+		assert.Equal(t, 0, db.Exists("key:001", "key:003"))
+		assert.Equal(t, 502, db.Len())
+
+		name, _ := db.Hash("user:1").Get("name")
+		assert.Equal(t, "alice", name)
+		assert.Equal(t, []string{"a", "b"}, db.List("queue").Range(0, -1))
+		assert.True(t, db.Set("tags").IsMember("go"))
+		assert.Equal(t, []xedb.ZSetMember{{Member: "alice", Score: 1.5}}, db.ZSet("scores").Range(0, -1))
+
+		keys, err := db.QueryIndex("by_name", "alice")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"user:1"}, keys)
+		assert.Len(t, scanAll(db, "key:*", 50), 498)
+	}
+	check(t, db)
+
+	// Flushed tables are merged in the background once CompactionL0Trigger of them pile up
+	require.Eventually(t, func() bool {
+		n := len(lsmTables(t, dir))
+		return n > 0 && n < 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	t.Run("Reopen", func(t *testing.T) {
+		require.NoError(t, db.Close())
+		db, err = xedb.New(lsmOptions(dir)...)
+		require.NoError(t, err)
+		check(t, db)
+	})
+
+	t.Run("Crash Recovery", func(t *testing.T) {
+		// Writes still in the memtable are recovered from its log
+		require.NoError(t, db.String("recent").Set("x"))
+		crashed := t.TempDir()
+		copyDir(t, dir, crashed)
+
+		recovered, err := xedb.New(lsmOptions(crashed)...)
+		require.NoError(t, err)
+		defer recovered.Close()
+		val, ok := recovered.String("recent").Get()
+		assert.True(t, ok)
+		assert.Equal(t, "x", val)
+		assert.Equal(t, 503, recovered.Len())
+	})
+
+	t.Run("Compact", func(t *testing.T) {
+		for i := 0; i < 500; i++ {
+			_, err := db.Delete(fmt.Sprintf("key:%03d", i))
+			require.NoError(t, err)
+		}
+		require.NoError(t, db.Compact())
+
+		assert.Len(t, lsmTables(t, dir), 1)
+		assert.Equal(t, 5, db.Len())
+		assert.Empty(t, db.Keys("key:*"))
+	})
+
+	require.NoError(t, db.Close())
+}
+
+func TestDB_LSMBackgroundCompaction(t *testing.T) {
+	dir := t.TempDir()
+	db, err := xedb.New(lsmOptions(dir, xedb.WithCompactionL0Trigger(2))...)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Readers, writers and snapshots keep going while tables are merged
+	value := strings.Repeat("v", 100)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 300; i++ {
+				key := fmt.Sprintf("w%d:%03d", w, i)
+				assert.NoError(t, db.String(key).Set(value))
+				if i%3 == 0 {
+					_, err := db.Delete(key)
+					assert.NoError(t, err)
+				}
+				val, ok := db.String(fmt.Sprintf("w%d:%03d", w, i/3*3+1)).Get()
+				if ok {
+					assert.Equal(t, value, val)
+				}
+			}
+		}(w)
+	}
+	snapshots := make([]bytes.Buffer, 3)
+	for i := range snapshots {
+		wg.Add(1)
+		go func(buf *bytes.Buffer) {
+			defer wg.Done()
+			assert.NoError(t, db.Compact())
+			assert.NoError(t, db.Snapshot(buf))
+		}(&snapshots[i])
+	}
+	wg.Wait()
+
+	require.NoError(t, db.Compact())
+	assert.Len(t, lsmTables(t, dir), 1)
+	assert.Equal(t, 800, db.Len())
+	assert.Empty(t, db.Keys("w*:[0369]0"))
+
+	for i := range snapshots {
+		restored, cleanup := setupPubSubDB(t)
+		require.NoError(t, restored.Restore(&snapshots[i]))
+		for _, key := range restored.Keys("*") {
+			val, ok := restored.String(key).Get()
+			assert.True(t, ok)
+			assert.Equal(t, value, val)
+		}
+		cleanup()
+	}
+}
+
+func TestDB_LSMSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	db, err := xedb.New(lsmOptions(dir)...)
+	require.NoError(t, err)
+	defer db.Close()
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.String(fmt.Sprintf("key:%03d", i)).Set(value))
+	}
+	require.NoError(t, db.ZSet("scores").Add(1.5, "alice"))
+	require.NoError(t, db.String("session").SetWithTTL("token", time.Hour))
+	require.NoError(t, db.String("expired").SetWithTTL("gone", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(db.Snapshot(pw))
+	}()
+	magic := make([]byte, 8)
+	_, err = io.ReadFull(pr, magic)
+	require.NoError(t, err)
+
+	// Tables merged away while the snapshot streams stay readable until it is written
+	for i := 0; i < 200; i++ {
+		_, err := db.Delete(fmt.Sprintf("key:%03d", i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Compact())
+	assert.Greater(t, len(lsmTables(t, dir)), 1)
+
+	restored, cleanup := setupPubSubDB(t)
+	defer cleanup()
+	require.NoError(t, restored.Restore(io.MultiReader(bytes.NewReader(magic), pr)))
+	require.Eventually(t, func() bool {
+		return len(lsmTables(t, dir)) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 202, restored.Len())
+	assert.Equal(t, 0, restored.Exists("expired"))
+	val, ok := restored.String("key:199").Get()
+	assert.True(t, ok)
+	assert.Equal(t, value, val)
+	assert.Equal(t, []xedb.ZSetMember{{Member: "alice", Score: 1.5}}, restored.ZSet("scores").Range(0, -1))
+	ttl, ok := restored.String("session").TTL()
+	assert.True(t, ok)
+	assert.Greater(t, ttl, 59*time.Minute)
+}
+
+func TestDB_LSMMemoryLimit(t *testing.T) {
+	// Only keys count towards MaxMemory when values are kept on disk
+	db, err := xedb.New(lsmOptions(t.TempDir(), xedb.WithMaxMemory(64<<10))...)
+	require.NoError(t, err)
+	defer db.Close()
+
+	value := strings.Repeat("x", 1024)
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.String(fmt.Sprintf("big:%d", i)).Set(value))
+	}
+	assert.Less(t, db.EvictionStats().MemoryUsage, int64(64<<10))
+}
+
+// countingEngine wraps the memory engine to check that custom engines are used
+type countingEngine struct {
+	xedb.Engine
+	puts int
+}
+
+func (e *countingEngine) Put(key string, entry xedb.Entry) error {
+	e.puts++
+	return e.Engine.Put(key, entry)
+}
+
+func TestDB_CustomEngine(t *testing.T) {
+	engine := &countingEngine{Engine: xedb.NewMemoryEngine()}
+	db, cleanup := setupPubSubDB(t, xedb.WithEngine(engine))
+	defer cleanup()
+
+	require.NoError(t, db.String("k").Set("v"))
+	assert.Equal(t, 1, engine.puts)
+}
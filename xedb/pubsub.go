@@ -0,0 +1,369 @@
+package xedb
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy determines what happens when a subscriber's buffer is full
+type OverflowPolicy string
+
+const (
+	// DropMessages discards messages for subscribers whose buffer is full
+	DropMessages OverflowPolicy = "drop"
+	// BlockPublisher waits until the subscriber has room for the message.
+	// Writers raising keyspace notifications wait too once the notification
+	// queue is full.
+	BlockPublisher OverflowPolicy = "block"
+)
+
+// Keyspace events, delivered as the payload of keyspace channels and as the
+// name of keyevent channels
+const (
+	EventSet     = "set"
+	EventDel     = "del"
+	EventExpired = "expired"
+	EventEvicted = "evicted"
+)
+
+const (
+	keyspacePrefix = "__keyspace@0__:"
+	keyeventPrefix = "__keyevent@0__:"
+)
+
+// keyspaceQueueSize bounds the keyspace notifications waiting for delivery.
+// When it is reached writers wait with BlockPublisher and further
+// notifications are dropped otherwise.
+const keyspaceQueueSize = 1024
+
+// Message is a message delivered to a subscription
+type Message struct {
+	// Channel is the channel the message was published to
+	Channel string
+	// Pattern is the subscription pattern that matched Channel
+	Pattern string
+	// Payload is the message body
+	Payload string
+}
+
+// Subscription receives messages published to channels matching its patterns
+type Subscription struct {
+	db       *DB
+	patterns []string
+	policy   OverflowPolicy
+	ch       chan Message
+	done     chan struct{}
+	// mutex is held for reading while sending so Close never closes ch under a sender
+	mutex   sync.RWMutex
+	once    sync.Once
+	dropped uint64
+}
+
+// pubsub tracks subscriptions and the queue of pending keyspace notifications
+type pubsub struct {
+	mutex sync.RWMutex
+	subs  map[*Subscription]struct{}
+	count int32
+
+	queueMutex sync.Mutex
+	queue      []Message
+	draining   bool
+	// queued counts notifications not delivered yet, including the batch being drained
+	queued int
+	// space is signalled as queued notifications are delivered
+	space *sync.Cond
+}
+
+func newPubsub() *pubsub {
+	ps := &pubsub{subs: make(map[*Subscription]struct{})}
+	ps.space = sync.NewCond(&ps.queueMutex)
+	return ps
+}
+
+// WithKeyspaceNotifications enables publishing keyspace events
+func WithKeyspaceNotifications(enable bool) Option {
+	return func(o *Options) {
+		o.NotifyKeyspaceEvents = enable
+	}
+}
+
+// WithSubscriberBufferSize sets the number of messages buffered per subscription
+func WithSubscriberBufferSize(size int) Option {
+	return func(o *Options) {
+		o.SubscriberBufferSize = size
+	}
+}
+
+// WithSubscriberOverflow sets what happens when a subscriber's buffer is full
+func WithSubscriberOverflow(policy OverflowPolicy) Option {
+	return func(o *Options) {
+		o.SubscriberOverflow = policy
+	}
+}
+
+// KeyspaceChannel returns the channel receiving events for key. key may be a pattern.
+func KeyspaceChannel(key string) string {
+	return keyspacePrefix + key
+}
+
+// KeyeventChannel returns the channel receiving the keys affected by event. event may be a pattern.
+func KeyeventChannel(event string) string {
+	return keyeventPrefix + event
+}
+
+// Subscribe creates a subscription to all channels matching the given glob-style
+// patterns (see MatchPattern). A pattern without wildcards matches a single channel.
+func (db *DB) Subscribe(patterns ...string) *Subscription {
+	size := db.options.SubscriberBufferSize
+	if size < 0 {
+		size = 0
+	}
+
+	s := &Subscription{
+		db:       db,
+		patterns: append([]string(nil), patterns...),
+		policy:   db.options.SubscriberOverflow,
+		ch:       make(chan Message, size),
+		done:     make(chan struct{}),
+	}
+
+	db.pubsub.mutex.Lock()
+	db.pubsub.subs[s] = struct{}{}
+	atomic.AddInt32(&db.pubsub.count, 1)
+	db.pubsub.mutex.Unlock()
+	return s
+}
+
+// Publish sends payload to every subscription matching channel and returns
+// how many received it. With BlockPublisher it waits for slow subscribers.
+func (db *DB) Publish(channel, payload string) int {
+	return db.pubsub.publish(Message{Channel: channel, Payload: payload})
+}
+
+// notifyKeyspace queues keyspace notifications for event on key.
+// Delivery is asynchronous so writers holding db.mutex never wait on subscribers.
+// Caller must hold db.mutex for writing.
+func (db *DB) notifyKeyspace(event, key string) {
+	if !db.options.NotifyKeyspaceEvents || atomic.LoadInt32(&db.pubsub.count) == 0 {
+		return
+	}
+	db.pubsub.enqueue(db.options.SubscriberOverflow, keyspaceMessages(event, key)...)
+}
+
+// notifyWrite holds back the keyspace notifications for a write until the
+// write is logged, so subscribers never hear of a write that failed.
+// Caller must hold db.mutex for writing.
+func (db *DB) notifyWrite(event, key string) {
+	if !db.options.NotifyKeyspaceEvents || atomic.LoadInt32(&db.pubsub.count) == 0 {
+		return
+	}
+	db.unlogged = append(db.unlogged, keyspaceMessages(event, key)...)
+}
+
+// flushNotifications queues the notifications held back by notifyWrite once
+// the writes are logged, or discards them if logging failed.
+// Caller must hold db.mutex for writing.
+func (db *DB) flushNotifications(logged bool) {
+	if logged && len(db.unlogged) > 0 {
+		db.pubsub.enqueue(db.options.SubscriberOverflow, db.unlogged...)
+	}
+	db.unlogged = nil
+}
+
+// lock takes db.mutex for a write. With BlockPublisher it first waits for
+// room in the notification queue, which keeps writers at the pace of
+// subscribers without holding db.mutex while they catch up. Notifications
+// left by an earlier write that failed before it was logged are discarded.
+func (db *DB) lock() {
+	if db.options.NotifyKeyspaceEvents && db.options.SubscriberOverflow == BlockPublisher {
+		db.pubsub.wait()
+	}
+	db.mutex.Lock()
+	db.unlogged = nil
+}
+
+// keyspaceMessages returns the keyspace and keyevent messages for event on key
+func keyspaceMessages(event, key string) []Message {
+	return []Message{
+		{Channel: keyspacePrefix + key, Payload: event},
+		{Channel: keyeventPrefix + event, Payload: key},
+	}
+}
+
+// publish delivers msg to matching subscriptions
+func (ps *pubsub) publish(msg Message) int {
+	type target struct {
+		sub     *Subscription
+		pattern string
+	}
+
+	ps.mutex.RLock()
+	targets := make([]target, 0, len(ps.subs))
+	for s := range ps.subs {
+		if pattern, ok := s.match(msg.Channel); ok {
+			targets = append(targets, target{sub: s, pattern: pattern})
+		}
+	}
+	ps.mutex.RUnlock()
+
+	delivered := 0
+	for _, t := range targets {
+		m := msg
+		m.Pattern = t.pattern
+		if t.sub.deliver(m) {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+// enqueue adds messages to the notification queue, starting a drain if none is
+// running. It never blocks: with BlockPublisher writers wait for room before
+// writing, otherwise messages beyond keyspaceQueueSize are dropped.
+func (ps *pubsub) enqueue(policy OverflowPolicy, msgs ...Message) {
+	ps.queueMutex.Lock()
+	defer ps.queueMutex.Unlock()
+
+	for _, msg := range msgs {
+		if ps.queued >= keyspaceQueueSize && policy != BlockPublisher {
+			ps.drop(msg)
+			continue
+		}
+		ps.queue = append(ps.queue, msg)
+		ps.queued++
+	}
+	if !ps.draining && len(ps.queue) > 0 {
+		ps.draining = true
+		go ps.drain()
+	}
+}
+
+// wait blocks while the notification queue is full
+func (ps *pubsub) wait() {
+	ps.queueMutex.Lock()
+	defer ps.queueMutex.Unlock()
+
+	for ps.queued >= keyspaceQueueSize {
+		ps.space.Wait()
+	}
+}
+
+// drain delivers queued notifications in order until the queue is empty
+func (ps *pubsub) drain() {
+	ps.queueMutex.Lock()
+	for len(ps.queue) > 0 {
+		batch := ps.queue
+		ps.queue = nil
+		ps.queueMutex.Unlock()
+
+		for _, msg := range batch {
+			ps.publish(msg)
+
+			ps.queueMutex.Lock()
+			ps.queued--
+			ps.space.Broadcast()
+			ps.queueMutex.Unlock()
+		}
+
+		ps.queueMutex.Lock()
+	}
+	ps.draining = false
+	ps.queueMutex.Unlock()
+}
+
+// drop counts msg as dropped by every subscription it would have reached
+func (ps *pubsub) drop(msg Message) {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	for s := range ps.subs {
+		if _, ok := s.match(msg.Channel); ok {
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// closeAll closes every subscription, unblocking pending publishers
+func (ps *pubsub) closeAll() {
+	ps.mutex.RLock()
+	subs := make([]*Subscription, 0, len(ps.subs))
+	for s := range ps.subs {
+		subs = append(subs, s)
+	}
+	ps.mutex.RUnlock()
+
+	for _, s := range subs {
+		s.Close()
+	}
+}
+
+// Channel returns the channel messages are delivered on. It is closed by Close.
+func (s *Subscription) Channel() <-chan Message {
+	return s.ch
+}
+
+// Patterns returns the patterns the subscription matches
+func (s *Subscription) Patterns() []string {
+	return append([]string(nil), s.patterns...)
+}
+
+// Dropped returns the number of messages discarded because the buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close unsubscribes and closes the message channel
+func (s *Subscription) Close() error {
+	s.once.Do(func() {
+		ps := s.db.pubsub
+		ps.mutex.Lock()
+		delete(ps.subs, s)
+		atomic.AddInt32(&ps.count, -1)
+		ps.mutex.Unlock()
+
+		close(s.done)
+		s.mutex.Lock()
+		close(s.ch)
+		s.mutex.Unlock()
+	})
+	return nil
+}
+
+// match returns the first pattern matching channel
+func (s *Subscription) match(channel string) (string, bool) {
+	for _, pattern := range s.patterns {
+		if MatchPattern(pattern, channel) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// deliver sends msg according to the overflow policy and reports whether it was delivered
+func (s *Subscription) deliver(msg Message) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	if s.policy == BlockPublisher {
+		select {
+		case s.ch <- msg:
+			return true
+		case <-s.done:
+			return false
+		}
+	}
+
+	select {
+	case s.ch <- msg:
+		return true
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		return false
+	}
+}
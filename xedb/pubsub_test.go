@@ -0,0 +1,325 @@
+package xedb_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupPubSubDB(t *testing.T, opts ...xedb.Option) (*xedb.DB, func()) {
+	dir, err := os.MkdirTemp("", "xedb-pubsub-test-*")
+	require.NoError(t, err)
+
+	opts = append([]xedb.Option{xedb.WithDataDir(dir), xedb.WithSyncWrite(false)}, opts...)
+	db, err := xedb.New(opts...)
+	require.NoError(t, err)
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func receive(t *testing.T, sub *xedb.Subscription) xedb.Message {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.Channel():
+		require.True(t, ok, "subscription closed")
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+		return xedb.Message{}
+	}
+}
+
+func TestDB_PublishSubscribe(t *testing.T) {
+	db, cleanup := setupPubSubDB(t)
+	defer cleanup()
+
+	news := db.Subscribe("news")
+	all := db.Subscribe("news*", "sports")
+	defer news.Close()
+	defer all.Close()
+
+	assert.Equal(t, 2, db.Publish("news", "hello"))
+	assert.Equal(t, 1, db.Publish("news.tech", "go 2"))
+	assert.Equal(t, 0, db.Publish("weather", "sunny"))
+
+	msg := receive(t, news)
+	assert.Equal(t, xedb.Message{Channel: "news", Pattern: "news", Payload: "hello"}, msg)
+
+	msg = receive(t, all)
+	assert.Equal(t, "news", msg.Channel)
+	assert.Equal(t, "news*", msg.Pattern)
+	msg = receive(t, all)
+	assert.Equal(t, "news.tech", msg.Channel)
+	assert.Equal(t, "go 2", msg.Payload)
+
+	t.Run("Close", func(t *testing.T) {
+		sub := db.Subscribe("closing")
+		require.NoError(t, sub.Close())
+		require.NoError(t, sub.Close())
+
+		_, ok := <-sub.Channel()
+		assert.False(t, ok)
+		assert.Equal(t, 0, db.Publish("closing", "ignored"))
+	})
+}
+
+func TestDB_SlowSubscribers(t *testing.T) {
+	t.Run("Drop", func(t *testing.T) {
+		db, cleanup := setupPubSubDB(t,
+			xedb.WithSubscriberBufferSize(2),
+			xedb.WithSubscriberOverflow(xedb.DropMessages),
+		)
+		defer cleanup()
+
+		sub := db.Subscribe("events")
+		defer sub.Close()
+
+		delivered := 0
+		for i := 0; i < 5; i++ {
+			delivered += db.Publish("events", "x")
+		}
+		assert.Equal(t, 2, delivered)
+		assert.Equal(t, uint64(3), sub.Dropped())
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		db, cleanup := setupPubSubDB(t,
+			xedb.WithSubscriberBufferSize(1),
+			xedb.WithSubscriberOverflow(xedb.BlockPublisher),
+		)
+		defer cleanup()
+
+		sub := db.Subscribe("events")
+		defer sub.Close()
+
+		assert.Equal(t, 1, db.Publish("events", "first"))
+
+		published := make(chan int)
+		go func() {
+			published <- db.Publish("events", "second")
+		}()
+
+		select {
+		case <-published:
+			t.Fatal("publish should block while the buffer is full")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		assert.Equal(t, "first", receive(t, sub).Payload)
+		assert.Equal(t, 1, <-published)
+		assert.Equal(t, "second", receive(t, sub).Payload)
+		assert.Equal(t, uint64(0), sub.Dropped())
+	})
+
+	t.Run("Close Unblocks Publisher", func(t *testing.T) {
+		db, cleanup := setupPubSubDB(t,
+			xedb.WithSubscriberBufferSize(0),
+			xedb.WithSubscriberOverflow(xedb.BlockPublisher),
+		)
+		defer cleanup()
+
+		sub := db.Subscribe("events")
+		published := make(chan int)
+		go func() {
+			published <- db.Publish("events", "never read")
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		sub.Close()
+		assert.Equal(t, 0, <-published)
+	})
+}
+
+func TestDB_KeyspaceNotifications(t *testing.T) {
+	db, cleanup := setupPubSubDB(t,
+		xedb.WithKeyspaceNotifications(true),
+		xedb.WithExpireScanInterval(10*time.Millisecond),
+	)
+	defer cleanup()
+
+	sub := db.Subscribe(xedb.KeyspaceChannel("user:*"))
+	defer sub.Close()
+	events := db.Subscribe(xedb.KeyeventChannel(xedb.EventExpired))
+	defer events.Close()
+
+	expectEvent := func(key, event string) {
+		t.Helper()
+		msg := receive(t, sub)
+		assert.Equal(t, xedb.KeyspaceChannel(key), msg.Channel)
+		assert.Equal(t, event, msg.Payload)
+	}
+
+	t.Run("Op Types", func(t *testing.T) {
+		require.NoError(t, db.String("user:1").Set("alice"))
+		expectEvent("user:1", xedb.EventSet)
+
+		require.NoError(t, db.List("user:2").Push("a"))
+		expectEvent("user:2", xedb.EventSet)
+
+		require.NoError(t, db.Hash("user:3").Set("f", "v"))
+		expectEvent("user:3", xedb.EventSet)
+
+		// Keys outside the pattern are not delivered
+		require.NoError(t, db.String("other").Set("x"))
+
+		removed, err := db.Delete("user:1")
+		require.NoError(t, err)
+		require.Equal(t, 1, removed)
+		expectEvent("user:1", xedb.EventDel)
+	})
+
+	t.Run("Batch And Transaction", func(t *testing.T) {
+		require.NoError(t, db.ExecuteBatch([]xedb.BatchOp{
+			{Op: "STRING", Key: "user:4", Value: "bob"},
+		}))
+		expectEvent("user:4", xedb.EventSet)
+
+		txn := db.NewTransaction(true)
+		require.NoError(t, txn.Set("user:5", xedb.Entry{Type: xedb.String, Value: "carol"}))
+		require.NoError(t, txn.Commit())
+		expectEvent("user:5", xedb.EventSet)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		require.NoError(t, db.String("user:6").SetWithTTL("dave", 20*time.Millisecond))
+		expectEvent("user:6", xedb.EventSet)
+		expectEvent("user:6", xedb.EventExpired)
+
+		msg := receive(t, events)
+		assert.Equal(t, xedb.KeyeventChannel(xedb.EventExpired), msg.Channel)
+		assert.Equal(t, "user:6", msg.Payload)
+	})
+}
+
+func TestDB_KeyspaceNotificationsEvicted(t *testing.T) {
+	db, cleanup := setupPubSubDB(t,
+		xedb.WithKeyspaceNotifications(true),
+		xedb.WithMaxMemory(512),
+		xedb.WithEvictionPolicy(xedb.AllKeysLRU),
+	)
+	defer cleanup()
+
+	sub := db.Subscribe(xedb.KeyeventChannel(xedb.EventEvicted))
+	defer sub.Close()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.String(string(rune('a'+i))).Set("0123456789012345678901234567890123456789"))
+	}
+
+	msg := receive(t, sub)
+	assert.NotEmpty(t, msg.Payload)
+}
+
+func TestDB_KeyspaceNotificationsSlowSubscriber(t *testing.T) {
+	const writes = 2000
+
+	t.Run("Block", func(t *testing.T) {
+		db, cleanup := setupPubSubDB(t,
+			xedb.WithKeyspaceNotifications(true),
+			xedb.WithSubscriberBufferSize(1),
+			xedb.WithSubscriberOverflow(xedb.BlockPublisher),
+		)
+		defer cleanup()
+
+		sub := db.Subscribe(xedb.KeyspaceChannel("*"))
+		defer sub.Close()
+
+		var written int64
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < writes; i++ {
+				assert.NoError(t, db.String(fmt.Sprint(i)).Set("v"))
+				atomic.AddInt64(&written, 1)
+			}
+		}()
+
+		// Writers wait for the subscriber once the queue is full
+		require.Eventually(t, func() bool {
+			n := atomic.LoadInt64(&written)
+			time.Sleep(20 * time.Millisecond)
+			return n == atomic.LoadInt64(&written)
+		}, 5*time.Second, time.Millisecond)
+		assert.Less(t, atomic.LoadInt64(&written), int64(writes))
+
+		// Reading from the database does not deadlock with blocked writers
+		_, ok := db.String("0").Get()
+		assert.True(t, ok)
+
+		for i := 0; i < writes; i++ {
+			assert.Equal(t, xedb.KeyspaceChannel(fmt.Sprint(i)), receive(t, sub).Channel)
+		}
+		<-done
+		assert.Zero(t, sub.Dropped())
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		db, cleanup := setupPubSubDB(t,
+			xedb.WithKeyspaceNotifications(true),
+			xedb.WithSubscriberBufferSize(1),
+			xedb.WithSubscriberOverflow(xedb.DropMessages),
+		)
+		defer cleanup()
+
+		sub := db.Subscribe(xedb.KeyspaceChannel("*"))
+		defer sub.Close()
+
+		for i := 0; i < writes; i++ {
+			require.NoError(t, db.String(fmt.Sprint(i)).Set("v"))
+		}
+		require.Eventually(t, func() bool {
+			return sub.Dropped() == writes-1
+		}, 2*time.Second, 5*time.Millisecond)
+		assert.Equal(t, xedb.KeyspaceChannel("0"), receive(t, sub).Channel)
+	})
+}
+
+func TestDB_KeyspaceNotificationsFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	db, err := xedb.New(xedb.WithDataDir(dir), xedb.WithSyncWrite(false), xedb.WithKeyspaceNotifications(true))
+	require.NoError(t, err)
+	defer db.Close()
+
+	sub := db.Subscribe(xedb.KeyspaceChannel("*"))
+	defer sub.Close()
+
+	// A directory in place of the WAL makes every write fail to log
+	walFile := filepath.Join(dir, "wal.db")
+	require.NoError(t, os.Mkdir(walFile, 0755))
+
+	require.Error(t, db.String("key").Set("value"))
+	select {
+	case msg := <-sub.Channel():
+		t.Fatalf("unexpected notification %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, os.Remove(walFile))
+	require.NoError(t, db.String("key").Set("value"))
+	assert.Equal(t, xedb.EventSet, receive(t, sub).Payload)
+}
+
+func TestDB_KeyspaceNotificationsDisabled(t *testing.T) {
+	db, cleanup := setupPubSubDB(t)
+	defer cleanup()
+
+	sub := db.Subscribe(xedb.KeyspaceChannel("*"))
+	defer sub.Close()
+
+	require.NoError(t, db.String("key").Set("value"))
+	select {
+	case msg := <-sub.Channel():
+		t.Fatalf("unexpected notification %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
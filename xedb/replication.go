@@ -0,0 +1,581 @@
+package xedb
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/seefs001/xox/xerror"
+)
+
+// ErrReplicaTooSlow is returned by ServeReplica when a follower cannot keep up with the write rate
+var ErrReplicaTooSlow = xerror.New("replica too slow")
+
+// Replication message types. Every message starts with its type, an offset,
+// which is a transaction id of the primary, and the replication id of the primary.
+const (
+	// replHello is sent by a follower with the offset it has applied
+	replHello byte = iota + 1
+	// replContinue tells the follower that the stream continues after its offset
+	replContinue
+	// replFullSync is followed by a snapshot taken at the offset
+	replFullSync
+	// replEntry is followed by a WAL record for the offset
+	replEntry
+	// replPing carries the current offset of the primary
+	replPing
+	// replAck is sent by a follower with the offset it has applied
+	replAck
+)
+
+const (
+	// replPingInterval is how often an idle primary announces its offset
+	replPingInterval = time.Second
+	// replQueueSize is the number of records buffered for a follower before it is dropped
+	replQueueSize = 1024
+	// replMaxBackoff caps the delay between reconnection attempts in Follow
+	replMaxBackoff = 5 * time.Second
+)
+
+// WithReplBacklogSize sets how many bytes of recent WAL records are kept so a
+// reconnecting follower can catch up without a full resync. Zero disables the backlog.
+func WithReplBacklogSize(size int64) Option {
+	return func(o *Options) {
+		o.ReplBacklogSize = size
+	}
+}
+
+// ReplicationInfo describes the replication state of a database
+type ReplicationInfo struct {
+	// Offset is the id of the last transaction written or applied
+	Offset uint64
+	// Following reports whether the database is applying the stream of a primary
+	Following bool
+	// PrimaryOffset is the latest offset announced by the primary
+	PrimaryOffset uint64
+	// Lag is the number of transactions the database is behind its primary
+	Lag uint64
+	// LastSync is when the last message from the primary was received
+	LastSync time.Time
+	// Replicas describes the connected followers
+	Replicas []ReplicaInfo
+}
+
+// ReplicaInfo describes a follower connected to a primary
+type ReplicaInfo struct {
+	ID uint64
+	// AckOffset is the last offset the follower reported as applied
+	AckOffset uint64
+	// Lag is the number of transactions the follower is behind
+	Lag uint64
+	// LastAck is when the follower last reported its offset
+	LastAck time.Time
+	// FullSync reports whether the follower was sent a snapshot on connect
+	FullSync bool
+}
+
+// backlogRecord is a WAL record kept for partial resynchronization
+type backlogRecord struct {
+	txID   uint64
+	record []byte
+}
+
+// replica is a follower served by ServeReplica
+type replica struct {
+	id        uint64
+	fullSync  bool
+	queue     chan backlogRecord
+	done      chan struct{}
+	once      sync.Once
+	err       error
+	ackOffset uint64
+	lastAck   int64
+}
+
+// close disconnects the follower, recording why
+func (r *replica) close(err error) {
+	r.once.Do(func() {
+		r.err = err
+		close(r.done)
+	})
+}
+
+// replication holds the primary and follower state of a database
+type replication struct {
+	mutex    sync.Mutex
+	replicas map[uint64]*replica
+	nextID   uint64
+
+	// id identifies the history of the dataset. It changes whenever the dataset
+	// is replaced, so offsets are only comparable between equal ids.
+	id uint64
+
+	// The backlog holds every record with a txID above backlogStart
+	backlog      []backlogRecord
+	backlogBytes int64
+	backlogStart uint64
+
+	following     int32
+	primaryID     uint64
+	primaryOffset uint64
+	lastSync      int64
+	// applied is the offset of the last entry applied from the primary. It is
+	// tracked apart from txCounter, which writes made on the follower advance too.
+	applied uint64
+}
+
+// newReplID returns a random non-zero replication id
+func newReplID() uint64 {
+	for {
+		if id := rand.Uint64(); id != 0 {
+			return id
+		}
+	}
+}
+
+// feedReplicas adds a WAL record to the backlog and queues it for followers.
+// Caller must hold db.mutex for writing.
+func (db *DB) feedReplicas(txID uint64, record []byte) {
+	repl := &db.repl
+	repl.mutex.Lock()
+	defer repl.mutex.Unlock()
+
+	if limit := db.options.ReplBacklogSize; limit > 0 {
+		repl.backlog = append(repl.backlog, backlogRecord{txID: txID, record: record})
+		repl.backlogBytes += int64(len(record))
+		for repl.backlogBytes > limit && len(repl.backlog) > 0 {
+			repl.backlogBytes -= int64(len(repl.backlog[0].record))
+			repl.backlogStart = repl.backlog[0].txID
+			repl.backlog = repl.backlog[1:]
+		}
+	} else {
+		repl.backlogStart = txID
+	}
+
+	for id, r := range repl.replicas {
+		select {
+		case r.queue <- backlogRecord{txID: txID, record: record}:
+		default:
+			// Never block writers on a slow follower; it resyncs when it reconnects
+			r.close(ErrReplicaTooSlow)
+			delete(repl.replicas, id)
+		}
+	}
+}
+
+// resetReplication discards the backlog and disconnects followers, which must
+// resync after the dataset was replaced. Caller must hold db.mutex for writing.
+func (db *DB) resetReplication() {
+	repl := &db.repl
+	repl.mutex.Lock()
+	defer repl.mutex.Unlock()
+
+	repl.id = newReplID()
+	repl.backlog = nil
+	repl.backlogBytes = 0
+	repl.backlogStart = atomic.LoadUint64(&db.txCounter)
+	for id, r := range repl.replicas {
+		r.close(nil)
+		delete(repl.replicas, id)
+	}
+}
+
+// closeReplicas disconnects every follower
+func (db *DB) closeReplicas() {
+	db.repl.mutex.Lock()
+	defer db.repl.mutex.Unlock()
+
+	for id, r := range db.repl.replicas {
+		r.close(ErrClosed)
+		delete(db.repl.replicas, id)
+	}
+}
+
+// replMessage is the header of a replication message
+type replMessage struct {
+	typ    byte
+	offset uint64
+	id     uint64
+}
+
+// writeReplMessage writes a message header
+func writeReplMessage(w io.Writer, msg replMessage) error {
+	var header [17]byte
+	header[0] = msg.typ
+	binary.BigEndian.PutUint64(header[1:9], msg.offset)
+	binary.BigEndian.PutUint64(header[9:17], msg.id)
+	_, err := w.Write(header[:])
+	return err
+}
+
+// readReplMessage reads a message header
+func readReplMessage(r io.Reader) (replMessage, error) {
+	var header [17]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return replMessage{}, err
+	}
+	return replMessage{
+		typ:    header[0],
+		offset: binary.BigEndian.Uint64(header[1:9]),
+		id:     binary.BigEndian.Uint64(header[9:17]),
+	}, nil
+}
+
+// ServeReplica streams the WAL to a follower connected through rw until the
+// stream fails, the follower falls too far behind or the database is closed.
+// A follower whose offset is still covered by the backlog only receives the
+// missing records; otherwise it is sent a snapshot first. The caller should
+// close rw once ServeReplica returns.
+func (db *DB) ServeReplica(rw io.ReadWriter) error {
+	br := bufio.NewReader(rw)
+	hello, err := readReplMessage(br)
+	if err != nil {
+		return fmt.Errorf("failed to read replica handshake: %w", err)
+	}
+	if hello.typ != replHello {
+		return fmt.Errorf("unexpected replication message %d", hello.typ)
+	}
+
+	r, id, pending, snap := db.registerReplica(hello)
+	defer db.unregisterReplica(r)
+
+	bw := bufio.NewWriter(rw)
+	if snap != nil {
+		err = writeReplMessage(bw, replMessage{typ: replFullSync, offset: snap.txID, id: id})
+		if err == nil {
+			err = writeSnapshot(bw, snap)
+		}
+		snap.release()
+	} else {
+		err = writeReplMessage(bw, replMessage{typ: replContinue, offset: hello.offset, id: id})
+		for _, rec := range pending {
+			if err != nil {
+				break
+			}
+			err = writeReplEntry(bw, rec, id)
+		}
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sync replica: %w", err)
+	}
+
+	// Acknowledgements arrive on the same stream
+	go func() {
+		for {
+			msg, err := readReplMessage(br)
+			if err != nil {
+				r.close(err)
+				return
+			}
+			if msg.typ == replAck {
+				atomic.StoreUint64(&r.ackOffset, msg.offset)
+				atomic.StoreInt64(&r.lastAck, time.Now().UnixNano())
+			}
+		}
+	}()
+
+	ping := time.NewTicker(replPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case rec := <-r.queue:
+			err = writeReplEntry(bw, rec, id)
+			// Batch whatever else is already queued into a single flush
+			for n := len(r.queue); err == nil && n > 0; n-- {
+				err = writeReplEntry(bw, <-r.queue, id)
+			}
+		case <-ping.C:
+			err = writeReplMessage(bw, replMessage{typ: replPing, offset: atomic.LoadUint64(&db.txCounter), id: id})
+		case <-r.done:
+			if r.err == io.EOF {
+				return nil
+			}
+			return r.err
+		}
+		if err == nil {
+			err = bw.Flush()
+		}
+		if err != nil {
+			r.close(err)
+			return fmt.Errorf("failed to stream to replica: %w", err)
+		}
+	}
+}
+
+// writeReplEntry writes a WAL record message
+func writeReplEntry(w io.Writer, rec backlogRecord, id uint64) error {
+	if err := writeReplMessage(w, replMessage{typ: replEntry, offset: rec.txID, id: id}); err != nil {
+		return err
+	}
+	_, err := w.Write(rec.record)
+	return err
+}
+
+// registerReplica starts queueing records for a follower that sent hello. It
+// returns the replication id and the backlog records the follower is missing or,
+// when the follower has another history or the backlog does not reach back far
+// enough, a snapshot to send instead.
+func (db *DB) registerReplica(hello replMessage) (*replica, uint64, []backlogRecord, *snapshot) {
+	// Holding db.mutex keeps writers out, so no record is missed or sent twice
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	repl := &db.repl
+	repl.mutex.Lock()
+	defer repl.mutex.Unlock()
+
+	repl.nextID++
+	r := &replica{
+		id:        repl.nextID,
+		queue:     make(chan backlogRecord, replQueueSize),
+		done:      make(chan struct{}),
+		ackOffset: hello.offset,
+		lastAck:   time.Now().UnixNano(),
+	}
+	if repl.replicas == nil {
+		repl.replicas = make(map[uint64]*replica)
+	}
+	repl.replicas[r.id] = r
+
+	current := atomic.LoadUint64(&db.txCounter)
+	if hello.id != repl.id || hello.offset < repl.backlogStart || hello.offset > current {
+		r.fullSync = true
+		r.ackOffset = 0
+		return r, repl.id, nil, db.capture()
+	}
+
+	var pending []backlogRecord
+	for _, rec := range repl.backlog {
+		if rec.txID > hello.offset {
+			pending = append(pending, rec)
+		}
+	}
+	return r, repl.id, pending, nil
+}
+
+// unregisterReplica stops queueing records for a follower
+func (db *DB) unregisterReplica(r *replica) {
+	db.repl.mutex.Lock()
+	delete(db.repl.replicas, r.id)
+	db.repl.mutex.Unlock()
+	r.close(nil)
+}
+
+// ListenReplicas serves followers connecting to ln until ln is closed
+func (db *DB) ListenReplicas(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			db.ServeReplica(conn)
+		}()
+	}
+}
+
+// ReplicateFrom makes the database follow the primary connected through rw,
+// applying its WAL entries in order until the stream fails or ctx is done.
+// Writes made directly to a follower do not stop the stream, but are
+// overwritten by a later full resync.
+func (db *DB) ReplicateFrom(ctx context.Context, rw io.ReadWriter) error {
+	if !atomic.CompareAndSwapInt32(&db.repl.following, 0, 1) {
+		return xerror.New("already following a primary")
+	}
+	defer atomic.StoreInt32(&db.repl.following, 0)
+
+	// Unblock reads when ctx is done or the database is closed
+	if closer, ok := rw.(io.Closer); ok {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-db.stopChan:
+			case <-stop:
+				return
+			}
+			closer.Close()
+		}()
+	}
+
+	bw := bufio.NewWriter(rw)
+	hello := replMessage{
+		typ:    replHello,
+		offset: atomic.LoadUint64(&db.repl.applied),
+		id:     atomic.LoadUint64(&db.repl.primaryID),
+	}
+	if err := writeReplMessage(bw, hello); err != nil {
+		return fmt.Errorf("failed to send replica handshake: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to send replica handshake: %w", err)
+	}
+
+	br := bufio.NewReader(rw)
+	for {
+		msg, err := readReplMessage(br)
+		if err == nil {
+			err = db.applyReplMessage(br, msg)
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return err
+		}
+		atomic.StoreInt64(&db.repl.lastSync, time.Now().UnixNano())
+
+		// Acknowledge once everything received so far is applied
+		if br.Buffered() == 0 {
+			err = writeReplMessage(bw, replMessage{typ: replAck, offset: atomic.LoadUint64(&db.repl.applied)})
+			if err == nil {
+				err = bw.Flush()
+			}
+			if err != nil {
+				return fmt.Errorf("failed to acknowledge: %w", err)
+			}
+		}
+	}
+}
+
+// applyReplMessage applies a message received from the primary
+func (db *DB) applyReplMessage(br *bufio.Reader, msg replMessage) error {
+	switch msg.typ {
+	case replContinue, replPing:
+		storeMax(&db.repl.primaryOffset, msg.offset)
+	case replFullSync:
+		snap, err := readSnapshot(br)
+		if err != nil {
+			return err
+		}
+		if err := db.restore(snap, true); err != nil {
+			return fmt.Errorf("failed to apply snapshot: %w", err)
+		}
+		atomic.StoreUint64(&db.repl.applied, msg.offset)
+		atomic.StoreUint64(&db.repl.primaryOffset, msg.offset)
+	case replEntry:
+		var entry WALEntry
+		if _, err := readRecord(br, &entry, math.MaxInt64); err != nil {
+			return fmt.Errorf("failed to read replicated entry: %w", err)
+		}
+		if err := db.applyReplicated(entry); err != nil {
+			return err
+		}
+		storeMax(&db.repl.primaryOffset, msg.offset)
+	default:
+		return fmt.Errorf("unexpected replication message %d", msg.typ)
+	}
+	atomic.StoreUint64(&db.repl.primaryID, msg.id)
+	return nil
+}
+
+// applyReplicated applies an entry received from the primary and logs it under
+// a new local transaction id, which differs from the offset once the follower
+// has been written to directly
+func (db *DB) applyReplicated(entry WALEntry) error {
+	db.lock()
+	defer db.mutex.Unlock()
+
+	if entry.TxID <= atomic.LoadUint64(&db.repl.applied) {
+		return nil
+	}
+	txID := atomic.AddUint64(&db.txCounter, 1)
+	for i, cmd := range entry.Commands {
+		if err := db.applyCommand(cmd, txID); err != nil {
+			return err
+		}
+		entry.Commands[i].Version = txID
+	}
+	atomic.StoreUint64(&db.repl.applied, entry.TxID)
+	entry.TxID = txID
+
+	if err := db.writeWAL(entry); err != nil {
+		return fmt.Errorf("failed to write WAL: %w", err)
+	}
+	return db.maybeCheckpoint()
+}
+
+// Follow connects to the primary at addr and follows it, reconnecting with
+// backoff whenever the connection drops, until ctx is done
+func (db *DB) Follow(ctx context.Context, addr string) error {
+	var dialer net.Dialer
+	backoff := 100 * time.Millisecond
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			err = db.ReplicateFrom(ctx, conn)
+			conn.Close()
+			backoff = 100 * time.Millisecond
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-db.stopChan:
+			return ErrClosed
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, replMaxBackoff)
+	}
+}
+
+// ReplicationInfo returns the replication offset, lag and connected followers
+func (db *DB) ReplicationInfo() ReplicationInfo {
+	offset := atomic.LoadUint64(&db.txCounter)
+	info := ReplicationInfo{
+		Offset:    offset,
+		Following: atomic.LoadInt32(&db.repl.following) == 1,
+	}
+	if lastSync := atomic.LoadInt64(&db.repl.lastSync); lastSync > 0 {
+		info.LastSync = time.Unix(0, lastSync)
+	}
+	info.PrimaryOffset = atomic.LoadUint64(&db.repl.primaryOffset)
+	if applied := atomic.LoadUint64(&db.repl.applied); info.PrimaryOffset > applied {
+		info.Lag = info.PrimaryOffset - applied
+	}
+
+	db.repl.mutex.Lock()
+	defer db.repl.mutex.Unlock()
+	for _, r := range db.repl.replicas {
+		ack := atomic.LoadUint64(&r.ackOffset)
+		replica := ReplicaInfo{
+			ID:        r.id,
+			AckOffset: ack,
+			LastAck:   time.Unix(0, atomic.LoadInt64(&r.lastAck)),
+			FullSync:  r.fullSync,
+		}
+		if offset > ack {
+			replica.Lag = offset - ack
+		}
+		info.Replicas = append(info.Replicas, replica)
+	}
+	sort.Slice(info.Replicas, func(i, j int) bool { return info.Replicas[i].ID < info.Replicas[j].ID })
+	return info
+}
+
+// storeMax raises the value at addr to v if it is lower
+func storeMax(addr *uint64, v uint64) {
+	for {
+		current := atomic.LoadUint64(addr)
+		if v <= current || atomic.CompareAndSwapUint64(addr, current, v) {
+			return
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package xedb_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replicate connects follower to primary over an in-memory pipe
+func replicate(t *testing.T, primary, follower *xedb.DB) context.CancelFunc {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	primaryConn, followerConn := net.Pipe()
+	go func() {
+		defer primaryConn.Close()
+		primary.ServeReplica(primaryConn)
+	}()
+	go follower.ReplicateFrom(ctx, followerConn)
+	return cancel
+}
+
+// waitInSync waits until follower has applied everything primary has written
+func waitInSync(t *testing.T, primary, follower *xedb.DB) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		offset := primary.ReplicationInfo().Offset
+		info := follower.ReplicationInfo()
+		return info.PrimaryOffset == offset && info.Lag == 0
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestReplication_Stream(t *testing.T) {
+	primary, cleanupPrimary := setupPubSubDB(t)
+	defer cleanupPrimary()
+	follower, cleanupFollower := setupPubSubDB(t)
+	defer cleanupFollower()
+
+	cancel := replicate(t, primary, follower)
+	defer cancel()
+
+	require.NoError(t, primary.String("name").Set("xedb"))
+	require.NoError(t, primary.List("list").Push("a", "b", "c"))
+	_, err := primary.List("list").LRem(1, "b")
+	require.NoError(t, err)
+	require.NoError(t, primary.Hash("hash").Set("f", "v"))
+	require.NoError(t, primary.ZSet("zset").Add(2, "z"))
+	require.NoError(t, primary.String("ttl").SetWithTTL("v", time.Hour))
+	require.NoError(t, primary.String("gone").Set("x"))
+	_, err = primary.Delete("gone")
+	require.NoError(t, err)
+
+	txn := primary.NewTransaction(true)
+	require.NoError(t, txn.Set("txn", xedb.Entry{Type: xedb.String, Value: "committed"}))
+	require.NoError(t, txn.Commit())
+
+	waitInSync(t, primary, follower)
+
+	value, _ := follower.String("name").Get()
+	assert.Equal(t, "xedb", value)
+	assert.Equal(t, []string{"a", "c"}, follower.List("list").Range(0, -1))
+	value, _ = follower.Hash("hash").Get("f")
+	assert.Equal(t, "v", value)
+	assert.Equal(t, []xedb.ZSetMember{{Member: "z", Score: 2}}, follower.ZSet("zset").Range(0, -1))
+	ttl, ok := follower.String("ttl").TTL()
+	assert.True(t, ok)
+	assert.Greater(t, ttl, 59*time.Minute)
+	assert.Equal(t, 0, follower.Exists("gone"))
+	value, _ = follower.String("txn").Get()
+	assert.Equal(t, "committed", value)
+
+	info := follower.ReplicationInfo()
+	assert.True(t, info.Following)
+	assert.Zero(t, info.Lag)
+
+	require.Eventually(t, func() bool {
+		replicas := primary.ReplicationInfo().Replicas
+		return len(replicas) == 1 && replicas[0].Lag == 0
+	}, 2*time.Second, 5*time.Millisecond)
+	// A follower without a shared history always starts from a snapshot
+	assert.True(t, primary.ReplicationInfo().Replicas[0].FullSync)
+}
+
+func TestReplication_LocalWrite(t *testing.T) {
+	primary, cleanupPrimary := setupPubSubDB(t)
+	defer cleanupPrimary()
+	follower, cleanupFollower := setupPubSubDB(t)
+	defer cleanupFollower()
+
+	cancel := replicate(t, primary, follower)
+	defer cancel()
+	require.NoError(t, primary.String("a").Set("1"))
+	waitInSync(t, primary, follower)
+
+	// A write on the follower does not make it skip the next primary writes
+	require.NoError(t, follower.String("local").Set("x"))
+	require.NoError(t, follower.String("more").Set("y"))
+	require.NoError(t, primary.String("b").Set("2"))
+	require.NoError(t, primary.String("c").Set("3"))
+	waitInSync(t, primary, follower)
+
+	value, _ := follower.String("b").Get()
+	assert.Equal(t, "2", value)
+	value, _ = follower.String("c").Get()
+	assert.Equal(t, "3", value)
+	value, _ = follower.String("local").Get()
+	assert.Equal(t, "x", value)
+	assert.Greater(t, follower.ReplicationInfo().Offset, primary.ReplicationInfo().Offset)
+	require.Eventually(t, func() bool {
+		replicas := primary.ReplicationInfo().Replicas
+		return len(replicas) == 1 && replicas[0].Lag == 0
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestReplication_FullResync(t *testing.T) {
+	// Without a backlog, a follower can only catch up from a snapshot
+	primary, cleanupPrimary := setupPubSubDB(t, xedb.WithReplBacklogSize(0))
+	defer cleanupPrimary()
+	follower, cleanupFollower := setupPubSubDB(t)
+	defer cleanupFollower()
+
+	require.NoError(t, primary.String("before").Set("snapshot"))
+	require.NoError(t, primary.Set("set").Add("a", "b"))
+	require.NoError(t, follower.String("stale").Set("replaced by the primary"))
+
+	cancel := replicate(t, primary, follower)
+	defer cancel()
+	waitInSync(t, primary, follower)
+
+	require.NoError(t, primary.String("after").Set("streamed"))
+	waitInSync(t, primary, follower)
+
+	assert.Equal(t, 0, follower.Exists("stale"))
+	value, _ := follower.String("before").Get()
+	assert.Equal(t, "snapshot", value)
+	assert.True(t, follower.Set("set").IsMember("b"))
+	value, _ = follower.String("after").Get()
+	assert.Equal(t, "streamed", value)
+
+	replicas := primary.ReplicationInfo().Replicas
+	require.Len(t, replicas, 1)
+	assert.True(t, replicas[0].FullSync)
+}
+
+func TestReplication_PartialResyncOverTCP(t *testing.T) {
+	primary, cleanupPrimary := setupPubSubDB(t)
+	defer cleanupPrimary()
+	follower, cleanupFollower := setupPubSubDB(t)
+	defer cleanupFollower()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go primary.ListenReplicas(ln)
+
+	require.NoError(t, primary.String("a").Set("1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- follower.Follow(ctx, ln.Addr().String()) }()
+	waitInSync(t, primary, follower)
+
+	// Writes made while the follower is away are replayed from the backlog
+	cancel()
+	assert.ErrorIs(t, <-stopped, context.Canceled)
+	require.Eventually(t, func() bool {
+		return len(primary.ReplicationInfo().Replicas) == 0
+	}, 2*time.Second, 5*time.Millisecond)
+	require.NoError(t, primary.String("b").Set("2"))
+	assert.False(t, follower.ReplicationInfo().Following)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go follower.Follow(ctx, ln.Addr().String())
+	waitInSync(t, primary, follower)
+
+	value, _ := follower.String("b").Get()
+	assert.Equal(t, "2", value)
+	replicas := primary.ReplicationInfo().Replicas
+	require.Len(t, replicas, 1)
+	assert.False(t, replicas[0].FullSync)
+}
@@ -0,0 +1,152 @@
+package xedb
+
+import (
+	"strings"
+	"time"
+)
+
+// keyspace keeps the keys of the database ordered twice: by key for prefix
+// iteration, and by a hash of the key for Scan. Positions in either order only
+// depend on the key itself, so cursors and iterators stay valid across writes.
+// It is guarded by db.mutex.
+type keyspace struct {
+	// lex holds every key with a zero score, which orders them bytewise
+	lex *skiplist
+	// hashed holds every key scored by scanHash
+	hashed *skiplist
+}
+
+// newKeyspace returns an empty keyspace
+func newKeyspace() *keyspace {
+	return &keyspace{lex: newSkiplist(), hashed: newSkiplist()}
+}
+
+// add inserts a key that must not already be present
+func (ks *keyspace) add(key string) {
+	ks.lex.insert(0, key)
+	ks.hashed.insert(scanHash(key), key)
+}
+
+// remove deletes a key
+func (ks *keyspace) remove(key string) {
+	ks.lex.delete(0, key)
+	ks.hashed.delete(scanHash(key), key)
+}
+
+// scanHash returns the 52 high bits of the FNV-1a hash of key, which a float64
+// score and a Scan cursor represent exactly
+func scanHash(key string) float64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return float64(h >> 12)
+}
+
+// contains reports whether key is present
+func (ks *keyspace) contains(key string) bool {
+	x := ks.lex.seek(0, key)
+	return x != nil && x.member == key
+}
+
+// resetKeyspace rebuilds the keyspace from the engine.
+// Caller must hold db.mutex for writing or own db exclusively.
+func (db *DB) resetKeyspace() {
+	db.keyspace = newKeyspace()
+	db.engine.Range(func(key string, _ Entry) bool {
+		db.keyspace.add(key)
+		return true
+	})
+}
+
+// patternPrefix returns the literal prefix every key matching a glob pattern starts with
+func patternPrefix(pattern string) string {
+	var prefix strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[':
+			return prefix.String()
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+		}
+		prefix.WriteByte(pattern[i])
+	}
+	return prefix.String()
+}
+
+// Scan returns a page of live keys matching a glob-style pattern and, if any are
+// given, one of types, along with the cursor of the next page. Iteration starts
+// with cursor 0 and is complete when the returned cursor is 0. Every key that
+// exists for the whole iteration is returned at least once, no matter what is
+// written in between; keys added or removed meanwhile may or may not be.
+// Like Redis, count bounds the keys examined per call rather than the keys returned.
+func (db *DB) Scan(cursor uint64, match string, count int, types ...DataType) (uint64, []string) {
+	defer db.observe("Scan", "", time.Now())
+
+	if count <= 0 {
+		count = 10
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	keys := make([]string, 0)
+	now := time.Now()
+	examined := 0
+	x := db.keyspace.hashed.seek(float64(cursor), "")
+	for ; x != nil; x = x.level[0].forward {
+		// Keys sharing a hash are never split across pages, since the cursor
+		// could not tell them apart
+		if examined >= count && x.score != x.backward.score {
+			break
+		}
+		examined++
+
+		key := x.member
+		if db.isExpired(key, now) || (match != "" && !MatchPattern(match, key)) {
+			continue
+		}
+		if len(types) > 0 {
+			if entry, ok := db.engine.Get(key); !ok || !hasType(types, entry.Type) {
+				continue
+			}
+		}
+		keys = append(keys, key)
+	}
+
+	if x == nil {
+		return 0, keys
+	}
+	return uint64(x.score), keys
+}
+
+// hasType reports whether typ is one of types
+func hasType(types []DataType, typ DataType) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// lastWithPrefix returns the last key that sorts before prefix or starts with it
+func (ks *keyspace) lastWithPrefix(prefix string) *skiplistNode {
+	// The successor of a prefix is the smallest string above every key starting with it
+	end := []byte(prefix)
+	for len(end) > 0 && end[len(end)-1] == 0xff {
+		end = end[:len(end)-1]
+	}
+	if len(end) == 0 {
+		return ks.lex.tail
+	}
+	end[len(end)-1]++
+
+	if x := ks.lex.seek(0, string(end)); x != nil {
+		return x.backward
+	}
+	return ks.lex.tail
+}
@@ -0,0 +1,141 @@
+package xedb_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scanAll pages through the whole keyspace and counts how often each key is returned
+func scanAll(db *xedb.DB, match string, count int, types ...xedb.DataType) map[string]int {
+	seen := make(map[string]int)
+	var cursor uint64
+	for {
+		next, keys := db.Scan(cursor, match, count, types...)
+		for _, key := range keys {
+			seen[key]++
+		}
+		if next == 0 {
+			return seen
+		}
+		cursor = next
+	}
+}
+
+func TestDB_Scan(t *testing.T) {
+	db, cleanup := setupPubSubDB(t)
+	defer cleanup()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.String(fmt.Sprintf("user:%03d", i)).Set("x"))
+	}
+	require.NoError(t, db.Hash("user:profile").Set("name", "alice"))
+	require.NoError(t, db.List("queue").Push("job"))
+
+	seen := scanAll(db, "", 7)
+	assert.Len(t, seen, 102)
+	for key, n := range seen {
+		assert.Equal(t, 1, n, key)
+	}
+
+	assert.Len(t, scanAll(db, "user:*", 10), 101)
+	assert.Len(t, scanAll(db, "user:0[0-4]?", 10), 50)
+	assert.Equal(t, map[string]int{"user:profile": 1}, scanAll(db, "user:*", 10, xedb.Hash))
+	assert.Len(t, scanAll(db, "", 10, xedb.Hash, xedb.List), 2)
+
+	t.Run("Count", func(t *testing.T) {
+		next, keys := db.Scan(0, "", 5)
+		assert.NotZero(t, next)
+		assert.Len(t, keys, 5)
+
+		// Filtered keys still count towards the work done per call
+		_, keys = db.Scan(0, "queue", 5)
+		assert.LessOrEqual(t, len(keys), 1)
+	})
+
+	t.Run("Keys", func(t *testing.T) {
+		assert.Equal(t, []string{"user:010", "user:011"}, db.Keys("user:01[01]"))
+		assert.Equal(t, []string{"queue"}, db.Keys("q*"))
+		assert.Empty(t, db.Keys("user\\*"))
+	})
+}
+
+func TestDB_ScanConcurrentWrites(t *testing.T) {
+	db, cleanup := setupPubSubDB(t)
+	defer cleanup()
+
+	for i := 0; i < 500; i++ {
+		require.NoError(t, db.String(fmt.Sprintf("stable:%d", i)).Set("x"))
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := fmt.Sprintf("churn:%d", i%200)
+			if i%3 == 0 {
+				db.Delete(key)
+			} else {
+				db.String(key).Set("x")
+			}
+		}
+	}()
+
+	// Keys that exist for the whole scan are returned no matter what changes around them
+	seen := scanAll(db, "stable:*", 16)
+	close(stop)
+	wg.Wait()
+	assert.Len(t, seen, 500)
+}
+
+func TestDB_IteratorConcurrentWrites(t *testing.T) {
+	db, cleanup := setupPubSubDB(t)
+	defer cleanup()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.String(fmt.Sprintf("k:%d", i)).Set(fmt.Sprint(i)))
+	}
+	require.NoError(t, db.String("other").Set("x"))
+
+	it := db.NewIterator(xedb.IteratorOptions{Prefix: "k:"})
+	var keys []string
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		require.NotNil(t, item)
+		keys = append(keys, item.Value.(string))
+
+		// Writes behind, at and ahead of the iterator do not disturb it
+		if len(keys) == 3 {
+			_, err := db.Delete("k:0", "k:2", "k:5")
+			require.NoError(t, err)
+			require.NoError(t, db.String("k:55").Set("55"))
+			require.NoError(t, db.String("k:1").Set("1"))
+		}
+	}
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "55", "6", "7", "8", "9"}, keys)
+
+	t.Run("Reverse", func(t *testing.T) {
+		it := db.NewIterator(xedb.IteratorOptions{Prefix: "k:", Reverse: true})
+		var keys []string
+		for it.Seek("k:4"); it.Valid(); it.Next() {
+			keys = append(keys, it.Item().Value.(string))
+		}
+		assert.Equal(t, []string{"4", "3", "1"}, keys)
+
+		// Seeking past the prefix starts from its last key
+		it.Seek("z")
+		require.True(t, it.Valid())
+		assert.Equal(t, "9", it.Item().Value)
+	})
+}
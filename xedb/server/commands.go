@@ -0,0 +1,1626 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+)
+
+// Version is reported to clients by HELLO
+const Version = "1.0.0"
+
+const (
+	errWrongType  = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	errSyntax     = "ERR syntax error"
+	errNotInteger = "ERR value is not an integer or out of range"
+	errNotFloat   = "ERR value is not a valid float"
+	errOOM        = "OOM command not allowed when used memory > 'maxmemory'."
+	errTimeout    = "ERR timeout is not a float or out of range"
+	errHashInt    = "ERR hash value is not an integer"
+	errNaN        = "ERR resulting score is not a number (NaN)"
+)
+
+type cmdFlags int
+
+const (
+	// flagNoAuth marks commands allowed before AUTH
+	flagNoAuth cmdFlags = 1 << iota
+	// flagMulti marks commands that control MULTI and are never queued
+	flagMulti
+	// flagWrite marks commands that modify data
+	flagWrite
+	// flagPubSub marks commands allowed while a RESP2 client is subscribed
+	flagPubSub
+	// flagTxn marks commands that can be queued inside MULTI. Their handlers
+	// only touch data through conn.db, so EXEC can run them against a transaction.
+	flagTxn
+)
+
+// command describes a supported command.
+// arity follows Redis: a positive value is exact, a negative value is a minimum.
+type command struct {
+	name    string
+	arity   int
+	flags   cmdFlags
+	handler func(c *conn, args []string)
+}
+
+var commands map[string]*command
+
+func init() {
+	commands = make(map[string]*command)
+	for _, cmd := range []*command{
+		// Connection
+		{name: "ping", arity: -1, flags: flagNoAuth | flagPubSub | flagTxn, handler: cmdPing},
+		{name: "echo", arity: 2, flags: flagTxn, handler: cmdEcho},
+		{name: "auth", arity: -2, flags: flagNoAuth | flagMulti, handler: cmdAuth},
+		{name: "hello", arity: -1, flags: flagNoAuth | flagMulti, handler: cmdHello},
+		{name: "quit", arity: -1, flags: flagNoAuth | flagMulti | flagPubSub, handler: cmdQuit},
+		{name: "select", arity: 2, handler: cmdSelect},
+		{name: "command", arity: -1, handler: cmdCommand},
+		{name: "client", arity: -2, handler: cmdClient},
+
+		// Server
+		{name: "dbsize", arity: 1, handler: cmdDBSize},
+		{name: "save", arity: 1, handler: cmdSave},
+		{name: "info", arity: -1, handler: cmdInfo},
+
+		// Keys
+		{name: "del", arity: -2, flags: flagWrite | flagTxn, handler: cmdDel},
+		{name: "unlink", arity: -2, flags: flagWrite | flagTxn, handler: cmdDel},
+		{name: "exists", arity: -2, flags: flagTxn, handler: cmdExists},
+		{name: "type", arity: 2, flags: flagTxn, handler: cmdType},
+		{name: "keys", arity: 2, handler: cmdKeys},
+		{name: "scan", arity: -2, handler: cmdScan},
+		{name: "expire", arity: 3, flags: flagWrite | flagTxn, handler: cmdExpire},
+		{name: "pexpire", arity: 3, flags: flagWrite | flagTxn, handler: cmdExpire},
+		{name: "expireat", arity: 3, flags: flagWrite | flagTxn, handler: cmdExpire},
+		{name: "pexpireat", arity: 3, flags: flagWrite | flagTxn, handler: cmdExpire},
+		{name: "ttl", arity: 2, handler: cmdTTL},
+		{name: "pttl", arity: 2, handler: cmdTTL},
+		{name: "persist", arity: 2, flags: flagWrite | flagTxn, handler: cmdPersist},
+
+		// Strings
+		{name: "get", arity: 2, flags: flagTxn, handler: cmdGet},
+		{name: "set", arity: -3, flags: flagWrite | flagTxn, handler: cmdSet},
+		{name: "setex", arity: 4, flags: flagWrite | flagTxn, handler: cmdSetEx},
+		{name: "psetex", arity: 4, flags: flagWrite | flagTxn, handler: cmdSetEx},
+		{name: "mget", arity: -2, flags: flagTxn, handler: cmdMGet},
+		{name: "mset", arity: -3, flags: flagWrite | flagTxn, handler: cmdMSet},
+		{name: "incr", arity: 2, flags: flagWrite | flagTxn, handler: cmdIncr},
+		{name: "incrby", arity: 3, flags: flagWrite | flagTxn, handler: cmdIncr},
+		{name: "decr", arity: 2, flags: flagWrite | flagTxn, handler: cmdIncr},
+		{name: "decrby", arity: 3, flags: flagWrite | flagTxn, handler: cmdIncr},
+		{name: "append", arity: 3, flags: flagWrite | flagTxn, handler: cmdAppend},
+		{name: "getset", arity: 3, flags: flagWrite | flagTxn, handler: cmdGetSet},
+		{name: "setnx", arity: 3, flags: flagWrite | flagTxn, handler: cmdSetNX},
+
+		// Lists
+		{name: "lpush", arity: -3, flags: flagWrite | flagTxn, handler: cmdPush},
+		{name: "rpush", arity: -3, flags: flagWrite | flagTxn, handler: cmdPush},
+		{name: "lpop", arity: 2, flags: flagWrite | flagTxn, handler: cmdPop},
+		{name: "rpop", arity: 2, flags: flagWrite | flagTxn, handler: cmdPop},
+		{name: "lrange", arity: 4, flags: flagTxn, handler: cmdLRange},
+		{name: "llen", arity: 2, flags: flagTxn, handler: cmdLLen},
+		{name: "lrem", arity: 4, flags: flagWrite | flagTxn, handler: cmdLRem},
+		{name: "ltrim", arity: 4, flags: flagWrite | flagTxn, handler: cmdLTrim},
+		{name: "lindex", arity: 3, handler: cmdLIndex},
+		{name: "linsert", arity: 5, flags: flagWrite | flagTxn, handler: cmdLInsert},
+		{name: "blpop", arity: -3, flags: flagWrite | flagTxn, handler: cmdBLPop},
+
+		// Hashes
+		{name: "hset", arity: -4, flags: flagWrite | flagTxn, handler: cmdHSet},
+		{name: "hmset", arity: -4, flags: flagWrite | flagTxn, handler: cmdHSet},
+		{name: "hget", arity: 3, flags: flagTxn, handler: cmdHGet},
+		{name: "hdel", arity: -3, flags: flagWrite | flagTxn, handler: cmdHDel},
+		{name: "hgetall", arity: 2, handler: cmdHGetAll},
+		{name: "hincrby", arity: 4, flags: flagWrite | flagTxn, handler: cmdHIncrBy},
+		{name: "hkeys", arity: 2, handler: cmdHKeys},
+		{name: "hlen", arity: 2, handler: cmdHLen},
+		{name: "hscan", arity: -3, handler: cmdHScan},
+
+		// Sets
+		{name: "sadd", arity: -3, flags: flagWrite | flagTxn, handler: cmdSAdd},
+		{name: "sismember", arity: 3, flags: flagTxn, handler: cmdSIsMember},
+		{name: "srem", arity: -3, flags: flagWrite | flagTxn, handler: cmdSRem},
+		{name: "smembers", arity: 2, handler: cmdSMembers},
+		{name: "sinter", arity: -2, handler: cmdSetAlgebra},
+		{name: "sunion", arity: -2, handler: cmdSetAlgebra},
+		{name: "sdiff", arity: -2, handler: cmdSetAlgebra},
+		{name: "spop", arity: 2, flags: flagWrite | flagTxn, handler: cmdSPop},
+		{name: "srandmember", arity: -2, handler: cmdSRandMember},
+
+		// Sorted sets
+		{name: "zadd", arity: -4, flags: flagWrite | flagTxn, handler: cmdZAdd},
+		{name: "zrange", arity: -4, flags: flagTxn, handler: cmdZRange},
+		{name: "zrem", arity: -3, flags: flagWrite | flagTxn, handler: cmdZRem},
+		{name: "zscore", arity: 3, handler: cmdZScore},
+		{name: "zrank", arity: 3, handler: cmdZRank},
+		{name: "zrangebyscore", arity: -4, handler: cmdZRangeByScore},
+		{name: "zincrby", arity: 4, flags: flagWrite | flagTxn, handler: cmdZIncrBy},
+		{name: "zrevrange", arity: -4, handler: cmdZRevRange},
+		{name: "zcount", arity: 4, handler: cmdZCount},
+
+		// Pub/sub
+		{name: "publish", arity: 3, handler: cmdPublish},
+		{name: "subscribe", arity: -2, flags: flagPubSub, handler: cmdSubscribe},
+		{name: "psubscribe", arity: -2, flags: flagPubSub, handler: cmdSubscribe},
+		{name: "unsubscribe", arity: -1, flags: flagPubSub, handler: cmdUnsubscribe},
+		{name: "punsubscribe", arity: -1, flags: flagPubSub, handler: cmdUnsubscribe},
+
+		// Transactions
+		{name: "multi", arity: 1, flags: flagMulti, handler: cmdMulti},
+		{name: "exec", arity: 1, flags: flagMulti, handler: cmdExec},
+		{name: "discard", arity: 1, flags: flagMulti, handler: cmdDiscard},
+		{name: "watch", arity: -2, flags: flagMulti, handler: cmdWatch},
+		{name: "unwatch", arity: 1, flags: flagMulti, handler: cmdUnwatch},
+	} {
+		commands[cmd.name] = cmd
+	}
+}
+
+// execute validates and runs a single command, or queues it inside MULTI
+func (c *conn) execute(args []string) {
+	name := strings.ToLower(args[0])
+	cmd, ok := commands[name]
+	if !ok {
+		c.flagMultiError()
+		c.w.writeError(fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", args[0], formatArgs(args[1:])))
+		return
+	}
+
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		c.flagMultiError()
+		c.w.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return
+	}
+
+	if !c.authenticated && cmd.flags&flagNoAuth == 0 {
+		c.flagMultiError()
+		c.w.writeError("NOAUTH Authentication required.")
+		return
+	}
+
+	// RESP3 clients can mix pushes and replies; RESP2 clients cannot
+	if c.w.proto < 3 && c.subscriptionCount() > 0 && cmd.flags&flagPubSub == 0 {
+		c.w.writeError(fmt.Sprintf("ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", name))
+		return
+	}
+
+	if c.inMulti && cmd.flags&flagMulti == 0 {
+		if cmd.flags&flagTxn == 0 {
+			c.flagMultiError()
+			c.w.writeError(fmt.Sprintf("ERR command '%s' is not supported inside MULTI", name))
+			return
+		}
+		c.queued = append(c.queued, args)
+		c.w.writeSimple("QUEUED")
+		return
+	}
+
+	cmd.handler(c, args)
+}
+
+// flagMultiError marks the open transaction so EXEC discards it
+func (c *conn) flagMultiError() {
+	if c.inMulti {
+		c.multiErr = true
+	}
+}
+
+// writeErr converts a database error into an error reply
+func (c *conn) writeErr(err error) {
+	writeErr(c.w, err)
+}
+
+func writeErr(w *writer, err error) {
+	switch {
+	case errors.Is(err, xedb.ErrMemoryLimit):
+		w.writeError(errOOM)
+	case errors.Is(err, xedb.ErrTypeMismatch):
+		w.writeError(errWrongType)
+	default:
+		w.writeError("ERR " + err.Error())
+	}
+}
+
+// checkType writes WRONGTYPE and returns false if key exists with a different type
+func (c *conn) checkType(key string, t xedb.DataType) bool {
+	if actual, ok := c.db.Type(key); ok && actual != t {
+		c.w.writeError(errWrongType)
+		return false
+	}
+	return true
+}
+
+// formatArgs quotes the first arguments of an unknown command for the error message
+func formatArgs(args []string) string {
+	var b strings.Builder
+	for i, arg := range args {
+		if i == 8 || b.Len() > 128 {
+			break
+		}
+		fmt.Fprintf(&b, "'%s' ", arg)
+	}
+	return b.String()
+}
+
+// Connection commands
+
+func cmdPing(c *conn, args []string) {
+	switch len(args) {
+	case 1:
+		c.w.writeSimple("PONG")
+	case 2:
+		c.w.writeBulk(args[1])
+	default:
+		c.w.writeError("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func cmdEcho(c *conn, args []string) {
+	c.w.writeBulk(args[1])
+}
+
+func cmdAuth(c *conn, args []string) {
+	if len(args) > 3 {
+		c.w.writeError(errSyntax)
+		return
+	}
+	if c.server.options.Password == "" {
+		c.w.writeError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		return
+	}
+
+	user := "default"
+	if len(args) == 3 {
+		user = args[1]
+	}
+	if !c.server.checkAuth(user, args[len(args)-1]) {
+		c.w.writeError("WRONGPASS invalid username-password pair or user is disabled.")
+		return
+	}
+	c.authenticated = true
+	c.w.writeOK()
+}
+
+// checkAuth compares credentials in constant time
+func (s *Server) checkAuth(user, password string) bool {
+	return user == "default" && subtle.ConstantTimeCompare([]byte(password), []byte(s.options.Password)) == 1
+}
+
+func cmdHello(c *conn, args []string) {
+	proto := c.w.proto
+	i := 1
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil {
+			c.w.writeError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if v < 2 || v > 3 {
+			c.w.writeError("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = v
+		i = 2
+	}
+
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "AUTH":
+			if i+2 >= len(args) {
+				c.w.writeError(errSyntax)
+				return
+			}
+			if c.server.options.Password == "" || !c.server.checkAuth(args[i+1], args[i+2]) {
+				c.w.writeError("WRONGPASS invalid username-password pair or user is disabled.")
+				return
+			}
+			c.authenticated = true
+			i += 2
+		case "SETNAME":
+			if i+1 >= len(args) {
+				c.w.writeError(errSyntax)
+				return
+			}
+			c.name = args[i+1]
+			i++
+		default:
+			c.w.writeError(errSyntax)
+			return
+		}
+	}
+
+	if !c.authenticated {
+		c.w.writeError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+		return
+	}
+
+	c.w.proto = proto
+	c.w.writeMap(7)
+	c.w.writeBulk("server")
+	c.w.writeBulk("xedb")
+	c.w.writeBulk("version")
+	c.w.writeBulk(Version)
+	c.w.writeBulk("proto")
+	c.w.writeInt(int64(proto))
+	c.w.writeBulk("id")
+	c.w.writeInt(c.id)
+	c.w.writeBulk("mode")
+	c.w.writeBulk("standalone")
+	c.w.writeBulk("role")
+	c.w.writeBulk("master")
+	c.w.writeBulk("modules")
+	c.w.writeArray(0)
+}
+
+func cmdQuit(c *conn, args []string) {
+	c.quit = true
+	c.w.writeOK()
+}
+
+func cmdSelect(c *conn, args []string) {
+	if args[1] != "0" {
+		c.w.writeError("ERR DB index is out of range")
+		return
+	}
+	c.w.writeOK()
+}
+
+func cmdCommand(c *conn, args []string) {
+	if len(args) > 1 && strings.EqualFold(args[1], "count") {
+		c.w.writeInt(int64(len(commands)))
+		return
+	}
+	// Clients only use COMMAND for optional introspection, so an empty reply is enough
+	c.w.writeArray(0)
+}
+
+func cmdClient(c *conn, args []string) {
+	switch strings.ToUpper(args[1]) {
+	case "SETNAME":
+		if len(args) != 3 {
+			c.w.writeError(errSyntax)
+			return
+		}
+		c.name = args[2]
+		c.w.writeOK()
+	case "GETNAME":
+		if c.name == "" {
+			c.w.writeNull()
+			return
+		}
+		c.w.writeBulk(c.name)
+	case "ID":
+		c.w.writeInt(c.id)
+	case "SETINFO":
+		c.w.writeOK()
+	default:
+		c.w.writeError(fmt.Sprintf("ERR unknown subcommand '%s'. Try CLIENT HELP.", args[1]))
+	}
+}
+
+// Server commands
+
+func cmdDBSize(c *conn, args []string) {
+	c.w.writeInt(int64(c.server.db.Len()))
+}
+
+func cmdSave(c *conn, args []string) {
+	if err := c.server.db.Save(); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeOK()
+}
+
+// infoSections lists the INFO sections in the order they are reported
+var infoSections = []string{"server", "clients", "memory", "persistence", "stats", "replication", "keyspace"}
+
+// cmdInfo reports database stats as Redis-style "field:value" lines grouped
+// in sections. Arguments select sections; "all", "default" and "everything"
+// select them all.
+func cmdInfo(c *conn, args []string) {
+	selected := make(map[string]bool)
+	for _, arg := range args[1:] {
+		switch name := strings.ToLower(arg); name {
+		case "all", "default", "everything":
+			for _, section := range infoSections {
+				selected[section] = true
+			}
+		default:
+			selected[name] = true
+		}
+	}
+
+	stats := c.server.db.Stats()
+	var b strings.Builder
+	for _, section := range infoSections {
+		if len(selected) > 0 && !selected[section] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", strings.ToUpper(section[:1])+section[1:])
+		field := func(name string, value interface{}) {
+			fmt.Fprintf(&b, "%s:%v\r\n", name, value)
+		}
+
+		switch section {
+		case "server":
+			field("xedb_version", Version)
+			field("uptime_in_seconds", int64(stats.Uptime.Seconds()))
+		case "clients":
+			field("connected_clients", c.server.ClientCount())
+		case "memory":
+			field("used_memory", stats.MemoryUsage)
+			field("maxmemory", stats.MaxMemory)
+		case "persistence":
+			var lastSave int64
+			if !stats.LastSave.IsZero() {
+				lastSave = stats.LastSave.Unix()
+			}
+			field("last_save_time", lastSave)
+			field("wal_size", stats.WALSize)
+			field("aof_size", stats.AOFSize)
+		case "stats":
+			field("total_commands_processed", stats.Ops)
+			field("instantaneous_ops_per_sec", strconv.FormatFloat(stats.OpsPerSec, 'f', 2, 64))
+			field("keyspace_hits", stats.Hits)
+			field("keyspace_misses", stats.Misses)
+			field("expired_keys", stats.ExpiredKeys)
+			field("evicted_keys", stats.EvictedKeys)
+			field("rejected_writes", stats.RejectedWrites)
+			field("txn_commits", stats.TxnCommits)
+			field("txn_conflicts", stats.TxnConflicts)
+			field("slowlog_count", stats.SlowOps)
+		case "replication":
+			info := c.server.db.ReplicationInfo()
+			if info.Following {
+				field("role", "replica")
+				field("replica_lag", info.Lag)
+			} else {
+				field("role", "master")
+				field("connected_replicas", len(info.Replicas))
+			}
+			field("repl_offset", info.Offset)
+		case "keyspace":
+			if stats.Keys > 0 {
+				field("db0", fmt.Sprintf("keys=%d,expires=%d", stats.Keys, stats.Expires))
+			}
+			for typ := xedb.String; typ <= xedb.JSON; typ++ {
+				if n := stats.KeysByType[typ]; n > 0 {
+					field("keys_"+typ.String(), n)
+				}
+			}
+		}
+	}
+	c.w.writeBulk(b.String())
+}
+
+// Key commands
+
+func cmdDel(c *conn, args []string) {
+	n, err := c.db.Delete(args[1:]...)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeInt(int64(n))
+}
+
+func cmdExists(c *conn, args []string) {
+	c.w.writeInt(int64(c.db.Exists(args[1:]...)))
+}
+
+func cmdType(c *conn, args []string) {
+	t, ok := c.db.Type(args[1])
+	if !ok {
+		c.w.writeSimple("none")
+		return
+	}
+	c.w.writeSimple(t.String())
+}
+
+func cmdKeys(c *conn, args []string) {
+	c.w.writeStrings(c.server.db.Keys(args[1]))
+}
+
+func cmdScan(c *conn, args []string) {
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		c.w.writeError("ERR invalid cursor")
+		return
+	}
+
+	match, count := "", 10
+	var types []xedb.DataType
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			c.w.writeError(errSyntax)
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			match = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count < 1 {
+				c.w.writeError(errSyntax)
+				return
+			}
+		case "TYPE":
+			t, ok := xedb.ParseDataType(strings.ToLower(args[i+1]))
+			if !ok {
+				c.w.writeError(fmt.Sprintf("ERR unknown type name '%s'", args[i+1]))
+				return
+			}
+			types = []xedb.DataType{t}
+		default:
+			c.w.writeError(errSyntax)
+			return
+		}
+	}
+
+	next, page := c.server.db.Scan(cursor, match, count, types...)
+	c.w.writeArray(2)
+	c.w.writeBulk(strconv.FormatUint(next, 10))
+	c.w.writeStrings(page)
+}
+
+// parseDeadline converts the argument of EXPIRE, PEXPIRE, EXPIREAT or PEXPIREAT
+// into an absolute deadline
+func parseDeadline(args []string) (time.Time, bool) {
+	n, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "pexpire":
+		return time.Now().Add(time.Duration(n) * time.Millisecond), true
+	case "expireat":
+		return time.Unix(n, 0), true
+	case "pexpireat":
+		return time.UnixMilli(n), true
+	default:
+		return time.Now().Add(time.Duration(n) * time.Second), true
+	}
+}
+
+func cmdExpire(c *conn, args []string) {
+	deadline, ok := parseDeadline(args)
+	if !ok {
+		c.w.writeError(errNotInteger)
+		return
+	}
+
+	err := c.db.String(args[1]).ExpireAt(deadline)
+	if errors.Is(err, xedb.ErrKeyNotFound) {
+		c.w.writeInt(0)
+		return
+	}
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeInt(1)
+}
+
+func cmdTTL(c *conn, args []string) {
+	ttl, ok := c.server.db.String(args[1]).TTL()
+	switch {
+	case !ok:
+		c.w.writeInt(-2)
+	case ttl == xedb.NoExpiration:
+		c.w.writeInt(-1)
+	case strings.EqualFold(args[0], "pttl"):
+		c.w.writeInt(ttl.Milliseconds())
+	default:
+		c.w.writeInt(int64((ttl + 500*time.Millisecond) / time.Second))
+	}
+}
+
+func cmdPersist(c *conn, args []string) {
+	op := c.db.String(args[1])
+	if ttl, ok := op.TTL(); !ok || ttl == xedb.NoExpiration {
+		c.w.writeInt(0)
+		return
+	}
+	if err := op.Persist(); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeInt(1)
+}
+
+// String commands
+
+func cmdGet(c *conn, args []string) {
+	if !c.checkType(args[1], xedb.String) {
+		return
+	}
+	val, ok := c.db.String(args[1]).Get()
+	if !ok {
+		c.w.writeNull()
+		return
+	}
+	c.w.writeBulk(val)
+}
+
+// parseSetOptions parses the EX/PX/NX options of SET
+func parseSetOptions(args []string) (ttl time.Duration, nx bool, ok bool) {
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		if opt == "NX" {
+			if nx {
+				return 0, false, false
+			}
+			nx = true
+			continue
+		}
+
+		if i+1 >= len(args) || ttl != 0 {
+			return 0, false, false
+		}
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil || n <= 0 {
+			return 0, false, false
+		}
+		switch opt {
+		case "EX":
+			ttl = time.Duration(n) * time.Second
+		case "PX":
+			ttl = time.Duration(n) * time.Millisecond
+		default:
+			return 0, false, false
+		}
+		i++
+	}
+	return ttl, nx, true
+}
+
+func cmdSet(c *conn, args []string) {
+	ttl, nx, ok := parseSetOptions(args[3:])
+	if !ok {
+		c.w.writeError(errSyntax)
+		return
+	}
+
+	op := c.db.String(args[1])
+	var err error
+	switch {
+	case nx:
+		var set bool
+		set, err = op.SetNX(args[2])
+		if err == nil && !set {
+			c.w.writeNull()
+			return
+		}
+		if err == nil && ttl > 0 {
+			err = op.Expire(ttl)
+		}
+	case ttl > 0:
+		err = op.SetWithTTL(args[2], ttl)
+	default:
+		err = op.Set(args[2])
+	}
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeOK()
+}
+
+// parseSetExTTL returns the ttl of SETEX or PSETEX, or an error reply
+func parseSetExTTL(args []string) (time.Duration, string) {
+	n, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(args[0]))
+	}
+	if strings.EqualFold(args[0], "psetex") {
+		return time.Duration(n) * time.Millisecond, ""
+	}
+	return time.Duration(n) * time.Second, ""
+}
+
+func cmdSetEx(c *conn, args []string) {
+	ttl, errMsg := parseSetExTTL(args)
+	if errMsg != "" {
+		c.w.writeError(errMsg)
+		return
+	}
+	if err := c.db.String(args[1]).SetWithTTL(args[3], ttl); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeOK()
+}
+
+func cmdMGet(c *conn, args []string) {
+	c.w.writeArray(len(args) - 1)
+	for _, key := range args[1:] {
+		if val, ok := c.db.String(key).Get(); ok {
+			c.w.writeBulk(val)
+		} else {
+			c.w.writeNull()
+		}
+	}
+}
+
+func cmdMSet(c *conn, args []string) {
+	if len(args)%2 == 0 {
+		c.w.writeError("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+
+	ops := make([]xedb.BatchOp, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		ops = append(ops, xedb.BatchOp{Op: "STRING", Key: args[i], Value: args[i+1]})
+	}
+	if err := c.db.ExecuteBatch(ops); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeOK()
+}
+
+// parseIncrDelta returns the signed delta of INCR, INCRBY, DECR or DECRBY, or an error reply
+func parseIncrDelta(args []string) (int64, string) {
+	delta := int64(1)
+	if len(args) == 3 {
+		n, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return 0, errNotInteger
+		}
+		delta = n
+	}
+	if name := strings.ToLower(args[0]); name == "decr" || name == "decrby" {
+		if delta == math.MinInt64 {
+			return 0, "ERR decrement would overflow"
+		}
+		delta = -delta
+	}
+	return delta, ""
+}
+
+func cmdIncr(c *conn, args []string) {
+	delta, errMsg := parseIncrDelta(args)
+	if errMsg != "" {
+		c.w.writeError(errMsg)
+		return
+	}
+
+	n, err := c.db.String(args[1]).IncrBy(delta)
+	if errors.Is(err, xedb.ErrInvalidValue) {
+		c.w.writeError(errNotInteger)
+		return
+	}
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeInt(n)
+}
+
+func cmdAppend(c *conn, args []string) {
+	n, err := c.db.String(args[1]).Append(args[2])
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeInt(int64(n))
+}
+
+func cmdGetSet(c *conn, args []string) {
+	old, existed, err := c.db.String(args[1]).GetSet(args[2])
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	if !existed {
+		c.w.writeNull()
+		return
+	}
+	c.w.writeBulk(old)
+}
+
+func cmdSetNX(c *conn, args []string) {
+	set, err := c.db.String(args[1]).SetNX(args[2])
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeInt(boolInt(set))
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// List commands
+
+func cmdPush(c *conn, args []string) {
+	key := args[1]
+	if !c.checkType(key, xedb.List) {
+		return
+	}
+
+	op := c.db.List(key)
+	var err error
+	if strings.EqualFold(args[0], "lpush") {
+		// LPUSH inserts each value at the head in turn, reversing their order
+		values := make([]string, 0, len(args)-2)
+		for i := len(args) - 1; i >= 2; i-- {
+			values = append(values, args[i])
+		}
+		err = op.LPush(values...)
+	} else {
+		err = op.Push(args[2:]...)
+	}
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeInt(int64(op.Len()))
+}
+
+func cmdPop(c *conn, args []string) {
+	if !c.checkType(args[1], xedb.List) {
+		return
+	}
+
+	val, ok, err := c.db.List(args[1]).PopFrom(strings.EqualFold(args[0], "lpop"))
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	if !ok {
+		c.w.writeNull()
+		return
+	}
+	c.w.writeBulk(val)
+}
+
+func cmdLRange(c *conn, args []string) {
+	start, err1 := strconv.Atoi(args[2])
+	stop, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		c.w.writeError(errNotInteger)
+		return
+	}
+	if !c.checkType(args[1], xedb.List) {
+		return
+	}
+	c.w.writeStrings(c.db.List(args[1]).Range(start, stop))
+}
+
+func cmdLLen(c *conn, args []string) {
+	if !c.checkType(args[1], xedb.List) {
+		return
+	}
+	c.w.writeInt(int64(c.db.List(args[1]).Len()))
+}
+
+func cmdLRem(c *conn, args []string) {
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		c.w.writeError(errNotInteger)
+		return
+	}
+	n, err := c.db.List(args[1]).LRem(count, args[3])
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeInt(int64(n))
+}
+
+func cmdLTrim(c *conn, args []string) {
+	start, err1 := strconv.Atoi(args[2])
+	stop, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		c.w.writeError(errNotInteger)
+		return
+	}
+	if err := c.db.List(args[1]).LTrim(start, stop); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeOK()
+}
+
+func cmdLIndex(c *conn, args []string) {
+	index, err := strconv.Atoi(args[2])
+	if err != nil {
+		c.w.writeError(errNotInteger)
+		return
+	}
+	if !c.checkType(args[1], xedb.List) {
+		return
+	}
+	val, ok := c.server.db.List(args[1]).LIndex(index)
+	if !ok {
+		c.w.writeNull()
+		return
+	}
+	c.w.writeBulk(val)
+}
+
+// parseWhere parses the BEFORE or AFTER argument of LINSERT
+func parseWhere(arg string) (before bool, ok bool) {
+	switch strings.ToUpper(arg) {
+	case "BEFORE":
+		return true, true
+	case "AFTER":
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+func cmdLInsert(c *conn, args []string) {
+	before, ok := parseWhere(args[2])
+	if !ok {
+		c.w.writeError(errSyntax)
+		return
+	}
+	n, err := c.db.List(args[1]).LInsert(before, args[3], args[4])
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeInt(int64(n))
+}
+
+// parseTimeout parses the timeout in seconds of a blocking command
+func parseTimeout(arg string) (float64, bool) {
+	seconds, err := strconv.ParseFloat(arg, 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) {
+		return 0, false
+	}
+	return seconds, true
+}
+
+func cmdBLPop(c *conn, args []string) {
+	seconds, ok := parseTimeout(args[len(args)-1])
+	if !ok {
+		c.w.writeError(errTimeout)
+		return
+	}
+
+	ctx := c.ctx
+	if seconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(seconds*float64(time.Second)))
+		defer cancel()
+	}
+
+	// Let subscription forwarders write while this client waits
+	c.w.flush()
+	c.wmu.Unlock()
+	key, val, err := c.db.BLPop(ctx, args[1:len(args)-1]...)
+	c.wmu.Lock()
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		c.w.writeNullArray()
+	case err != nil:
+		c.writeErr(err)
+	default:
+		c.w.writeStrings([]string{key, val})
+	}
+}
+
+// Hash commands
+
+func cmdHSet(c *conn, args []string) {
+	if len(args)%2 != 0 {
+		c.w.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(args[0])))
+		return
+	}
+	if !c.checkType(args[1], xedb.Hash) {
+		return
+	}
+
+	op := c.db.Hash(args[1])
+	added := 0
+	for i := 2; i < len(args); i += 2 {
+		if _, exists := op.Get(args[i]); !exists {
+			added++
+		}
+		if err := op.Set(args[i], args[i+1]); err != nil {
+			c.writeErr(err)
+			return
+		}
+	}
+
+	if strings.EqualFold(args[0], "hmset") {
+		c.w.writeOK()
+		return
+	}
+	c.w.writeInt(int64(added))
+}
+
+func cmdHGet(c *conn, args []string) {
+	if !c.checkType(args[1], xedb.Hash) {
+		return
+	}
+	val, ok := c.db.Hash(args[1]).Get(args[2])
+	if !ok {
+		c.w.writeNull()
+		return
+	}
+	c.w.writeBulk(val)
+}
+
+func cmdHDel(c *conn, args []string) {
+	n, err := c.db.Hash(args[1]).HDel(args[2:]...)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeInt(int64(n))
+}
+
+func cmdHGetAll(c *conn, args []string) {
+	if !c.checkType(args[1], xedb.Hash) {
+		return
+	}
+	hash := c.server.db.Hash(args[1]).HGetAll()
+	writeHash(c.w, hash)
+}
+
+// writeHash writes fields as a map in sorted field order
+func writeHash(w *writer, hash map[string]string) {
+	fields := make([]string, 0, len(hash))
+	for field := range hash {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	w.writeMap(len(fields))
+	for _, field := range fields {
+		w.writeBulk(field)
+		w.writeBulk(hash[field])
+	}
+}
+
+func cmdHIncrBy(c *conn, args []string) {
+	delta, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		c.w.writeError(errNotInteger)
+		return
+	}
+	n, err := c.db.Hash(args[1]).HIncrBy(args[2], delta)
+	if errors.Is(err, xedb.ErrInvalidValue) {
+		c.w.writeError(errHashInt)
+		return
+	}
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeInt(n)
+}
+
+func cmdHKeys(c *conn, args []string) {
+	if !c.checkType(args[1], xedb.Hash) {
+		return
+	}
+	c.w.writeStrings(c.server.db.Hash(args[1]).HKeys())
+}
+
+func cmdHLen(c *conn, args []string) {
+	if !c.checkType(args[1], xedb.Hash) {
+		return
+	}
+	c.w.writeInt(int64(c.server.db.Hash(args[1]).HLen()))
+}
+
+func cmdHScan(c *conn, args []string) {
+	cursor, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		c.w.writeError("ERR invalid cursor")
+		return
+	}
+	match, count := "", 10
+	for i := 3; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			c.w.writeError(errSyntax)
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			match = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count < 1 {
+				c.w.writeError(errSyntax)
+				return
+			}
+		default:
+			c.w.writeError(errSyntax)
+			return
+		}
+	}
+	if !c.checkType(args[1], xedb.Hash) {
+		return
+	}
+
+	next, fields := c.server.db.Hash(args[1]).HScan(cursor, match, count)
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+
+	c.w.writeArray(2)
+	c.w.writeBulk(strconv.FormatUint(next, 10))
+	c.w.writeArray(len(names) * 2)
+	for _, field := range names {
+		c.w.writeBulk(field)
+		c.w.writeBulk(fields[field])
+	}
+}
+
+// Set commands
+
+func cmdSAdd(c *conn, args []string) {
+	if !c.checkType(args[1], xedb.Set) {
+		return
+	}
+
+	op := c.db.Set(args[1])
+	seen := make(map[string]struct{}, len(args)-2)
+	added := 0
+	for _, member := range args[2:] {
+		if _, dup := seen[member]; dup {
+			continue
+		}
+		seen[member] = struct{}{}
+		if !op.IsMember(member) {
+			added++
+		}
+	}
+
+	if err := op.Add(args[2:]...); err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeInt(int64(added))
+}
+
+func cmdSIsMember(c *conn, args []string) {
+	if !c.checkType(args[1], xedb.Set) {
+		return
+	}
+	if c.db.Set(args[1]).IsMember(args[2]) {
+		c.w.writeInt(1)
+		return
+	}
+	c.w.writeInt(0)
+}
+
+func cmdSRem(c *conn, args []string) {
+	n, err := c.db.Set(args[1]).SRem(args[2:]...)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeInt(int64(n))
+}
+
+func cmdSMembers(c *conn, args []string) {
+	if !c.checkType(args[1], xedb.Set) {
+		return
+	}
+	writeSetMembers(c.w, c.server.db.Set(args[1]).SMembers())
+}
+
+func writeSetMembers(w *writer, members []string) {
+	w.writeSet(len(members))
+	for _, m := range members {
+		w.writeBulk(m)
+	}
+}
+
+func cmdSetAlgebra(c *conn, args []string) {
+	op := c.server.db.Set(args[1])
+	var members []string
+	var err error
+	switch strings.ToLower(args[0]) {
+	case "sinter":
+		members, err = op.SInter(args[2:]...)
+	case "sunion":
+		members, err = op.SUnion(args[2:]...)
+	default:
+		members, err = op.SDiff(args[2:]...)
+	}
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	writeSetMembers(c.w, members)
+}
+
+func cmdSPop(c *conn, args []string) {
+	member, ok, err := c.db.Set(args[1]).SPop()
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	if !ok {
+		c.w.writeNull()
+		return
+	}
+	c.w.writeBulk(member)
+}
+
+func cmdSRandMember(c *conn, args []string) {
+	if len(args) > 3 {
+		c.w.writeError(errSyntax)
+		return
+	}
+	if !c.checkType(args[1], xedb.Set) {
+		return
+	}
+
+	op := c.server.db.Set(args[1])
+	if len(args) == 2 {
+		members := op.SRandMember(1)
+		if len(members) == 0 {
+			c.w.writeNull()
+			return
+		}
+		c.w.writeBulk(members[0])
+		return
+	}
+
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		c.w.writeError(errNotInteger)
+		return
+	}
+	c.w.writeStrings(op.SRandMember(count))
+}
+
+// Sorted set commands
+
+// parseScorePairs parses "score member [score member ...]"
+func parseScorePairs(args []string) ([]xedb.ZSetMember, string) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return nil, errSyntax
+	}
+	members := make([]xedb.ZSetMember, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		score, err := parseFloat(args[i])
+		if err != nil {
+			return nil, errNotFloat
+		}
+		members = append(members, xedb.ZSetMember{Member: args[i+1], Score: score})
+	}
+	return members, ""
+}
+
+func cmdZAdd(c *conn, args []string) {
+	members, errMsg := parseScorePairs(args[2:])
+	if errMsg != "" {
+		c.w.writeError(errMsg)
+		return
+	}
+	if !c.checkType(args[1], xedb.ZSet) {
+		return
+	}
+
+	op := c.db.ZSet(args[1])
+	added := 0
+	for _, m := range members {
+		if _, ok := op.ZScore(m.Member); !ok {
+			added++
+		}
+		if err := op.Add(m.Score, m.Member); err != nil {
+			c.writeErr(err)
+			return
+		}
+	}
+	c.w.writeInt(int64(added))
+}
+
+// parseZRangeArgs parses "start stop [WITHSCORES]"
+func parseZRangeArgs(args []string) (start, stop int, withScores bool, errMsg string) {
+	start, err1 := strconv.Atoi(args[0])
+	stop, err2 := strconv.Atoi(args[1])
+	if err1 != nil || err2 != nil {
+		return 0, 0, false, errNotInteger
+	}
+	switch {
+	case len(args) == 3 && strings.EqualFold(args[2], "withscores"):
+		withScores = true
+	case len(args) > 2:
+		return 0, 0, false, errSyntax
+	}
+	return start, stop, withScores, ""
+}
+
+func cmdZRange(c *conn, args []string) {
+	start, stop, withScores, errMsg := parseZRangeArgs(args[2:])
+	if errMsg != "" {
+		c.w.writeError(errMsg)
+		return
+	}
+	if !c.checkType(args[1], xedb.ZSet) {
+		return
+	}
+	writeZMembers(c.w, c.db.ZSet(args[1]).Range(start, stop), withScores)
+}
+
+// writeZMembers writes sorted set members, as [member, score] pairs in RESP3
+// and as a flat member/score array in RESP2
+func writeZMembers(w *writer, members []xedb.ZSetMember, withScores bool) {
+	if !withScores {
+		w.writeArray(len(members))
+		for _, m := range members {
+			w.writeBulk(m.Member)
+		}
+		return
+	}
+
+	if w.proto >= 3 {
+		w.writeArray(len(members))
+		for _, m := range members {
+			w.writeArray(2)
+			w.writeBulk(m.Member)
+			w.writeDouble(m.Score)
+		}
+		return
+	}
+
+	w.writeArray(len(members) * 2)
+	for _, m := range members {
+		w.writeBulk(m.Member)
+		w.writeBulk(formatFloat(m.Score))
+	}
+}
+
+func cmdZRem(c *conn, args []string) {
+	n, err := c.db.ZSet(args[1]).ZRem(args[2:]...)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeInt(int64(n))
+}
+
+func cmdZScore(c *conn, args []string) {
+	if !c.checkType(args[1], xedb.ZSet) {
+		return
+	}
+	score, ok := c.server.db.ZSet(args[1]).ZScore(args[2])
+	if !ok {
+		c.w.writeNull()
+		return
+	}
+	c.w.writeDouble(score)
+}
+
+func cmdZRank(c *conn, args []string) {
+	if !c.checkType(args[1], xedb.ZSet) {
+		return
+	}
+	rank, ok := c.server.db.ZSet(args[1]).ZRank(args[2])
+	if !ok {
+		c.w.writeNull()
+		return
+	}
+	c.w.writeInt(int64(rank))
+}
+
+func cmdZIncrBy(c *conn, args []string) {
+	delta, err := parseFloat(args[2])
+	if err != nil {
+		c.w.writeError(errNotFloat)
+		return
+	}
+	score, err := c.db.ZSet(args[1]).ZIncrBy(delta, args[3])
+	if errors.Is(err, xedb.ErrInvalidValue) {
+		c.w.writeError(errNaN)
+		return
+	}
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.w.writeDouble(score)
+}
+
+func cmdZRevRange(c *conn, args []string) {
+	start, stop, withScores, errMsg := parseZRangeArgs(args[2:])
+	if errMsg != "" {
+		c.w.writeError(errMsg)
+		return
+	}
+	if !c.checkType(args[1], xedb.ZSet) {
+		return
+	}
+	writeZMembers(c.w, c.server.db.ZSet(args[1]).ZRevRange(start, stop), withScores)
+}
+
+// scoreBound is a ZRANGEBYSCORE/ZCOUNT bound such as "1.5", "(1.5" or "-inf"
+type scoreBound struct {
+	value     float64
+	exclusive bool
+}
+
+func parseScoreBound(s string) (scoreBound, bool) {
+	var b scoreBound
+	if strings.HasPrefix(s, "(") {
+		b.exclusive = true
+		s = s[1:]
+	}
+	v, err := parseFloat(s)
+	if err != nil {
+		return b, false
+	}
+	b.value = v
+	return b, true
+}
+
+// scoreRange returns the members between min and max, honouring exclusive bounds
+func scoreRange(op *xedb.ZSetOp, min, max scoreBound) []xedb.ZSetMember {
+	members := op.ZRangeByScore(min.value, max.value)
+	if !min.exclusive && !max.exclusive {
+		return members
+	}
+	result := members[:0]
+	for _, m := range members {
+		if (min.exclusive && m.Score == min.value) || (max.exclusive && m.Score == max.value) {
+			continue
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+func cmdZRangeByScore(c *conn, args []string) {
+	min, ok1 := parseScoreBound(args[2])
+	max, ok2 := parseScoreBound(args[3])
+	if !ok1 || !ok2 {
+		c.w.writeError("ERR min or max is not a float")
+		return
+	}
+
+	withScores := false
+	offset, limit := 0, -1
+	for i := 4; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				c.w.writeError(errSyntax)
+				return
+			}
+			var err1, err2 error
+			offset, err1 = strconv.Atoi(args[i+1])
+			limit, err2 = strconv.Atoi(args[i+2])
+			if err1 != nil || err2 != nil {
+				c.w.writeError(errNotInteger)
+				return
+			}
+			i += 2
+		default:
+			c.w.writeError(errSyntax)
+			return
+		}
+	}
+	if !c.checkType(args[1], xedb.ZSet) {
+		return
+	}
+
+	members := scoreRange(c.server.db.ZSet(args[1]), min, max)
+	if offset < 0 || offset >= len(members) {
+		members = nil
+	} else {
+		members = members[offset:]
+		if limit >= 0 && limit < len(members) {
+			members = members[:limit]
+		}
+	}
+	writeZMembers(c.w, members, withScores)
+}
+
+func cmdZCount(c *conn, args []string) {
+	min, ok1 := parseScoreBound(args[2])
+	max, ok2 := parseScoreBound(args[3])
+	if !ok1 || !ok2 {
+		c.w.writeError("ERR min or max is not a float")
+		return
+	}
+	if !c.checkType(args[1], xedb.ZSet) {
+		return
+	}
+
+	op := c.server.db.ZSet(args[1])
+	if !min.exclusive && !max.exclusive {
+		c.w.writeInt(int64(op.ZCount(min.value, max.value)))
+		return
+	}
+	c.w.writeInt(int64(len(scoreRange(op, min, max))))
+}
+
+// Transaction commands
+
+func cmdMulti(c *conn, args []string) {
+	if c.inMulti {
+		c.w.writeError("ERR MULTI calls can not be nested")
+		return
+	}
+	c.inMulti = true
+	c.multiErr = false
+	c.queued = nil
+	c.w.writeOK()
+}
+
+func cmdDiscard(c *conn, args []string) {
+	if !c.inMulti {
+		c.w.writeError("ERR DISCARD without MULTI")
+		return
+	}
+	c.resetMulti()
+	c.w.writeOK()
+}
+
+func cmdExec(c *conn, args []string) {
+	if !c.inMulti {
+		c.w.writeError("ERR EXEC without MULTI")
+		return
+	}
+
+	queued, failed, watch := c.queued, c.multiErr, c.watch
+	c.resetMulti()
+	if failed {
+		c.w.writeError("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+	c.exec(queued, watch)
+}
+
+func cmdWatch(c *conn, args []string) {
+	if c.inMulti {
+		c.w.writeError("ERR WATCH inside MULTI is not allowed")
+		return
+	}
+	// EXEC runs in the transaction that watches the keys
+	if c.watch == nil {
+		c.watch = c.server.db.NewTransaction(true)
+	}
+	c.watch.Watch(args[1:]...)
+	c.w.writeOK()
+}
+
+func cmdUnwatch(c *conn, args []string) {
+	c.watch = nil
+	c.w.writeOK()
+}
+
+// resetMulti leaves MULTI, which also forgets watched keys
+func (c *conn) resetMulti() {
+	c.inMulti = false
+	c.multiErr = false
+	c.queued = nil
+	c.watch = nil
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+)
+
+// keyspace is what the commands that can be queued in MULTI read and write.
+// Outside MULTI it is the database; EXEC runs the same handlers against a
+// txnKeyspace, so both paths share one implementation of every command.
+type keyspace interface {
+	Type(key string) (xedb.DataType, bool)
+	Exists(keys ...string) int
+	Delete(keys ...string) (int, error)
+	ExecuteBatch(ops []xedb.BatchOp) error
+	BLPop(ctx context.Context, keys ...string) (string, string, error)
+	String(key string) stringOps
+	List(key string) listOps
+	Hash(key string) hashOps
+	Set(key string) setOps
+	ZSet(key string) zsetOps
+}
+
+// stringOps mirrors xedb.StringOp, including the key-level operations
+type stringOps interface {
+	Get() (string, bool)
+	Set(value string) error
+	SetWithTTL(value string, ttl time.Duration) error
+	SetNX(value string) (bool, error)
+	GetSet(value string) (string, bool, error)
+	IncrBy(delta int64) (int64, error)
+	Append(value string) (int, error)
+	Expire(ttl time.Duration) error
+	ExpireAt(deadline time.Time) error
+	TTL() (time.Duration, bool)
+	Persist() error
+}
+
+// listOps mirrors xedb.ListOp
+type listOps interface {
+	Push(values ...string) error
+	LPush(values ...string) error
+	PopFrom(head bool) (string, bool, error)
+	Range(start, stop int) []string
+	Len() int
+	LRem(count int, value string) (int, error)
+	LTrim(start, stop int) error
+	LInsert(before bool, pivot, value string) (int, error)
+}
+
+// hashOps mirrors xedb.HashOp
+type hashOps interface {
+	Get(field string) (string, bool)
+	Set(field, value string) error
+	HDel(fields ...string) (int, error)
+	HIncrBy(field string, delta int64) (int64, error)
+}
+
+// setOps mirrors xedb.SetOp
+type setOps interface {
+	IsMember(member string) bool
+	Add(members ...string) error
+	SRem(members ...string) (int, error)
+	SPop() (string, bool, error)
+}
+
+// zsetOps mirrors xedb.ZSetOp
+type zsetOps interface {
+	ZScore(member string) (float64, bool)
+	Add(score float64, member string) error
+	Range(start, stop int) []xedb.ZSetMember
+	ZRem(members ...string) (int, error)
+	ZIncrBy(delta float64, member string) (float64, error)
+}
+
+// dbKeyspace runs commands directly against the database
+type dbKeyspace struct {
+	*xedb.DB
+}
+
+func (k dbKeyspace) String(key string) stringOps { return k.DB.String(key) }
+func (k dbKeyspace) List(key string) listOps     { return k.DB.List(key) }
+func (k dbKeyspace) Hash(key string) hashOps     { return k.DB.Hash(key) }
+func (k dbKeyspace) Set(key string) setOps       { return k.DB.Set(key) }
+func (k dbKeyspace) ZSet(key string) zsetOps     { return k.DB.ZSet(key) }
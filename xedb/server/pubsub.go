@@ -0,0 +1,129 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/seefs001/xox/xedb"
+)
+
+// subscriptionCount returns the number of channels and patterns the client is subscribed to
+func (c *conn) subscriptionCount() int {
+	return len(c.subs) + len(c.psubs)
+}
+
+// escapePattern quotes glob metacharacters so a channel name only matches itself
+func escapePattern(channel string) string {
+	if !strings.ContainsAny(channel, `*?[]\`) {
+		return channel
+	}
+	var b strings.Builder
+	for i := 0; i < len(channel); i++ {
+		switch channel[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(channel[i])
+	}
+	return b.String()
+}
+
+// forward writes messages from sub to the client until the subscription is closed
+func (c *conn) forward(sub *xedb.Subscription, pattern bool) {
+	for msg := range sub.Channel() {
+		c.wmu.Lock()
+		if pattern {
+			c.w.writePush(4)
+			c.w.writeBulk("pmessage")
+			c.w.writeBulk(msg.Pattern)
+		} else {
+			c.w.writePush(3)
+			c.w.writeBulk("message")
+		}
+		c.w.writeBulk(msg.Channel)
+		c.w.writeBulk(msg.Payload)
+		c.w.flush()
+		c.wmu.Unlock()
+	}
+}
+
+// writeSubscription writes a (un)subscribe confirmation
+func (c *conn) writeSubscription(kind, channel string) {
+	c.w.writePush(3)
+	c.w.writeBulk(kind)
+	if channel == "" {
+		c.w.writeNull()
+	} else {
+		c.w.writeBulk(channel)
+	}
+	c.w.writeInt(int64(c.subscriptionCount()))
+}
+
+// unsubscribeAll closes every subscription of the client
+func (c *conn) unsubscribeAll() {
+	for channel, sub := range c.subs {
+		sub.Close()
+		delete(c.subs, channel)
+	}
+	for pattern, sub := range c.psubs {
+		sub.Close()
+		delete(c.psubs, pattern)
+	}
+}
+
+func cmdPublish(c *conn, args []string) {
+	c.w.writeInt(int64(c.server.db.Publish(args[1], args[2])))
+}
+
+func cmdSubscribe(c *conn, args []string) {
+	pattern := strings.EqualFold(args[0], "psubscribe")
+	kind := "subscribe"
+	subs := &c.subs
+	if pattern {
+		kind = "psubscribe"
+		subs = &c.psubs
+	}
+	if *subs == nil {
+		*subs = make(map[string]*xedb.Subscription)
+	}
+
+	for _, name := range args[1:] {
+		if _, ok := (*subs)[name]; !ok {
+			glob := name
+			if !pattern {
+				glob = escapePattern(name)
+			}
+			sub := c.server.db.Subscribe(glob)
+			(*subs)[name] = sub
+			go c.forward(sub, pattern)
+		}
+		c.writeSubscription(kind, name)
+	}
+}
+
+func cmdUnsubscribe(c *conn, args []string) {
+	kind := "unsubscribe"
+	subs := c.subs
+	if strings.EqualFold(args[0], "punsubscribe") {
+		kind = "punsubscribe"
+		subs = c.psubs
+	}
+
+	names := args[1:]
+	if len(names) == 0 {
+		for name := range subs {
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			c.writeSubscription(kind, "")
+			return
+		}
+	}
+
+	for _, name := range names {
+		if sub, ok := subs[name]; ok {
+			sub.Close()
+			delete(subs, name)
+		}
+		c.writeSubscription(kind, name)
+	}
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxArgs bounds the number of elements in a single command
+	maxArgs = 1024 * 1024
+	// maxBulkLength bounds the size of a single argument, matching Redis' proto-max-bulk-len
+	maxBulkLength = 512 * 1024 * 1024
+	// maxInlineLength bounds the size of an inline command
+	maxInlineLength = 64 * 1024
+)
+
+// protocolError reports malformed client input; the connection is closed after replying
+type protocolError struct {
+	msg string
+}
+
+func (e *protocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+// reader parses commands sent by clients, either as RESP arrays of bulk
+// strings or as inline space-separated commands
+type reader struct {
+	br *bufio.Reader
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{br: bufio.NewReader(r)}
+}
+
+// buffered returns the number of bytes already read from the connection but not yet parsed
+func (r *reader) buffered() int {
+	return r.br.Buffered()
+}
+
+// readCommand reads the next command. It returns an empty slice for blank inline lines.
+func (r *reader) readCommand() ([]string, error) {
+	prefix, err := r.br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if prefix[0] != '*' {
+		return r.readInline()
+	}
+
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count > maxArgs {
+		return nil, &protocolError{msg: "invalid multibulk length"}
+	}
+	if count <= 0 {
+		return []string{}, nil
+	}
+
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, &protocolError{msg: fmt.Sprintf("expected '$', got '%.1s'", line)}
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkLength {
+			return nil, &protocolError{msg: "invalid bulk length"}
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r.br, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, &protocolError{msg: "invalid bulk terminator"}
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readInline reads a command written as a single line, as sent by telnet
+func (r *reader) readInline() ([]string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(line), nil
+}
+
+// readLine reads a CRLF or LF terminated line without the terminator
+func (r *reader) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.br.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxInlineLength {
+			return "", &protocolError{msg: "too big inline request"}
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// writer encodes replies in RESP2 or RESP3, depending on the protocol
+// negotiated with HELLO. RESP3-only types degrade to their RESP2 equivalents.
+type writer struct {
+	bw    *bufio.Writer
+	proto int
+}
+
+func newWriter(w io.Writer) *writer {
+	return &writer{bw: bufio.NewWriter(w), proto: 2}
+}
+
+func (w *writer) writeLine(prefix byte, s string) {
+	w.bw.WriteByte(prefix)
+	w.bw.WriteString(s)
+	w.bw.WriteString("\r\n")
+}
+
+// writeSimple writes a simple string reply such as OK
+func (w *writer) writeSimple(s string) {
+	w.writeLine('+', s)
+}
+
+// writeOK writes the +OK reply
+func (w *writer) writeOK() {
+	w.writeSimple("OK")
+}
+
+// writeError writes an error reply. msg should start with an error code such as ERR.
+func (w *writer) writeError(msg string) {
+	// Error replies cannot span lines
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	w.writeLine('-', msg)
+}
+
+// writeInt writes an integer reply
+func (w *writer) writeInt(n int64) {
+	w.writeLine(':', strconv.FormatInt(n, 10))
+}
+
+// writeBulk writes a bulk string reply
+func (w *writer) writeBulk(s string) {
+	w.writeLine('$', strconv.Itoa(len(s)))
+	w.bw.WriteString(s)
+	w.bw.WriteString("\r\n")
+}
+
+// writeNull writes a null reply
+func (w *writer) writeNull() {
+	if w.proto >= 3 {
+		w.bw.WriteString("_\r\n")
+		return
+	}
+	w.bw.WriteString("$-1\r\n")
+}
+
+// writeNullArray writes a null array reply, used for aborted transactions
+func (w *writer) writeNullArray() {
+	if w.proto >= 3 {
+		w.bw.WriteString("_\r\n")
+		return
+	}
+	w.bw.WriteString("*-1\r\n")
+}
+
+// writeArray writes the header of an array with n elements
+func (w *writer) writeArray(n int) {
+	w.writeLine('*', strconv.Itoa(n))
+}
+
+// writePush writes the header of an out-of-band push message with n elements
+func (w *writer) writePush(n int) {
+	if w.proto >= 3 {
+		w.writeLine('>', strconv.Itoa(n))
+		return
+	}
+	w.writeArray(n)
+}
+
+// writeMap writes the header of a map with n key/value pairs
+func (w *writer) writeMap(n int) {
+	if w.proto >= 3 {
+		w.writeLine('%', strconv.Itoa(n))
+		return
+	}
+	w.writeArray(n * 2)
+}
+
+// writeSet writes the header of a set with n members
+func (w *writer) writeSet(n int) {
+	if w.proto >= 3 {
+		w.writeLine('~', strconv.Itoa(n))
+		return
+	}
+	w.writeArray(n)
+}
+
+// writeDouble writes a floating point reply
+func (w *writer) writeDouble(f float64) {
+	if w.proto >= 3 {
+		w.writeLine(',', formatFloat(f))
+		return
+	}
+	w.writeBulk(formatFloat(f))
+}
+
+// writeStrings writes an array of bulk strings
+func (w *writer) writeStrings(values []string) {
+	w.writeArray(len(values))
+	for _, v := range values {
+		w.writeBulk(v)
+	}
+}
+
+// writeRaw copies already encoded replies, used to emit buffered EXEC results
+func (w *writer) writeRaw(p []byte) {
+	w.bw.Write(p)
+}
+
+func (w *writer) flush() error {
+	return w.bw.Flush()
+}
+
+// formatFloat formats a float the way Redis does for scores
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	default:
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+}
+
+// parseFloat parses a score, accepting Redis' inf spellings
+func parseFloat(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errors.New("not a float")
+	}
+	return f, nil
+}
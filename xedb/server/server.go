@@ -0,0 +1,371 @@
+// Package server exposes an xedb.DB over TCP using the Redis serialization
+// protocol (RESP2 and RESP3), so redis-cli and Redis client libraries can
+// read and write the same data as the embedding process.
+//
+// MULTI/EXEC runs the queued commands in a single xedb transaction. Every
+// write command can be queued; read commands without a transactional form,
+// such as KEYS, SCAN or TTL, are rejected when queued.
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/seefs001/xox/xerror"
+	"github.com/seefs001/xox/xlog"
+)
+
+var (
+	// ErrServerClosed is returned by Serve and ListenAndServe after Shutdown or Close
+	ErrServerClosed = xerror.New("xedb: server closed")
+
+	errMaxClients = xerror.New("max number of clients reached")
+)
+
+// Options represents server configuration options
+type Options struct {
+	// Addr is the TCP address used by ListenAndServe
+	Addr string
+
+	// Password enables AUTH; clients must authenticate before running commands
+	Password string
+
+	// MaxClients limits the number of concurrent connections (0 means unlimited)
+	MaxClients int
+
+	// IdleTimeout closes connections that send nothing for this long (0 disables)
+	IdleTimeout time.Duration
+}
+
+// DefaultOptions returns default server options
+func DefaultOptions() Options {
+	return Options{
+		Addr:       ":6380",
+		MaxClients: 10000,
+	}
+}
+
+// Option represents a function that sets an option
+type Option func(*Options)
+
+// WithAddr sets the listen address
+func WithAddr(addr string) Option {
+	return func(o *Options) {
+		o.Addr = addr
+	}
+}
+
+// WithPassword sets the password required by AUTH
+func WithPassword(password string) Option {
+	return func(o *Options) {
+		o.Password = password
+	}
+}
+
+// WithMaxClients sets the maximum number of concurrent connections
+func WithMaxClients(n int) Option {
+	return func(o *Options) {
+		o.MaxClients = n
+	}
+}
+
+// WithIdleTimeout sets the idle connection timeout
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.IdleTimeout = timeout
+	}
+}
+
+// Server serves an xedb.DB to RESP clients
+type Server struct {
+	db      *xedb.DB
+	options Options
+
+	mutex     sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+	wg        sync.WaitGroup
+	closing   atomic.Bool
+	nextID    atomic.Int64
+}
+
+// New creates a server for db
+func New(db *xedb.DB, opts ...Option) *Server {
+	options := DefaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return &Server{
+		db:        db,
+		options:   options,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on Options.Addr and serves clients until the server is closed
+func (s *Server) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.options.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln until the server is closed.
+// It always returns a non-nil error; after Shutdown or Close it returns ErrServerClosed.
+func (s *Server) Serve(ln net.Listener) error {
+	if !s.trackListener(ln) {
+		ln.Close()
+		return ErrServerClosed
+	}
+	defer s.untrackListener(ln)
+
+	var backoff time.Duration
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if s.closing.Load() {
+				return ErrServerClosed
+			}
+			var te interface{ Temporary() bool }
+			if errors.As(err, &te) && te.Temporary() {
+				// Back off on temporary accept errors such as running out of file descriptors
+				if backoff == 0 {
+					backoff = 5 * time.Millisecond
+				} else if backoff *= 2; backoff > time.Second {
+					backoff = time.Second
+				}
+				xlog.Warnf("xedb server: accept error: %v; retrying in %v", err, backoff)
+				time.Sleep(backoff)
+				continue
+			}
+			return err
+		}
+		backoff = 0
+
+		c := newConn(s, nc)
+		if err := s.trackConn(c); err != nil {
+			if err == errMaxClients {
+				c.w.writeError("ERR max number of clients reached")
+				c.w.flush()
+			}
+			c.cancel()
+			nc.Close()
+			continue
+		}
+
+		go func() {
+			defer s.untrackConn(c)
+			c.serve()
+		}()
+	}
+}
+
+// Shutdown gracefully stops the server. It closes all listeners, lets each
+// connection finish the commands it has already received, and waits for
+// connections to close or for ctx to be done, whichever comes first.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closing.Store(true)
+
+	s.mutex.Lock()
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	// Wake up connections blocked waiting for the next command or in BLPOP
+	for c := range s.conns {
+		c.nc.SetReadDeadline(time.Now())
+		c.cancel()
+	}
+	s.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.closeConns()
+		return ctx.Err()
+	}
+}
+
+// Close immediately closes all listeners and connections
+func (s *Server) Close() error {
+	s.closing.Store(true)
+
+	s.mutex.Lock()
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	s.mutex.Unlock()
+
+	s.closeConns()
+	return nil
+}
+
+// ClientCount returns the number of connected clients
+func (s *Server) ClientCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.conns)
+}
+
+func (s *Server) closeConns() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.conns {
+		c.cancel()
+		c.nc.Close()
+	}
+}
+
+func (s *Server) trackListener(ln net.Listener) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closing.Load() {
+		return false
+	}
+	s.listeners[ln] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackListener(ln net.Listener) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.listeners, ln)
+}
+
+// trackConn registers c, refusing it when the server is closing or the client limit is reached
+func (s *Server) trackConn(c *conn) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closing.Load() {
+		return ErrServerClosed
+	}
+	if s.options.MaxClients > 0 && len(s.conns) >= s.options.MaxClients {
+		return errMaxClients
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	return nil
+}
+
+func (s *Server) untrackConn(c *conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.conns, c)
+	s.wg.Done()
+}
+
+// conn represents a single client connection
+type conn struct {
+	server *Server
+	nc     net.Conn
+	// ctx is canceled when the connection closes, releasing blocked commands
+	ctx    context.Context
+	cancel context.CancelFunc
+	r      *reader
+	// wmu guards w, which subscription forwarders write to concurrently
+	wmu  sync.Mutex
+	w    *writer
+	id   int64
+	name string
+
+	// db is the data commands read and write: the database, or the
+	// transaction while EXEC runs the queued commands
+	db keyspace
+
+	authenticated bool
+	quit          bool
+
+	// MULTI state. watch is the transaction EXEC runs in once WATCH was called.
+	inMulti  bool
+	multiErr bool
+	queued   [][]string
+	watch    *xedb.Txn
+
+	// Pub/sub state, keyed by channel and by pattern
+	subs  map[string]*xedb.Subscription
+	psubs map[string]*xedb.Subscription
+}
+
+func newConn(s *Server, nc net.Conn) *conn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &conn{
+		server:        s,
+		db:            dbKeyspace{s.db},
+		nc:            nc,
+		ctx:           ctx,
+		cancel:        cancel,
+		r:             newReader(nc),
+		w:             newWriter(nc),
+		id:            s.nextID.Add(1),
+		authenticated: s.options.Password == "",
+	}
+}
+
+// serve reads and executes commands until the client quits, the connection
+// fails, or the server shuts down
+func (c *conn) serve() {
+	defer c.nc.Close()
+	defer c.cancel()
+	defer c.unsubscribeAll()
+
+	for {
+		// Finish pipelined commands that were already received before stopping
+		if c.server.closing.Load() && c.r.buffered() == 0 {
+			return
+		}
+
+		if timeout := c.server.options.IdleTimeout; timeout > 0 {
+			deadline := time.Now().Add(timeout)
+			if c.subscriptionCount() > 0 {
+				// Subscribers legitimately wait for messages without sending anything
+				deadline = time.Time{}
+			}
+			c.nc.SetReadDeadline(deadline)
+			// Shutdown may have reset the deadline just before we did
+			if c.server.closing.Load() && c.r.buffered() == 0 {
+				return
+			}
+		}
+
+		args, err := c.r.readCommand()
+		if err != nil {
+			var pe *protocolError
+			if errors.As(err, &pe) {
+				c.wmu.Lock()
+				c.w.writeError("ERR " + pe.Error())
+				c.w.flush()
+				c.wmu.Unlock()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		c.wmu.Lock()
+		c.execute(args)
+
+		// Batch replies to pipelined commands into a single write
+		if c.r.buffered() == 0 || c.quit {
+			err = c.w.flush()
+		}
+		c.wmu.Unlock()
+		if err != nil || c.quit {
+			return
+		}
+	}
+}
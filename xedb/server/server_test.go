@@ -0,0 +1,632 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/seefs001/xox/xedb/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// respError is an error reply decoded by the test client
+type respError string
+
+// client is a minimal RESP client used to drive the server in tests
+type client struct {
+	t  *testing.T
+	nc net.Conn
+	br *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *client {
+	nc, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { nc.Close() })
+	return &client{t: t, nc: nc, br: bufio.NewReader(nc)}
+}
+
+func (c *client) send(args ...string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := c.nc.Write([]byte(b.String()))
+	require.NoError(c.t, err)
+}
+
+func (c *client) do(args ...string) interface{} {
+	c.send(args...)
+	return c.read()
+}
+
+func (c *client) read() interface{} {
+	c.nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.br.ReadString('\n')
+	require.NoError(c.t, err)
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return respError(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		require.NoError(c.t, err)
+		return n
+	case ',':
+		f, err := strconv.ParseFloat(line[1:], 64)
+		require.NoError(c.t, err)
+		return f
+	case '_':
+		return nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		require.NoError(c.t, err)
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(c.br, buf)
+		require.NoError(c.t, err)
+		return string(buf[:n])
+	case '*', '~', '%':
+		n, err := strconv.Atoi(line[1:])
+		require.NoError(c.t, err)
+		if n < 0 {
+			return nil
+		}
+		if line[0] == '%' {
+			n *= 2
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i] = c.read()
+		}
+		return items
+	}
+	c.t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+func setupServer(t *testing.T, opts ...server.Option) (*server.Server, *xedb.DB, string) {
+	dir, err := os.MkdirTemp("", "xedb-server-test-*")
+	require.NoError(t, err)
+
+	db, err := xedb.New(xedb.WithDataDir(dir), xedb.WithSyncWrite(false))
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := server.New(db, opts...)
+	go srv.Serve(ln)
+
+	t.Cleanup(func() {
+		srv.Close()
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	return srv, db, ln.Addr().String()
+}
+
+func TestServer_Basics(t *testing.T) {
+	_, db, addr := setupServer(t)
+	c := dial(t, addr)
+
+	assert.Equal(t, "PONG", c.do("PING"))
+	assert.Equal(t, "hello", c.do("PING", "hello"))
+	assert.Equal(t, "hi", c.do("ECHO", "hi"))
+
+	t.Run("Strings", func(t *testing.T) {
+		assert.Equal(t, "OK", c.do("SET", "name", "xedb"))
+		assert.Equal(t, "xedb", c.do("GET", "name"))
+		assert.Nil(t, c.do("GET", "missing"))
+
+		// Data written over the network is visible to the embedding process
+		val, exists := db.String("name").Get()
+		assert.True(t, exists)
+		assert.Equal(t, "xedb", val)
+
+		assert.Equal(t, "OK", c.do("MSET", "a", "1", "b", "2"))
+		assert.Equal(t, []interface{}{"1", "2", nil}, c.do("MGET", "a", "b", "c"))
+	})
+
+	t.Run("Lists", func(t *testing.T) {
+		assert.Equal(t, int64(3), c.do("RPUSH", "list", "a", "b", "c"))
+		assert.Equal(t, int64(5), c.do("LPUSH", "list", "y", "z"))
+		assert.Equal(t, []interface{}{"z", "y", "a", "b", "c"}, c.do("LRANGE", "list", "0", "-1"))
+		assert.Equal(t, []interface{}{"z", "y"}, c.do("LRANGE", "list", "-100", "1"))
+		assert.Equal(t, "z", c.do("LPOP", "list"))
+		assert.Equal(t, "c", c.do("RPOP", "list"))
+		assert.Equal(t, int64(3), c.do("LLEN", "list"))
+	})
+
+	t.Run("Hashes", func(t *testing.T) {
+		assert.Equal(t, int64(2), c.do("HSET", "user", "name", "alice", "age", "30"))
+		assert.Equal(t, int64(0), c.do("HSET", "user", "age", "31"))
+		assert.Equal(t, "31", c.do("HGET", "user", "age"))
+		assert.Nil(t, c.do("HGET", "user", "missing"))
+	})
+
+	t.Run("Sets And Sorted Sets", func(t *testing.T) {
+		assert.Equal(t, int64(2), c.do("SADD", "tags", "go", "db", "go"))
+		assert.Equal(t, int64(1), c.do("SISMEMBER", "tags", "go"))
+		assert.Equal(t, int64(0), c.do("SISMEMBER", "tags", "rust"))
+
+		assert.Equal(t, int64(2), c.do("ZADD", "scores", "2", "bob", "1", "alice"))
+		assert.Equal(t, int64(0), c.do("ZADD", "scores", "3", "alice"))
+		assert.Equal(t, []interface{}{"bob", "alice"}, c.do("ZRANGE", "scores", "0", "-1"))
+		assert.Equal(t, []interface{}{"bob", "2", "alice", "3"}, c.do("ZRANGE", "scores", "0", "-1", "WITHSCORES"))
+	})
+
+	t.Run("Keys", func(t *testing.T) {
+		assert.Equal(t, "string", c.do("TYPE", "name"))
+		assert.Equal(t, "zset", c.do("TYPE", "scores"))
+		assert.Equal(t, "none", c.do("TYPE", "missing"))
+		assert.Equal(t, int64(2), c.do("EXISTS", "name", "list", "missing"))
+		assert.Equal(t, []interface{}{"scores"}, c.do("KEYS", "sc*"))
+		assert.Equal(t, int64(2), c.do("DEL", "a", "b", "missing"))
+		assert.Equal(t, int64(0), c.do("EXISTS", "a"))
+	})
+
+	t.Run("Info", func(t *testing.T) {
+		info, ok := c.do("INFO").(string)
+		require.True(t, ok)
+		assert.Contains(t, info, "# Server\r\nxedb_version:"+server.Version+"\r\n")
+		assert.Contains(t, info, "connected_clients:1\r\n")
+		assert.Contains(t, info, "db0:keys=5,expires=0\r\n")
+		assert.Contains(t, info, "keys_zset:1\r\n")
+		assert.Regexp(t, `keyspace_hits:[1-9]\d*\r\n`, info)
+
+		info, ok = c.do("INFO", "memory").(string)
+		require.True(t, ok)
+		assert.True(t, strings.HasPrefix(info, "# Memory\r\nused_memory:"))
+		assert.NotContains(t, info, "# Keyspace")
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		assert.Equal(t, respError("WRONGTYPE Operation against a key holding the wrong kind of value"), c.do("LPUSH", "name", "x"))
+		assert.Equal(t, respError("ERR wrong number of arguments for 'get' command"), c.do("GET"))
+		reply, ok := c.do("NOSUCHCOMMAND", "x").(respError)
+		assert.True(t, ok)
+		assert.Contains(t, string(reply), "ERR unknown command")
+		assert.Equal(t, respError("ERR value is not a valid float"), c.do("ZADD", "scores", "abc", "x"))
+	})
+}
+
+func TestServer_Expire(t *testing.T) {
+	_, _, addr := setupServer(t)
+	c := dial(t, addr)
+
+	assert.Equal(t, "OK", c.do("SET", "session", "token", "PX", "50"))
+	ttl := c.do("PTTL", "session").(int64)
+	assert.Greater(t, ttl, int64(0))
+	assert.LessOrEqual(t, ttl, int64(50))
+
+	assert.Equal(t, "OK", c.do("SET", "cache", "v"))
+	assert.Equal(t, int64(-1), c.do("TTL", "cache"))
+	assert.Equal(t, int64(1), c.do("EXPIRE", "cache", "100"))
+	assert.Equal(t, int64(100), c.do("TTL", "cache"))
+	assert.Equal(t, int64(1), c.do("PERSIST", "cache"))
+	assert.Equal(t, int64(0), c.do("PERSIST", "cache"))
+	assert.Equal(t, int64(0), c.do("EXPIRE", "missing", "100"))
+	assert.Equal(t, int64(-2), c.do("TTL", "missing"))
+
+	time.Sleep(80 * time.Millisecond)
+	assert.Nil(t, c.do("GET", "session"))
+}
+
+func TestServer_Pipelining(t *testing.T) {
+	_, _, addr := setupServer(t)
+	c := dial(t, addr)
+
+	for i := 0; i < 100; i++ {
+		c.send("RPUSH", "queue", strconv.Itoa(i))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, int64(i+1), c.read())
+	}
+
+	// Inline commands as sent by telnet
+	_, err := c.nc.Write([]byte("LLEN queue\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), c.read())
+}
+
+func TestServer_Auth(t *testing.T) {
+	_, _, addr := setupServer(t, server.WithPassword("secret"))
+	c := dial(t, addr)
+
+	assert.Equal(t, "PONG", c.do("PING"))
+	assert.Equal(t, respError("NOAUTH Authentication required."), c.do("GET", "key"))
+	assert.Equal(t, respError("WRONGPASS invalid username-password pair or user is disabled."), c.do("AUTH", "wrong"))
+	assert.Equal(t, "OK", c.do("AUTH", "secret"))
+	assert.Equal(t, "OK", c.do("SET", "key", "value"))
+
+	// HELLO can authenticate and switch protocols in one step
+	c2 := dial(t, addr)
+	reply := c2.do("HELLO", "3", "AUTH", "default", "secret")
+	items, ok := reply.([]interface{})
+	require.True(t, ok)
+	assert.Equal(t, "server", items[0])
+	assert.Equal(t, "xedb", items[1])
+	assert.Equal(t, "value", c2.do("GET", "key"))
+}
+
+func TestServer_RESP3(t *testing.T) {
+	_, _, addr := setupServer(t)
+	c := dial(t, addr)
+
+	reply := c.do("HELLO", "3").([]interface{})
+	assert.Equal(t, "proto", reply[4])
+	assert.Equal(t, int64(3), reply[5])
+
+	assert.Nil(t, c.do("GET", "missing"))
+	assert.Equal(t, int64(2), c.do("ZADD", "z", "1.5", "a", "2", "b"))
+	assert.Equal(t, []interface{}{
+		[]interface{}{"a", 1.5},
+		[]interface{}{"b", 2.0},
+	}, c.do("ZRANGE", "z", "0", "-1", "WITHSCORES"))
+
+	assert.Equal(t, respError("NOPROTO unsupported protocol version"), c.do("HELLO", "4"))
+}
+
+func TestServer_MultiExec(t *testing.T) {
+	_, db, addr := setupServer(t)
+	c := dial(t, addr)
+
+	t.Run("Exec", func(t *testing.T) {
+		assert.Equal(t, "OK", c.do("MULTI"))
+		assert.Equal(t, "QUEUED", c.do("SET", "k", "v"))
+		assert.Equal(t, "QUEUED", c.do("RPUSH", "l", "a", "b"))
+		assert.Equal(t, "QUEUED", c.do("GET", "k"))
+		assert.Equal(t, "QUEUED", c.do("HSET", "h", "f", "1"))
+		assert.Equal(t, []interface{}{"OK", int64(2), "v", int64(1)}, c.do("EXEC"))
+
+		assert.Equal(t, []string{"a", "b"}, db.List("l").Range(0, -1))
+		val, exists := db.Hash("h").Get("f")
+		assert.True(t, exists)
+		assert.Equal(t, "1", val)
+	})
+
+	t.Run("Write Commands", func(t *testing.T) {
+		queue := func(cmds ...[]string) interface{} {
+			t.Helper()
+			require.Equal(t, "OK", c.do("MULTI"))
+			for _, args := range cmds {
+				require.Equal(t, "QUEUED", c.do(args...), args[0])
+			}
+			return c.do("EXEC")
+		}
+
+		assert.Equal(t, []interface{}{
+			int64(1), int64(11), int64(8), int64(7), int64(5), "hello", int64(1), int64(0), nil, "OK", "OK",
+		}, queue(
+			[]string{"INCR", "mn"},
+			[]string{"INCRBY", "mn", "10"},
+			[]string{"DECRBY", "mn", "3"},
+			[]string{"DECR", "mn"},
+			[]string{"APPEND", "ms", "hello"},
+			[]string{"GETSET", "ms", "bye"},
+			[]string{"SETNX", "mlock", "a"},
+			[]string{"SETNX", "mlock", "b"},
+			[]string{"SET", "mlock", "c", "NX"},
+			[]string{"SET", "mfresh", "c", "NX", "EX", "100"},
+			[]string{"SETEX", "mtemp", "100", "v"},
+		))
+		assert.Equal(t, "bye", c.do("GET", "ms"))
+		assert.Equal(t, int64(100), c.do("TTL", "mfresh"))
+		assert.Equal(t, int64(100), c.do("TTL", "mtemp"))
+
+		c.do("RPUSH", "ml", "a", "b", "a", "c")
+		c.do("EXPIRE", "ml", "100")
+		assert.Equal(t, []interface{}{
+			int64(2), int64(3), "OK", []interface{}{"ml", "b"}, nil,
+		}, queue(
+			[]string{"LREM", "ml", "0", "a"},
+			[]string{"LINSERT", "ml", "BEFORE", "c", "x"},
+			[]string{"LTRIM", "ml", "0", "1"},
+			[]string{"BLPOP", "mnone", "ml", "0"},
+			[]string{"BLPOP", "mnone", "1"},
+		))
+		assert.Equal(t, []interface{}{"x"}, c.do("LRANGE", "ml", "0", "-1"))
+		assert.Equal(t, int64(100), c.do("TTL", "ml"), "modifying a list keeps its deadline")
+
+		c.do("HSET", "mh", "a", "1", "b", "2")
+		c.do("SADD", "mset", "a")
+		c.do("ZADD", "mz", "1", "a", "2", "b")
+		assert.Equal(t, []interface{}{
+			int64(11), int64(1), int64(1), nil, "4.5", int64(1),
+		}, queue(
+			[]string{"HINCRBY", "mh", "a", "10"},
+			[]string{"HDEL", "mh", "b", "missing"},
+			[]string{"SREM", "mset", "a"},
+			[]string{"SPOP", "mset"},
+			[]string{"ZINCRBY", "mz", "3.5", "a"},
+			[]string{"ZREM", "mz", "b"},
+		))
+		assert.Equal(t, []interface{}{"a", "11"}, c.do("HGETALL", "mh"))
+		assert.Equal(t, int64(0), c.do("EXISTS", "mset"), "an emptied set is deleted")
+		assert.Equal(t, []interface{}{"a", "4.5"}, c.do("ZRANGE", "mz", "0", "-1", "WITHSCORES"))
+
+		assert.Equal(t, []interface{}{
+			int64(1), int64(1), int64(0), int64(2), int64(0),
+		}, queue(
+			[]string{"EXPIRE", "mn", "100"},
+			[]string{"PERSIST", "mn"},
+			[]string{"PERSIST", "mn"},
+			[]string{"DEL", "ms", "mlock", "missing"},
+			[]string{"PEXPIRE", "missing", "100"},
+		))
+		assert.Equal(t, int64(-1), c.do("TTL", "mn"))
+		assert.Equal(t, int64(0), c.do("EXISTS", "ms", "mlock"))
+
+		assert.Equal(t, []interface{}{int64(1), nil}, queue(
+			[]string{"PEXPIRE", "mn", "0"},
+			[]string{"GET", "mn"},
+		))
+		assert.Equal(t, int64(0), c.do("EXISTS", "mn"), "a past deadline deletes the key on commit")
+
+		assert.Equal(t, []interface{}{
+			respError("ERR value is not an integer or out of range"),
+			respError("WRONGTYPE Operation against a key holding the wrong kind of value"),
+		}, queue(
+			[]string{"INCR", "mfresh"},
+			[]string{"HDEL", "mfresh", "f"},
+		))
+	})
+
+	t.Run("Discard", func(t *testing.T) {
+		assert.Equal(t, "OK", c.do("MULTI"))
+		assert.Equal(t, "QUEUED", c.do("SET", "k", "discarded"))
+		assert.Equal(t, "OK", c.do("DISCARD"))
+		assert.Equal(t, "v", c.do("GET", "k"))
+	})
+
+	t.Run("Abort On Queue Error", func(t *testing.T) {
+		assert.Equal(t, "OK", c.do("MULTI"))
+		assert.Equal(t, "QUEUED", c.do("SET", "k", "aborted"))
+		_, isErr := c.do("GET").(respError)
+		assert.True(t, isErr)
+		assert.Equal(t, respError("EXECABORT Transaction discarded because of previous errors."), c.do("EXEC"))
+		assert.Equal(t, "v", c.do("GET", "k"))
+	})
+
+	t.Run("Concurrent Writes", func(t *testing.T) {
+		// EXEC works on copies, so it never reads a collection another client is changing
+		other := dial(t, addr)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 200; i++ {
+				other.do("HSET", "ch", strconv.Itoa(i), "v")
+				other.do("SADD", "cs", strconv.Itoa(i))
+			}
+		}()
+
+		for i := 0; i < 50; i++ {
+			assert.Equal(t, "OK", c.do("MULTI"))
+			c.do("HSET", "ch", "multi", strconv.Itoa(i))
+			c.do("HDEL", "ch", "0")
+			c.do("SADD", "cs", "multi")
+			c.do("SREM", "cs", "0")
+			_, isErr := c.do("EXEC").(respError)
+			assert.False(t, isErr)
+		}
+		<-done
+		assert.Equal(t, "v", c.do("HGET", "ch", "199"))
+		assert.Equal(t, int64(1), c.do("SISMEMBER", "cs", "199"))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		assert.Equal(t, respError("ERR EXEC without MULTI"), c.do("EXEC"))
+		assert.Equal(t, "OK", c.do("MULTI"))
+		assert.Equal(t, respError("ERR MULTI calls can not be nested"), c.do("MULTI"))
+		assert.Equal(t, "OK", c.do("DISCARD"))
+	})
+}
+
+func TestServer_Watch(t *testing.T) {
+	_, db, addr := setupServer(t)
+	c := dial(t, addr)
+	other := dial(t, addr)
+
+	t.Run("Changed Key Aborts Exec", func(t *testing.T) {
+		assert.Equal(t, "OK", c.do("WATCH", "balance"))
+		assert.Equal(t, "OK", other.do("SET", "balance", "10"))
+		assert.Equal(t, "OK", c.do("MULTI"))
+		assert.Equal(t, "QUEUED", c.do("SET", "balance", "20"))
+		assert.Nil(t, c.do("EXEC"))
+		assert.Equal(t, "10", c.do("GET", "balance"))
+	})
+
+	t.Run("Unchanged Key", func(t *testing.T) {
+		assert.Equal(t, "OK", c.do("WATCH", "balance"))
+		assert.Equal(t, "OK", c.do("MULTI"))
+		assert.Equal(t, "QUEUED", c.do("GET", "balance"))
+		assert.Equal(t, "QUEUED", c.do("SET", "balance", "15"))
+		assert.Equal(t, []interface{}{"10", "OK"}, c.do("EXEC"))
+	})
+
+	t.Run("Unwatch", func(t *testing.T) {
+		assert.Equal(t, "OK", c.do("WATCH", "balance"))
+		require.NoError(t, db.String("balance").Set("0"))
+		assert.Equal(t, "OK", c.do("UNWATCH"))
+		assert.Equal(t, "OK", c.do("MULTI"))
+		assert.Equal(t, "QUEUED", c.do("SET", "balance", "1"))
+		assert.Equal(t, []interface{}{"OK"}, c.do("EXEC"))
+	})
+
+	t.Run("Inside Multi", func(t *testing.T) {
+		assert.Equal(t, "OK", c.do("MULTI"))
+		assert.Equal(t, respError("ERR WATCH inside MULTI is not allowed"), c.do("WATCH", "balance"))
+		assert.Equal(t, "OK", c.do("DISCARD"))
+	})
+}
+
+func TestServer_Scan(t *testing.T) {
+	_, db, addr := setupServer(t)
+	c := dial(t, addr)
+
+	for i := 0; i < 25; i++ {
+		require.NoError(t, db.String(fmt.Sprintf("user:%02d", i)).Set("x"))
+	}
+	require.NoError(t, db.List("user:list").Push("x"))
+
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply := c.do("SCAN", cursor, "MATCH", "user:*", "COUNT", "10", "TYPE", "string").([]interface{})
+		cursor = reply[0].(string)
+		for _, key := range reply[1].([]interface{}) {
+			seen[key.(string)] = true
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Len(t, seen, 25)
+	assert.False(t, seen["user:list"])
+}
+
+func TestServer_MaxClients(t *testing.T) {
+	srv, _, addr := setupServer(t, server.WithMaxClients(1))
+
+	c1 := dial(t, addr)
+	assert.Equal(t, "PONG", c1.do("PING"))
+	assert.Equal(t, 1, srv.ClientCount())
+
+	c2 := dial(t, addr)
+	assert.Equal(t, respError("ERR max number of clients reached"), c2.read())
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	srv, _, addr := setupServer(t)
+	c := dial(t, addr)
+	assert.Equal(t, "PONG", c.do("PING"))
+
+	assert.Equal(t, "OK", c.do("QUIT"))
+
+	c2 := dial(t, addr)
+	assert.Equal(t, "PONG", c2.do("PING"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, srv.Shutdown(ctx))
+	assert.Equal(t, 0, srv.ClientCount())
+
+	_, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+	assert.Error(t, err)
+}
+
+func TestServer_PubSub(t *testing.T) {
+	_, db, addr := setupServer(t)
+	subscriber := dial(t, addr)
+	publisher := dial(t, addr)
+
+	assert.Equal(t, []interface{}{"subscribe", "news", int64(1)}, subscriber.do("SUBSCRIBE", "news"))
+	assert.Equal(t, []interface{}{"psubscribe", "user:*", int64(2)}, subscriber.do("PSUBSCRIBE", "user:*"))
+
+	// RESP2 clients may only manage subscriptions while subscribed
+	_, isErr := subscriber.do("GET", "key").(respError)
+	assert.True(t, isErr)
+
+	assert.Equal(t, int64(1), publisher.do("PUBLISH", "news", "hello"))
+	assert.Equal(t, []interface{}{"message", "news", "hello"}, subscriber.read())
+
+	assert.Equal(t, 1, db.Publish("user:42", "joined"))
+	assert.Equal(t, []interface{}{"pmessage", "user:*", "user:42", "joined"}, subscriber.read())
+
+	assert.Equal(t, []interface{}{"unsubscribe", "news", int64(1)}, subscriber.do("UNSUBSCRIBE"))
+	assert.Equal(t, []interface{}{"punsubscribe", "user:*", int64(0)}, subscriber.do("PUNSUBSCRIBE", "user:*"))
+	assert.Equal(t, int64(0), publisher.do("PUBLISH", "news", "nobody"))
+	assert.Equal(t, "PONG", subscriber.do("PING"))
+}
+
+func TestServer_DataStructureCommands(t *testing.T) {
+	_, db, addr := setupServer(t)
+	c := dial(t, addr)
+
+	t.Run("Strings", func(t *testing.T) {
+		assert.Equal(t, int64(1), c.do("INCR", "n"))
+		assert.Equal(t, int64(11), c.do("INCRBY", "n", "10"))
+		assert.Equal(t, int64(8), c.do("DECRBY", "n", "3"))
+		assert.Equal(t, int64(7), c.do("DECR", "n"))
+		assert.Equal(t, int64(5), c.do("APPEND", "s", "hello"))
+		assert.Equal(t, "hello", c.do("GETSET", "s", "bye"))
+		assert.Equal(t, int64(1), c.do("SETNX", "lock", "a"))
+		assert.Equal(t, int64(0), c.do("SETNX", "lock", "b"))
+		assert.Nil(t, c.do("SET", "lock", "c", "NX"))
+		assert.Equal(t, "OK", c.do("SET", "fresh", "c", "NX", "EX", "100"))
+		assert.Equal(t, int64(100), c.do("TTL", "fresh"))
+		assert.Equal(t, respError("ERR value is not an integer or out of range"), c.do("INCR", "s"))
+	})
+
+	t.Run("Lists", func(t *testing.T) {
+		c.do("RPUSH", "l", "a", "b", "a", "c")
+		assert.Equal(t, int64(2), c.do("LREM", "l", "0", "a"))
+		assert.Equal(t, int64(3), c.do("LINSERT", "l", "BEFORE", "c", "x"))
+		assert.Equal(t, "x", c.do("LINDEX", "l", "1"))
+		assert.Equal(t, "OK", c.do("LTRIM", "l", "0", "1"))
+		assert.Equal(t, []interface{}{"b", "x"}, c.do("LRANGE", "l", "0", "-1"))
+	})
+
+	t.Run("BLPop", func(t *testing.T) {
+		assert.Nil(t, c.do("BLPOP", "jobs", "0.05"))
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			db.List("jobs").Push("job1")
+		}()
+		assert.Equal(t, []interface{}{"jobs", "job1"}, c.do("BLPOP", "other", "jobs", "1"))
+	})
+
+	t.Run("Hashes", func(t *testing.T) {
+		c.do("HSET", "h", "a", "1", "b", "2", "c", "3")
+		assert.Equal(t, int64(3), c.do("HLEN", "h"))
+		assert.Equal(t, int64(11), c.do("HINCRBY", "h", "a", "10"))
+		assert.Equal(t, int64(1), c.do("HDEL", "h", "c", "missing"))
+		assert.Equal(t, []interface{}{"a", "b"}, c.do("HKEYS", "h"))
+		assert.Equal(t, []interface{}{"a", "11", "b", "2"}, c.do("HGETALL", "h"))
+		assert.Equal(t, []interface{}{"0", []interface{}{"a", "11"}}, c.do("HSCAN", "h", "0", "MATCH", "a*"))
+	})
+
+	t.Run("Sets", func(t *testing.T) {
+		c.do("SADD", "s1", "a", "b", "c")
+		c.do("SADD", "s2", "b", "c", "d")
+		assert.Equal(t, []interface{}{"b", "c"}, c.do("SINTER", "s1", "s2"))
+		assert.Equal(t, []interface{}{"a", "b", "c", "d"}, c.do("SUNION", "s1", "s2"))
+		assert.Equal(t, []interface{}{"a"}, c.do("SDIFF", "s1", "s2"))
+		assert.Equal(t, int64(1), c.do("SREM", "s1", "a"))
+		assert.Equal(t, []interface{}{"b", "c"}, c.do("SMEMBERS", "s1"))
+		assert.Len(t, c.do("SRANDMEMBER", "s1", "-3"), 3)
+		member := c.do("SPOP", "s1").(string)
+		assert.Contains(t, []string{"b", "c"}, member)
+	})
+
+	t.Run("Sorted Sets", func(t *testing.T) {
+		c.do("ZADD", "z", "1", "a", "2", "b", "3", "c")
+		assert.Equal(t, "2", c.do("ZSCORE", "z", "b"))
+		assert.Equal(t, int64(2), c.do("ZRANK", "z", "c"))
+		assert.Nil(t, c.do("ZRANK", "z", "missing"))
+		assert.Equal(t, "4.5", c.do("ZINCRBY", "z", "3.5", "a"))
+		assert.Equal(t, []interface{}{"a", "c"}, c.do("ZREVRANGE", "z", "0", "1"))
+		assert.Equal(t, []interface{}{"c"}, c.do("ZRANGEBYSCORE", "z", "(2", "4"))
+		assert.Equal(t, []interface{}{"b", "2"}, c.do("ZRANGEBYSCORE", "z", "-inf", "+inf", "WITHSCORES", "LIMIT", "0", "1"))
+		assert.Equal(t, int64(2), c.do("ZCOUNT", "z", "2", "(4.5"))
+		assert.Equal(t, int64(2), c.do("ZREM", "z", "a", "b"))
+		assert.Equal(t, []interface{}{"c"}, c.do("ZRANGE", "z", "0", "-1"))
+	})
+}
@@ -0,0 +1,726 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+)
+
+// maxExecRetries bounds how often EXEC retries after a write conflict
+const maxExecRetries = 10
+
+// exec runs queued commands in a single xedb transaction. Replies are buffered
+// and only sent once the transaction commits, so clients never see the
+// results of an attempt that was retried or rolled back. A conflict is retried
+// unless the client watched keys, in which case EXEC replies with a null array
+// like Redis does and the client decides whether to try again.
+func (c *conn) exec(queued [][]string, watch *xedb.Txn) {
+	update := false
+	for _, args := range queued {
+		if commands[strings.ToLower(args[0])].flags&flagWrite != 0 {
+			update = true
+			break
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		w.proto = c.w.proto
+
+		txn := watch
+		if txn == nil {
+			txn = c.server.db.NewTransaction(update)
+		}
+		err := c.runQueued(txn, w, queued)
+		if err == nil {
+			err = txn.Commit()
+		}
+
+		if err == nil {
+			c.w.writeArray(len(queued))
+			c.w.writeRaw(buf.Bytes())
+			return
+		}
+		if !errors.Is(err, xedb.ErrConflict) {
+			c.writeErr(err)
+			return
+		}
+		if watch != nil || attempt >= maxExecRetries {
+			c.w.writeNullArray()
+			return
+		}
+	}
+}
+
+// runQueued runs the queued commands through their usual handlers, on a
+// connection that works on txn and buffers its replies in w. It returns the
+// first error of the transaction itself, such as an early conflict, which
+// aborts the attempt.
+func (c *conn) runQueued(txn *xedb.Txn, w *writer, queued [][]string) error {
+	ks := &txnKeyspace{txn: txn}
+	tc := &conn{
+		server:        c.server,
+		ctx:           c.ctx,
+		w:             w,
+		id:            c.id,
+		name:          c.name,
+		authenticated: true,
+		db:            ks,
+	}
+
+	// Handlers run with the writer lock held, as they do in serve
+	tc.wmu.Lock()
+	defer tc.wmu.Unlock()
+	for _, args := range queued {
+		commands[strings.ToLower(args[0])].handler(tc, args)
+		if ks.err != nil {
+			return ks.err
+		}
+	}
+	return w.flush()
+}
+
+// txnKeyspace runs commands against a transaction. Txn.Get returns a private
+// copy of the value, so the operations change it in place and buffer it as the
+// new value without touching anything other connections can see.
+type txnKeyspace struct {
+	txn *xedb.Txn
+	// err is the first error of the transaction itself, as opposed to
+	// errors such as WRONGTYPE that become the reply of a single command
+	err error
+}
+
+// txnKey is the part of a txnKeyspace that operates on a single key
+type txnKey struct {
+	ks  *txnKeyspace
+	key string
+}
+
+type (
+	txnString struct{ txnKey }
+	txnList   struct{ txnKey }
+	txnHash   struct{ txnKey }
+	txnSet    struct{ txnKey }
+	txnZSet   struct{ txnKey }
+)
+
+func (ks *txnKeyspace) String(key string) stringOps { return txnString{txnKey{ks, key}} }
+func (ks *txnKeyspace) List(key string) listOps     { return txnList{txnKey{ks, key}} }
+func (ks *txnKeyspace) Hash(key string) hashOps     { return txnHash{txnKey{ks, key}} }
+func (ks *txnKeyspace) Set(key string) setOps       { return txnSet{txnKey{ks, key}} }
+func (ks *txnKeyspace) ZSet(key string) zsetOps     { return txnZSet{txnKey{ks, key}} }
+
+// fail records err as the error of the transaction and returns it
+func (ks *txnKeyspace) fail(err error) error {
+	if err != nil && ks.err == nil {
+		ks.err = err
+	}
+	return err
+}
+
+func (ks *txnKeyspace) Type(key string) (xedb.DataType, bool) {
+	entry, ok, _ := txnKey{ks, key}.entry()
+	return entry.Type, ok
+}
+
+func (ks *txnKeyspace) Exists(keys ...string) int {
+	count := 0
+	for _, key := range keys {
+		if _, ok, _ := (txnKey{ks, key}).entry(); ok {
+			count++
+		}
+	}
+	return count
+}
+
+func (ks *txnKeyspace) Delete(keys ...string) (int, error) {
+	count := 0
+	for _, key := range keys {
+		_, ok, err := txnKey{ks, key}.entry()
+		if err != nil {
+			return count, err
+		}
+		if !ok {
+			continue
+		}
+		if err := ks.fail(ks.txn.Delete(key)); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (ks *txnKeyspace) ExecuteBatch(ops []xedb.BatchOp) error {
+	for _, op := range ops {
+		// Like ExecuteBatch, an unknown op stores a string
+		t, _ := xedb.ParseDataType(strings.ToLower(op.Op))
+		if err := ks.fail(ks.txn.Set(op.Key, xedb.Entry{Type: t, Value: op.Value})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BLPop pops from the first non-empty list without blocking, as Redis does
+// inside MULTI, and fails with context.DeadlineExceeded if every list is empty
+func (ks *txnKeyspace) BLPop(_ context.Context, keys ...string) (string, string, error) {
+	for _, key := range keys {
+		value, ok, err := ks.List(key).PopFrom(true)
+		if err != nil || ok {
+			return key, value, err
+		}
+	}
+	return "", "", context.DeadlineExceeded
+}
+
+// entry returns the entry at key, including writes pending in the transaction
+func (k txnKey) entry() (xedb.Entry, bool, error) {
+	entry, err := k.ks.txn.Get(k.key)
+	if errors.Is(err, xedb.ErrKeyNotFound) {
+		return xedb.Entry{}, false, nil
+	}
+	if err != nil {
+		return xedb.Entry{}, false, k.ks.fail(err)
+	}
+	return entry, true, nil
+}
+
+// found returns ErrKeyNotFound if the key does not exist
+func (k txnKey) found() error {
+	_, ok, err := k.entry()
+	if err == nil && !ok {
+		return xedb.ErrKeyNotFound
+	}
+	return err
+}
+
+// lookup returns the value at key if it holds t, and ErrTypeMismatch if it
+// holds another type
+func (k txnKey) lookup(t xedb.DataType) (interface{}, bool, error) {
+	entry, ok, err := k.entry()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if entry.Type != t {
+		return nil, false, xedb.ErrTypeMismatch
+	}
+	return entry.Value, true, nil
+}
+
+// replace buffers value as the new contents of key. Like Set, it clears the deadline.
+func (k txnKey) replace(t xedb.DataType, value interface{}) error {
+	return k.ks.fail(k.ks.txn.Set(k.key, xedb.Entry{Type: t, Value: value}))
+}
+
+// update buffers value as the new contents of key, keeping its deadline like
+// the database operations do
+func (k txnKey) update(t xedb.DataType, value interface{}) error {
+	deadline := k.ks.txn.Deadline(k.key)
+	if err := k.replace(t, value); err != nil || deadline.IsZero() {
+		return err
+	}
+	return k.ks.fail(k.ks.txn.ExpireAt(k.key, deadline))
+}
+
+// store buffers a changed collection of size elements, deleting the key once it is empty
+func (k txnKey) store(t xedb.DataType, value interface{}, size int) error {
+	if size == 0 {
+		return k.ks.fail(k.ks.txn.Delete(k.key))
+	}
+	return k.update(t, value)
+}
+
+func (k txnKey) Expire(ttl time.Duration) error {
+	return k.ExpireAt(time.Now().Add(ttl))
+}
+
+func (k txnKey) ExpireAt(deadline time.Time) error {
+	if err := k.found(); err != nil {
+		return err
+	}
+	return k.ks.fail(k.ks.txn.ExpireAt(k.key, deadline))
+}
+
+func (k txnKey) TTL() (time.Duration, bool) {
+	if k.found() != nil {
+		return 0, false
+	}
+	deadline := k.ks.txn.Deadline(k.key)
+	if deadline.IsZero() {
+		return xedb.NoExpiration, true
+	}
+	return time.Until(deadline), true
+}
+
+func (k txnKey) Persist() error {
+	if err := k.found(); err != nil {
+		return err
+	}
+	if k.ks.txn.Deadline(k.key).IsZero() {
+		return nil
+	}
+	return k.ks.fail(k.ks.txn.Persist(k.key))
+}
+
+// String operations
+
+func (op txnString) Get() (string, bool) {
+	value, ok, _ := op.lookup(xedb.String)
+	if !ok {
+		return "", false
+	}
+	return value.(string), true
+}
+
+func (op txnString) Set(value string) error {
+	return op.replace(xedb.String, value)
+}
+
+func (op txnString) SetWithTTL(value string, ttl time.Duration) error {
+	if err := op.Set(value); err != nil {
+		return err
+	}
+	return op.Expire(ttl)
+}
+
+func (op txnString) SetNX(value string) (bool, error) {
+	if _, exists, err := op.entry(); err != nil || exists {
+		return false, err
+	}
+	return true, op.Set(value)
+}
+
+func (op txnString) GetSet(value string) (string, bool, error) {
+	old, exists, err := op.lookup(xedb.String)
+	if err != nil {
+		return "", false, err
+	}
+	if err := op.Set(value); err != nil || !exists {
+		return "", false, err
+	}
+	return old.(string), true, nil
+}
+
+func (op txnString) IncrBy(delta int64) (int64, error) {
+	value, exists, err := op.lookup(xedb.String)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	if exists {
+		if n, err = strconv.ParseInt(value.(string), 10, 64); err != nil {
+			return 0, xedb.ErrInvalidValue
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, xedb.ErrInvalidValue
+	}
+	n += delta
+	return n, op.update(xedb.String, strconv.FormatInt(n, 10))
+}
+
+func (op txnString) Append(value string) (int, error) {
+	current, exists, err := op.lookup(xedb.String)
+	if err != nil {
+		return 0, err
+	}
+	result := value
+	if exists {
+		result = current.(string) + value
+	}
+	return len(result), op.update(xedb.String, result)
+}
+
+// List operations
+
+// list returns the list at key, nil if the key does not exist
+func (op txnList) list() ([]string, error) {
+	value, exists, err := op.lookup(xedb.List)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return value.([]string), nil
+}
+
+func (op txnList) Push(values ...string) error {
+	list, err := op.list()
+	if err != nil {
+		return err
+	}
+	return op.update(xedb.List, append(list, values...))
+}
+
+func (op txnList) LPush(values ...string) error {
+	list, err := op.list()
+	if err != nil {
+		return err
+	}
+	return op.update(xedb.List, append(append([]string(nil), values...), list...))
+}
+
+func (op txnList) PopFrom(head bool) (string, bool, error) {
+	list, err := op.list()
+	if err != nil || len(list) == 0 {
+		return "", false, err
+	}
+
+	var value string
+	if head {
+		value, list = list[0], list[1:]
+	} else {
+		value, list = list[len(list)-1], list[:len(list)-1]
+	}
+	return value, true, op.store(xedb.List, list, len(list))
+}
+
+func (op txnList) Range(start, stop int) []string {
+	list, _ := op.list()
+	lo, hi, ok := rangeBounds(start, stop, len(list))
+	if !ok {
+		return nil
+	}
+	return list[lo:hi]
+}
+
+func (op txnList) Len() int {
+	list, _ := op.list()
+	return len(list)
+}
+
+func (op txnList) LRem(count int, value string) (int, error) {
+	list, err := op.list()
+	if err != nil || list == nil {
+		return 0, err
+	}
+
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	keep := make([]bool, len(list))
+	removed := 0
+	for i := range list {
+		// Walk from the tail when count is negative
+		idx := i
+		if count < 0 {
+			idx = len(list) - 1 - i
+		}
+		if list[idx] == value && (limit == 0 || removed < limit) {
+			removed++
+			continue
+		}
+		keep[idx] = true
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	result := make([]string, 0, len(list)-removed)
+	for i, v := range list {
+		if keep[i] {
+			result = append(result, v)
+		}
+	}
+	return removed, op.store(xedb.List, result, len(result))
+}
+
+func (op txnList) LTrim(start, stop int) error {
+	list, err := op.list()
+	if err != nil || list == nil {
+		return err
+	}
+	lo, hi, ok := rangeBounds(start, stop, len(list))
+	if !ok {
+		lo, hi = 0, 0
+	}
+	return op.store(xedb.List, list[lo:hi], hi-lo)
+}
+
+func (op txnList) LInsert(before bool, pivot, value string) (int, error) {
+	list, err := op.list()
+	if err != nil || list == nil {
+		return 0, err
+	}
+
+	pos := -1
+	for i, v := range list {
+		if v == pivot {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		return -1, nil
+	}
+	if !before {
+		pos++
+	}
+
+	result := make([]string, 0, len(list)+1)
+	result = append(result, list[:pos]...)
+	result = append(result, value)
+	result = append(result, list[pos:]...)
+	return len(result), op.update(xedb.List, result)
+}
+
+// rangeBounds converts Redis-style inclusive indices into slice bounds
+func rangeBounds(start, stop, length int) (int, int, bool) {
+	if start < 0 {
+		start = max(length+start, 0)
+	}
+	if stop < 0 {
+		stop = length + stop
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start >= length || stop < 0 || start > stop {
+		return 0, 0, false
+	}
+	return start, stop + 1, true
+}
+
+// Hash operations
+
+// hash returns the hash at key, an empty one if the key does not exist
+func (op txnHash) hash() (map[string]string, error) {
+	value, exists, err := op.lookup(xedb.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return make(map[string]string), nil
+	}
+	return value.(map[string]string), nil
+}
+
+func (op txnHash) Get(field string) (string, bool) {
+	hash, _ := op.hash()
+	value, ok := hash[field]
+	return value, ok
+}
+
+func (op txnHash) Set(field, value string) error {
+	hash, err := op.hash()
+	if err != nil {
+		return err
+	}
+	hash[field] = value
+	return op.update(xedb.Hash, hash)
+}
+
+func (op txnHash) HDel(fields ...string) (int, error) {
+	hash, err := op.hash()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, field := range fields {
+		if _, ok := hash[field]; ok {
+			delete(hash, field)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, op.store(xedb.Hash, hash, len(hash))
+}
+
+func (op txnHash) HIncrBy(field string, delta int64) (int64, error) {
+	hash, err := op.hash()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	if current, ok := hash[field]; ok {
+		if n, err = strconv.ParseInt(current, 10, 64); err != nil {
+			return 0, xedb.ErrInvalidValue
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, xedb.ErrInvalidValue
+	}
+	n += delta
+
+	hash[field] = strconv.FormatInt(n, 10)
+	return n, op.update(xedb.Hash, hash)
+}
+
+// Set operations
+
+// set returns the set at key, an empty one if the key does not exist
+func (op txnSet) set() (map[string]struct{}, error) {
+	value, exists, err := op.lookup(xedb.Set)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return make(map[string]struct{}), nil
+	}
+	return value.(map[string]struct{}), nil
+}
+
+func (op txnSet) IsMember(member string) bool {
+	set, _ := op.set()
+	_, ok := set[member]
+	return ok
+}
+
+func (op txnSet) Add(members ...string) error {
+	set, err := op.set()
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		set[member] = struct{}{}
+	}
+	return op.update(xedb.Set, set)
+}
+
+func (op txnSet) SRem(members ...string) (int, error) {
+	set, err := op.set()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, member := range members {
+		if _, ok := set[member]; ok {
+			delete(set, member)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, op.store(xedb.Set, set, len(set))
+}
+
+func (op txnSet) SPop() (string, bool, error) {
+	set, err := op.set()
+	if err != nil || len(set) == 0 {
+		return "", false, err
+	}
+
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	member := members[rand.Intn(len(members))]
+
+	delete(set, member)
+	return member, true, op.store(xedb.Set, set, len(set))
+}
+
+// Sorted set operations
+
+// members returns the sorted set at key, ordered by score, then member
+func (op txnZSet) members() ([]xedb.ZSetMember, error) {
+	value, exists, err := op.lookup(xedb.ZSet)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return value.([]xedb.ZSetMember), nil
+}
+
+// scores returns the scores of the sorted set at key by member
+func (op txnZSet) scores() (map[string]float64, error) {
+	members, err := op.members()
+	if err != nil {
+		return nil, err
+	}
+	scores := make(map[string]float64, len(members))
+	for _, m := range members {
+		scores[m.Member] = m.Score
+	}
+	return scores, nil
+}
+
+// storeScores buffers scores as the new sorted set, in the order sorted sets keep
+func (op txnZSet) storeScores(scores map[string]float64) error {
+	zset := make([]xedb.ZSetMember, 0, len(scores))
+	for member, score := range scores {
+		zset = append(zset, xedb.ZSetMember{Member: member, Score: score})
+	}
+	sort.Slice(zset, func(i, j int) bool {
+		if zset[i].Score != zset[j].Score {
+			return zset[i].Score < zset[j].Score
+		}
+		return zset[i].Member < zset[j].Member
+	})
+	return op.store(xedb.ZSet, zset, len(zset))
+}
+
+func (op txnZSet) ZScore(member string) (float64, bool) {
+	scores, _ := op.scores()
+	score, ok := scores[member]
+	return score, ok
+}
+
+func (op txnZSet) Add(score float64, member string) error {
+	if math.IsNaN(score) {
+		return xedb.ErrInvalidValue
+	}
+	scores, err := op.scores()
+	if err != nil {
+		return err
+	}
+	scores[member] = score
+	return op.storeScores(scores)
+}
+
+func (op txnZSet) Range(start, stop int) []xedb.ZSetMember {
+	members, _ := op.members()
+	lo, hi, ok := rangeBounds(start, stop, len(members))
+	if !ok {
+		return nil
+	}
+	return members[lo:hi]
+}
+
+func (op txnZSet) ZRem(members ...string) (int, error) {
+	scores, err := op.scores()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, member := range members {
+		if _, ok := scores[member]; ok {
+			delete(scores, member)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, op.storeScores(scores)
+}
+
+func (op txnZSet) ZIncrBy(delta float64, member string) (float64, error) {
+	scores, err := op.scores()
+	if err != nil {
+		return 0, err
+	}
+	score := scores[member] + delta
+	if math.IsNaN(score) {
+		return 0, xedb.ErrInvalidValue
+	}
+	scores[member] = score
+	return score, op.storeScores(scores)
+}
@@ -0,0 +1,181 @@
+package xedb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// snapshotMagic identifies a stream written by Snapshot
+const snapshotMagic = "XEDBSNAP"
+
+// snapshotVersion is the version of the snapshot format
+const snapshotVersion = 1
+
+// snapshotHeader is the first record of a snapshot
+type snapshotHeader struct {
+	Version   uint32
+	TxCounter uint64
+	Keys      int
+	Indexes   int
+}
+
+// Snapshot writes a consistent point-in-time copy of the database to w.
+// Writers are only blocked while the dataset is copied, not while it is encoded.
+// With an engine that keeps entries on disk, such as LSMStorage, only the
+// memtable is copied and the rest is streamed from the files.
+// Version history is not included.
+func (db *DB) Snapshot(w io.Writer) error {
+	db.mutex.RLock()
+	snap := db.capture()
+	db.mutex.RUnlock()
+	defer snap.release()
+
+	return writeSnapshot(w, snap)
+}
+
+// writeSnapshot writes snap to w in the format read by readSnapshot
+func writeSnapshot(w io.Writer, snap *snapshot) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(snapshotMagic); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	header, err := encodeRecord(snapshotHeader{
+		Version:   snapshotVersion,
+		TxCounter: snap.txID,
+		Keys:      snap.len(),
+		Indexes:   len(snap.indexes),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot header: %w", err)
+	}
+	if _, err := bw.Write(header); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := snap.writeRecords(bw); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// Restore replaces the contents of the database with a snapshot read from r.
+// The snapshot is fully read and verified before the database is touched.
+func (db *DB) Restore(r io.Reader) error {
+	snap, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+	return db.restore(snap, false)
+}
+
+// restore replaces the dataset with snap. A replicated snapshot takes over the
+// transaction counter of the primary; otherwise the counter moves past both.
+func (db *DB) restore(snap *snapshot, replicated bool) error {
+	// Wait for a running rewrite, which would otherwise resurrect the old dataset
+	db.rewriteMutex.Lock()
+	defer db.rewriteMutex.Unlock()
+
+	db.mutex.Lock()
+	txID := snap.txID
+	if !replicated {
+		txID = max(atomic.LoadUint64(&db.txCounter), snap.txID) + 1
+	}
+
+	now := time.Now()
+	entries := make(map[string]Entry, len(snap.entries))
+	expires := make(map[string]time.Time, len(snap.expires))
+	for key, entry := range snap.entries {
+		deadline, ok := snap.expires[key]
+		if ok && !deadline.After(now) {
+			continue
+		}
+		if ok {
+			expires[key] = deadline
+		}
+		entry.Version = txID
+		entry.Value = storedValue(entry.Type, entry.Value)
+		entries[key] = entry
+	}
+	if err := db.engine.Reset(entries); err != nil {
+		db.mutex.Unlock()
+		return fmt.Errorf("failed to restore entries: %w", err)
+	}
+	atomic.StoreUint64(&db.txCounter, txID)
+	db.expires = expires
+	db.resetAccounting()
+	db.resetKeyspace()
+	db.resetIndexes(snap.indexes)
+	db.resetReplication()
+	err := db.checkpoint()
+	db.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to persist restored data: %w", err)
+	}
+
+	if db.options.EnableAOF {
+		return db.rewriteAOF()
+	}
+	return nil
+}
+
+// readSnapshot reads and verifies a snapshot written by Snapshot
+func readSnapshot(r io.Reader) (*snapshot, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != snapshotMagic {
+		return nil, fmt.Errorf("%w: not a snapshot", ErrCorrupted)
+	}
+
+	var header snapshotHeader
+	if _, err := readRecord(br, &header, math.MaxInt64); err != nil {
+		return nil, snapshotError(err)
+	}
+	if header.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+
+	now := time.Now()
+	snap := &snapshot{
+		txID:    header.TxCounter,
+		entries: make(map[string]Entry, header.Keys),
+		expires: make(map[string]time.Time),
+	}
+	for read := 0; read < header.Keys+header.Indexes; {
+		var entry WALEntry
+		if _, err := readRecord(br, &entry, math.MaxInt64); err != nil {
+			return nil, snapshotError(err)
+		}
+		for _, cmd := range entry.Commands {
+			if cmd.Op == opCreateIndex {
+				prefix, _ := cmd.Value.(string)
+				snap.indexes = append(snap.indexes, IndexInfo{Name: cmd.Key, KeyPrefix: prefix, Field: cmd.Field})
+				continue
+			}
+			snap.entries[cmd.Key] = Entry{
+				Type:        cmd.Type,
+				Value:       cmd.Value,
+				Created:     now,
+				LastUpdated: now,
+			}
+			if !cmd.ExpireAt.IsZero() {
+				snap.expires[cmd.Key] = cmd.ExpireAt
+			}
+		}
+		read += len(entry.Commands)
+	}
+	return snap, nil
+}
+
+// snapshotError converts a record error into a snapshot read error
+func snapshotError(err error) error {
+	if err == io.EOF || err == errTornRecord {
+		return fmt.Errorf("%w: snapshot is truncated", ErrCorrupted)
+	}
+	return fmt.Errorf("failed to read snapshot: %w", err)
+}
@@ -0,0 +1,314 @@
+package xedb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+const (
+	// sstIndexInterval is the number of records between sparse index entries
+	sstIndexInterval = 16
+	// bloomBitsPerKey gives a false positive rate of about 1% with bloomHashes hashes
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+	// sstFooterSize is the size of the trailing offset of the meta record
+	sstFooterSize = 8
+)
+
+// lsmRecord is a key and its entry, or a tombstone, as stored in the memtable
+// log and in SSTables. Values are in their persisted form.
+type lsmRecord struct {
+	Key     string
+	Entry   Entry
+	Deleted bool
+}
+
+// bloomFilter answers whether a key may be in a table without reading it
+type bloomFilter struct {
+	Bits []uint64
+}
+
+// bloomHash returns the two hashes combined to derive the probe positions
+func bloomHash(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	return sum, sum>>33 | sum<<31 | 1
+}
+
+func newBloomFilter(keys int) bloomFilter {
+	words := (keys*bloomBitsPerKey + 63) / 64
+	return bloomFilter{Bits: make([]uint64, max(words, 1))}
+}
+
+func (b bloomFilter) add(key string) {
+	h1, h2 := bloomHash(key)
+	m := uint64(len(b.Bits) * 64)
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		b.Bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b bloomFilter) mayContain(key string) bool {
+	if len(b.Bits) == 0 {
+		return true
+	}
+	h1, h2 := bloomHash(key)
+	m := uint64(len(b.Bits) * 64)
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		if b.Bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sstIndexEntry points at the record holding Key
+type sstIndexEntry struct {
+	Key    string
+	Offset int64
+}
+
+// sstMeta follows the records of an SSTable
+type sstMeta struct {
+	Index   []sstIndexEntry
+	Bloom   bloomFilter
+	Count   int
+	DataEnd int64
+}
+
+// sstable is an immutable file of records sorted by key, followed by a meta
+// record with a sparse index and a bloom filter, and the offset of that record.
+// It stays open while referenced: the engine holds one reference while the
+// table is live, and compactions and views hold their own.
+type sstable struct {
+	seq  uint64
+	path string
+	file *os.File
+	size int64
+	meta sstMeta
+	refs atomic.Int32
+	// obsolete tables are removed once the last reference is dropped
+	obsolete atomic.Bool
+}
+
+// sstWriter writes records, which must be added in key order, to a new SSTable
+type sstWriter struct {
+	file   *os.File
+	w      *bufio.Writer
+	offset int64
+	keys   []string
+	meta   sstMeta
+}
+
+func newSSTWriter(path string) (*sstWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create table: %w", err)
+	}
+	return &sstWriter{file: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (sw *sstWriter) add(rec lsmRecord) error {
+	record, err := encodeRecord(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+	if sw.meta.Count%sstIndexInterval == 0 {
+		sw.meta.Index = append(sw.meta.Index, sstIndexEntry{Key: rec.Key, Offset: sw.offset})
+	}
+	if _, err := sw.w.Write(record); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	sw.offset += int64(len(record))
+	sw.keys = append(sw.keys, rec.Key)
+	sw.meta.Count++
+	return nil
+}
+
+// finish writes the meta record and footer and closes the file. On error the
+// caller aborts the writer.
+func (sw *sstWriter) finish(sync bool) error {
+	sw.meta.DataEnd = sw.offset
+	sw.meta.Bloom = newBloomFilter(len(sw.keys))
+	for _, key := range sw.keys {
+		sw.meta.Bloom.add(key)
+	}
+
+	record, err := encodeRecord(sw.meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode table meta: %w", err)
+	}
+	var footer [sstFooterSize]byte
+	binary.BigEndian.PutUint64(footer[:], uint64(sw.offset))
+	if _, err := sw.w.Write(record); err != nil {
+		return fmt.Errorf("failed to write table meta: %w", err)
+	}
+	if _, err := sw.w.Write(footer[:]); err != nil {
+		return fmt.Errorf("failed to write table footer: %w", err)
+	}
+	if err := sw.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write table: %w", err)
+	}
+	if sync {
+		if err := sw.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync table: %w", err)
+		}
+	}
+	return sw.file.Close()
+}
+
+// abort closes and removes a table that is not going to be finished
+func (sw *sstWriter) abort() {
+	sw.file.Close()
+	os.Remove(sw.file.Name())
+}
+
+// openSSTable opens a table and loads its meta record
+func openSSTable(path string, seq uint64) (*sstable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open table: %w", err)
+	}
+	t, err := loadSSTable(f, path, seq)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func loadSSTable(f *os.File, path string, seq uint64) (*sstable, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat table: %w", err)
+	}
+	size := stat.Size()
+	if size < sstFooterSize {
+		return nil, fmt.Errorf("%w: table %s is truncated", ErrCorrupted, path)
+	}
+
+	var footer [sstFooterSize]byte
+	if _, err := f.ReadAt(footer[:], size-sstFooterSize); err != nil {
+		return nil, fmt.Errorf("failed to read table footer: %w", err)
+	}
+	metaOffset := int64(binary.BigEndian.Uint64(footer[:]))
+	if metaOffset < 0 || metaOffset > size-sstFooterSize {
+		return nil, fmt.Errorf("%w: table %s has a bad footer", ErrCorrupted, path)
+	}
+
+	t := &sstable{seq: seq, path: path, file: f, size: size}
+	t.refs.Store(1)
+	metaSize := size - sstFooterSize - metaOffset
+	if _, err := readRecord(io.NewSectionReader(f, metaOffset, metaSize), &t.meta, metaSize); err != nil {
+		return nil, fmt.Errorf("failed to read meta of table %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// get looks key up using the bloom filter and the sparse index
+func (t *sstable) get(key string) (lsmRecord, bool, error) {
+	if !t.meta.Bloom.mayContain(key) {
+		return lsmRecord{}, false, nil
+	}
+
+	index := t.meta.Index
+	i := sort.Search(len(index), func(i int) bool { return index[i].Key > key }) - 1
+	if i < 0 {
+		return lsmRecord{}, false, nil
+	}
+	end := t.meta.DataEnd
+	if i+1 < len(index) {
+		end = index[i+1].Offset
+	}
+
+	it := t.iterator(index[i].Offset, end)
+	for ; it.valid(); it.next() {
+		if it.rec.Key == key {
+			return it.rec, true, nil
+		}
+		if it.rec.Key > key {
+			break
+		}
+	}
+	return lsmRecord{}, false, it.err
+}
+
+// iterator returns an iterator over the records between two offsets
+func (t *sstable) iterator(start, end int64) *sstIterator {
+	it := &sstIterator{
+		r:         bufio.NewReader(io.NewSectionReader(t.file, start, end-start)),
+		remaining: end - start,
+	}
+	it.next()
+	return it
+}
+
+func (t *sstable) close() error {
+	return t.file.Close()
+}
+
+// ref keeps the table open until the matching unref
+func (t *sstable) ref() {
+	t.refs.Add(1)
+}
+
+// unref drops a reference and closes the table once it is unused
+func (t *sstable) unref() {
+	if t.refs.Add(-1) == 0 {
+		t.close()
+		if t.obsolete.Load() {
+			os.Remove(t.path)
+		}
+	}
+}
+
+// retire drops the reference of the engine to a table it no longer lists,
+// removing the file once nothing reads it anymore
+func (t *sstable) retire() {
+	t.obsolete.Store(true)
+	t.unref()
+}
+
+// sstIterator reads the records of a table in key order
+type sstIterator struct {
+	r         *bufio.Reader
+	remaining int64
+	rec       lsmRecord
+	ok        bool
+	err       error
+}
+
+func (it *sstIterator) valid() bool {
+	return it.ok
+}
+
+func (it *sstIterator) record() lsmRecord {
+	return it.rec
+}
+
+func (it *sstIterator) next() {
+	it.ok = false
+	if it.remaining <= 0 || it.err != nil {
+		return
+	}
+
+	it.rec = lsmRecord{}
+	n, err := readRecord(it.r, &it.rec, it.remaining)
+	it.remaining -= n
+	if err != nil {
+		if err != io.EOF {
+			it.err = err
+		}
+		return
+	}
+	it.ok = true
+}
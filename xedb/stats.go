@@ -0,0 +1,269 @@
+package xedb
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// opsSampleInterval is how often the operation rate is sampled
+	opsSampleInterval = 100 * time.Millisecond
+	// opsSamples is the number of samples averaged into Stats.OpsPerSec
+	opsSamples = 16
+)
+
+// Stats is a snapshot of the state and activity of a database
+type Stats struct {
+	// Keys is the number of stored keys, including expired keys not yet removed
+	Keys int
+	// KeysByType is the number of stored keys per data type
+	KeysByType map[DataType]int
+	// Expires is the number of keys with a deadline
+	Expires int
+
+	MemoryUsage int64
+	MaxMemory   int64
+	WALSize     int64
+	AOFSize     int64
+	// LastSave is when the data file was last written
+	LastSave time.Time
+	Uptime   time.Duration
+
+	// Ops counts operations since the database was opened, OpsPerSec is the
+	// recent rate
+	Ops       uint64
+	OpsPerSec float64
+	// Hits and Misses count key reads that found a live key or not
+	Hits   uint64
+	Misses uint64
+
+	TxnCommits     uint64
+	TxnConflicts   uint64
+	EvictedKeys    uint64
+	ExpiredKeys    uint64
+	RejectedWrites uint64
+	// SlowOps counts operations that took at least SlowLogThreshold
+	SlowOps uint64
+}
+
+// SlowOp is an entry of the slow operation log
+type SlowOp struct {
+	ID       uint64
+	Time     time.Time
+	Duration time.Duration
+	Op       string
+	Key      string
+}
+
+// metrics holds activity counters. Counters are updated atomically; the
+// operation rate samples and the slow log have their own mutexes.
+type metrics struct {
+	started  time.Time
+	lastSave int64 // unix nanoseconds
+
+	ops          uint64
+	hits         uint64
+	misses       uint64
+	txnCommits   uint64
+	txnConflicts uint64
+	expiredKeys  uint64
+
+	sampleMutex   sync.Mutex
+	lastSample    time.Time
+	lastSampleOps uint64
+	samples       [opsSamples]float64
+	sampled       int
+
+	slowMutex sync.Mutex
+	slowLog   []SlowOp // oldest first
+	slowOps   uint64
+}
+
+// WithSlowLogThreshold sets the duration from which operations are recorded in
+// the slow log. Zero records every operation and a negative value disables it.
+func WithSlowLogThreshold(threshold time.Duration) Option {
+	return func(o *Options) {
+		o.SlowLogThreshold = threshold
+	}
+}
+
+// WithSlowLogMaxLen sets the number of slow operations kept
+func WithSlowLogMaxLen(n int) Option {
+	return func(o *Options) {
+		o.SlowLogMaxLen = n
+	}
+}
+
+// observe counts an operation that started at start and records it in the slow
+// log if it took long enough. Operations defer it on entry.
+func (db *DB) observe(op, key string, start time.Time) {
+	atomic.AddUint64(&db.metrics.ops, 1)
+
+	threshold := db.options.SlowLogThreshold
+	if threshold < 0 || db.options.SlowLogMaxLen <= 0 {
+		return
+	}
+	elapsed := time.Since(start)
+	if elapsed < threshold {
+		return
+	}
+
+	m := &db.metrics
+	m.slowMutex.Lock()
+	defer m.slowMutex.Unlock()
+	m.slowOps++
+	if len(m.slowLog) >= db.options.SlowLogMaxLen {
+		m.slowLog = append(m.slowLog[:0], m.slowLog[len(m.slowLog)-db.options.SlowLogMaxLen+1:]...)
+	}
+	m.slowLog = append(m.slowLog, SlowOp{
+		ID:       m.slowOps,
+		Time:     start,
+		Duration: elapsed,
+		Op:       op,
+		Key:      key,
+	})
+}
+
+// SlowLog returns the recorded slow operations, newest first
+func (db *DB) SlowLog() []SlowOp {
+	m := &db.metrics
+	m.slowMutex.Lock()
+	defer m.slowMutex.Unlock()
+
+	ops := make([]SlowOp, len(m.slowLog))
+	for i, op := range m.slowLog {
+		ops[len(ops)-1-i] = op
+	}
+	return ops
+}
+
+// ResetSlowLog clears the slow operation log
+func (db *DB) ResetSlowLog() {
+	m := &db.metrics
+	m.slowMutex.Lock()
+	defer m.slowMutex.Unlock()
+	m.slowLog = nil
+}
+
+// sampleOps records the operation rate since the previous sample
+func (db *DB) sampleOps(now time.Time) {
+	m := &db.metrics
+	m.sampleMutex.Lock()
+	defer m.sampleMutex.Unlock()
+
+	ops := atomic.LoadUint64(&m.ops)
+	if !m.lastSample.IsZero() {
+		if elapsed := now.Sub(m.lastSample).Seconds(); elapsed > 0 {
+			m.samples[m.sampled%opsSamples] = float64(ops-m.lastSampleOps) / elapsed
+			m.sampled++
+		}
+	}
+	m.lastSample, m.lastSampleOps = now, ops
+}
+
+// opsPerSec averages the recent operation rate samples
+func (db *DB) opsPerSec() float64 {
+	m := &db.metrics
+	m.sampleMutex.Lock()
+	defer m.sampleMutex.Unlock()
+
+	n := min(m.sampled, opsSamples)
+	if n == 0 {
+		return 0
+	}
+	var sum float64
+	for _, rate := range m.samples[:n] {
+		sum += rate
+	}
+	return sum / float64(n)
+}
+
+// Stats returns counters and sizes describing the database
+func (db *DB) Stats() Stats {
+	db.mutex.RLock()
+	stats := Stats{
+		Keys:       db.keyspace.lex.length,
+		KeysByType: make(map[DataType]int),
+		Expires:    len(db.expires),
+	}
+	for typ, n := range db.keysByType {
+		if n > 0 {
+			stats.KeysByType[DataType(typ)] = n
+		}
+	}
+	db.mutex.RUnlock()
+
+	m := &db.metrics
+	stats.MemoryUsage = atomic.LoadInt64(&db.memUsage)
+	stats.MaxMemory = db.options.MaxMemory
+	if info, err := os.Stat(db.walFile); err == nil {
+		stats.WALSize = info.Size()
+	}
+	stats.AOFSize = db.AOFSize()
+	if lastSave := atomic.LoadInt64(&m.lastSave); lastSave > 0 {
+		stats.LastSave = time.Unix(0, lastSave)
+	}
+	stats.Uptime = time.Since(m.started)
+
+	stats.Ops = atomic.LoadUint64(&m.ops)
+	stats.OpsPerSec = db.opsPerSec()
+	stats.Hits = atomic.LoadUint64(&m.hits)
+	stats.Misses = atomic.LoadUint64(&m.misses)
+	stats.TxnCommits = atomic.LoadUint64(&m.txnCommits)
+	stats.TxnConflicts = atomic.LoadUint64(&m.txnConflicts)
+	stats.EvictedKeys = atomic.LoadUint64(&db.evictedKeys)
+	stats.ExpiredKeys = atomic.LoadUint64(&m.expiredKeys)
+	stats.RejectedWrites = atomic.LoadUint64(&db.rejectedWrites)
+
+	m.slowMutex.Lock()
+	stats.SlowOps = m.slowOps
+	m.slowMutex.Unlock()
+	return stats
+}
+
+// MetricsHandler returns an HTTP handler that serves Stats in the Prometheus
+// text exposition format
+func (db *DB) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		db.Stats().writePrometheus(w)
+	})
+}
+
+// writePrometheus writes the stats in the Prometheus text exposition format
+func (s Stats) writePrometheus(w io.Writer) {
+	metric := func(name, typ, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, value)
+	}
+
+	fmt.Fprintf(w, "# HELP xedb_keys Number of stored keys by type.\n# TYPE xedb_keys gauge\n")
+	for typ := String; typ <= JSON; typ++ {
+		fmt.Fprintf(w, "xedb_keys{type=%q} %d\n", typ.String(), s.KeysByType[typ])
+	}
+	metric("xedb_expires", "gauge", "Number of keys with a deadline.", s.Expires)
+	metric("xedb_memory_used_bytes", "gauge", "Estimated memory used by keys and values.", s.MemoryUsage)
+	metric("xedb_memory_max_bytes", "gauge", "Configured memory limit, 0 if unlimited.", s.MaxMemory)
+	metric("xedb_wal_size_bytes", "gauge", "Size of the write-ahead log.", s.WALSize)
+	metric("xedb_aof_size_bytes", "gauge", "Size of the append-only file.", s.AOFSize)
+	var lastSave int64
+	if !s.LastSave.IsZero() {
+		lastSave = s.LastSave.Unix()
+	}
+	metric("xedb_last_save_timestamp_seconds", "gauge", "Time the data file was last written.", lastSave)
+	metric("xedb_uptime_seconds", "gauge", "Time since the database was opened.", s.Uptime.Seconds())
+	metric("xedb_ops_total", "counter", "Operations processed.", s.Ops)
+	metric("xedb_ops_per_second", "gauge", "Recent rate of operations.", s.OpsPerSec)
+	metric("xedb_keyspace_hits_total", "counter", "Key reads that found a live key.", s.Hits)
+	metric("xedb_keyspace_misses_total", "counter", "Key reads that found no live key.", s.Misses)
+	metric("xedb_txn_commits_total", "counter", "Transactions committed.", s.TxnCommits)
+	metric("xedb_txn_conflicts_total", "counter", "Transaction commits that failed with a conflict.", s.TxnConflicts)
+	metric("xedb_evicted_keys_total", "counter", "Keys evicted to stay under the memory limit.", s.EvictedKeys)
+	metric("xedb_expired_keys_total", "counter", "Keys removed because their deadline passed.", s.ExpiredKeys)
+	metric("xedb_rejected_writes_total", "counter", "Writes rejected by the memory limit.", s.RejectedWrites)
+	metric("xedb_slow_ops_total", "counter", "Operations that reached the slow log threshold.", s.SlowOps)
+}
@@ -0,0 +1,129 @@
+package xedb_test
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_Stats(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	t.Run("Keys By Type", func(t *testing.T) {
+		require.NoError(t, db.String("a").Set("1"))
+		require.NoError(t, db.String("b").Set("2"))
+		require.NoError(t, db.List("l").Push("x"))
+		require.NoError(t, db.Hash("h").Set("f", "v"))
+		require.NoError(t, db.String("tmp").SetWithTTL("v", time.Hour))
+
+		stats := db.Stats()
+		assert.Equal(t, 5, stats.Keys)
+		assert.Equal(t, 1, stats.Expires)
+		assert.Equal(t, map[xedb.DataType]int{xedb.String: 3, xedb.List: 1, xedb.Hash: 1}, stats.KeysByType)
+
+		// Replacing a key with another type moves it between counts
+		_, err := db.Delete("b", "tmp")
+		require.NoError(t, err)
+		require.NoError(t, db.Set("a").Add("m"))
+		stats = db.Stats()
+		assert.Equal(t, 3, stats.Keys)
+		assert.Equal(t, map[xedb.DataType]int{xedb.List: 1, xedb.Hash: 1, xedb.Set: 1}, stats.KeysByType)
+		assert.Greater(t, stats.MemoryUsage, int64(0))
+		assert.Greater(t, stats.WALSize, int64(0))
+		assert.False(t, stats.LastSave.IsZero())
+	})
+
+	t.Run("Hits And Misses", func(t *testing.T) {
+		before := db.Stats()
+		db.List("l").Range(0, -1)
+		db.String("missing").Get()
+		db.String("missing").Get()
+
+		stats := db.Stats()
+		assert.Equal(t, before.Hits+1, stats.Hits)
+		assert.Equal(t, before.Misses+2, stats.Misses)
+		assert.Greater(t, stats.Ops, before.Ops)
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		before := db.Stats()
+		require.NoError(t, db.String("counter").Set("1"))
+
+		txn := db.NewTransaction(true)
+		_, err := txn.Get("counter")
+		require.NoError(t, err)
+		require.NoError(t, txn.Set("counter", stringEntry("2")))
+		require.NoError(t, db.String("counter").Set("3"))
+		assert.ErrorIs(t, txn.Commit(), xedb.ErrConflict)
+
+		txn = db.NewTransaction(true)
+		require.NoError(t, txn.Set("counter", stringEntry("4")))
+		require.NoError(t, txn.Commit())
+
+		stats := db.Stats()
+		assert.Equal(t, before.TxnConflicts+1, stats.TxnConflicts)
+		assert.Equal(t, before.TxnCommits+1, stats.TxnCommits)
+	})
+
+	t.Run("Expired Keys", func(t *testing.T) {
+		before := db.Stats()
+		require.NoError(t, db.String("short").SetWithTTL("v", 10*time.Millisecond))
+		time.Sleep(20 * time.Millisecond)
+		_, exists := db.String("short").Get()
+		assert.False(t, exists)
+
+		// Reads hide the key; the next write to it removes it
+		require.NoError(t, db.String("short").Set("again"))
+		assert.Equal(t, before.ExpiredKeys+1, db.Stats().ExpiredKeys)
+	})
+
+	t.Run("Metrics Handler", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		db.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+		body := rec.Body.String()
+		assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+		assert.Contains(t, body, "# TYPE xedb_keys gauge\n")
+		assert.Contains(t, body, `xedb_keys{type="list"} 1`+"\n")
+		assert.Contains(t, body, `xedb_keys{type="zset"} 0`+"\n")
+		assert.Contains(t, body, "# TYPE xedb_ops_total counter\n")
+		assert.Regexp(t, `\nxedb_keyspace_misses_total [1-9]\d*\n`, body)
+	})
+}
+
+func TestDB_SlowLog(t *testing.T) {
+	dir, err := os.MkdirTemp("", "xedb-test-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := xedb.New(
+		xedb.WithDataDir(dir),
+		xedb.WithSlowLogThreshold(0),
+		xedb.WithSlowLogMaxLen(2),
+	)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.String("a").Set("1"))
+	require.NoError(t, db.Hash("b").Set("f", "v"))
+	db.String("c").Get()
+
+	// Only the newest entries are kept, newest first
+	ops := db.SlowLog()
+	require.Len(t, ops, 2)
+	assert.Equal(t, "String.Get", ops[0].Op)
+	assert.Equal(t, "c", ops[0].Key)
+	assert.Equal(t, "Hash.Set", ops[1].Op)
+	assert.Equal(t, "b", ops[1].Key)
+	assert.Greater(t, ops[0].ID, ops[1].ID)
+	assert.Equal(t, uint64(3), db.Stats().SlowOps)
+
+	db.ResetSlowLog()
+	assert.Empty(t, db.SlowLog())
+}
@@ -0,0 +1,449 @@
+package xedb
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/seefs001/xox/xerror"
+)
+
+var (
+	// ErrConflict is returned when a transaction read or wrote a key that
+	// another transaction changed before it could commit
+	ErrConflict = xerror.New("write conflict")
+	// ErrReadOnlyTxn is returned when writing in a read-only transaction
+	ErrReadOnlyTxn = xerror.New("cannot write in read-only transaction")
+)
+
+// WithTxnMaxRetries sets how often Update retries a transaction that failed with ErrConflict
+func WithTxnMaxRetries(retries int) Option {
+	return func(o *Options) {
+		o.TxnMaxRetries = retries
+	}
+}
+
+// Txn is an optimistic transaction. Reads are not blocked and writes are
+// buffered until Commit, which atomically checks that no key the transaction
+// read or watched was changed meanwhile, and that no key it writes without
+// reading was changed since the transaction started.
+type Txn struct {
+	db       *DB
+	readTs   uint64
+	readOnly bool
+	mutex    sync.Mutex
+	// reads holds the version of every key read or watched, 0 if it did not exist
+	reads map[string]uint64
+	// writes holds the pending writes; a nil entry deletes the key
+	writes map[string]*Entry
+	// deadlines holds the pending deadlines; a zero deadline removes the timeout
+	deadlines map[string]time.Time
+}
+
+// NewTransaction starts a transaction. A read-only transaction sees the
+// database as of its start where the version history allows it, which
+// requires KeepHistory.
+func (db *DB) NewTransaction(update bool) *Txn {
+	return &Txn{
+		db:        db,
+		readTs:    atomic.LoadUint64(&db.txCounter),
+		readOnly:  !update,
+		reads:     make(map[string]uint64),
+		writes:    make(map[string]*Entry),
+		deadlines: make(map[string]time.Time),
+	}
+}
+
+// Transaction is the former name of Txn.
+//
+// Deprecated: use Txn.
+type Transaction = Txn
+
+// Update runs fn in a read-write transaction and commits it. When the commit
+// or fn fails with ErrConflict, fn runs again in a new transaction, up to
+// Options.TxnMaxRetries times, so fn must not have side effects outside txn.
+func (db *DB) Update(fn func(txn *Txn) error) error {
+	for attempt := 0; ; attempt++ {
+		txn := db.NewTransaction(true)
+		err := fn(txn)
+		if err == nil {
+			err = txn.Commit()
+		}
+		if !errors.Is(err, ErrConflict) || attempt >= db.options.TxnMaxRetries {
+			return err
+		}
+
+		// Spread out transactions that keep colliding
+		time.Sleep(time.Duration(rand.Int63n(int64(attempt+1) * int64(50*time.Microsecond))))
+	}
+}
+
+// View runs fn in a read-only transaction
+func (db *DB) View(fn func(txn *Txn) error) error {
+	return fn(db.NewTransaction(false))
+}
+
+// version returns the version of the live entry at key, or 0 if there is none.
+// Caller must hold db.mutex.
+func (db *DB) version(key string) uint64 {
+	entry, ok := db.engine.Get(key)
+	if !ok || db.isExpired(key, time.Now()) {
+		return 0
+	}
+	return entry.Version
+}
+
+// Get returns the entry at key, including writes pending in the transaction
+func (txn *Txn) Get(key string) (Entry, error) {
+	defer txn.db.observe("Txn.Get", key, time.Now())
+	txn.mutex.Lock()
+	defer txn.mutex.Unlock()
+
+	if entry, ok := txn.writes[key]; ok {
+		if entry == nil {
+			return Entry{}, ErrKeyNotFound
+		}
+		return *entry, nil
+	}
+
+	txn.db.mutex.RLock()
+	entry, exists := txn.db.get(key)
+	entry = copyEntry(entry)
+	txn.db.mutex.RUnlock()
+
+	if !txn.readOnly {
+		// Commit fails if the key changes after this first read
+		if _, ok := txn.reads[key]; !ok {
+			txn.reads[key] = entry.Version
+		}
+	}
+	if !exists {
+		return Entry{}, ErrKeyNotFound
+	}
+
+	// Read-only transactions see the version that was current when they started
+	if txn.readOnly && entry.Version > txn.readTs && len(entry.Versions) > 0 {
+		for _, ver := range entry.Versions {
+			if ver.Version <= txn.readTs {
+				entry.Value = ver.Value
+				break
+			}
+		}
+	}
+
+	return entry, nil
+}
+
+// Watch makes Commit fail with ErrConflict if any of keys changes from now on,
+// whether or not the transaction reads it
+func (txn *Txn) Watch(keys ...string) {
+	txn.mutex.Lock()
+	defer txn.mutex.Unlock()
+
+	txn.db.mutex.RLock()
+	defer txn.db.mutex.RUnlock()
+	for _, key := range keys {
+		if _, ok := txn.reads[key]; !ok {
+			txn.reads[key] = txn.db.version(key)
+		}
+	}
+}
+
+// Deadline returns the deadline of key, including changes pending in the
+// transaction. It returns the zero time if the key has no timeout.
+func (txn *Txn) Deadline(key string) time.Time {
+	txn.mutex.Lock()
+	defer txn.mutex.Unlock()
+
+	if deadline, ok := txn.deadlines[key]; ok {
+		return deadline
+	}
+	if _, ok := txn.writes[key]; ok {
+		return time.Time{}
+	}
+
+	txn.db.mutex.RLock()
+	defer txn.db.mutex.RUnlock()
+	if _, ok := txn.db.get(key); !ok {
+		return time.Time{}
+	}
+	return txn.db.expires[key]
+}
+
+// ExpireAt buffers an absolute deadline for key. A deadline in the past
+// deletes the key, and a key that does not exist on commit is left alone.
+func (txn *Txn) ExpireAt(key string, deadline time.Time) error {
+	if deadline.IsZero() {
+		return ErrInvalidValue
+	}
+	return txn.expire(key, deadline)
+}
+
+// Persist buffers the removal of the timeout from key
+func (txn *Txn) Persist(key string) error {
+	return txn.expire(key, time.Time{})
+}
+
+// Set buffers a write of entry to key. Like String.Set, it clears any timeout.
+func (txn *Txn) Set(key string, entry Entry) error {
+	return txn.write(key, &entry)
+}
+
+// Delete buffers the removal of key
+func (txn *Txn) Delete(key string) error {
+	return txn.write(key, nil)
+}
+
+// write buffers a write, failing early if it is already bound to conflict
+func (txn *Txn) write(key string, entry *Entry) error {
+	if txn.readOnly {
+		return ErrReadOnlyTxn
+	}
+
+	txn.mutex.Lock()
+	defer txn.mutex.Unlock()
+
+	txn.db.mutex.RLock()
+	err := txn.conflict(key)
+	txn.db.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	txn.writes[key] = entry
+	delete(txn.deadlines, key)
+	return nil
+}
+
+// expire buffers a deadline change, failing early if it is already bound to conflict
+func (txn *Txn) expire(key string, deadline time.Time) error {
+	if txn.readOnly {
+		return ErrReadOnlyTxn
+	}
+
+	txn.mutex.Lock()
+	defer txn.mutex.Unlock()
+
+	txn.db.mutex.RLock()
+	err := txn.conflict(key)
+	txn.db.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// A deadline that already passed deletes the key, so later reads miss it
+	if !deadline.IsZero() && !deadline.After(time.Now()) {
+		txn.writes[key] = nil
+		delete(txn.deadlines, key)
+		return nil
+	}
+	txn.deadlines[key] = deadline
+	return nil
+}
+
+// conflict returns ErrConflict if key changed since the transaction first read
+// it or, if it was never read, since the transaction started.
+// Caller must hold txn.mutex and db.mutex.
+func (txn *Txn) conflict(key string) error {
+	current := txn.db.version(key)
+	if read, ok := txn.reads[key]; ok {
+		if current != read {
+			return fmt.Errorf("%w on key %q", ErrConflict, key)
+		}
+		return nil
+	}
+	if current > txn.readTs {
+		return fmt.Errorf("%w on key %q", ErrConflict, key)
+	}
+	return nil
+}
+
+// Commit validates the transaction and applies its writes atomically under a
+// single transaction id. It returns an error wrapping ErrConflict if another
+// transaction got in the way, in which case nothing is written.
+func (txn *Txn) Commit() error {
+	defer txn.db.observe("Txn.Commit", "", time.Now())
+
+	if txn.readOnly {
+		return nil
+	}
+
+	txn.mutex.Lock()
+	defer txn.mutex.Unlock()
+
+	// Validation and apply happen under one lock, so nothing can commit in between
+	db := txn.db
+	db.lock()
+	defer db.mutex.Unlock()
+
+	for key := range txn.reads {
+		if err := txn.conflict(key); err != nil {
+			atomic.AddUint64(&db.metrics.txnConflicts, 1)
+			return err
+		}
+	}
+	for key := range txn.writes {
+		if err := txn.conflict(key); err != nil {
+			atomic.AddUint64(&db.metrics.txnConflicts, 1)
+			return err
+		}
+	}
+	for key := range txn.deadlines {
+		if err := txn.conflict(key); err != nil {
+			atomic.AddUint64(&db.metrics.txnConflicts, 1)
+			return err
+		}
+	}
+	if len(txn.writes) == 0 && len(txn.deadlines) == 0 {
+		return nil
+	}
+
+	var writeSize int64
+	for _, entry := range txn.writes {
+		if entry != nil {
+			writeSize += valueSize(entry.Value)
+		}
+	}
+	if err := db.checkMemoryLimit(writeSize); err != nil {
+		return err
+	}
+
+	// Apply and log in key order so replicas and recovery see the same sequence
+	keys := make([]string, 0, len(txn.writes)+len(txn.deadlines))
+	for key := range txn.writes {
+		keys = append(keys, key)
+	}
+	for key := range txn.deadlines {
+		if _, ok := txn.writes[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	// Keys are saved before they change, so a commit that fails before it is
+	// logged puts them back and leaves nothing applied
+	saved := make([]savedKey, 0, len(keys))
+	rollback := func() {
+		for i := len(saved) - 1; i >= 0; i-- {
+			db.restoreKey(saved[i])
+		}
+		db.flushNotifications(false)
+	}
+
+	txID := atomic.AddUint64(&db.txCounter, 1)
+	walEntry := WALEntry{TxID: txID, Commands: make([]Command, 0, len(keys))}
+	now := time.Now()
+	for _, key := range keys {
+		saved = append(saved, db.saveKey(key))
+		pending, written := txn.writes[key]
+		deadline := txn.deadlines[key]
+		if !written {
+			walEntry.Commands = append(walEntry.Commands, db.applyDeadline(key, deadline, txID, now)...)
+			continue
+		}
+		if pending == nil {
+			if db.keyspace.contains(key) {
+				db.removeKey(key)
+				db.notifyWrite(EventDel, key)
+			}
+			walEntry.Commands = append(walEntry.Commands, Command{Op: "DEL", Key: key, Version: txID})
+			continue
+		}
+
+		// putEntry keeps the replaced value in the history with KeepHistory set
+		entry := *pending
+		entry.Versions = nil
+		entry.Version = txID
+		entry.LastUpdated = now
+		if err := db.putEntry(key, entry); err != nil {
+			rollback()
+			return err
+		}
+		if deadline.IsZero() {
+			delete(db.expires, key)
+		} else {
+			db.expires[key] = deadline
+		}
+
+		walEntry.Commands = append(walEntry.Commands, Command{
+			Op:       opFromType(entry.Type),
+			Key:      key,
+			Value:    entry.Value,
+			Version:  txID,
+			Type:     entry.Type,
+			ExpireAt: deadline,
+		})
+		if !deadline.IsZero() && !deadline.After(now) {
+			db.removeKey(key)
+			db.notifyWrite(EventDel, key)
+		}
+	}
+	if len(walEntry.Commands) == 0 {
+		return nil
+	}
+
+	if err := db.writeWAL(walEntry); err != nil {
+		rollback()
+		return fmt.Errorf("failed to write WAL: %w", err)
+	}
+	atomic.AddUint64(&db.metrics.txnCommits, 1)
+	return db.maybeCheckpoint()
+}
+
+// savedKey is the state of a key before a transaction changed it
+type savedKey struct {
+	key      string
+	entry    Entry
+	exists   bool
+	deadline time.Time
+}
+
+// saveKey returns the state of key for restoreKey.
+// Caller must hold db.mutex for writing.
+func (db *DB) saveKey(key string) savedKey {
+	entry, exists := db.engine.Get(key)
+	return savedKey{key: key, entry: entry, exists: exists, deadline: db.expires[key]}
+}
+
+// restoreKey puts a key back in the state saveKey returned.
+// Caller must hold db.mutex for writing.
+func (db *DB) restoreKey(s savedKey) {
+	db.removeKey(s.key)
+	if !s.exists {
+		return
+	}
+	// A persistent engine that fails here reports the error on its next write
+	db.putEntry(s.key, s.entry)
+	if !s.deadline.IsZero() {
+		db.expires[s.key] = s.deadline
+	}
+}
+
+// applyDeadline applies a pending deadline to key and returns the commands that
+// log it. A key that no longer exists is left alone.
+// Caller must hold db.mutex for writing.
+func (db *DB) applyDeadline(key string, deadline time.Time, txID uint64, now time.Time) []Command {
+	db.expireIfNeeded(key)
+	if _, ok := db.engine.Get(key); !ok {
+		return nil
+	}
+
+	if deadline.IsZero() {
+		if _, ok := db.expires[key]; !ok {
+			return nil
+		}
+		delete(db.expires, key)
+		return []Command{{Op: "PERSIST", Key: key, Version: txID}}
+	}
+
+	db.expires[key] = deadline
+	if !deadline.After(now) {
+		db.removeKey(key)
+		db.notifyWrite(EventDel, key)
+	}
+	return []Command{{Op: "EXPIRE", Key: key, ExpireAt: deadline, Version: txID}}
+}
@@ -0,0 +1,354 @@
+package xedb_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stringEntry(value string) xedb.Entry {
+	return xedb.Entry{Type: xedb.String, Value: value}
+}
+
+func TestTxn_Conflicts(t *testing.T) {
+	db, cleanup := setupPubSubDB(t)
+	defer cleanup()
+	require.NoError(t, db.String("balance").Set("100"))
+
+	t.Run("Read Write", func(t *testing.T) {
+		txn := db.NewTransaction(true)
+		_, err := txn.Get("balance")
+		require.NoError(t, err)
+		require.NoError(t, txn.Set("audit", stringEntry("checked")))
+
+		// The key read is changed before the transaction commits
+		require.NoError(t, db.String("balance").Set("50"))
+		assert.ErrorIs(t, txn.Commit(), xedb.ErrConflict)
+		assert.Equal(t, 0, db.Exists("audit"))
+	})
+
+	t.Run("Read Of Missing Key", func(t *testing.T) {
+		txn := db.NewTransaction(true)
+		_, err := txn.Get("lock")
+		require.ErrorIs(t, err, xedb.ErrKeyNotFound)
+		require.NoError(t, txn.Set("lock", stringEntry("mine")))
+
+		require.NoError(t, db.String("lock").Set("theirs"))
+		assert.ErrorIs(t, txn.Commit(), xedb.ErrConflict)
+		value, _ := db.String("lock").Get()
+		assert.Equal(t, "theirs", value)
+	})
+
+	t.Run("Read Then Write After Start", func(t *testing.T) {
+		// Changes made before the first read are not conflicts
+		txn := db.NewTransaction(true)
+		require.NoError(t, db.String("balance").Set("75"))
+		entry, err := txn.Get("balance")
+		require.NoError(t, err)
+		assert.Equal(t, "75", entry.Value)
+		require.NoError(t, txn.Set("balance", stringEntry("70")))
+		require.NoError(t, txn.Commit())
+	})
+
+	t.Run("Watch", func(t *testing.T) {
+		txn := db.NewTransaction(true)
+		txn.Watch("balance")
+		require.NoError(t, db.String("balance").Set("0"))
+		assert.ErrorIs(t, txn.Commit(), xedb.ErrConflict)
+
+		txn = db.NewTransaction(true)
+		txn.Watch("balance", "missing")
+		require.NoError(t, txn.Set("audit", stringEntry("ok")))
+		require.NoError(t, txn.Commit())
+		assert.Equal(t, 1, db.Exists("audit"))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		txn := db.NewTransaction(true)
+		require.NoError(t, txn.Delete("audit"))
+		_, err := txn.Get("audit")
+		assert.ErrorIs(t, err, xedb.ErrKeyNotFound)
+		require.NoError(t, txn.Commit())
+		assert.Equal(t, 0, db.Exists("audit"))
+	})
+
+	t.Run("Read Only", func(t *testing.T) {
+		txn := db.NewTransaction(false)
+		assert.ErrorIs(t, txn.Set("k", stringEntry("v")), xedb.ErrReadOnlyTxn)
+	})
+}
+
+func TestTxn_Deadlines(t *testing.T) {
+	dir := t.TempDir()
+	db, err := xedb.New(xedb.WithDataDir(dir), xedb.WithSyncWrite(false))
+	require.NoError(t, err)
+
+	require.NoError(t, db.String("session").SetWithTTL("token", time.Hour))
+	require.NoError(t, db.String("cache").SetWithTTL("v", time.Hour))
+	require.NoError(t, db.String("stale").Set("v"))
+
+	txn := db.NewTransaction(true)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), txn.Deadline("session"), time.Second)
+	assert.True(t, txn.Deadline("stale").IsZero())
+
+	deadline := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	require.NoError(t, txn.ExpireAt("stale", deadline))
+	require.NoError(t, txn.Persist("cache"))
+	require.NoError(t, txn.ExpireAt("session", time.Now().Add(-time.Second)))
+	_, err = txn.Get("session")
+	assert.ErrorIs(t, err, xedb.ErrKeyNotFound, "a past deadline deletes the key")
+
+	// Set clears a pending deadline, and one set afterwards sticks
+	require.NoError(t, txn.ExpireAt("fresh", deadline))
+	require.NoError(t, txn.Set("fresh", stringEntry("v")))
+	assert.True(t, txn.Deadline("fresh").IsZero())
+	require.NoError(t, txn.Set("counter", stringEntry("1")))
+	require.NoError(t, txn.ExpireAt("counter", deadline))
+	require.NoError(t, txn.Commit())
+
+	check := func(db *xedb.DB) {
+		t.Helper()
+		assert.Equal(t, 0, db.Exists("session"))
+		ttl, ok := db.String("cache").TTL()
+		assert.True(t, ok)
+		assert.Equal(t, xedb.NoExpiration, ttl)
+		ttl, _ = db.String("fresh").TTL()
+		assert.Equal(t, xedb.NoExpiration, ttl)
+		for _, key := range []string{"stale", "counter"} {
+			ttl, ok := db.String(key).TTL()
+			assert.True(t, ok, key)
+			assert.InDelta(t, time.Minute, ttl, float64(time.Second), key)
+		}
+	}
+	check(db)
+
+	// The deadlines are logged with the transaction
+	crash(t, db, dir)
+	db, err = xedb.New(xedb.WithDataDir(dir), xedb.WithSyncWrite(false))
+	require.NoError(t, err)
+	defer db.Close()
+	check(db)
+
+	t.Run("Conflict", func(t *testing.T) {
+		txn := db.NewTransaction(true)
+		require.NoError(t, txn.ExpireAt("stale", deadline))
+		require.NoError(t, db.String("stale").Set("changed"))
+		assert.ErrorIs(t, txn.Commit(), xedb.ErrConflict)
+		ttl, _ := db.String("stale").TTL()
+		assert.Equal(t, xedb.NoExpiration, ttl)
+	})
+}
+
+// failingEngine wraps the memory engine and fails every Put to one key
+type failingEngine struct {
+	xedb.Engine
+	key string
+}
+
+func (e *failingEngine) Put(key string, entry xedb.Entry) error {
+	if key == e.key {
+		return errors.New("disk full")
+	}
+	return e.Engine.Put(key, entry)
+}
+
+func TestTxn_CommitRollback(t *testing.T) {
+	commit := func(db *xedb.DB) error {
+		txn := db.NewTransaction(true)
+		require.NoError(t, txn.Set("a", stringEntry("new")))
+		require.NoError(t, txn.Set("b", stringEntry("new")))
+		require.NoError(t, txn.Delete("c"))
+		require.NoError(t, txn.ExpireAt("d", time.Now().Add(time.Hour)))
+		require.NoError(t, txn.Set("fail", stringEntry("new")))
+		return txn.Commit()
+	}
+	seed := func(db *xedb.DB) {
+		require.NoError(t, db.String("a").SetWithTTL("old", time.Hour))
+		require.NoError(t, db.String("c").Set("old"))
+		require.NoError(t, db.String("d").Set("old"))
+	}
+	unchanged := func(t *testing.T, db *xedb.DB) {
+		value, _ := db.String("a").Get()
+		assert.Equal(t, "old", value)
+		ttl, _ := db.String("a").TTL()
+		assert.Greater(t, ttl, 59*time.Minute)
+		assert.Equal(t, 0, db.Exists("b", "fail"))
+		value, _ = db.String("c").Get()
+		assert.Equal(t, "old", value)
+		ttl, _ = db.String("d").TTL()
+		assert.Equal(t, xedb.NoExpiration, ttl)
+	}
+
+	t.Run("Engine Error", func(t *testing.T) {
+		db, cleanup := setupPubSubDB(t, xedb.WithEngine(&failingEngine{Engine: xedb.NewMemoryEngine(), key: "fail"}))
+		defer cleanup()
+		seed(db)
+
+		// The keys before the failing one are put back
+		assert.Error(t, commit(db))
+		unchanged(t, db)
+	})
+
+	t.Run("WAL Error", func(t *testing.T) {
+		dir := t.TempDir()
+		db, err := xedb.New(xedb.WithDataDir(dir), xedb.WithSyncWrite(false))
+		require.NoError(t, err)
+		defer db.Close()
+		seed(db)
+
+		// A directory in place of the WAL makes the commit fail to log
+		require.NoError(t, db.Save())
+		walFile := filepath.Join(dir, "wal.db")
+		require.NoError(t, os.Remove(walFile))
+		require.NoError(t, os.Mkdir(walFile, 0755))
+		assert.Error(t, commit(db))
+		unchanged(t, db)
+		require.NoError(t, os.Remove(walFile))
+	})
+}
+
+func TestDB_ReadsCopyValues(t *testing.T) {
+	db, cleanup := setupPubSubDB(t)
+	defer cleanup()
+
+	// Writes change stored hashes and sets in place, which must not reach
+	// values handed out earlier
+	reads := map[string]func() []interface{}{
+		"Txn.Get": func() []interface{} {
+			txn := db.NewTransaction(false)
+			h, err := txn.Get("h")
+			require.NoError(t, err)
+			s, err := txn.Get("s")
+			require.NoError(t, err)
+			return []interface{}{h.Value, s.Value}
+		},
+		"Iterator.Item": func() []interface{} {
+			var values []interface{}
+			it := db.NewIterator(xedb.IteratorOptions{})
+			for it.Rewind(); it.Valid(); it.Next() {
+				values = append(values, it.Item().Value)
+			}
+			return values
+		},
+		"History": func() []interface{} {
+			v, ok := db.Hash("h").GetAt(^uint64(0))
+			require.True(t, ok)
+			return []interface{}{db.Hash("h").History()[0].Value, v.Value}
+		},
+	}
+	for name, read := range reads {
+		t.Run(name, func(t *testing.T) {
+			_, err := db.Delete("h", "s")
+			require.NoError(t, err)
+			require.NoError(t, db.Hash("h").Set("f", "v"))
+			require.NoError(t, db.Set("s").Add("m"))
+
+			values := read()
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					assert.NoError(t, db.Hash("h").Set(strconv.Itoa(i), "v"))
+					assert.NoError(t, db.Set("s").Add(strconv.Itoa(i)))
+				}
+			}()
+			for i := 0; i < 200; i++ {
+				for _, value := range values {
+					switch v := value.(type) {
+					case map[string]string:
+						for range v {
+						}
+					case map[string]struct{}:
+						for range v {
+						}
+					}
+				}
+			}
+			wg.Wait()
+			for _, value := range values {
+				assert.Len(t, value, 1)
+			}
+		})
+	}
+}
+
+func TestDB_Update(t *testing.T) {
+	db, cleanup := setupPubSubDB(t)
+	defer cleanup()
+
+	increment := func(txn *xedb.Txn) error {
+		n := 0
+		entry, err := txn.Get("counter")
+		if err == nil {
+			n, _ = strconv.Atoi(entry.Value.(string))
+		} else if !errors.Is(err, xedb.ErrKeyNotFound) {
+			return err
+		}
+		return txn.Set("counter", stringEntry(strconv.Itoa(n+1)))
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				assert.NoError(t, db.Update(increment))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every increment lands exactly once despite the contention
+	value, _ := db.String("counter").Get()
+	assert.Equal(t, "200", value)
+
+	t.Run("Errors Are Not Retried", func(t *testing.T) {
+		calls := 0
+		failure := errors.New("failed")
+		err := db.Update(func(txn *xedb.Txn) error {
+			calls++
+			require.NoError(t, txn.Set("counter", stringEntry("0")))
+			return failure
+		})
+		assert.ErrorIs(t, err, failure)
+		assert.Equal(t, 1, calls)
+		value, _ := db.String("counter").Get()
+		assert.Equal(t, "200", value)
+	})
+
+	t.Run("Retries Are Bounded", func(t *testing.T) {
+		db, cleanup := setupPubSubDB(t, xedb.WithTxnMaxRetries(2))
+		defer cleanup()
+
+		calls := 0
+		err := db.Update(func(txn *xedb.Txn) error {
+			calls++
+			txn.Watch("k")
+			return db.String("k").Set(strconv.Itoa(calls))
+		})
+		assert.ErrorIs(t, err, xedb.ErrConflict)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("View", func(t *testing.T) {
+		err := db.View(func(txn *xedb.Txn) error {
+			entry, err := txn.Get("counter")
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, "200", entry.Value)
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+}
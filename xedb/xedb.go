@@ -5,10 +5,11 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
-	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,6 +24,7 @@ var (
 	ErrKeyExists    = xerror.New("key already exists")
 	ErrInvalidType  = xerror.New("invalid type")
 	ErrInvalidValue = xerror.New("invalid value")
+	ErrClosed       = xerror.New("database closed")
 )
 
 // Options represents database configuration options
@@ -39,12 +41,24 @@ type Options struct {
 	// MaxMemory specifies the maximum memory usage (in bytes)
 	MaxMemory int64
 
-	// CompactionThreshold triggers compaction when WAL size exceeds this value
+	// CompactionThreshold is the WAL size at which the data file is synced
+	// and the WAL truncated, as Save and Close always do
 	CompactionThreshold int64
 
 	// EnableAOF enables Append-Only File persistence
 	EnableAOF bool
 
+	// AOFRewriteMinSize is the size the AOF must reach before it is rewritten
+	AOFRewriteMinSize int64
+
+	// AOFRewritePercentage is the growth since the last rewrite, in percent,
+	// that triggers a background rewrite (0 disables automatic rewrites)
+	AOFRewritePercentage int
+
+	// ReplBacklogSize is the number of bytes of recent WAL records kept for
+	// followers that reconnect (0 disables the backlog)
+	ReplBacklogSize int64
+
 	// LogLevel specifies the logging level
 	LogLevel string
 
@@ -57,28 +71,92 @@ type Options struct {
 	// CompactionL0Trigger is the number of L0 tables that triggers compaction
 	CompactionL0Trigger int
 
-	// EnableVersioning enables multi-version support
+	// EnableVersioning has no effect. Every write, whether plain or in a
+	// transaction, keeps version history according to KeepHistory, and
+	// SetWithVersion always records the replaced value.
+	//
+	// Deprecated: use KeepHistory.
 	EnableVersioning bool
 
 	// MaxVersions specifies maximum versions to keep per key (0 means unlimited)
 	MaxVersions int
+
+	// KeepHistory keeps the value replaced by every write, including
+	// transactions, in the version history, up to MaxVersions per key, for
+	// History, GetAt, Rollback and the reads of read-only transactions.
+	// Each write then copies the value it modifies, and retained versions
+	// count towards MaxMemory.
+	KeepHistory bool
+
+	// EvictionPolicy determines which keys are evicted when MaxMemory is reached
+	EvictionPolicy EvictionPolicy
+
+	// EvictionSamples is the number of keys sampled to pick an eviction candidate
+	EvictionSamples int
+
+	// ExpireScanInterval specifies how often expired keys are actively reclaimed (0 disables)
+	ExpireScanInterval time.Duration
+
+	// ExpireSampleSize is the number of keys with a TTL sampled per expiration round
+	ExpireSampleSize int
+
+	// NotifyKeyspaceEvents publishes keyspace and keyevent notifications on writes
+	NotifyKeyspaceEvents bool
+
+	// SubscriberBufferSize is the number of messages buffered per subscription
+	SubscriberBufferSize int
+
+	// SubscriberOverflow determines what happens when a subscription's buffer is full
+	SubscriberOverflow OverflowPolicy
+
+	// TxnMaxRetries is how often Update retries a transaction that failed with ErrConflict
+	TxnMaxRetries int
+
+	// StorageEngine selects the built-in engine that stores the entries
+	StorageEngine StorageEngine
+
+	// Engine is a custom storage engine that overrides StorageEngine
+	Engine Engine
+
+	// MemtableSize is the size the LSM memtable reaches before it is flushed to disk
+	MemtableSize int64
+
+	// SlowLogThreshold is the duration from which operations are recorded in the
+	// slow log (0 records every operation, a negative value disables the log)
+	SlowLogThreshold time.Duration
+
+	// SlowLogMaxLen is the number of slow operations kept
+	SlowLogMaxLen int
 }
 
 // DefaultOptions returns default configuration options
 func DefaultOptions() Options {
 	return Options{
-		DataDir:             "data",
-		SyncWrite:           true,
-		AutoSaveInterval:    time.Minute * 5,
-		MaxMemory:           1 << 30, // 1GB
-		CompactionThreshold: 1 << 20, // 1MB
-		EnableAOF:           false,
-		LogLevel:            "info",
-		ValueLogFileSize:    1 << 30, // 1GB
-		NumVersionsToKeep:   1,
-		CompactionL0Trigger: 10,
-		EnableVersioning:    true, // default to true
-		MaxVersions:         10,
+		DataDir:              "data",
+		SyncWrite:            true,
+		AutoSaveInterval:     time.Minute * 5,
+		MaxMemory:            1 << 30, // 1GB
+		CompactionThreshold:  1 << 20, // 1MB
+		EnableAOF:            false,
+		AOFRewriteMinSize:    64 << 20, // 64MB
+		AOFRewritePercentage: 100,
+		ReplBacklogSize:      1 << 20, // 1MB
+		LogLevel:             "info",
+		ValueLogFileSize:     1 << 30, // 1GB
+		NumVersionsToKeep:    1,
+		CompactionL0Trigger:  10,
+		MaxVersions:          10,
+		EvictionPolicy:       NoEviction,
+		EvictionSamples:      5,
+		ExpireScanInterval:   time.Millisecond * 100,
+		ExpireSampleSize:     20,
+		SubscriberBufferSize: 256,
+		SubscriberOverflow:   DropMessages,
+		TxnMaxRetries:        100,
+		StorageEngine:        MemoryStorage,
+		MemtableSize:         4 << 20, // 4MB
+		SlowLogThreshold:     10 * time.Millisecond,
+		SlowLogMaxLen:        128,
 	}
 }
 
@@ -113,6 +191,14 @@ func WithMaxMemory(size int64) Option {
 	}
 }
 
+// WithCompactionThreshold sets the WAL size at which the data file is synced
+// and the WAL truncated (0 leaves it to Save and Close)
+func WithCompactionThreshold(size int64) Option {
+	return func(o *Options) {
+		o.CompactionThreshold = size
+	}
+}
+
 func init() {
 	// Register types for gob encoding
 	gob.Register([]string{})
@@ -130,6 +216,7 @@ const (
 	Hash
 	Set
 	ZSet
+	JSON
 )
 
 // ZSetMember represents a sorted set member
@@ -145,22 +232,59 @@ type SetMember struct {
 
 // DB represents the main database structure
 type DB struct {
-	data      map[string]Entry
+	engine    Engine
 	mutex     sync.RWMutex
 	expires   map[string]time.Time
-	txMutex   sync.Mutex
 	dataFile  string
 	walFile   string
+	walSize   int64
 	aofFile   string
 	txCounter uint64
 	options   Options
 	memUsage  int64
 
+	// Per-key access metadata used for memory accounting and eviction
+	access         map[string]*accessInfo
+	evictedKeys    uint64
+	rejectedWrites uint64
+
+	// Number of keys per data type, guarded by mutex
+	keysByType [JSON + 1]int
+
+	// Activity counters reported by Stats
+	metrics metrics
+
+	// Append-only file, guarded by aofMutex. While a rewrite is in progress
+	// appended records are also buffered so they can be carried over.
+	aof               *os.File
+	aofMutex          sync.Mutex
+	aofSize           int64
+	aofBaseSize       int64
+	aofRewriting      bool
+	aofRewriteBuf     [][]byte
+	aofRewritePending int32
+	rewriteMutex      sync.Mutex
+
+	// Followers, backlog and follower state
+	repl replication
+
+	// Ordered keys for iteration and scanning, guarded by mutex
+	keyspace *keyspace
+
+	// Secondary indexes on hash fields, keyed by name and guarded by mutex
+	indexes map[string]*hashIndex
+
+	// Subscriptions and pending keyspace notifications
+	pubsub *pubsub
+	// Keyspace notifications of writes not logged yet, guarded by mutex
+	unlogged []Message
+
+	// Clients blocked in BLPop, keyed by list
+	listWaiters map[string][]chan struct{}
+
 	// Channels for control
-	stopChan   chan struct{}
-	saveChan   chan struct{}
-	commitLock sync.Mutex
-	txnLock    sync.Mutex
+	stopChan chan struct{}
+	saveChan chan struct{}
 }
 
 // Entry represents a value stored in the database
@@ -178,6 +302,8 @@ type DataFile struct {
 	Version   uint32
 	TxCounter uint64
 	Entries   map[string]Entry
+	Expires   map[string]time.Time
+	Indexes   []IndexInfo
 }
 
 // WALEntry represents a write-ahead log entry
@@ -186,34 +312,40 @@ type WALEntry struct {
 	Commands []Command
 }
 
-// StringOp provides string operations
-type StringOp struct {
+// keyOp provides the key-level operations shared by every op type
+type keyOp struct {
 	db  *DB
 	key string
 }
 
+// StringOp provides string operations
+type StringOp struct {
+	keyOp
+}
+
 // ListOp provides list operations
 type ListOp struct {
-	db  *DB
-	key string
+	keyOp
 }
 
 // HashOp provides hash operations
 type HashOp struct {
-	db  *DB
-	key string
+	keyOp
 }
 
 // SetOp provides set operations
 type SetOp struct {
-	db  *DB
-	key string
+	keyOp
 }
 
 // ZSetOp provides sorted set operations
 type ZSetOp struct {
-	db  *DB
-	key string
+	keyOp
+}
+
+// JSONOp provides JSON document operations
+type JSONOp struct {
+	keyOp
 }
 
 // New creates a new database instance with options
@@ -224,12 +356,17 @@ func New(opts ...Option) (*DB, error) {
 	}
 
 	db := &DB{
-		data:     make(map[string]Entry),
-		expires:  make(map[string]time.Time),
-		options:  options,
-		stopChan: make(chan struct{}),
-		saveChan: make(chan struct{}),
-	}
+		expires:     make(map[string]time.Time),
+		access:      make(map[string]*accessInfo),
+		keyspace:    newKeyspace(),
+		indexes:     make(map[string]*hashIndex),
+		pubsub:      newPubsub(),
+		listWaiters: make(map[string][]chan struct{}),
+		options:     options,
+		stopChan:    make(chan struct{}),
+		saveChan:    make(chan struct{}),
+	}
+	db.metrics.started = time.Now()
 
 	// Initialize paths
 	db.dataFile = filepath.Join(options.DataDir, "data.db")
@@ -243,8 +380,15 @@ func New(opts ...Option) (*DB, error) {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
+	engine, err := db.openEngine()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage engine: %w", err)
+	}
+	db.engine = engine
+
 	// Initialize database
 	if err := db.initialize(); err != nil {
+		engine.Close()
 		return nil, err
 	}
 
@@ -263,28 +407,39 @@ func (db *DB) initialize() error {
 	// Create data file if not exists
 	if _, err := os.Stat(db.dataFile); os.IsNotExist(err) {
 		// Initialize empty database file
-		if err := db.writeData(); err != nil {
+		if err := db.writeDataFile(db.options.SyncWrite); err != nil {
 			return fmt.Errorf("failed to create initial data file: %w", err)
 		}
 	}
 
-	// Recover from WAL if exists
-	if err := db.recoverFromWAL(); err != nil {
-		return fmt.Errorf("WAL recovery failed: %w", err)
-	}
-
 	// Load data file
 	if err := db.loadData(); err != nil {
 		return fmt.Errorf("data loading failed: %w", err)
 	}
 
-	// Initialize AOF if enabled
+	// Replay WAL entries newer than the data file, then fold them into it so
+	// the next start does not read them again
+	if err := db.replayLog(db.walFile); err != nil {
+		return fmt.Errorf("WAL recovery failed: %w", err)
+	}
+	if info, err := os.Stat(db.walFile); err == nil && info.Size() > 0 {
+		db.walSize = info.Size()
+		if err := db.checkpoint(); err != nil {
+			return fmt.Errorf("WAL checkpoint failed: %w", err)
+		}
+	}
+
+	// Replay the AOF on top and open it for appending
 	if db.options.EnableAOF {
-		if err := db.initAOF(); err != nil {
+		if err := db.openAOF(); err != nil {
 			return fmt.Errorf("AOF initialization failed: %w", err)
 		}
 	}
 
+	// Followers can only resume from records logged from now on
+	db.repl.id = newReplID()
+	db.repl.backlogStart = db.txCounter
+
 	return nil
 }
 
@@ -292,11 +447,28 @@ func (db *DB) backgroundTasks() {
 	autoSaveTicker := time.NewTicker(db.options.AutoSaveInterval)
 	defer autoSaveTicker.Stop()
 
+	// A nil channel never fires, which disables active expiration
+	var expireC <-chan time.Time
+	if db.options.ExpireScanInterval > 0 {
+		expireTicker := time.NewTicker(db.options.ExpireScanInterval)
+		defer expireTicker.Stop()
+		expireC = expireTicker.C
+	}
+
+	statsTicker := time.NewTicker(opsSampleInterval)
+	defer statsTicker.Stop()
+
 	for {
 		select {
 		case <-db.stopChan:
 			return
 
+		case now := <-statsTicker.C:
+			db.sampleOps(now)
+
+		case <-expireC:
+			db.activeExpire()
+
 		case <-autoSaveTicker.C:
 			if err := db.Save(); err != nil {
 				// log error
@@ -315,35 +487,55 @@ func (db *DB) Save() error {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
-	if err := db.writeData(); err != nil {
+	if err := db.checkpoint(); err != nil {
 		return fmt.Errorf("failed to write data: %w", err)
 	}
 
-	if db.options.EnableAOF {
-		if err := db.rotateAOF(); err != nil {
-			return fmt.Errorf("failed to rotate AOF: %w", err)
-		}
-	}
-
 	return nil
 }
 
 // Close gracefully shuts down the database
 func (db *DB) Close() error {
 	close(db.stopChan)
+	db.pubsub.closeAll()
+	db.closeReplicas()
+
+	// Wait for a background AOF rewrite to finish
+	db.rewriteMutex.Lock()
+	defer db.rewriteMutex.Unlock()
 
 	// Final save
 	if err := db.Save(); err != nil {
 		return fmt.Errorf("failed to save on close: %w", err)
 	}
+	if err := db.engine.Close(); err != nil {
+		return fmt.Errorf("failed to close storage engine: %w", err)
+	}
+
+	db.aofMutex.Lock()
+	defer db.aofMutex.Unlock()
+	if db.aof != nil {
+		if err := db.aof.Close(); err != nil {
+			return fmt.Errorf("failed to close AOF file: %w", err)
+		}
+		db.aof = nil
+	}
 
 	return nil
 }
 
-// checkMemoryLimit checks if operation would exceed memory limit
+// checkMemoryLimit checks if operation would exceed memory limit, evicting
+// keys according to the eviction policy to make room.
+// Caller must hold db.mutex for writing.
 func (db *DB) checkMemoryLimit(additionalBytes int64) error {
-	if db.options.MaxMemory > 0 {
-		if atomic.LoadInt64(&db.memUsage)+additionalBytes > db.options.MaxMemory {
+	if db.options.MaxMemory <= 0 {
+		return nil
+	}
+
+	for atomic.LoadInt64(&db.memUsage)+additionalBytes > db.options.MaxMemory {
+		// Evicting cannot help if the write alone exceeds the limit
+		if additionalBytes > db.options.MaxMemory || !db.evictOne() {
+			atomic.AddUint64(&db.rejectedWrites, 1)
 			return ErrMemoryLimit
 		}
 	}
@@ -355,46 +547,39 @@ func (db *DB) updateMemUsage(delta int64) {
 	atomic.AddInt64(&db.memUsage, delta)
 }
 
-// writeWAL writes a transaction to the WAL file
-func (db *DB) writeWAL(entry WALEntry) error {
+// writeWAL writes a transaction to the WAL file, then releases the keyspace
+// notifications of its writes
+func (db *DB) writeWAL(entry WALEntry) (err error) {
+	defer func() { db.flushNotifications(err == nil) }()
+
 	f, err := os.OpenFile(db.walFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to open WAL file: %w", err)
 	}
 	defer f.Close()
 
-	enc := gob.NewEncoder(f)
-	if err := enc.Encode(entry); err != nil {
-		return fmt.Errorf("failed to encode WAL entry: %w", err)
+	for i, cmd := range entry.Commands {
+		entry.Commands[i].Value = exportValue(cmd.Value)
 	}
 
-	if err := f.Sync(); err != nil {
-		return fmt.Errorf("failed to sync WAL file: %w", err)
-	}
-
-	return nil
-}
-
-// persistData writes the current state to disk
-func (db *DB) persistData() error {
-	data, err := json.Marshal(db.data)
+	record, err := encodeRecord(entry)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to encode WAL entry: %w", err)
 	}
-
-	// Write to temporary file first
-	tmpFile := db.dataFile + ".tmp"
-	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
-		return err
+	if _, err := f.Write(record); err != nil {
+		return fmt.Errorf("failed to write WAL entry: %w", err)
 	}
 
-	// Atomic rename
-	if err := os.Rename(tmpFile, db.dataFile); err != nil {
-		return err
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL file: %w", err)
 	}
+	db.walSize += int64(len(record))
+	db.feedReplicas(entry.TxID, record)
 
-	// Clear WAL after successful persistence
-	return os.Remove(db.walFile)
+	if db.options.EnableAOF {
+		return db.appendAOF(record)
+	}
+	return nil
 }
 
 // loadData loads the database state from disk
@@ -413,7 +598,9 @@ func (db *DB) loadData() error {
 
 	// Return if file is empty (newly created)
 	if stat.Size() == 0 {
-		db.data = make(map[string]Entry)
+		db.expires = make(map[string]time.Time)
+		db.resetAccounting()
+		db.resetKeyspace()
 		return nil
 	}
 
@@ -424,105 +611,140 @@ func (db *DB) loadData() error {
 		return fmt.Errorf("failed to decode data file: %w", err)
 	}
 
-	db.data = df.Entries
+	for key, entry := range df.Entries {
+		if entry.Type == ZSet {
+			entry.Value = storedValue(entry.Type, entry.Value)
+			df.Entries[key] = entry
+		}
+	}
+	if err := db.loadEntries(df.Entries); err != nil {
+		return err
+	}
+	db.expires = make(map[string]time.Time, len(df.Expires))
+	now := time.Now()
+	for key, deadline := range df.Expires {
+		// Drop keys that expired while the database was closed
+		if !deadline.After(now) {
+			if err := db.engine.Delete(key); err != nil {
+				return fmt.Errorf("failed to drop expired key: %w", err)
+			}
+			continue
+		}
+		db.expires[key] = deadline
+	}
 	db.txCounter = df.TxCounter
+	db.resetAccounting()
+	db.resetKeyspace()
+	db.resetIndexes(df.Indexes)
 	return nil
 }
 
-// recoverFromWAL recovers the database state from WAL
-func (db *DB) recoverFromWAL() error {
-	f, err := os.Open(db.walFile)
-	if os.IsNotExist(err) {
-		return nil
-	}
-	if err != nil {
-		return fmt.Errorf("failed to open WAL file: %w", err)
-	}
-	defer f.Close()
-
-	dec := gob.NewDecoder(f)
-	for {
-		var entry WALEntry
-		err := dec.Decode(&entry)
-		if err == io.EOF {
-			break
+// applyCommand applies a single logged command to the in-memory state
+func (db *DB) applyCommand(cmd Command, txID uint64) error {
+	switch cmd.Op {
+	case "EXPIRE":
+		if _, ok := db.engine.Get(cmd.Key); ok {
+			db.expires[cmd.Key] = cmd.ExpireAt
+			db.expireIfNeeded(cmd.Key)
 		}
-		if err != nil {
-			return fmt.Errorf("failed to decode WAL entry: %w", err)
+	case "PERSIST":
+		delete(db.expires, cmd.Key)
+	case "DEL":
+		db.removeKey(cmd.Key)
+		db.notifyWrite(EventDel, cmd.Key)
+	case opCreateIndex:
+		if prefix, ok := cmd.Value.(string); ok {
+			db.createIndex(cmd.Key, prefix, cmd.Field)
 		}
-
-		// Apply WAL entries
-		for _, cmd := range entry.Commands {
-			db.data[cmd.Key] = Entry{
-				Type:    cmd.Type,
-				Value:   cmd.Value,
-				Version: entry.TxID,
-				Created: time.Now(),
-			}
+	case opDropIndex:
+		delete(db.indexes, cmd.Key)
+	case opLPush, opRPush, opLPop, opRPop, opLRem, opLTrim, opLInsert:
+		return db.applyListCommand(cmd, txID)
+	case opHSet, opHDel:
+		return db.applyHashCommand(cmd, txID)
+	case opSAdd, opSRem:
+		return db.applySetCommand(cmd, txID)
+	case opZAdd, opZRem:
+		return db.applyZSetCommand(cmd, txID)
+	default:
+		if err := db.putEntry(cmd.Key, Entry{
+			Type:    cmd.Type,
+			Value:   cmd.Value,
+			Version: txID,
+			Created: time.Now(),
+		}); err != nil {
+			return err
 		}
-
-		if entry.TxID > db.txCounter {
-			db.txCounter = entry.TxID
+		if cmd.ExpireAt.IsZero() {
+			delete(db.expires, cmd.Key)
+		} else {
+			db.expires[cmd.Key] = cmd.ExpireAt
 		}
 	}
-
 	return nil
 }
 
 // String returns string operations for a key
 func (db *DB) String(key string) *StringOp {
-	return &StringOp{db: db, key: key}
+	return &StringOp{keyOp{db: db, key: key}}
 }
 
 // List returns list operations for a key
 func (db *DB) List(key string) *ListOp {
-	return &ListOp{db: db, key: key}
+	return &ListOp{keyOp{db: db, key: key}}
 }
 
 // Hash returns hash operations for a key
 func (db *DB) Hash(key string) *HashOp {
-	return &HashOp{db: db, key: key}
+	return &HashOp{keyOp{db: db, key: key}}
 }
 
 // Set returns set operations for a key
 func (db *DB) Set(key string) *SetOp {
-	return &SetOp{db: db, key: key}
+	return &SetOp{keyOp{db: db, key: key}}
 }
 
 // ZSet returns sorted set operations for a key
 func (db *DB) ZSet(key string) *ZSetOp {
-	return &ZSetOp{db: db, key: key}
+	return &ZSetOp{keyOp{db: db, key: key}}
+}
+
+// JSON returns JSON document operations for a key
+func (db *DB) JSON(key string) *JSONOp {
+	return &JSONOp{keyOp{db: db, key: key}}
 }
 
 // String operations
 func (op *StringOp) Set(value string) error {
-	op.db.mutex.Lock()
+	defer op.db.observe("String.Set", op.key, time.Now())
+	op.db.lock()
 	defer op.db.mutex.Unlock()
 
+	op.db.expireIfNeeded(op.key)
+
 	// Check memory limit before setting value
-	valueSize := int64(len(value))
-	if err := op.db.checkMemoryLimit(valueSize); err != nil {
+	if err := op.db.checkMemoryLimit(int64(len(value))); err != nil {
 		return err
 	}
 
-	// Update memory usage
-	op.db.updateMemUsage(valueSize)
-
-	op.db.data[op.key] = Entry{
+	if err := op.db.putEntry(op.key, Entry{
 		Type:    String,
 		Value:   value,
 		Version: op.db.txCounter + 1,
 		Created: time.Now(),
+	}); err != nil {
+		return err
 	}
-	op.db.txCounter++
-	return op.db.writeData()
+	delete(op.db.expires, op.key)
+	return op.db.logValue(op.key, String, value)
 }
 
 func (op *StringOp) Get() (string, bool) {
+	defer op.db.observe("String.Get", op.key, time.Now())
 	op.db.mutex.RLock()
 	defer op.db.mutex.RUnlock()
 
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == String {
+	if entry, ok := op.db.get(op.key); ok && entry.Type == String {
 		return entry.Value.(string), true
 	}
 	return "", false
@@ -530,113 +752,129 @@ func (op *StringOp) Get() (string, bool) {
 
 // List operations
 func (op *ListOp) Push(values ...string) error {
-	op.db.mutex.Lock()
+	defer op.db.observe("List.Push", op.key, time.Now())
+	op.db.lock()
 	defer op.db.mutex.Unlock()
 
+	op.db.expireIfNeeded(op.key)
+
+	if err := op.db.checkMemoryLimit(stringsSize(values)); err != nil {
+		return err
+	}
+
 	var list []string
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == List {
+	if entry, ok := op.db.engine.Get(op.key); ok && entry.Type == List {
 		list = entry.Value.([]string)
 	}
 
-	list = append(list, values...)
-	op.db.data[op.key] = Entry{
+	// Never append into a backing array a previous version may share
+	list = append(list[:len(list):len(list)], values...)
+	if err := op.db.putEntry(op.key, Entry{
 		Type:    List,
 		Value:   list,
 		Version: op.db.txCounter + 1,
+	}); err != nil {
+		return err
 	}
-	op.db.txCounter++
-	return op.db.writeData()
+	return op.db.logChange(Command{Op: opRPush, Key: op.key, Type: List, Value: values})
 }
 
+// Pop removes and returns the last element. It reports false if the write
+// could not be stored or logged; PopFrom returns that error.
 func (op *ListOp) Pop() (string, bool) {
-	op.db.mutex.Lock()
+	value, ok, _ := op.PopFrom(false)
+	return value, ok
+}
+
+// PopFrom removes and returns the first element if head is set, or else the
+// last element
+func (op *ListOp) PopFrom(head bool) (string, bool, error) {
+	name := "List.Pop"
+	if head {
+		name = "List.LPop"
+	}
+	defer op.db.observe(name, op.key, time.Now())
+	op.db.lock()
 	defer op.db.mutex.Unlock()
 
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == List {
+	op.db.expireIfNeeded(op.key)
+
+	if entry, ok := op.db.engine.Get(op.key); ok && entry.Type == List {
 		list := entry.Value.([]string)
 		if len(list) == 0 {
-			return "", false
+			return "", false, nil
 		}
 
-		value := list[len(list)-1]
-		list = list[:len(list)-1]
+		var value string
+		logged := opRPop
+		if head {
+			value, list, logged = list[0], list[1:], opLPop
+		} else {
+			value, list = list[len(list)-1], list[:len(list)-1]
+		}
 
-		op.db.data[op.key] = Entry{
+		if err := op.db.putEntry(op.key, Entry{
 			Type:    List,
 			Value:   list,
 			Version: op.db.txCounter + 1,
+		}); err != nil {
+			return "", false, err
+		}
+		if err := op.db.logChange(Command{Op: logged, Key: op.key, Type: List}); err != nil {
+			return "", false, err
 		}
-		op.db.txCounter++
-		op.db.writeData()
-		return value, true
+		return value, true, nil
 	}
-	return "", false
+	return "", false, nil
 }
 
 // LPush adds elements to the beginning of the list
 func (op *ListOp) LPush(values ...string) error {
-	op.db.mutex.Lock()
+	defer op.db.observe("List.LPush", op.key, time.Now())
+	op.db.lock()
 	defer op.db.mutex.Unlock()
 
-	var list []string
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == List {
-		list = entry.Value.([]string)
+	op.db.expireIfNeeded(op.key)
+
+	if err := op.db.checkMemoryLimit(stringsSize(values)); err != nil {
+		return err
 	}
 
-	// Prepend values in correct order
-	newList := make([]string, len(values)+len(list))
-	copy(newList[len(values):], list)
-	for i, v := range values {
-		newList[i] = v
+	var list []string
+	if entry, ok := op.db.engine.Get(op.key); ok && entry.Type == List {
+		list = entry.Value.([]string)
 	}
 
-	op.db.data[op.key] = Entry{
+	if err := op.db.putEntry(op.key, Entry{
 		Type:    List,
-		Value:   newList,
+		Value:   prependValues(list, values),
 		Version: op.db.txCounter + 1,
+	}); err != nil {
+		return err
 	}
-	op.db.txCounter++
-	return op.db.writeData()
+	return op.db.logChange(Command{Op: opLPush, Key: op.key, Type: List, Value: values})
 }
 
-// LPop removes and returns the first element
+// LPop removes and returns the first element. It reports false if the write
+// could not be stored or logged; PopFrom returns that error.
 func (op *ListOp) LPop() (string, bool) {
-	op.db.mutex.Lock()
-	defer op.db.mutex.Unlock()
-
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == List {
-		list := entry.Value.([]string)
-		if len(list) == 0 {
-			return "", false
-		}
-
-		value := list[0]
-		list = list[1:]
-
-		op.db.data[op.key] = Entry{
-			Type:    List,
-			Value:   list,
-			Version: op.db.txCounter + 1,
-		}
-		op.db.txCounter++
-		op.db.writeData()
-		return value, true
-	}
-	return "", false
+	value, ok, _ := op.PopFrom(true)
+	return value, ok
 }
 
 // Range returns a slice of elements from start to stop index
 func (op *ListOp) Range(start, stop int) []string {
+	defer op.db.observe("List.Range", op.key, time.Now())
 	op.db.mutex.RLock()
 	defer op.db.mutex.RUnlock()
 
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == List {
+	if entry, ok := op.db.get(op.key); ok && entry.Type == List {
 		list := entry.Value.([]string)
 		length := len(list)
 
-		// Convert negative indices
+		// Convert negative indices, clamping the start like Redis does
 		if start < 0 {
-			start = length + start
+			start = max(length+start, 0)
 		}
 		if stop < 0 {
 			stop = length + stop
@@ -659,10 +897,11 @@ func (op *ListOp) Range(start, stop int) []string {
 
 // Len returns the length of the list
 func (op *ListOp) Len() int {
+	defer op.db.observe("List.Len", op.key, time.Now())
 	op.db.mutex.RLock()
 	defer op.db.mutex.RUnlock()
 
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == List {
+	if entry, ok := op.db.get(op.key); ok && entry.Type == List {
 		return len(entry.Value.([]string))
 	}
 	return 0
@@ -670,31 +909,26 @@ func (op *ListOp) Len() int {
 
 // Hash operations
 func (op *HashOp) Set(field string, value string) error {
-	op.db.mutex.Lock()
+	defer op.db.observe("Hash.Set", op.key, time.Now())
+	op.db.lock()
 	defer op.db.mutex.Unlock()
 
-	var hash map[string]string
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == Hash {
-		hash = entry.Value.(map[string]string)
-	} else {
-		hash = make(map[string]string)
-	}
+	op.db.expireIfNeeded(op.key)
 
-	hash[field] = value
-	op.db.data[op.key] = Entry{
-		Type:    Hash,
-		Value:   hash,
-		Version: op.db.txCounter + 1,
+	if err := op.db.checkMemoryLimit(int64(len(field) + len(value))); err != nil {
+		return err
 	}
-	op.db.txCounter++
-	return op.db.writeData()
+
+	entry, ok := op.db.engine.Get(op.key)
+	return op.db.hset(op.key, entry, ok && entry.Type == Hash, field, value)
 }
 
 func (op *HashOp) Get(field string) (string, bool) {
+	defer op.db.observe("Hash.Get", op.key, time.Now())
 	op.db.mutex.RLock()
 	defer op.db.mutex.RUnlock()
 
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == Hash {
+	if entry, ok := op.db.get(op.key); ok && entry.Type == Hash {
 		hash := entry.Value.(map[string]string)
 		value, exists := hash[field]
 		return value, exists
@@ -704,34 +938,38 @@ func (op *HashOp) Get(field string) (string, bool) {
 
 // Set operations
 func (op *SetOp) Add(members ...string) error {
-	op.db.mutex.Lock()
+	defer op.db.observe("Set.Add", op.key, time.Now())
+	op.db.lock()
 	defer op.db.mutex.Unlock()
 
-	var set map[string]struct{}
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == Set {
-		set = entry.Value.(map[string]struct{})
-	} else {
-		set = make(map[string]struct{})
+	op.db.expireIfNeeded(op.key)
+
+	if err := op.db.checkMemoryLimit(stringsSize(members)); err != nil {
+		return err
 	}
 
+	entry, ok := op.db.engine.Get(op.key)
+	set := op.db.setForWrite(entry, ok && entry.Type == Set)
 	for _, member := range members {
 		set[member] = struct{}{}
 	}
 
-	op.db.data[op.key] = Entry{
+	if err := op.db.putEntry(op.key, Entry{
 		Type:    Set,
 		Value:   set,
 		Version: op.db.txCounter + 1,
+	}); err != nil {
+		return err
 	}
-	op.db.txCounter++
-	return op.db.writeData()
+	return op.db.logChange(Command{Op: opSAdd, Key: op.key, Type: Set, Value: members})
 }
 
 func (op *SetOp) IsMember(member string) bool {
+	defer op.db.observe("Set.IsMember", op.key, time.Now())
 	op.db.mutex.RLock()
 	defer op.db.mutex.RUnlock()
 
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == Set {
+	if entry, ok := op.db.get(op.key); ok && entry.Type == Set {
 		set := entry.Value.(map[string]struct{})
 		_, exists := set[member]
 		return exists
@@ -741,84 +979,108 @@ func (op *SetOp) IsMember(member string) bool {
 
 // ZSet operations
 func (op *ZSetOp) Add(score float64, member string) error {
-	op.db.mutex.Lock()
+	defer op.db.observe("ZSet.Add", op.key, time.Now())
+	op.db.lock()
 	defer op.db.mutex.Unlock()
 
-	var zset []ZSetMember
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == ZSet {
-		zset = entry.Value.([]ZSetMember)
-	}
+	op.db.expireIfNeeded(op.key)
 
-	// Update or add member
-	found := false
-	for i, m := range zset {
-		if m.Member == member {
-			zset[i].Score = score
-			found = true
-			break
-		}
+	if math.IsNaN(score) {
+		return ErrInvalidValue
 	}
-
-	if !found {
-		zset = append(zset, ZSetMember{Member: member, Score: score})
+	if err := op.db.checkMemoryLimit(int64(len(member)) + 8); err != nil {
+		return err
 	}
 
-	// Sort by score
-	sort.Slice(zset, func(i, j int) bool {
-		return zset[i].Score < zset[j].Score
-	})
+	entry, ok := op.db.engine.Get(op.key)
+	zset := op.db.zsetForWrite(entry, ok && entry.Type == ZSet)
+	zset.add(member, score)
 
-	op.db.data[op.key] = Entry{
+	if err := op.db.putEntry(op.key, Entry{
 		Type:    ZSet,
 		Value:   zset,
 		Version: op.db.txCounter + 1,
+	}); err != nil {
+		return err
 	}
-	op.db.txCounter++
-	return op.db.writeData()
+	return op.db.logZAdd(op.key, member, score)
 }
 
 func (op *ZSetOp) Range(start, stop int) []ZSetMember {
+	defer op.db.observe("ZSet.Range", op.key, time.Now())
 	op.db.mutex.RLock()
 	defer op.db.mutex.RUnlock()
 
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == ZSet {
-		zset := entry.Value.([]ZSetMember)
-		length := len(zset)
+	if entry, ok := op.db.get(op.key); ok && entry.Type == ZSet {
+		zset := entry.Value.(*sortedSet)
 
-		// Convert negative indices to positive
-		if start < 0 {
-			start = length + start
-		}
-		if stop < 0 {
-			stop = length + stop
+		// Negative indices count from the end, clamping the start like Redis does
+		if lo, hi, ok := normalizeRange(start, stop, zset.len()); ok {
+			return zset.rangeByRank(lo, hi, false)
 		}
+	}
+	return nil
+}
 
-		// Handle out of bounds cases
-		if start < 0 || start >= length || stop < 0 || start > stop {
-			return nil
-		}
+// maybeCheckpoint runs after a write has been logged. The WAL already makes
+// the write durable, so the data file is only rewritten once the WAL has grown
+// past CompactionThreshold, and otherwise on Save, auto-save and Close.
+// Caller must hold db.mutex for writing.
+func (db *DB) maybeCheckpoint() error {
+	if t := db.options.CompactionThreshold; t > 0 && db.walSize >= t {
+		return db.checkpoint()
+	}
+	return nil
+}
 
-		// Adjust stop if it exceeds length
-		if stop >= length {
-			stop = length - 1
+// checkpoint writes and syncs the data file and truncates the WAL, whose
+// records it then reflects. With a persistent engine that cannot sync its
+// writes the WAL is kept, as it is the only durable copy of them.
+// Caller must hold db.mutex for writing.
+func (db *DB) checkpoint() error {
+	if err := db.writeDataFile(true); err != nil {
+		return err
+	}
+	if s, ok := db.engine.(Syncer); ok {
+		if err := s.Sync(); err != nil {
+			return fmt.Errorf("failed to sync storage engine: %w", err)
 		}
+	} else if db.engine.Persistent() {
+		return nil
+	}
 
-		// Return slice including stop index
-		return zset[start : stop+1]
+	if err := os.Truncate(db.walFile, 0); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to truncate WAL file: %w", err)
 	}
+	db.walSize = 0
 	return nil
 }
 
-// writeData with improved error handling and proper cleanup
-func (db *DB) writeData() error {
+// writeDataFile writes the data file, syncing it to disk if sync is set
+func (db *DB) writeDataFile(sync bool) error {
 	if db.dataFile == "" {
 		return fmt.Errorf("data file path not set")
 	}
 
+	// Sorted sets are persisted as []ZSetMember to keep the file format stable.
+	// A persistent engine stores the entries itself.
+	var entries map[string]Entry
+	if !db.engine.Persistent() {
+		entries = make(map[string]Entry, db.keyspace.lex.length)
+		if err := db.engine.Range(func(key string, entry Entry) bool {
+			entries[key] = exportEntry(entry)
+			return true
+		}); err != nil {
+			return fmt.Errorf("failed to read entries: %w", err)
+		}
+	}
+
 	df := DataFile{
 		Version:   1,
 		TxCounter: db.txCounter,
-		Entries:   db.data,
+		Entries:   entries,
+		Expires:   db.expires,
+		Indexes:   db.indexInfos(),
 	}
 
 	// Create parent directory if it doesn't exist
@@ -845,7 +1107,7 @@ func (db *DB) writeData() error {
 		return fmt.Errorf("failed to encode data: %w", err)
 	}
 
-	if db.options.SyncWrite {
+	if sync {
 		if err := f.Sync(); err != nil {
 			return fmt.Errorf("failed to sync file: %w", err)
 		}
@@ -873,68 +1135,21 @@ func (db *DB) writeData() error {
 
 	// Clean up backup file
 	os.Remove(backupFile)
-	return nil
-}
-
-// initAOF initializes the AOF file
-func (db *DB) initAOF() error {
-	if !db.options.EnableAOF {
-		return nil
-	}
-
-	f, err := os.OpenFile(db.aofFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open AOF file: %w", err)
-	}
-	defer f.Close()
-
-	return nil
-}
-
-// rotateAOF performs AOF rewrite
-func (db *DB) rotateAOF() error {
-	if !db.options.EnableAOF {
-		return nil
-	}
-
-	// Create new AOF file
-	newAOF := db.aofFile + ".new"
-	f, err := os.Create(newAOF)
-	if err != nil {
-		return fmt.Errorf("failed to create new AOF file: %w", err)
-	}
-	defer f.Close()
-
-	// Write current dataset to new AOF
-	enc := gob.NewEncoder(f)
-	if err := enc.Encode(db.data); err != nil {
-		return fmt.Errorf("failed to encode AOF data: %w", err)
-	}
-
-	if db.options.SyncWrite {
-		if err := f.Sync(); err != nil {
-			return fmt.Errorf("failed to sync AOF file: %w", err)
-		}
-	}
-
-	// Atomic rename
-	if err := os.Rename(newAOF, db.aofFile); err != nil {
-		return fmt.Errorf("failed to rename AOF file: %w", err)
-	}
-
+	atomic.StoreInt64(&db.metrics.lastSave, time.Now().UnixNano())
 	return nil
 }
 
 // Command represents a database operation
 type Command struct {
-	Op      string        // Operation type (SET, GET, etc.)
-	Key     string        // Key to operate on
-	Value   interface{}   // Value for the operation
-	Version uint64        // Version number
-	Type    DataType      // Data type
-	Field   string        // For hash operations
-	Score   float64       // For sorted set operations
-	TTL     time.Duration // For key expiration
+	Op       string        // Operation type (SET, GET, etc.)
+	Key      string        // Key to operate on
+	Value    interface{}   // Value for the operation
+	Version  uint64        // Version number
+	Type     DataType      // Data type
+	Field    string        // For hash operations
+	Score    float64       // For sorted set operations
+	TTL      time.Duration // For key expiration
+	ExpireAt time.Time     // Absolute deadline for EXPIRE and SET with TTL
 }
 
 // BatchOp represents a batch operation
@@ -946,9 +1161,19 @@ type BatchOp struct {
 
 // ExecuteBatch executes multiple operations atomically
 func (db *DB) ExecuteBatch(ops []BatchOp) error {
-	db.mutex.Lock()
+	defer db.observe("ExecuteBatch", "", time.Now())
+	db.lock()
 	defer db.mutex.Unlock()
 
+	// Make room for the whole batch before applying any of it
+	var batchSize int64
+	for _, op := range ops {
+		batchSize += valueSize(op.Value)
+	}
+	if err := db.checkMemoryLimit(batchSize); err != nil {
+		return err
+	}
+
 	// Prepare WAL entry
 	walEntry := WALEntry{
 		TxID:     atomic.AddUint64(&db.txCounter, 1),
@@ -963,7 +1188,10 @@ func (db *DB) ExecuteBatch(ops []BatchOp) error {
 			Version: walEntry.TxID,
 			Created: time.Now(),
 		}
-		db.data[op.Key] = entry
+		if err := db.putEntry(op.Key, entry); err != nil {
+			return err
+		}
+		delete(db.expires, op.Key)
 
 		// Record command in WAL
 		walEntry.Commands[i] = Command{
@@ -980,7 +1208,7 @@ func (db *DB) ExecuteBatch(ops []BatchOp) error {
 		return fmt.Errorf("failed to write WAL: %w", err)
 	}
 
-	return db.writeData()
+	return db.maybeCheckpoint()
 }
 
 // getTypeFromOp converts operation string to DataType
@@ -996,204 +1224,55 @@ func getTypeFromOp(op string) DataType {
 		return Set
 	case "ZSET":
 		return ZSet
+	case "JSON":
+		return JSON
 	default:
 		return String // Default to String type
 	}
 }
 
-// Add new transaction types
-type Txn struct {
-	db       *DB
-	readTs   uint64
-	writes   map[string]*Entry
-	pending  []*Entry
-	conflict map[string]struct{}
-	mutex    sync.Mutex
-	readOnly bool
-}
-
-// Add iterator interface
+// Iterator walks the keys of a database in order, see NewIterator
 type Iterator struct {
 	db      *DB
 	prefix  []byte
 	reverse bool
+	asOf    time.Time
 	curr    string
+	valid   bool
 	mutex   sync.RWMutex
 }
 
-// Add transaction methods
-func (db *DB) NewTransaction(update bool) *Txn {
-	db.txMutex.Lock()
-	defer db.txMutex.Unlock()
-
-	txn := &Txn{
-		db:       db,
-		readTs:   atomic.LoadUint64(&db.txCounter),
-		writes:   make(map[string]*Entry),
-		pending:  make([]*Entry, 0),
-		conflict: make(map[string]struct{}),
-		readOnly: !update,
-	}
-
-	return txn
-}
-
-func (txn *Txn) Get(key string) (Entry, error) {
-	txn.mutex.Lock()
-	defer txn.mutex.Unlock()
-
-	// First check if the key exists in the transaction's writes
-	if entry, exists := txn.writes[key]; exists {
-		return *entry, nil
-	}
-
-	// Otherwise read from the DB
-	txn.db.mutex.RLock()
-	entry, exists := txn.db.data[key]
-	txn.db.mutex.RUnlock()
-
-	if !exists {
-		return Entry{}, ErrKeyNotFound
-	}
-
-	// Store this read for conflict detection
-	if !txn.readOnly {
-		txn.conflict[key] = struct{}{}
-	}
-
-	// For read consistency, keep a snapshot of the entry at the time the transaction started
-	if entry.Version > txn.readTs && len(entry.Versions) > 0 {
-		// Find the version that was active at the time the transaction started
-		for _, ver := range entry.Versions {
-			if ver.Version <= txn.readTs {
-				entry.Value = ver.Value
-				break
-			}
-		}
-	}
-
-	return entry, nil
-}
-
-func (txn *Txn) Set(key string, entry Entry) error {
-	if txn.readOnly {
-		return xerror.New("cannot write in read-only transaction")
-	}
-
-	txn.mutex.Lock()
-	defer txn.mutex.Unlock()
-
-	// Check for write conflicts
-	txn.db.mutex.RLock()
-	if existing, ok := txn.db.data[key]; ok {
-		if existing.Version > txn.readTs {
-			txn.db.mutex.RUnlock()
-			return xerror.New("write conflict")
-		}
-	}
-	txn.db.mutex.RUnlock()
-
-	entryCopy := entry
-	txn.writes[key] = &entryCopy
-	return nil
-}
-
-func (txn *Txn) Commit() error {
-	if txn.readOnly {
-		return nil
-	}
-
-	txn.mutex.Lock()
-	defer txn.mutex.Unlock()
-
-	// Global commit lock to ensure serial commits
-	txn.db.commitLock.Lock()
-	defer txn.db.commitLock.Unlock()
-
-	// Re-check for conflicts
-	txn.db.mutex.RLock()
-	for key := range txn.writes {
-		if entry, ok := txn.db.data[key]; ok {
-			if entry.Version > txn.readTs {
-				txn.db.mutex.RUnlock()
-				return xerror.New("write conflict")
-			}
-		}
-	}
-	txn.db.mutex.RUnlock()
-
-	// Get new transaction ID
-	newTxID := atomic.AddUint64(&txn.db.txCounter, 1)
-
-	// Apply changes
-	txn.db.mutex.Lock()
-	for key, entry := range txn.writes {
-		// Save the current version for history if versioning is enabled
-		if txn.db.options.EnableVersioning {
-			if oldEntry, exists := txn.db.data[key]; exists {
-				// Create a versioned entry from the current value
-				versionedEntry := VersionedEntry{
-					Value:       oldEntry.Value,
-					Version:     oldEntry.Version,
-					Created:     oldEntry.Created,
-					LastUpdated: oldEntry.LastUpdated,
-				}
-
-				// Add to version history
-				entry.Versions = append([]VersionedEntry{versionedEntry}, oldEntry.Versions...)
-
-				// Limit the number of versions if configured
-				if txn.db.options.MaxVersions > 0 && len(entry.Versions) > txn.db.options.MaxVersions {
-					entry.Versions = entry.Versions[:txn.db.options.MaxVersions]
-				}
-			}
-		}
-
-		entry.Version = newTxID
-		entry.LastUpdated = time.Now()
-		if entry.Created.IsZero() {
-			entry.Created = entry.LastUpdated
-		}
-		txn.db.data[key] = *entry
-	}
-	txn.db.mutex.Unlock()
-
-	// Write WAL entry
-	walEntry := WALEntry{
-		TxID:     newTxID,
-		Commands: make([]Command, 0, len(txn.writes)),
-	}
-
-	for key, entry := range txn.writes {
-		walEntry.Commands = append(walEntry.Commands, Command{
-			Key:     key,
-			Value:   entry.Value,
-			Version: entry.Version,
-			Type:    entry.Type,
-		})
-	}
-
-	if err := txn.db.writeWAL(walEntry); err != nil {
-		return fmt.Errorf("failed to write WAL: %w", err)
-	}
-
-	return txn.db.writeData()
-}
-
-// Add iterator methods
+// NewIterator returns an iterator over the keys starting with opts.Prefix in key
+// order. Every step finds its place by key under a short read lock, so a long
+// walk never blocks writers and is not disturbed by writes to other keys.
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
 	return &Iterator{
 		db:      db,
 		prefix:  []byte(opts.Prefix),
 		reverse: opts.Reverse,
+		asOf:    opts.AsOf,
 	}
 }
 
+// IteratorOptions configures an Iterator
 type IteratorOptions struct {
-	Prefix  string
+	// Prefix restricts iteration to keys starting with it
+	Prefix string
+	// Reverse iterates in descending key order
 	Reverse bool
+	// AsOf, if set, yields every key as it was at that time from its version
+	// history, skipping keys that had no retained version then. Keys deleted
+	// since are not seen.
+	AsOf time.Time
 }
 
+// Rewind moves to the first key, or the last key when iterating in reverse
+func (it *Iterator) Rewind() {
+	it.Seek(string(it.prefix))
+}
+
+// Seek moves to the first key at or after key. When iterating in reverse it moves
+// to the last key at or before key, counting keys that start with key as before it.
 func (it *Iterator) Seek(key string) {
 	it.mutex.Lock()
 	defer it.mutex.Unlock()
@@ -1201,97 +1280,86 @@ func (it *Iterator) Seek(key string) {
 	it.db.mutex.RLock()
 	defer it.db.mutex.RUnlock()
 
-	// Collect all matching keys
-	var matchingKeys []string
-	for k := range it.db.data {
-		if bytes.HasPrefix([]byte(k), it.prefix) {
-			matchingKeys = append(matchingKeys, k)
-		}
-	}
-
-	// Sort keys based on iteration direction
+	prefix := string(it.prefix)
 	if it.reverse {
-		sort.Sort(sort.Reverse(sort.StringSlice(matchingKeys)))
-	} else {
-		sort.Strings(matchingKeys)
+		// Seeking past the prefix starts from its last key
+		if key > prefix && !strings.HasPrefix(key, prefix) {
+			key = prefix
+		}
+		it.settle(it.db.keyspace.lastWithPrefix(key))
+		return
 	}
+	it.settle(it.db.keyspace.lex.seek(0, max(key, prefix)))
+}
 
-	// Find starting position
-	it.curr = ""
-	for _, k := range matchingKeys {
+// settle moves to the first live key from x on in the iteration direction,
+// invalidating the iterator once it leaves the prefix. Caller must hold it.mutex
+// and db.mutex for reading.
+func (it *Iterator) settle(x *skiplistNode) {
+	now := time.Now()
+	for x != nil && (it.db.isExpired(x.member, now) || !it.visible(x.member)) {
 		if it.reverse {
-			if k <= key {
-				it.curr = k
-				break
-			}
+			x = x.backward
 		} else {
-			if k >= key {
-				it.curr = k
-				break
-			}
+			x = x.level[0].forward
 		}
 	}
+	it.valid = x != nil && bytes.HasPrefix([]byte(x.member), it.prefix)
+	if it.valid {
+		it.curr = x.member
+	}
+}
 
-	// If no matching key found and we have keys, use first/last key
-	if it.curr == "" && len(matchingKeys) > 0 {
-		if it.reverse {
-			it.curr = matchingKeys[0]
-		} else {
-			it.curr = matchingKeys[0]
-		}
+// visible reports whether key had a version at the iterator's AsOf time.
+// Caller must hold db.mutex for reading.
+func (it *Iterator) visible(key string) bool {
+	if it.asOf.IsZero() {
+		return true
+	}
+	entry, ok := it.db.engine.Get(key)
+	if !ok {
+		return false
 	}
+	_, ok = versionAsOf(entry, it.asOf)
+	return ok
 }
 
+// Valid reports whether the iterator is positioned on a key
 func (it *Iterator) Valid() bool {
 	it.mutex.RLock()
 	defer it.mutex.RUnlock()
-
-	if it.curr == "" {
-		return false
-	}
-
-	return bytes.HasPrefix([]byte(it.curr), it.prefix)
+	return it.valid
 }
 
+// Next moves to the following key. Keys removed since the last step are skipped
+// and keys added after the current position are visited.
 func (it *Iterator) Next() {
 	it.mutex.Lock()
 	defer it.mutex.Unlock()
 
+	if !it.valid {
+		return
+	}
+
 	it.db.mutex.RLock()
 	defer it.db.mutex.RUnlock()
 
-	// Get all keys
-	var keys []string
-	for k := range it.db.data {
-		if bytes.HasPrefix([]byte(k), it.prefix) {
-			keys = append(keys, k)
-		}
-	}
-
-	// Sort keys based on direction
+	x := it.db.keyspace.lex.seek(0, it.curr)
 	if it.reverse {
-		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
-	} else {
-		sort.Strings(keys)
-	}
-
-	// Find next key
-	if it.curr == "" {
-		if len(keys) > 0 {
-			it.curr = keys[0]
-		}
-		return
-	}
-
-	for i, k := range keys {
-		if k == it.curr && i+1 < len(keys) {
-			it.curr = keys[i+1]
-			return
+		// The key before curr, which may itself have been removed meanwhile
+		if x == nil {
+			x = it.db.keyspace.lex.tail
+		} else {
+			x = x.backward
 		}
+	} else if x != nil && x.member == it.curr {
+		x = x.level[0].forward
 	}
-	it.curr = ""
+	it.settle(x)
 }
 
+// Item returns a copy of the entry at the current key, or nil if the key was
+// removed after the iterator moved to it
 func (it *Iterator) Item() *Entry {
 	it.mutex.RLock()
 	defer it.mutex.RUnlock()
@@ -1299,18 +1367,32 @@ func (it *Iterator) Item() *Entry {
 	it.db.mutex.RLock()
 	defer it.db.mutex.RUnlock()
 
-	// Return nil if current key is empty
-	if it.curr == "" {
+	if !it.valid {
 		return nil
 	}
 
 	// Get entry from DB
-	if entry, ok := it.db.data[it.curr]; ok {
-		// Return a copy of the entry to prevent modification
-		entryCopy := entry
+	entry, ok := it.db.get(it.curr)
+	if !ok {
+		return nil
+	}
+	if it.asOf.IsZero() {
+		// Return a copy of the entry, which later writes may modify in place
+		entryCopy := copyEntry(entry)
 		return &entryCopy
 	}
-	return nil
+
+	v, ok := versionAsOf(entry, it.asOf)
+	if !ok {
+		return nil
+	}
+	return &Entry{
+		Type:        entry.Type,
+		Value:       copyValue(v.Value),
+		Version:     v.Version,
+		Created:     v.Created,
+		LastUpdated: v.LastUpdated,
+	}
 }
 
 // ExportToJSON exports the database content as a JSON string
@@ -1319,8 +1401,13 @@ func (db *DB) ExportToJSON() (string, error) {
 	defer db.mutex.RUnlock()
 
 	exportData := make(map[string]interface{})
-	for key, entry := range db.data {
-		var value interface{}
+	now := time.Now()
+	err := db.engine.Range(func(key string, entry Entry) bool {
+		if db.isExpired(key, now) {
+			return true
+		}
+
+		var value map[string]interface{}
 
 		// Format value based on type
 		switch entry.Type {
@@ -1410,7 +1497,7 @@ func (db *DB) ExportToJSON() (string, error) {
 		case ZSet:
 			zsetValue := map[string]interface{}{
 				"type":         entry.Type,
-				"value":        entry.Value.([]ZSetMember),
+				"value":        entry.Value.(*sortedSet).members(),
 				"version":      entry.Version,
 				"created":      entry.Created,
 				"last_updated": entry.LastUpdated,
@@ -1428,9 +1515,40 @@ func (db *DB) ExportToJSON() (string, error) {
 				zsetValue["versions"] = versions
 			}
 			value = zsetValue
+
+		case JSON:
+			// Documents are embedded as they are rather than as strings
+			jsonValue := map[string]interface{}{
+				"type":         entry.Type,
+				"value":        json.RawMessage(entry.Value.(string)),
+				"version":      entry.Version,
+				"created":      entry.Created,
+				"last_updated": entry.LastUpdated,
+			}
+			if len(entry.Versions) > 0 {
+				versions := make([]map[string]interface{}, len(entry.Versions))
+				for i, v := range entry.Versions {
+					versions[i] = map[string]interface{}{
+						"value":        json.RawMessage(v.Value.(string)),
+						"version":      v.Version,
+						"created":      v.Created,
+						"last_updated": v.LastUpdated,
+					}
+				}
+				jsonValue["versions"] = versions
+			}
+			value = jsonValue
+		}
+
+		if deadline, ok := db.expires[key]; ok && value != nil {
+			value["expires_at"] = deadline
 		}
 
 		exportData[key] = value
+		return true
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read entries: %w", err)
 	}
 
 	data, err := json.MarshalIndent(exportData, "", "    ")
@@ -1475,11 +1593,11 @@ type VersionedEntry struct {
 	LastUpdated time.Time
 }
 
-// WithVersioning enables version control
+// WithVersioning keeps the value replaced by every write in the version history.
+//
+// Deprecated: use WithHistory.
 func WithVersioning(enable bool) Option {
-	return func(o *Options) {
-		o.EnableVersioning = enable
-	}
+	return WithHistory(enable)
 }
 
 // WithMaxVersions sets maximum versions per key
@@ -1489,16 +1607,26 @@ func WithMaxVersions(max int) Option {
 	}
 }
 
+// WithHistory keeps the value replaced by every write in the version history
+func WithHistory(enable bool) Option {
+	return func(o *Options) {
+		o.KeepHistory = enable
+	}
+}
+
 // StringOp operations with time tracking
 func (op *StringOp) SetWithVersion(value string) error {
-	op.db.mutex.Lock()
+	defer op.db.observe("String.SetWithVersion", op.key, time.Now())
+	op.db.lock()
 	defer op.db.mutex.Unlock()
 
+	op.db.expireIfNeeded(op.key)
+
 	now := time.Now()
 	newVersion := op.db.txCounter + 1
 
 	var versions []VersionedEntry
-	if existing, ok := op.db.data[op.key]; ok {
+	if existing, ok := op.db.engine.Get(op.key); ok {
 		// Add current value to version history
 		versions = append(versions, VersionedEntry{
 			Value:       existing.Value,
@@ -1525,17 +1653,20 @@ func (op *StringOp) SetWithVersion(value string) error {
 		Versions:    versions,
 	}
 
-	op.db.data[op.key] = entry
-	op.db.txCounter++
-	return op.db.writeData()
+	if err := op.db.putEntry(op.key, entry); err != nil {
+		return err
+	}
+	delete(op.db.expires, op.key)
+	return op.db.logValue(op.key, String, value)
 }
 
 // GetVersion retrieves a specific version of a string value
 func (op *StringOp) GetVersion(version uint64) (string, bool) {
+	defer op.db.observe("String.GetVersion", op.key, time.Now())
 	op.db.mutex.RLock()
 	defer op.db.mutex.RUnlock()
 
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == String {
+	if entry, ok := op.db.get(op.key); ok && entry.Type == String {
 		// Return current version if it matches
 		if entry.Version == version {
 			return entry.Value.(string), true
@@ -1553,10 +1684,11 @@ func (op *StringOp) GetVersion(version uint64) (string, bool) {
 
 // ListVersions returns all available versions for a key
 func (op *StringOp) ListVersions() []uint64 {
+	defer op.db.observe("String.ListVersions", op.key, time.Now())
 	op.db.mutex.RLock()
 	defer op.db.mutex.RUnlock()
 
-	if entry, ok := op.db.data[op.key]; ok && entry.Type == String {
+	if entry, ok := op.db.get(op.key); ok && entry.Type == String {
 		versions := make([]uint64, 0, len(entry.Versions)+1)
 		versions = append(versions, entry.Version)
 
@@ -1573,106 +1705,3 @@ func (op *StringOp) ListVersions() []uint64 {
 	}
 	return nil
 }
-
-// Transaction implementation
-type Transaction struct {
-	db       *DB
-	readOnly bool
-	writes   map[string]Entry
-	reads    map[string]Entry
-	mutex    sync.RWMutex
-	started  time.Time
-}
-
-func (txn *Transaction) Get(key string) (Entry, error) {
-	txn.mutex.RLock()
-	defer txn.mutex.RUnlock()
-
-	// First check writes
-	if entry, ok := txn.writes[key]; ok {
-		return entry, nil
-	}
-
-	// Then check reads
-	if entry, ok := txn.reads[key]; ok {
-		return entry, nil
-	}
-
-	// Finally check database
-	txn.db.mutex.RLock()
-	defer txn.db.mutex.RUnlock()
-
-	if entry, ok := txn.db.data[key]; ok {
-		// Store in reads for consistency
-		txn.reads[key] = entry
-		return entry, nil
-	}
-
-	return Entry{}, ErrKeyNotFound
-}
-
-func (txn *Transaction) Set(key string, entry Entry) error {
-	if txn.readOnly {
-		return fmt.Errorf("cannot write in read-only transaction")
-	}
-
-	txn.mutex.Lock()
-	defer txn.mutex.Unlock()
-
-	// Store in writes
-	txn.writes[key] = entry
-	return nil
-}
-
-func (txn *Transaction) Commit() error {
-	if txn.readOnly {
-		return nil
-	}
-
-	txn.db.txMutex.Lock()
-	defer txn.db.txMutex.Unlock()
-
-	// Check for conflicts
-	for key := range txn.writes {
-		if currentEntry, ok := txn.db.data[key]; ok {
-			if readEntry, wasRead := txn.reads[key]; wasRead {
-				// Check if the entry was modified after our read
-				if currentEntry.Version != readEntry.Version {
-					return fmt.Errorf("transaction conflict: key %s has been modified", key)
-				}
-			}
-		}
-	}
-
-	// Apply all writes
-	now := time.Now()
-	for key, entry := range txn.writes {
-		entry.Version = txn.db.txCounter + 1
-		entry.LastUpdated = now
-		if entry.Created.IsZero() {
-			entry.Created = now
-		}
-		txn.db.data[key] = entry
-	}
-
-	txn.db.txCounter++
-	return nil
-}
-
-// Concurrent write control
-func (db *DB) writeWithLock(key string, entry Entry) error {
-	db.txMutex.Lock()
-	defer db.txMutex.Unlock()
-
-	// Update version and timestamps
-	entry.Version = db.txCounter + 1
-	now := time.Now()
-	if entry.Created.IsZero() {
-		entry.Created = now
-	}
-	entry.LastUpdated = now
-
-	db.data[key] = entry
-	db.txCounter++
-	return nil
-}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -343,6 +344,35 @@ func TestDB_ListOperations(t *testing.T) {
 	})
 }
 
+func TestDB_ListPopFrom(t *testing.T) {
+	dir := t.TempDir()
+	db, err := xedb.New(xedb.WithDataDir(dir), xedb.WithSyncWrite(false))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.List("list").Push("a", "b", "c"))
+
+	val, ok, err := db.List("list").PopFrom(true)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "a", val)
+
+	val, ok, err = db.List("list").PopFrom(false)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "c", val)
+
+	// A directory in place of the WAL makes the pop fail to log
+	require.NoError(t, db.Save())
+	walFile := filepath.Join(dir, "wal.db")
+	require.NoError(t, os.Remove(walFile))
+	require.NoError(t, os.Mkdir(walFile, 0755))
+	_, ok, err = db.List("list").PopFrom(true)
+	assert.Error(t, err)
+	assert.False(t, ok)
+	require.NoError(t, os.Remove(walFile))
+}
+
 func TestDB_HashOperations(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -573,7 +603,8 @@ func TestDB_Iterator(t *testing.T) {
 }
 
 func TestDB_TransactionVersioning(t *testing.T) {
-	db, cleanup := setupTestDB(t)
+	// Read-only transactions find older values in the version history
+	db, cleanup := setupPubSubDB(t, xedb.WithHistory(true))
 	defer cleanup()
 
 	t.Run("Version Increments", func(t *testing.T) {
@@ -0,0 +1,394 @@
+package xedb
+
+import "math/rand"
+
+const (
+	// skiplistMaxLevel bounds the node height, enough for 2^64 elements with p = 1/4
+	skiplistMaxLevel = 32
+	// skiplistP is the probability of a node being promoted to the next level
+	skiplistP = 0.25
+)
+
+// skiplistLevel is a forward link of a node. span counts the nodes it skips,
+// which lets rank lookups run in O(log n).
+type skiplistLevel struct {
+	forward *skiplistNode
+	span    int
+}
+
+// skiplistNode holds a single sorted set member
+type skiplistNode struct {
+	member   string
+	score    float64
+	backward *skiplistNode
+	level    []skiplistLevel
+}
+
+// skiplist keeps members ordered by score, breaking ties by member like Redis
+type skiplist struct {
+	header *skiplistNode
+	tail   *skiplistNode
+	length int
+	level  int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: &skiplistNode{level: make([]skiplistLevel, skiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+// less reports whether node n sorts before (score, member)
+func (n *skiplistNode) less(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// after reports whether node n sorts after (score, member)
+func (n *skiplistNode) after(score float64, member string) bool {
+	return n.score > score || (n.score == score && n.member > member)
+}
+
+// randomLevel returns a level between 1 and skiplistMaxLevel with a powerlaw distribution
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// insert adds a member that must not already be in the list
+func (sl *skiplist) insert(score float64, member string) {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			update[i] = sl.header
+			update[i].level[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = &skiplistNode{member: member, score: score, level: make([]skiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+}
+
+// delete removes the node matching score and member, reporting whether it was found
+func (sl *skiplist) delete(score float64, member string) bool {
+	var update [skiplistMaxLevel]*skiplistNode
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < sl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+	return true
+}
+
+// rank returns the 0-based position of the member, or -1 if it is not in the list
+func (sl *skiplist) rank(score float64, member string) int {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for next := x.level[i].forward; next != nil && !next.after(score, member); next = x.level[i].forward {
+			rank += x.level[i].span
+			x = next
+		}
+		if x != sl.header && x.score == score && x.member == member {
+			return rank - 1
+		}
+	}
+	return -1
+}
+
+// byRank returns the node at the 0-based position, or nil if out of range
+func (sl *skiplist) byRank(rank int) *skiplistNode {
+	if rank < 0 || rank >= sl.length {
+		return nil
+	}
+
+	traversed := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank+1 {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// seek returns the first node not sorting before (score, member), or nil if there is none
+func (sl *skiplist) seek(score float64, member string) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+// countBelow returns the number of nodes with a score below bound,
+// or at most bound if inclusive is set
+func (sl *skiplist) countBelow(bound float64, inclusive bool) int {
+	count := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for next := x.level[i].forward; next != nil && (next.score < bound || inclusive && next.score == bound); next = x.level[i].forward {
+			count += x.level[i].span
+			x = next
+		}
+	}
+	return count
+}
+
+// sortedSet is the in-memory representation of a sorted set. The dict maps
+// members to scores for O(1) lookups while the skiplist keeps them ordered.
+type sortedSet struct {
+	dict map[string]float64
+	sl   *skiplist
+	// size is the estimated memory footprint, kept up to date by add and remove
+	size int64
+}
+
+// newSortedSet builds a sorted set from members. Later duplicates override earlier scores.
+func newSortedSet(members []ZSetMember) *sortedSet {
+	zs := &sortedSet{dict: make(map[string]float64, len(members)), sl: newSkiplist()}
+	for _, m := range members {
+		zs.add(m.Member, m.Score)
+	}
+	return zs
+}
+
+// zsetMemberSize is the estimated memory used by a member
+func zsetMemberSize(member string) int64 {
+	return int64(len(member)) + 24
+}
+
+// add sets the score of member and reports whether it was newly added
+func (zs *sortedSet) add(member string, score float64) bool {
+	if current, ok := zs.dict[member]; ok {
+		if current != score {
+			zs.sl.delete(current, member)
+			zs.sl.insert(score, member)
+			zs.dict[member] = score
+		}
+		return false
+	}
+	zs.sl.insert(score, member)
+	zs.dict[member] = score
+	zs.size += zsetMemberSize(member)
+	return true
+}
+
+// remove deletes member and reports whether it existed
+func (zs *sortedSet) remove(member string) bool {
+	score, ok := zs.dict[member]
+	if !ok {
+		return false
+	}
+	zs.sl.delete(score, member)
+	delete(zs.dict, member)
+	zs.size -= zsetMemberSize(member)
+	return true
+}
+
+// len returns the number of members
+func (zs *sortedSet) len() int {
+	return len(zs.dict)
+}
+
+// score returns the score of member
+func (zs *sortedSet) score(member string) (float64, bool) {
+	score, ok := zs.dict[member]
+	return score, ok
+}
+
+// rank returns the 0-based ascending rank of member
+func (zs *sortedSet) rank(member string) (int, bool) {
+	score, ok := zs.dict[member]
+	if !ok {
+		return 0, false
+	}
+	return zs.sl.rank(score, member), true
+}
+
+// rangeByRank returns the members with ranks in [lo, hi), walking backwards from the
+// highest score if reverse is set
+func (zs *sortedSet) rangeByRank(lo, hi int, reverse bool) []ZSetMember {
+	if lo >= hi {
+		return nil
+	}
+
+	result := make([]ZSetMember, 0, hi-lo)
+	if reverse {
+		for x := zs.sl.byRank(zs.sl.length - 1 - lo); x != nil && len(result) < hi-lo; x = x.backward {
+			result = append(result, ZSetMember{Member: x.member, Score: x.score})
+		}
+		return result
+	}
+	for x := zs.sl.byRank(lo); x != nil && len(result) < hi-lo; x = x.level[0].forward {
+		result = append(result, ZSetMember{Member: x.member, Score: x.score})
+	}
+	return result
+}
+
+// rangeByScore returns members with min <= score <= max in ascending order
+func (zs *sortedSet) rangeByScore(min, max float64) []ZSetMember {
+	lo := zs.sl.countBelow(min, false)
+	hi := zs.sl.countBelow(max, true)
+	return zs.rangeByRank(lo, hi, false)
+}
+
+// count returns the number of members with min <= score <= max
+func (zs *sortedSet) count(min, max float64) int {
+	if n := zs.sl.countBelow(max, true) - zs.sl.countBelow(min, false); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// members returns all members in ascending order
+func (zs *sortedSet) members() []ZSetMember {
+	return zs.rangeByRank(0, zs.sl.length, false)
+}
+
+// clone returns an independent copy of the sorted set
+func (zs *sortedSet) clone() *sortedSet {
+	return newSortedSet(zs.members())
+}
+
+// storedValue converts a sorted set given as []ZSetMember, as found in the data
+// file, the WAL and transactions, to its in-memory representation
+func storedValue(typ DataType, value interface{}) interface{} {
+	if members, ok := value.([]ZSetMember); ok && typ == ZSet {
+		return newSortedSet(members)
+	}
+	return value
+}
+
+// exportValue converts an in-memory value to the form that is persisted and
+// handed out to callers
+func exportValue(value interface{}) interface{} {
+	if zs, ok := value.(*sortedSet); ok {
+		return zs.members()
+	}
+	return value
+}
+
+// exportEntry returns a copy of entry that holds no in-memory representations
+func exportEntry(entry Entry) Entry {
+	entry.Value = exportValue(entry.Value)
+	return entry
+}
+
+// zsetForWrite returns the sorted set held by entry, ready to be modified in place.
+// With KeepHistory set the set is cloned so the version history keeps the old members.
+func (db *DB) zsetForWrite(entry Entry, exists bool) *sortedSet {
+	if !exists {
+		return newSortedSet(nil)
+	}
+	zs := entry.Value.(*sortedSet)
+	if db.options.KeepHistory {
+		return zs.clone()
+	}
+	return zs
+}
+
+// Logged sorted set changes, so a write logs the members it touched rather
+// than the whole set. ZADD sets the score of the field member and ZREM removes
+// the members in the value. Both keep the deadline in the command.
+const (
+	opZAdd = "ZADD"
+	opZRem = "ZREM"
+)
+
+// logZAdd logs member being stored with score in the sorted set at key.
+// Caller must hold db.mutex for writing.
+func (db *DB) logZAdd(key, member string, score float64) error {
+	return db.logChange(Command{Op: opZAdd, Key: key, Type: ZSet, Field: member, Score: score})
+}
+
+// applyZSetCommand applies a logged ZADD or ZREM. A ZADD to a key of another
+// type replaces it with a new set, as ZSetOp.Add does.
+func (db *DB) applyZSetCommand(cmd Command, txID uint64) error {
+	entry, ok := db.engine.Get(cmd.Key)
+	exists := ok && entry.Type == ZSet
+	if !exists && cmd.Op == opZRem {
+		return nil
+	}
+
+	zset := db.zsetForWrite(entry, exists)
+	if cmd.Op == opZAdd {
+		zset.add(cmd.Field, cmd.Score)
+	} else {
+		members, _ := cmd.Value.([]string)
+		for _, member := range members {
+			zset.remove(member)
+		}
+	}
+
+	return db.putChanged(cmd, zset, txID)
+}
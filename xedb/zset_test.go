@@ -0,0 +1,272 @@
+package xedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sliceZSet is the previous sorted set representation, kept as a reference
+// implementation and a benchmark baseline
+type sliceZSet []ZSetMember
+
+func (z *sliceZSet) add(member string, score float64) {
+	found := false
+	for i, m := range *z {
+		if m.Member == member {
+			(*z)[i].Score = score
+			found = true
+			break
+		}
+	}
+	if !found {
+		*z = append(*z, ZSetMember{Member: member, Score: score})
+	}
+	sort.Slice(*z, func(i, j int) bool {
+		if (*z)[i].Score != (*z)[j].Score {
+			return (*z)[i].Score < (*z)[j].Score
+		}
+		return (*z)[i].Member < (*z)[j].Member
+	})
+}
+
+func (z *sliceZSet) remove(member string) {
+	for i, m := range *z {
+		if m.Member == member {
+			*z = append((*z)[:i], (*z)[i+1:]...)
+			return
+		}
+	}
+}
+
+func (z sliceZSet) rank(member string) (int, bool) {
+	for i, m := range z {
+		if m.Member == member {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (z sliceZSet) rangeByScore(min, max float64) []ZSetMember {
+	lo := sort.Search(len(z), func(i int) bool { return z[i].Score >= min })
+	hi := sort.Search(len(z), func(i int) bool { return z[i].Score > max })
+	if lo >= hi {
+		return nil
+	}
+	return append([]ZSetMember(nil), z[lo:hi]...)
+}
+
+func TestSortedSet(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	zs := newSortedSet(nil)
+	var ref sliceZSet
+
+	for i := 0; i < 5000; i++ {
+		member := fmt.Sprintf("m%d", rng.Intn(500))
+		switch rng.Intn(4) {
+		case 0:
+			zs.remove(member)
+			ref.remove(member)
+		default:
+			score := float64(rng.Intn(100))
+			zs.add(member, score)
+			ref.add(member, score)
+		}
+
+		if i%250 == 0 {
+			require.Equal(t, []ZSetMember(ref), append([]ZSetMember(nil), zs.members()...))
+		}
+	}
+
+	require.Equal(t, len(ref), zs.len())
+	require.Equal(t, valueSize([]ZSetMember(ref)), valueSize(zs))
+	for i, m := range ref {
+		rank, ok := zs.rank(m.Member)
+		require.True(t, ok)
+		require.Equal(t, i, rank)
+
+		score, ok := zs.score(m.Member)
+		require.True(t, ok)
+		require.Equal(t, m.Score, score)
+	}
+	_, ok := zs.rank("missing")
+	assert.False(t, ok)
+
+	for min := -10.0; min <= 110; min += 7 {
+		max := min + 13
+		expected := ref.rangeByScore(min, max)
+		assert.Equal(t, expected, zs.rangeByScore(min, max))
+		assert.Equal(t, len(expected), zs.count(min, max))
+	}
+	assert.Equal(t, 0, zs.count(50, 10))
+
+	reversed := zs.rangeByRank(0, zs.len(), true)
+	require.Len(t, reversed, len(ref))
+	for i, m := range reversed {
+		assert.Equal(t, ref[len(ref)-1-i], m)
+	}
+
+	clone := zs.clone()
+	clone.add("extra", -1)
+	assert.Equal(t, len(ref), zs.len())
+	assert.Equal(t, len(ref)+1, clone.len())
+}
+
+func TestSortedSet_Persistence(t *testing.T) {
+	dir, err := os.MkdirTemp("", "xedb-zset-test-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := New(WithDataDir(dir), WithSyncWrite(false))
+	require.NoError(t, err)
+
+	require.NoError(t, db.ZSet("board").Add(2, "b"))
+	require.NoError(t, db.ZSet("board").Add(1, "a"))
+	_, err = db.ZSet("board").ZIncrBy(5, "c")
+	require.NoError(t, err)
+	require.NoError(t, db.ZSet("ttl").SetWithTTL([]ZSetMember{{Member: "x", Score: 1}}, time.Hour))
+	assert.ErrorIs(t, db.ZSet("board").Add(math.NaN(), "d"), ErrInvalidValue)
+
+	// Entries handed out to callers keep the []ZSetMember representation
+	txn := db.NewTransaction(false)
+	entry, err := txn.Get("board")
+	require.NoError(t, err)
+	expected := []ZSetMember{{Member: "a", Score: 1}, {Member: "b", Score: 2}, {Member: "c", Score: 5}}
+	assert.Equal(t, expected, entry.Value)
+
+	exported, err := db.ExportToJSON()
+	require.NoError(t, err)
+	var doc map[string]struct {
+		Value []ZSetMember `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(exported), &doc))
+	assert.Equal(t, expected, doc["board"].Value)
+	require.NoError(t, db.Close())
+
+	db, err = New(WithDataDir(dir), WithSyncWrite(false))
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Equal(t, expected, db.ZSet("board").Range(0, -1))
+	rank, ok := db.ZSet("board").ZRank("c")
+	assert.True(t, ok)
+	assert.Equal(t, 2, rank)
+	assert.Equal(t, []ZSetMember{{Member: "x", Score: 1}}, db.ZSet("ttl").Range(0, -1))
+}
+
+const benchZSetSize = 100000
+
+func benchMembers() []ZSetMember {
+	rng := rand.New(rand.NewSource(1))
+	members := make([]ZSetMember, benchZSetSize)
+	for i := range members {
+		members[i] = ZSetMember{Member: fmt.Sprintf("member:%d", i), Score: float64(rng.Intn(benchZSetSize))}
+	}
+	return members
+}
+
+func BenchmarkSortedSet_Add(b *testing.B) {
+	members := benchMembers()
+	b.Run("Skiplist", func(b *testing.B) {
+		zs := newSortedSet(members)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			m := members[i%len(members)]
+			zs.add(m.Member, m.Score+float64(i))
+		}
+	})
+	b.Run("Slice", func(b *testing.B) {
+		ref := sliceZSet(append([]ZSetMember(nil), members...))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			m := members[i%len(members)]
+			ref.add(m.Member, m.Score+float64(i))
+		}
+	})
+}
+
+func BenchmarkSortedSet_Rank(b *testing.B) {
+	members := benchMembers()
+	b.Run("Skiplist", func(b *testing.B) {
+		zs := newSortedSet(members)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			zs.rank(members[i%len(members)].Member)
+		}
+	})
+	b.Run("Slice", func(b *testing.B) {
+		ref := sliceZSet(append([]ZSetMember(nil), members...))
+		sort.Slice(ref, func(i, j int) bool { return ref[i].Score < ref[j].Score })
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			ref.rank(members[i%len(members)].Member)
+		}
+	})
+}
+
+func BenchmarkSortedSet_RangeByScore(b *testing.B) {
+	members := benchMembers()
+	b.Run("Skiplist", func(b *testing.B) {
+		zs := newSortedSet(members)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			min := float64(i % benchZSetSize)
+			zs.rangeByScore(min, min+10)
+		}
+	})
+	b.Run("Slice", func(b *testing.B) {
+		ref := sliceZSet(append([]ZSetMember(nil), members...))
+		sort.Slice(ref, func(i, j int) bool { return ref[i].Score < ref[j].Score })
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			min := float64(i % benchZSetSize)
+			ref.rangeByScore(min, min+10)
+		}
+	})
+}
+
+func BenchmarkZSetOp_ZRank(b *testing.B) {
+	dir, err := os.MkdirTemp("", "xedb-zset-bench-*")
+	require.NoError(b, err)
+	defer os.RemoveAll(dir)
+
+	db, err := New(WithDataDir(dir), WithSyncWrite(false))
+	require.NoError(b, err)
+	defer db.Close()
+
+	members := benchMembers()
+	require.NoError(b, db.ZSet("board").SetWithTTL(members, time.Hour))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.ZSet("board").ZRank(members[i%len(members)].Member)
+	}
+}
+
+func BenchmarkZSetOp_Add(b *testing.B) {
+	dir, err := os.MkdirTemp("", "xedb-zset-bench-*")
+	require.NoError(b, err)
+	defer os.RemoveAll(dir)
+
+	db, err := New(WithDataDir(dir), WithSyncWrite(false))
+	require.NoError(b, err)
+	defer db.Close()
+
+	members := benchMembers()
+	require.NoError(b, db.ZSet("board").SetWithTTL(members, time.Hour))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := members[i%len(members)]
+		require.NoError(b, db.ZSet("board").Add(m.Score+float64(i), m.Member))
+	}
+}
@@ -414,6 +414,106 @@ func getPath(data interface{}, path string) (interface{}, error) {
 	return current, nil
 }
 
+// Lookup retrieves a value from any JSON value using a JSON path. Unlike Get
+// the root may be an array or a scalar, and numbers are returned as decoded.
+func Lookup(data interface{}, path JSONPath) (interface{}, error) {
+	return getPath(data, string(path))
+}
+
+// Set sets the value at a JSON path and returns the updated data. Objects and
+// arrays along the path are modified in place. The last key of a path is
+// created if it is missing, while array indexes must exist. An empty path
+// replaces data with value.
+func Set(data interface{}, path JSONPath, value interface{}) (interface{}, error) {
+	if path == "" {
+		return value, nil
+	}
+	if data == nil {
+		return nil, fmt.Errorf("nil data input")
+	}
+
+	parts := strings.Split(string(path), ".")
+	current := data
+	for i, part := range parts {
+		key, index, hasIndex, err := parseSegment(part)
+		if err != nil {
+			return nil, err
+		}
+		last := i == len(parts)-1
+
+		if key != "" {
+			obj, ok := asObject(current)
+			if !ok {
+				return nil, fmt.Errorf("cannot access property on non-object type at: %s", key)
+			}
+			if last && !hasIndex {
+				obj[key] = value
+				return data, nil
+			}
+			if current, ok = obj[key]; !ok {
+				return nil, fmt.Errorf("key not found: %s", key)
+			}
+		}
+
+		if hasIndex {
+			arr, ok := asArray(current)
+			if !ok {
+				return nil, fmt.Errorf("cannot access index on non-array type at: %s", part)
+			}
+			if index < 0 || index >= len(arr) {
+				return nil, fmt.Errorf("array index out of bounds: %d, length: %d", index, len(arr))
+			}
+			if last {
+				arr[index] = value
+				return data, nil
+			}
+			current = arr[index]
+		}
+	}
+	return data, nil
+}
+
+// parseSegment splits a path segment such as "key" or "key[1]" into its key
+// and optional array index
+func parseSegment(part string) (string, int, bool, error) {
+	if !strings.HasSuffix(part, "]") {
+		if part == "" {
+			return "", 0, false, fmt.Errorf("empty path segment")
+		}
+		return part, 0, false, nil
+	}
+
+	arrayParts := strings.Split(strings.TrimSpace(part[:len(part)-1]), "[")
+	if len(arrayParts) != 2 {
+		return "", 0, false, fmt.Errorf("invalid array access syntax: %s", part)
+	}
+	index, err := strconv.Atoi(strings.TrimSpace(arrayParts[1]))
+	if err != nil {
+		return "", 0, false, fmt.Errorf("invalid array index: %s", arrayParts[1])
+	}
+	return strings.TrimSpace(arrayParts[0]), index, true, nil
+}
+
+func asObject(v interface{}) (map[string]interface{}, bool) {
+	switch v := v.(type) {
+	case JSONObject:
+		return v, true
+	case map[string]interface{}:
+		return v, true
+	}
+	return nil, false
+}
+
+func asArray(v interface{}) ([]interface{}, bool) {
+	switch v := v.(type) {
+	case JSONArray:
+		return v, true
+	case []interface{}:
+		return v, true
+	}
+	return nil, false
+}
+
 // ParseJSON parses a JSON string into a JSONObject
 func ParseJSON(jsonStr string) (JSONObject, error) {
 	if jsonStr == "" {
@@ -115,6 +115,30 @@ func TestXJSON(t *testing.T) {
 		xlog.Info("Retrieved array value", "key", "grades", "value", value)
 	})
 
+	t.Run("Set", func(t *testing.T) {
+		data, _ := xjson.ParseJSON(jsonStr)
+		updated, err := xjson.Set(data, "address.zip", "12345")
+		assert.NoError(t, err)
+		value, err := xjson.Lookup(updated, "address.zip")
+		assert.NoError(t, err)
+		assert.Equal(t, "12345", value)
+
+		_, err = xjson.Set(data, "contacts[1].value", "555-0000")
+		assert.NoError(t, err)
+		value, err = xjson.Get(data, "contacts[1].value")
+		assert.NoError(t, err)
+		assert.Equal(t, "555-0000", value)
+
+		_, err = xjson.Set(data, "grades[3]", 100)
+		assert.Error(t, err)
+		_, err = xjson.Set(data, "missing.key", 1)
+		assert.Error(t, err)
+
+		root, err := xjson.Set(data, "", []interface{}{1})
+		assert.NoError(t, err)
+		assert.Equal(t, []interface{}{1}, root)
+	})
+
 	t.Run("GetArrayFromString", func(t *testing.T) {
 		value, err := xjson.GetArrayFromString(jsonStr, "grades")
 		assert.NoError(t, err)
@@ -0,0 +1,251 @@
+package xsb
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// maxParams is the most placeholders a single statement may have in each dialect
+var maxParams = map[Dialect]int{
+	PostgreSQL: 65535,
+	MySQL:      65535,
+	SQLite:     999,
+	MSSQL:      2100,
+}
+
+// maxRowsMSSQL is the most rows a VALUES list may have in MSSQL
+const maxRowsMSSQL = 1000
+
+// Statement is a query and its arguments
+type Statement struct {
+	Query string
+	Args  []interface{}
+}
+
+// InsertMany sets the rows of a multi-row INSERT. rows is a slice of structs,
+// of pointers to structs or of map[string]interface{}. Struct rows insert the
+// columns of their db tags that are set in at least one row; map rows must
+// all have the same keys.
+func (b *Builder) InsertMany(rows interface{}) *Builder {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Slice {
+		b.err = fmt.Errorf("InsertMany: expected a slice, got %T", rows)
+		return b
+	}
+
+	b.rows = make([][]interface{}, 0, v.Len())
+	if v.Len() == 0 {
+		return b
+	}
+
+	elemType := v.Type().Elem()
+	if elemType.Kind() == reflect.Map && elemType.Key().Kind() == reflect.String {
+		return b.insertMaps(v)
+	}
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		b.err = fmt.Errorf("InsertMany: unsupported row type %s", v.Type().Elem())
+		return b
+	}
+
+	fields := structFields(elemType)
+	used := make([]bool, len(fields))
+	values := make([][]interface{}, v.Len())
+	for i := range values {
+		row := v.Index(i)
+		if row.Kind() == reflect.Ptr {
+			if row.IsNil() {
+				b.err = fmt.Errorf("InsertMany: row %d is nil", i)
+				return b
+			}
+			row = row.Elem()
+		}
+		values[i] = make([]interface{}, len(fields))
+		for j, f := range fields {
+			field, ok := fieldByIndex(row, f.index, false)
+			if !ok {
+				continue
+			}
+			values[i][j] = field.Interface()
+			used[j] = used[j] || !field.IsZero()
+		}
+	}
+
+	// Keep only the columns set in some row
+	b.columns = nil
+	for j, f := range fields {
+		if used[j] {
+			b.columns = append(b.columns, f.column)
+		}
+	}
+	if len(b.columns) == 0 {
+		b.err = fmt.Errorf("InsertMany: no values to insert")
+		return b
+	}
+	for _, row := range values {
+		kept := make([]interface{}, 0, len(b.columns))
+		for j, value := range row {
+			if used[j] {
+				kept = append(kept, value)
+			}
+		}
+		b.rows = append(b.rows, kept)
+	}
+	return b
+}
+
+// insertMaps sets the rows of a multi-row INSERT from a slice of maps, using
+// the sorted keys of the first map as columns
+func (b *Builder) insertMaps(v reflect.Value) *Builder {
+	first := v.Index(0)
+	b.columns = make([]string, 0, first.Len())
+	for _, key := range first.MapKeys() {
+		b.columns = append(b.columns, key.String())
+	}
+	sort.Strings(b.columns)
+
+	for i := 0; i < v.Len(); i++ {
+		m := v.Index(i)
+		if m.Len() != len(b.columns) {
+			b.err = fmt.Errorf("InsertMany: row %d has %d columns, expected %d", i, m.Len(), len(b.columns))
+			return b
+		}
+		row := make([]interface{}, len(b.columns))
+		for j, column := range b.columns {
+			value := m.MapIndex(reflect.ValueOf(column).Convert(m.Type().Key()))
+			if !value.IsValid() {
+				b.err = fmt.Errorf("InsertMany: row %d has no column %s", i, column)
+				return b
+			}
+			row[j] = value.Interface()
+		}
+		b.rows = append(b.rows, row)
+	}
+	return b
+}
+
+// BatchSize limits the number of rows in each statement built by InsertMany
+func (b *Builder) BatchSize(n int) *Builder {
+	b.batchSize = n
+	return b
+}
+
+// returnColumn returns the column whose values a multi-row INSERT returns:
+// the first Returning column, or id for PostgreSQL. MySQL returns nothing.
+func (b *Builder) returnColumn() string {
+	if b.dialect == MySQL {
+		return ""
+	}
+	if len(b.returning) > 0 {
+		return b.returning[0]
+	}
+	if b.dialect == PostgreSQL {
+		return "id"
+	}
+	return ""
+}
+
+// BuildInsertMany builds the INSERT statements for the rows set by InsertMany.
+// Rows are split into as few statements as the placeholder limit of the
+// dialect allows, or BatchSize rows each if that is smaller. An Upsert or
+// OnDuplicateKeyUpdate clause is added to every statement.
+func (b *Builder) BuildInsertMany() ([]Statement, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.table == "" {
+		return nil, fmt.Errorf("InsertMany: no table set")
+	}
+	if len(b.rows) == 0 {
+		return nil, nil
+	}
+
+	_, conflictArgs := b.conflictClause()
+	perStatement := (maxParams[b.dialect] - len(conflictArgs)) / len(b.columns)
+	if b.dialect == MSSQL {
+		perStatement = min(perStatement, maxRowsMSSQL)
+	}
+	if b.batchSize > 0 {
+		perStatement = min(perStatement, b.batchSize)
+	}
+	if perStatement < 1 {
+		return nil, fmt.Errorf("InsertMany: %d columns exceed the placeholder limit", len(b.columns))
+	}
+
+	returnColumn := b.returnColumn()
+	var statements []Statement
+	for start := 0; start < len(b.rows); start += perStatement {
+		chunk := b.rows[start:min(start+perStatement, len(b.rows))]
+		var query strings.Builder
+		args := make([]interface{}, 0, len(chunk)*len(b.columns)+len(conflictArgs))
+		fmt.Fprintf(&query, "INSERT INTO %s (%s)", b.table, strings.Join(b.columns, ", "))
+		if returnColumn != "" && b.dialect == MSSQL {
+			query.WriteString(" OUTPUT INSERTED." + returnColumn)
+		}
+		query.WriteString(" VALUES ")
+		for i, row := range chunk {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			placeholders := make([]string, len(row))
+			for j := range row {
+				placeholders[j] = b.placeholder()
+			}
+			query.WriteString("(" + strings.Join(placeholders, ", ") + ")")
+			args = append(args, row...)
+		}
+
+		conflict, conflictArgs := b.conflictClause()
+		query.WriteString(conflict)
+		args = append(args, conflictArgs...)
+		if returnColumn != "" && b.dialect != MSSQL {
+			query.WriteString(" RETURNING " + returnColumn)
+		}
+		statements = append(statements, Statement{Query: b.render(query.String()), Args: args})
+	}
+	return statements, nil
+}
+
+// ExecInsertMany runs the statements of BuildInsertMany in order and returns
+// the generated ids where the dialect can return them, i.e. the id column (or
+// first Returning column) on PostgreSQL and the Returning column on SQLite
+// and MSSQL. Rows skipped by a conflict return no id. Use WithTransaction to
+// make the statements atomic, and ExecInsertManyInto for keys that are not
+// integers.
+func (b *Builder) ExecInsertMany(db Querier) ([]int64, error) {
+	var ids []int64
+	err := b.ExecInsertManyInto(db, &ids)
+	return ids, err
+}
+
+// ExecInsertManyInto is ExecInsertMany, appending the returned values to the
+// slice dest points to, e.g. a *[]string for UUID keys
+func (b *Builder) ExecInsertManyInto(db Querier, dest interface{}) error {
+	statements, err := b.BuildInsertMany()
+	if err != nil {
+		return err
+	}
+
+	returning := b.returnColumn() != ""
+	for _, stmt := range statements {
+		if !returning {
+			if _, err := b.execContext(db, stmt.Query, stmt.Args); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rows, err := b.queryContext(db, stmt.Query, stmt.Args)
+		if err != nil {
+			return err
+		}
+		if err := ScanAll(rows, dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
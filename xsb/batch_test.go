@@ -0,0 +1,197 @@
+package xsb_test
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/seefs001/xox/xsb"
+	"github.com/seefs001/xox/xsb/internal/fakedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type product struct {
+	ID    int64  `db:"id"`
+	Name  string `db:"name"`
+	Price int    `db:"price"`
+	Note  *string
+}
+
+func TestInsertMany(t *testing.T) {
+	products := []product{{Name: "a", Price: 1}, {Name: "b"}, {Name: "c", Price: 3}}
+
+	stmts, err := xsb.New().Table("products").InsertMany(products).BuildInsertMany()
+	require.NoError(t, err)
+	require.Len(t, stmts, 1)
+	assert.Equal(t, "INSERT INTO products (name, price) VALUES ($1, $2), ($3, $4), ($5, $6) RETURNING id", stmts[0].Query)
+	assert.Equal(t, []interface{}{"a", 1, "b", 0, "c", 3}, stmts[0].Args)
+
+	// Maps use sorted keys; BatchSize splits the rows
+	rows := []map[string]interface{}{{"name": "a", "price": 1}, {"price": 2, "name": "b"}, {"name": "c", "price": 3}}
+	stmts, err = xsb.New().WithDialect(xsb.MySQL).Table("products").InsertMany(rows).BatchSize(2).BuildInsertMany()
+	require.NoError(t, err)
+	require.Len(t, stmts, 2)
+	assert.Equal(t, "INSERT INTO products (name, price) VALUES (?, ?), (?, ?)", stmts[0].Query)
+	assert.Equal(t, []interface{}{"a", 1, "b", 2}, stmts[0].Args)
+	assert.Equal(t, "INSERT INTO products (name, price) VALUES (?, ?)", stmts[1].Query)
+	assert.Equal(t, []interface{}{"c", 3}, stmts[1].Args)
+
+	// Returning is opt-in outside PostgreSQL
+	stmts, err = xsb.New().WithDialect(xsb.SQLite).Table("products").InsertMany(rows[:1]).Returning("id").BuildInsertMany()
+	require.NoError(t, err)
+	assert.Equal(t, "INSERT INTO products (name, price) VALUES (?, ?) RETURNING id", stmts[0].Query)
+	stmts, err = xsb.New().WithDialect(xsb.MSSQL).Table("products").InsertMany(rows[:1]).Returning("id").BuildInsertMany()
+	require.NoError(t, err)
+	assert.Equal(t, "INSERT INTO products (name, price) OUTPUT INSERTED.id VALUES (?, ?)", stmts[0].Query)
+
+	stmts, err = xsb.New().Table("products").InsertMany([]product{}).BuildInsertMany()
+	assert.NoError(t, err)
+	assert.Empty(t, stmts)
+
+	for _, bad := range []interface{}{product{}, []int{1}, []product{{}}, []*product{nil},
+		[]map[string]interface{}{{"a": 1}, {"b": 1}}, []map[string]interface{}{{"a": 1}, {"a": 1, "b": 2}}} {
+		_, err := xsb.New().Table("products").InsertMany(bad).BuildInsertMany()
+		assert.Error(t, err, "%#v", bad)
+	}
+	_, err = xsb.New().InsertMany(products).BuildInsertMany()
+	assert.Error(t, err)
+}
+
+func TestInsertMany_Chunking(t *testing.T) {
+	rows := make([]map[string]interface{}, 1000)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"a": i, "b": i, "c": i}
+	}
+
+	tests := []struct {
+		dialect xsb.Dialect
+		sizes   []int
+	}{
+		{xsb.SQLite, []int{333, 333, 333, 1}},
+		{xsb.MSSQL, []int{700, 300}},
+		{xsb.PostgreSQL, []int{1000}},
+	}
+	for _, tt := range tests {
+		stmts, err := xsb.New().WithDialect(tt.dialect).Table("t").InsertMany(rows).BuildInsertMany()
+		require.NoError(t, err)
+		var sizes []int
+		for _, stmt := range stmts {
+			sizes = append(sizes, len(stmt.Args)/3)
+			assert.LessOrEqual(t, len(stmt.Args), map[xsb.Dialect]int{xsb.SQLite: 999, xsb.MSSQL: 2100, xsb.PostgreSQL: 65535}[tt.dialect])
+		}
+		assert.Equal(t, tt.sizes, sizes, tt.dialect)
+	}
+
+	// Placeholders restart in every statement
+	stmts, err := xsb.New().Table("t").InsertMany(rows[:3]).BatchSize(2).BuildInsertMany()
+	require.NoError(t, err)
+	assert.Equal(t, "INSERT INTO t (a, b, c) VALUES ($1, $2, $3) RETURNING id", stmts[1].Query)
+}
+
+func TestInsertMany_Upsert(t *testing.T) {
+	rows := []map[string]interface{}{{"email": "a@x", "name": "A"}, {"email": "b@x", "name": "B"}}
+
+	stmts, err := xsb.New().
+		Table("users").
+		InsertMany(rows).
+		Upsert([]string{"email"}, []xsb.UpdateClause{
+			{Column: "name", Value: xsb.RawExpr{Expr: "excluded.name"}},
+			{Column: "updated", Value: true},
+		}).
+		BatchSize(1).
+		BuildInsertMany()
+	require.NoError(t, err)
+	require.Len(t, stmts, 2)
+	assert.Equal(t, "INSERT INTO users (email, name) VALUES ($1, $2) ON CONFLICT (email) DO UPDATE SET name = excluded.name, updated = $3 RETURNING id", stmts[1].Query)
+	assert.Equal(t, []interface{}{"b@x", "B", true}, stmts[1].Args)
+
+	stmts, err = xsb.New().
+		WithDialect(xsb.MySQL).
+		Table("users").
+		InsertMany(rows).
+		OnDuplicateKeyUpdate([]xsb.UpdateClause{{Column: "name", Value: xsb.Raw("VALUES(name)")}}).
+		BuildInsertMany()
+	require.NoError(t, err)
+	assert.Equal(t, "INSERT INTO users (email, name) VALUES (?, ?), (?, ?) ON DUPLICATE KEY UPDATE name = VALUES(name)", stmts[0].Query)
+
+	// Single row inserts render raw update values the same way
+	query, args := xsb.New().
+		Table("users").
+		Columns("email").
+		Values("a@x").
+		Upsert([]string{"email"}, []xsb.UpdateClause{{Column: "hits", Value: xsb.Raw("users.hits + ?", 1)}}).
+		BuildInsert()
+	assert.Equal(t, "INSERT INTO users (email) VALUES ($1) ON CONFLICT (email) DO UPDATE SET hits = users.hits + $2 RETURNING id", query)
+	assert.Equal(t, []interface{}{"a@x", 1}, args)
+}
+
+func TestExecInsertMany(t *testing.T) {
+	db, fake := fakedb.Open(t)
+	next := int64(0)
+	fake.Handle(func(query string, args []driver.Value) fakedb.Result {
+		if !strings.Contains(query, "RETURNING") {
+			return fakedb.Result{}
+		}
+		var rows [][]driver.Value
+		for range strings.Count(query, "(") - 1 {
+			next++
+			rows = append(rows, []driver.Value{next})
+		}
+		return fakedb.Result{Columns: []string{"id"}, Rows: rows}
+	})
+
+	products := []product{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	ids, err := xsb.New().Table("products").InsertMany(products).BatchSize(2).ExecInsertMany(db)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 2, 3}, ids)
+	assert.Len(t, fake.Statements(), 2)
+
+	ids, err = xsb.New().WithDialect(xsb.MySQL).Table("products").InsertMany(products).ExecInsertMany(db)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+	stmts := fake.Statements()
+	require.Len(t, stmts, 1)
+	assert.Equal(t, []driver.Value{"a", "b", "c"}, stmts[0].Args)
+
+	// The repository sets the generated keys
+	next = 10
+	repo := xsb.NewRepository[product](db, "products")
+	require.NoError(t, repo.InsertMany(products))
+	assert.Equal(t, []int64{11, 12, 13}, []int64{products[0].ID, products[1].ID, products[2].ID})
+	assert.Equal(t, "INSERT INTO products (name) VALUES ($1), ($2), ($3) RETURNING id", fake.Statements()[0].Query)
+	assert.NoError(t, repo.InsertMany(nil))
+
+	_, err = xsb.New().Table("products").InsertMany(42).ExecInsertMany(db)
+	assert.Error(t, err)
+}
+
+type document struct {
+	ID    string `db:"id"`
+	Title string `db:"title"`
+}
+
+func TestExecInsertMany_Keys(t *testing.T) {
+	db, fake := fakedb.Open(t)
+	returned := 2
+	fake.Handle(func(query string, args []driver.Value) fakedb.Result {
+		rows := [][]driver.Value{{"6f1c"}, {"9a2e"}}
+		return fakedb.Result{Columns: []string{"id"}, Rows: rows[:returned]}
+	})
+
+	// Keys are scanned in the type of the key field
+	var keys []string
+	docs := []document{{Title: "a"}, {ID: "fixed", Title: "b"}}
+	require.NoError(t, xsb.New().Table("docs").InsertMany(docs).ExecInsertManyInto(db, &keys))
+	assert.Equal(t, []string{"6f1c", "9a2e"}, keys)
+
+	repo := xsb.NewRepository[document](db, "docs")
+	require.NoError(t, repo.InsertMany(docs))
+	assert.Equal(t, "6f1c", docs[0].ID)
+	assert.Equal(t, "fixed", docs[1].ID)
+
+	// Missing keys are reported rather than leaving entities without one
+	returned = 1
+	err := repo.InsertMany([]document{{Title: "c"}, {Title: "d"}})
+	assert.ErrorContains(t, err, "got 1 keys")
+}
@@ -0,0 +1,958 @@
+package xsb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Execer executes statements; *sql.DB, *sql.Tx and *sql.Conn implement it
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// execAll executes statements in order
+func execAll(ctx context.Context, db Execer, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type typeKind int
+
+const (
+	rawType typeKind = iota
+	integerType
+	bigIntType
+	smallIntType
+	booleanType
+	textType
+	varcharType
+	floatType
+	decimalType
+	timestampType
+	dateType
+	blobType
+	jsonType
+	uuidType
+)
+
+// ColumnType is a portable column type, rendered for each dialect
+type ColumnType struct {
+	kind  typeKind
+	size  int
+	scale int
+	raw   string
+}
+
+// Portable column types
+var (
+	Integer   = ColumnType{kind: integerType}
+	BigInt    = ColumnType{kind: bigIntType}
+	SmallInt  = ColumnType{kind: smallIntType}
+	Boolean   = ColumnType{kind: booleanType}
+	Text      = ColumnType{kind: textType}
+	Float     = ColumnType{kind: floatType}
+	Timestamp = ColumnType{kind: timestampType}
+	Date      = ColumnType{kind: dateType}
+	Blob      = ColumnType{kind: blobType}
+	JSON      = ColumnType{kind: jsonType}
+	UUID      = ColumnType{kind: uuidType}
+)
+
+// Varchar returns a variable-length string type of at most size characters
+func Varchar(size int) ColumnType {
+	return ColumnType{kind: varcharType, size: size}
+}
+
+// Decimal returns an exact numeric type
+func Decimal(precision, scale int) ColumnType {
+	return ColumnType{kind: decimalType, size: precision, scale: scale}
+}
+
+// RawType returns a type rendered as is for every dialect
+func RawType(sql string) ColumnType {
+	return ColumnType{kind: rawType, raw: sql}
+}
+
+// render returns the type in dialect
+func (t ColumnType) render(dialect Dialect) string {
+	switch t.kind {
+	case integerType:
+		if dialect == MySQL || dialect == MSSQL {
+			return "INT"
+		}
+		return "INTEGER"
+	case bigIntType:
+		if dialect == SQLite {
+			return "INTEGER"
+		}
+		return "BIGINT"
+	case smallIntType:
+		if dialect == SQLite {
+			return "INTEGER"
+		}
+		return "SMALLINT"
+	case booleanType:
+		switch dialect {
+		case MySQL:
+			return "TINYINT(1)"
+		case MSSQL:
+			return "BIT"
+		}
+		return "BOOLEAN"
+	case textType:
+		if dialect == MSSQL {
+			return "NVARCHAR(MAX)"
+		}
+		return "TEXT"
+	case varcharType:
+		if dialect == MSSQL {
+			return fmt.Sprintf("NVARCHAR(%d)", t.size)
+		}
+		return fmt.Sprintf("VARCHAR(%d)", t.size)
+	case floatType:
+		switch dialect {
+		case PostgreSQL:
+			return "DOUBLE PRECISION"
+		case MySQL:
+			return "DOUBLE"
+		case SQLite:
+			return "REAL"
+		}
+		return "FLOAT"
+	case decimalType:
+		if dialect == PostgreSQL || dialect == SQLite {
+			return fmt.Sprintf("NUMERIC(%d, %d)", t.size, t.scale)
+		}
+		return fmt.Sprintf("DECIMAL(%d, %d)", t.size, t.scale)
+	case timestampType:
+		switch dialect {
+		case MySQL:
+			return "DATETIME(6)"
+		case MSSQL:
+			return "DATETIME2"
+		}
+		return "TIMESTAMP"
+	case dateType:
+		return "DATE"
+	case blobType:
+		switch dialect {
+		case PostgreSQL:
+			return "BYTEA"
+		case MSSQL:
+			return "VARBINARY(MAX)"
+		}
+		return "BLOB"
+	case jsonType:
+		switch dialect {
+		case PostgreSQL:
+			return "JSONB"
+		case MySQL:
+			return "JSON"
+		case MSSQL:
+			return "NVARCHAR(MAX)"
+		}
+		return "TEXT"
+	case uuidType:
+		switch dialect {
+		case PostgreSQL:
+			return "UUID"
+		case MySQL:
+			return "CHAR(36)"
+		case MSSQL:
+			return "UNIQUEIDENTIFIER"
+		}
+		return "TEXT"
+	}
+	return t.raw
+}
+
+// Reference is a foreign key reference to a column of another table
+type Reference struct {
+	Table    string
+	Column   string
+	OnDelete string
+	OnUpdate string
+}
+
+// render returns the REFERENCES clause
+func (r *Reference) render() string {
+	s := fmt.Sprintf("REFERENCES %s (%s)", r.Table, r.Column)
+	if r.OnDelete != "" {
+		s += " ON DELETE " + r.OnDelete
+	}
+	if r.OnUpdate != "" {
+		s += " ON UPDATE " + r.OnUpdate
+	}
+	return s
+}
+
+// ColumnDef describes a table column
+type ColumnDef struct {
+	Name          string
+	Type          ColumnType
+	NotNull       bool
+	PrimaryKey    bool
+	AutoIncrement bool
+	Unique        bool
+	// Default is rendered as a literal; a RawExpr is rendered as is
+	Default    interface{}
+	Check      string
+	References *Reference
+}
+
+// ColumnOption configures a ColumnDef
+type ColumnOption func(*ColumnDef)
+
+// NotNull forbids NULL values
+func NotNull() ColumnOption {
+	return func(c *ColumnDef) {
+		c.NotNull = true
+	}
+}
+
+// PrimaryKey makes the column the primary key. Marking several columns
+// creates a composite key.
+func PrimaryKey() ColumnOption {
+	return func(c *ColumnDef) {
+		c.PrimaryKey = true
+	}
+}
+
+// AutoIncrement generates values for an integer column. SQLite only supports
+// it on a single-column primary key.
+func AutoIncrement() ColumnOption {
+	return func(c *ColumnDef) {
+		c.AutoIncrement = true
+	}
+}
+
+// Unique forbids duplicate values
+func Unique() ColumnOption {
+	return func(c *ColumnDef) {
+		c.Unique = true
+	}
+}
+
+// Default sets the default value
+func Default(value interface{}) ColumnOption {
+	return func(c *ColumnDef) {
+		c.Default = value
+	}
+}
+
+// Check adds a CHECK constraint on the column
+func Check(expr string) ColumnOption {
+	return func(c *ColumnDef) {
+		c.Check = expr
+	}
+}
+
+// References makes the column a foreign key to column of table
+func References(table, column string) ColumnOption {
+	return func(c *ColumnDef) {
+		if c.References == nil {
+			c.References = &Reference{}
+		}
+		c.References.Table, c.References.Column = table, column
+	}
+}
+
+// OnDelete sets the action of the column's foreign key when the referenced
+// row is deleted, e.g. "CASCADE"
+func OnDelete(action string) ColumnOption {
+	return func(c *ColumnDef) {
+		if c.References == nil {
+			c.References = &Reference{}
+		}
+		c.References.OnDelete = action
+	}
+}
+
+// OnUpdate sets the action of the column's foreign key when the referenced
+// key is updated
+func OnUpdate(action string) ColumnOption {
+	return func(c *ColumnDef) {
+		if c.References == nil {
+			c.References = &Reference{}
+		}
+		c.References.OnUpdate = action
+	}
+}
+
+// newColumn returns the definition of a column
+func newColumn(name string, typ ColumnType, opts []ColumnOption) ColumnDef {
+	c := ColumnDef{Name: name, Type: typ}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// render returns the column definition. inlinePK renders the PRIMARY KEY
+// constraint with the column. MySQL ignores inline REFERENCES, so the caller
+// renders its foreign keys separately.
+func (c ColumnDef) render(dialect Dialect, inlinePK bool) (string, error) {
+	parts := []string{c.Name, c.Type.render(dialect)}
+	if c.AutoIncrement {
+		switch dialect {
+		case PostgreSQL:
+			parts = append(parts, "GENERATED BY DEFAULT AS IDENTITY")
+		case MySQL:
+			parts = append(parts, "AUTO_INCREMENT")
+		case MSSQL:
+			parts = append(parts, "IDENTITY(1,1)")
+		case SQLite:
+			if !inlinePK {
+				return "", fmt.Errorf("column %s: SQLite only supports AUTOINCREMENT on a single-column primary key", c.Name)
+			}
+			// Only INTEGER PRIMARY KEY columns alias the rowid
+			parts[1] = "INTEGER"
+		}
+	}
+	if c.NotNull {
+		parts = append(parts, "NOT NULL")
+	}
+	if c.Default != nil {
+		value, err := literal(dialect, c.Default)
+		if err != nil {
+			return "", fmt.Errorf("column %s: %w", c.Name, err)
+		}
+		parts = append(parts, "DEFAULT "+value)
+	}
+	if inlinePK {
+		parts = append(parts, "PRIMARY KEY")
+		if c.AutoIncrement && dialect == SQLite {
+			parts = append(parts, "AUTOINCREMENT")
+		}
+	}
+	if c.Unique {
+		parts = append(parts, "UNIQUE")
+	}
+	if c.Check != "" {
+		parts = append(parts, "CHECK ("+c.Check+")")
+	}
+	if c.References != nil {
+		if c.References.Table == "" {
+			return "", fmt.Errorf("column %s: foreign key action without References", c.Name)
+		}
+		if dialect != MySQL {
+			parts = append(parts, c.References.render())
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// literal renders a default value
+func literal(dialect Dialect, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case RawExpr:
+		return v.Expr, nil
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'", nil
+	case bool:
+		if dialect == SQLite || dialect == MSSQL {
+			if v {
+				return "1", nil
+			}
+			return "0", nil
+		}
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	}
+
+	switch v := reflect.ValueOf(value); v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported default value of type %T", value)
+}
+
+// ForeignKey is a table-level foreign key constraint
+type ForeignKey struct {
+	// Name is optional
+	Name       string
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+	OnDelete   string
+	OnUpdate   string
+}
+
+// render returns the constraint
+func (fk ForeignKey) render() string {
+	s := fmt.Sprintf("%sFOREIGN KEY (%s) REFERENCES %s (%s)",
+		constraintName(fk.Name), strings.Join(fk.Columns, ", "), fk.RefTable, strings.Join(fk.RefColumns, ", "))
+	if fk.OnDelete != "" {
+		s += " ON DELETE " + fk.OnDelete
+	}
+	if fk.OnUpdate != "" {
+		s += " ON UPDATE " + fk.OnUpdate
+	}
+	return s
+}
+
+// columnForeignKey returns the foreign key of a column as a table constraint
+func columnForeignKey(c ColumnDef) ForeignKey {
+	return ForeignKey{
+		Columns:    []string{c.Name},
+		RefTable:   c.References.Table,
+		RefColumns: []string{c.References.Column},
+		OnDelete:   c.References.OnDelete,
+		OnUpdate:   c.References.OnUpdate,
+	}
+}
+
+// constraintName returns the CONSTRAINT prefix of a named constraint
+func constraintName(name string) string {
+	if name == "" {
+		return ""
+	}
+	return "CONSTRAINT " + name + " "
+}
+
+// CreateTableBuilder builds a CREATE TABLE statement
+type CreateTableBuilder struct {
+	dialect     Dialect
+	table       string
+	ifNotExists bool
+	columns     []ColumnDef
+	primaryKey  []string
+	constraints []string
+	foreignKeys []ForeignKey
+	err         error
+}
+
+// CreateTable starts a CREATE TABLE statement for PostgreSQL
+func CreateTable(name string) *CreateTableBuilder {
+	return &CreateTableBuilder{dialect: PostgreSQL, table: name}
+}
+
+// WithDialect sets the dialect the statement is rendered for
+func (b *CreateTableBuilder) WithDialect(dialect Dialect) *CreateTableBuilder {
+	b.dialect = dialect
+	return b
+}
+
+// IfNotExists only creates the table if it does not exist
+func (b *CreateTableBuilder) IfNotExists() *CreateTableBuilder {
+	b.ifNotExists = true
+	return b
+}
+
+// Column adds a column
+func (b *CreateTableBuilder) Column(name string, typ ColumnType, opts ...ColumnOption) *CreateTableBuilder {
+	b.columns = append(b.columns, newColumn(name, typ, opts))
+	return b
+}
+
+// PrimaryKey sets a primary key over columns
+func (b *CreateTableBuilder) PrimaryKey(columns ...string) *CreateTableBuilder {
+	b.primaryKey = columns
+	return b
+}
+
+// Unique adds a unique constraint over columns. The name is optional.
+func (b *CreateTableBuilder) Unique(name string, columns ...string) *CreateTableBuilder {
+	b.constraints = append(b.constraints, fmt.Sprintf("%sUNIQUE (%s)", constraintName(name), strings.Join(columns, ", ")))
+	return b
+}
+
+// Check adds a check constraint. The name is optional.
+func (b *CreateTableBuilder) Check(name, expr string) *CreateTableBuilder {
+	b.constraints = append(b.constraints, fmt.Sprintf("%sCHECK (%s)", constraintName(name), expr))
+	return b
+}
+
+// ForeignKey adds a foreign key constraint
+func (b *CreateTableBuilder) ForeignKey(fk ForeignKey) *CreateTableBuilder {
+	b.foreignKeys = append(b.foreignKeys, fk)
+	return b
+}
+
+// FromStruct adds a column for each field of s with a db tag, in field order.
+// Fields of embedded structs without a db tag are included. The column type
+// follows the Go type; pointers and sql.Null* types are nullable and other
+// fields are NOT NULL. A ddl tag adds options separated by semicolons:
+//
+//	pk; autoincrement; unique; null; notnull; size:<n> (VARCHAR);
+//	type:<sql type>; default:<sql expr>; check:<expr>; references:<table>.<column>
+func (b *CreateTableBuilder) FromStruct(s interface{}) *CreateTableBuilder {
+	t := reflect.TypeOf(s)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		b.err = fmt.Errorf("FromStruct: expected struct, got %T", s)
+		return b
+	}
+	if err := b.addStructFields(t); err != nil {
+		b.err = err
+	}
+	return b
+}
+
+// addStructFields adds the columns of the fields of t
+func (b *CreateTableBuilder) addStructFields(t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("db")
+
+		if field.Anonymous && tag == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if err := b.addStructFields(embedded); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if tag == "" || tag == "-" || !field.IsExported() {
+			continue
+		}
+
+		typ, nullable, known := columnTypeOf(field.Type)
+		c := ColumnDef{Name: tag, Type: typ, NotNull: !nullable}
+		for _, opt := range strings.Split(field.Tag.Get("ddl"), ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(opt), ":")
+			switch key {
+			case "":
+			case "pk":
+				c.PrimaryKey = true
+			case "autoincrement":
+				c.AutoIncrement = true
+			case "unique":
+				c.Unique = true
+			case "null":
+				c.NotNull = false
+			case "notnull":
+				c.NotNull = true
+			case "size":
+				size, err := strconv.Atoi(value)
+				if err != nil {
+					return fmt.Errorf("field %s: invalid size %q", field.Name, value)
+				}
+				c.Type, known = Varchar(size), true
+			case "type":
+				c.Type, known = RawType(value), true
+			case "default":
+				c.Default = RawExpr{Expr: value}
+			case "check":
+				c.Check = value
+			case "references":
+				table, column, ok := strings.Cut(value, ".")
+				if !ok {
+					return fmt.Errorf("field %s: references must be <table>.<column>", field.Name)
+				}
+				c.References = &Reference{Table: table, Column: column}
+			default:
+				return fmt.Errorf("field %s: unknown ddl option %q", field.Name, key)
+			}
+		}
+		if !known {
+			return fmt.Errorf("field %s: no column type for %s", field.Name, field.Type)
+		}
+		b.columns = append(b.columns, c)
+	}
+	return nil
+}
+
+// nullTypes maps the sql.Null* types to column types
+var nullTypes = map[reflect.Type]ColumnType{
+	reflect.TypeOf(sql.NullString{}):  Text,
+	reflect.TypeOf(sql.NullInt64{}):   BigInt,
+	reflect.TypeOf(sql.NullInt32{}):   Integer,
+	reflect.TypeOf(sql.NullInt16{}):   SmallInt,
+	reflect.TypeOf(sql.NullFloat64{}): Float,
+	reflect.TypeOf(sql.NullBool{}):    Boolean,
+	reflect.TypeOf(sql.NullTime{}):    Timestamp,
+}
+
+// columnTypeOf returns the column type of a Go type and whether it holds NULL
+func columnTypeOf(t reflect.Type) (typ ColumnType, nullable bool, ok bool) {
+	if t.Kind() == reflect.Ptr {
+		t, nullable = t.Elem(), true
+	}
+	if typ, ok := nullTypes[t]; ok {
+		return typ, true, true
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return Timestamp, nullable, true
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return Boolean, nullable, true
+	case reflect.Int8, reflect.Int16, reflect.Uint8:
+		return SmallInt, nullable, true
+	case reflect.Int32, reflect.Uint16:
+		return Integer, nullable, true
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return BigInt, nullable, true
+	case reflect.Float32, reflect.Float64:
+		return Float, nullable, true
+	case reflect.String:
+		return Text, nullable, true
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return Blob, true, true
+		}
+	}
+	return ColumnType{}, false, false
+}
+
+// Build returns the CREATE TABLE statement
+func (b *CreateTableBuilder) Build() (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	if b.table == "" {
+		return "", fmt.Errorf("table name is required")
+	}
+	if len(b.columns) == 0 {
+		return "", fmt.Errorf("table %s has no columns", b.table)
+	}
+
+	primaryKey := b.primaryKey
+	var keyColumns []string
+	for _, c := range b.columns {
+		if c.PrimaryKey {
+			keyColumns = append(keyColumns, c.Name)
+		}
+	}
+	if len(primaryKey) > 0 && len(keyColumns) > 0 {
+		return "", fmt.Errorf("table %s has primary key columns and a PrimaryKey constraint", b.table)
+	}
+	if len(keyColumns) > 1 {
+		primaryKey = keyColumns
+	}
+
+	defs := make([]string, 0, len(b.columns)+len(b.constraints)+len(b.foreignKeys)+1)
+	foreignKeys := b.foreignKeys
+	for _, c := range b.columns {
+		def, err := c.render(b.dialect, c.PrimaryKey && len(keyColumns) == 1)
+		if err != nil {
+			return "", err
+		}
+		defs = append(defs, def)
+		if c.References != nil && b.dialect == MySQL {
+			foreignKeys = append(foreignKeys, columnForeignKey(c))
+		}
+	}
+	if len(primaryKey) > 0 {
+		defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(primaryKey, ", ")))
+	}
+	defs = append(defs, b.constraints...)
+	for _, fk := range foreignKeys {
+		defs = append(defs, fk.render())
+	}
+
+	var query strings.Builder
+	if b.ifNotExists && b.dialect == MSSQL {
+		fmt.Fprintf(&query, "IF OBJECT_ID(N'%s', N'U') IS NULL ", b.table)
+	}
+	query.WriteString("CREATE TABLE ")
+	if b.ifNotExists && b.dialect != MSSQL {
+		query.WriteString("IF NOT EXISTS ")
+	}
+	fmt.Fprintf(&query, "%s (%s)", b.table, strings.Join(defs, ", "))
+	return query.String(), nil
+}
+
+// Exec executes the statement
+func (b *CreateTableBuilder) Exec(ctx context.Context, db Execer) error {
+	query, err := b.Build()
+	if err != nil {
+		return err
+	}
+	return execAll(ctx, db, query)
+}
+
+type alterKind int
+
+const (
+	addColumn alterKind = iota
+	dropColumn
+	renameColumn
+)
+
+type alterOp struct {
+	kind    alterKind
+	column  ColumnDef
+	name    string
+	newName string
+}
+
+// AlterTableBuilder builds ALTER TABLE statements
+type AlterTableBuilder struct {
+	dialect Dialect
+	table   string
+	ops     []alterOp
+}
+
+// AlterTable starts ALTER TABLE statements for PostgreSQL
+func AlterTable(name string) *AlterTableBuilder {
+	return &AlterTableBuilder{dialect: PostgreSQL, table: name}
+}
+
+// WithDialect sets the dialect the statements are rendered for
+func (b *AlterTableBuilder) WithDialect(dialect Dialect) *AlterTableBuilder {
+	b.dialect = dialect
+	return b
+}
+
+// AddColumn adds a column
+func (b *AlterTableBuilder) AddColumn(name string, typ ColumnType, opts ...ColumnOption) *AlterTableBuilder {
+	b.ops = append(b.ops, alterOp{kind: addColumn, column: newColumn(name, typ, opts)})
+	return b
+}
+
+// DropColumn drops a column
+func (b *AlterTableBuilder) DropColumn(name string) *AlterTableBuilder {
+	b.ops = append(b.ops, alterOp{kind: dropColumn, name: name})
+	return b
+}
+
+// RenameColumn renames a column
+func (b *AlterTableBuilder) RenameColumn(name, newName string) *AlterTableBuilder {
+	b.ops = append(b.ops, alterOp{kind: renameColumn, name: name, newName: newName})
+	return b
+}
+
+// Build returns one statement per change, in order; not every dialect can
+// combine them
+func (b *AlterTableBuilder) Build() ([]string, error) {
+	if b.table == "" {
+		return nil, fmt.Errorf("table name is required")
+	}
+
+	stmts := make([]string, 0, len(b.ops))
+	for _, op := range b.ops {
+		switch op.kind {
+		case addColumn:
+			def, err := op.column.render(b.dialect, op.column.PrimaryKey)
+			if err != nil {
+				return nil, err
+			}
+			if b.dialect == MSSQL {
+				stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD %s", b.table, def))
+			} else {
+				stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", b.table, def))
+			}
+			if op.column.References != nil && b.dialect == MySQL {
+				stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD %s", b.table, columnForeignKey(op.column).render()))
+			}
+		case dropColumn:
+			stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", b.table, op.name))
+		case renameColumn:
+			if b.dialect == MSSQL {
+				stmts = append(stmts, fmt.Sprintf("EXEC sp_rename '%s.%s', '%s', 'COLUMN'", b.table, op.name, op.newName))
+			} else {
+				stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", b.table, op.name, op.newName))
+			}
+		}
+	}
+	return stmts, nil
+}
+
+// Exec executes the statements
+func (b *AlterTableBuilder) Exec(ctx context.Context, db Execer) error {
+	stmts, err := b.Build()
+	if err != nil {
+		return err
+	}
+	return execAll(ctx, db, stmts...)
+}
+
+// DropTableBuilder builds a DROP TABLE statement
+type DropTableBuilder struct {
+	table    string
+	ifExists bool
+}
+
+// DropTable starts a DROP TABLE statement
+func DropTable(name string) *DropTableBuilder {
+	return &DropTableBuilder{table: name}
+}
+
+// IfExists ignores a missing table
+func (b *DropTableBuilder) IfExists() *DropTableBuilder {
+	b.ifExists = true
+	return b
+}
+
+// Build returns the DROP TABLE statement, which is the same in every dialect
+func (b *DropTableBuilder) Build() (string, error) {
+	if b.table == "" {
+		return "", fmt.Errorf("table name is required")
+	}
+	if b.ifExists {
+		return "DROP TABLE IF EXISTS " + b.table, nil
+	}
+	return "DROP TABLE " + b.table, nil
+}
+
+// Exec executes the statement
+func (b *DropTableBuilder) Exec(ctx context.Context, db Execer) error {
+	query, err := b.Build()
+	if err != nil {
+		return err
+	}
+	return execAll(ctx, db, query)
+}
+
+// IndexBuilder builds a CREATE INDEX statement
+type IndexBuilder struct {
+	dialect     Dialect
+	name        string
+	table       string
+	columns     []string
+	unique      bool
+	ifNotExists bool
+	where       string
+}
+
+// CreateIndex starts a CREATE INDEX statement for PostgreSQL. Columns may
+// carry a sort order, e.g. "created_at DESC".
+func CreateIndex(name, table string, columns ...string) *IndexBuilder {
+	return &IndexBuilder{dialect: PostgreSQL, name: name, table: table, columns: columns}
+}
+
+// WithDialect sets the dialect the statement is rendered for
+func (b *IndexBuilder) WithDialect(dialect Dialect) *IndexBuilder {
+	b.dialect = dialect
+	return b
+}
+
+// Unique creates a unique index
+func (b *IndexBuilder) Unique() *IndexBuilder {
+	b.unique = true
+	return b
+}
+
+// IfNotExists only creates the index if it does not exist. MySQL does not
+// support it.
+func (b *IndexBuilder) IfNotExists() *IndexBuilder {
+	b.ifNotExists = true
+	return b
+}
+
+// Where makes the index partial, covering only the rows matching condition.
+// MySQL does not support partial indexes.
+func (b *IndexBuilder) Where(condition string) *IndexBuilder {
+	b.where = condition
+	return b
+}
+
+// Build returns the CREATE INDEX statement
+func (b *IndexBuilder) Build() (string, error) {
+	if b.name == "" || b.table == "" || len(b.columns) == 0 {
+		return "", fmt.Errorf("index name, table and columns are required")
+	}
+	if b.dialect == MySQL && (b.ifNotExists || b.where != "") {
+		return "", fmt.Errorf("MySQL does not support IF NOT EXISTS or partial indexes")
+	}
+
+	var query strings.Builder
+	if b.ifNotExists && b.dialect == MSSQL {
+		fmt.Fprintf(&query, "IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'%s' AND object_id = OBJECT_ID(N'%s')) ", b.name, b.table)
+	}
+	query.WriteString("CREATE ")
+	if b.unique {
+		query.WriteString("UNIQUE ")
+	}
+	query.WriteString("INDEX ")
+	if b.ifNotExists && b.dialect != MSSQL {
+		query.WriteString("IF NOT EXISTS ")
+	}
+	fmt.Fprintf(&query, "%s ON %s (%s)", b.name, b.table, strings.Join(b.columns, ", "))
+	if b.where != "" {
+		query.WriteString(" WHERE ")
+		query.WriteString(b.where)
+	}
+	return query.String(), nil
+}
+
+// Exec executes the statement
+func (b *IndexBuilder) Exec(ctx context.Context, db Execer) error {
+	query, err := b.Build()
+	if err != nil {
+		return err
+	}
+	return execAll(ctx, db, query)
+}
+
+// DropIndexBuilder builds a DROP INDEX statement
+type DropIndexBuilder struct {
+	dialect  Dialect
+	name     string
+	table    string
+	ifExists bool
+}
+
+// DropIndex starts a DROP INDEX statement for PostgreSQL. MySQL and MSSQL
+// need the table of the index.
+func DropIndex(name, table string) *DropIndexBuilder {
+	return &DropIndexBuilder{dialect: PostgreSQL, name: name, table: table}
+}
+
+// WithDialect sets the dialect the statement is rendered for
+func (b *DropIndexBuilder) WithDialect(dialect Dialect) *DropIndexBuilder {
+	b.dialect = dialect
+	return b
+}
+
+// IfExists ignores a missing index. MySQL does not support it.
+func (b *DropIndexBuilder) IfExists() *DropIndexBuilder {
+	b.ifExists = true
+	return b
+}
+
+// Build returns the DROP INDEX statement
+func (b *DropIndexBuilder) Build() (string, error) {
+	if b.name == "" {
+		return "", fmt.Errorf("index name is required")
+	}
+	if b.dialect == MySQL && b.ifExists {
+		return "", fmt.Errorf("MySQL does not support DROP INDEX IF EXISTS")
+	}
+
+	query := "DROP INDEX "
+	if b.ifExists {
+		query += "IF EXISTS "
+	}
+	query += b.name
+	if b.dialect == MySQL || b.dialect == MSSQL {
+		if b.table == "" {
+			return "", fmt.Errorf("dropping an index needs its table in this dialect")
+		}
+		query += " ON " + b.table
+	}
+	return query, nil
+}
+
+// Exec executes the statement
+func (b *DropIndexBuilder) Exec(ctx context.Context, db Execer) error {
+	query, err := b.Build()
+	if err != nil {
+		return err
+	}
+	return execAll(ctx, db, query)
+}
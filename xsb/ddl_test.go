@@ -0,0 +1,238 @@
+package xsb_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xsb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateTable(t *testing.T) {
+	users := func(dialect xsb.Dialect) *xsb.CreateTableBuilder {
+		return xsb.CreateTable("users").
+			WithDialect(dialect).
+			IfNotExists().
+			Column("id", xsb.BigInt, xsb.PrimaryKey(), xsb.AutoIncrement()).
+			Column("email", xsb.Varchar(255), xsb.NotNull(), xsb.Unique()).
+			Column("active", xsb.Boolean, xsb.NotNull(), xsb.Default(true)).
+			Column("team_id", xsb.BigInt, xsb.References("teams", "id"), xsb.OnDelete("CASCADE")).
+			Column("created_at", xsb.Timestamp, xsb.Default(xsb.RawExpr{Expr: "CURRENT_TIMESTAMP"})).
+			Check("id_positive", "id > 0")
+	}
+
+	tests := []struct {
+		dialect xsb.Dialect
+		want    string
+	}{
+		{xsb.PostgreSQL, "CREATE TABLE IF NOT EXISTS users (" +
+			"id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY, " +
+			"email VARCHAR(255) NOT NULL UNIQUE, " +
+			"active BOOLEAN NOT NULL DEFAULT TRUE, " +
+			"team_id BIGINT REFERENCES teams (id) ON DELETE CASCADE, " +
+			"created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, " +
+			"CONSTRAINT id_positive CHECK (id > 0))"},
+		{xsb.MySQL, "CREATE TABLE IF NOT EXISTS users (" +
+			"id BIGINT AUTO_INCREMENT PRIMARY KEY, " +
+			"email VARCHAR(255) NOT NULL UNIQUE, " +
+			"active TINYINT(1) NOT NULL DEFAULT TRUE, " +
+			"team_id BIGINT, " +
+			"created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP, " +
+			"CONSTRAINT id_positive CHECK (id > 0), " +
+			"FOREIGN KEY (team_id) REFERENCES teams (id) ON DELETE CASCADE)"},
+		{xsb.SQLite, "CREATE TABLE IF NOT EXISTS users (" +
+			"id INTEGER PRIMARY KEY AUTOINCREMENT, " +
+			"email VARCHAR(255) NOT NULL UNIQUE, " +
+			"active BOOLEAN NOT NULL DEFAULT 1, " +
+			"team_id INTEGER REFERENCES teams (id) ON DELETE CASCADE, " +
+			"created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, " +
+			"CONSTRAINT id_positive CHECK (id > 0))"},
+		{xsb.MSSQL, "IF OBJECT_ID(N'users', N'U') IS NULL CREATE TABLE users (" +
+			"id BIGINT IDENTITY(1,1) PRIMARY KEY, " +
+			"email NVARCHAR(255) NOT NULL UNIQUE, " +
+			"active BIT NOT NULL DEFAULT 1, " +
+			"team_id BIGINT REFERENCES teams (id) ON DELETE CASCADE, " +
+			"created_at DATETIME2 DEFAULT CURRENT_TIMESTAMP, " +
+			"CONSTRAINT id_positive CHECK (id > 0))"},
+	}
+	for _, tt := range tests {
+		query, err := users(tt.dialect).Build()
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, query)
+	}
+
+	t.Run("Composite Keys And Constraints", func(t *testing.T) {
+		query, err := xsb.CreateTable("memberships").
+			Column("user_id", xsb.BigInt, xsb.NotNull()).
+			Column("team_id", xsb.BigInt, xsb.NotNull()).
+			Column("role", xsb.Text, xsb.Default("it's a member"), xsb.Check("role <> ''")).
+			Column("score", xsb.Decimal(10, 2), xsb.Default(1.5)).
+			PrimaryKey("user_id", "team_id").
+			Unique("", "role", "score").
+			ForeignKey(xsb.ForeignKey{
+				Name:       "memberships_team",
+				Columns:    []string{"team_id"},
+				RefTable:   "teams",
+				RefColumns: []string{"id"},
+				OnUpdate:   "RESTRICT",
+			}).
+			Build()
+		require.NoError(t, err)
+		assert.Equal(t, "CREATE TABLE memberships ("+
+			"user_id BIGINT NOT NULL, team_id BIGINT NOT NULL, "+
+			"role TEXT DEFAULT 'it''s a member' CHECK (role <> ''), "+
+			"score NUMERIC(10, 2) DEFAULT 1.5, "+
+			"PRIMARY KEY (user_id, team_id), UNIQUE (role, score), "+
+			"CONSTRAINT memberships_team FOREIGN KEY (team_id) REFERENCES teams (id) ON UPDATE RESTRICT)", query)
+
+		// Several primary key columns make a composite key
+		query, err = xsb.CreateTable("pairs").
+			WithDialect(xsb.SQLite).
+			Column("a", xsb.Integer, xsb.PrimaryKey()).
+			Column("b", xsb.Integer, xsb.PrimaryKey()).
+			Build()
+		require.NoError(t, err)
+		assert.Equal(t, "CREATE TABLE pairs (a INTEGER, b INTEGER, PRIMARY KEY (a, b))", query)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		_, err := xsb.CreateTable("t").Build()
+		assert.Error(t, err)
+
+		_, err = xsb.CreateTable("t").
+			WithDialect(xsb.SQLite).
+			Column("id", xsb.Integer, xsb.AutoIncrement()).
+			Build()
+		assert.ErrorContains(t, err, "AUTOINCREMENT")
+
+		_, err = xsb.CreateTable("t").
+			Column("id", xsb.Integer, xsb.PrimaryKey()).
+			PrimaryKey("id").
+			Build()
+		assert.Error(t, err)
+
+		_, err = xsb.CreateTable("t").Column("at", xsb.Timestamp, xsb.Default(time.Now())).Build()
+		assert.ErrorContains(t, err, "unsupported default value")
+	})
+}
+
+type timestamps struct {
+	CreatedAt time.Time  `db:"created_at" ddl:"default:CURRENT_TIMESTAMP"`
+	DeletedAt *time.Time `db:"deleted_at"`
+}
+
+type account struct {
+	ID       int64          `db:"id" ddl:"pk;autoincrement"`
+	Email    string         `db:"email" ddl:"size:255;unique"`
+	Nickname sql.NullString `db:"nickname"`
+	Balance  float64        `db:"balance" ddl:"type:NUMERIC(12, 2);check:balance >= 0"`
+	OwnerID  int32          `db:"owner_id" ddl:"references:users.id"`
+	Avatar   []byte         `db:"avatar"`
+	Settings string         `db:"settings" ddl:"type:JSONB;null"`
+	Ignored  string
+	Skipped  string `db:"-"`
+	timestamps
+}
+
+func TestCreateTableFromStruct(t *testing.T) {
+	query, err := xsb.CreateTable("accounts").FromStruct(&account{}).Build()
+	require.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE accounts ("+
+		"id BIGINT GENERATED BY DEFAULT AS IDENTITY NOT NULL PRIMARY KEY, "+
+		"email VARCHAR(255) NOT NULL UNIQUE, "+
+		"nickname TEXT, "+
+		"balance NUMERIC(12, 2) NOT NULL CHECK (balance >= 0), "+
+		"owner_id INTEGER NOT NULL REFERENCES users (id), "+
+		"avatar BYTEA, "+
+		"settings JSONB, "+
+		"created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, "+
+		"deleted_at TIMESTAMP)", query)
+
+	_, err = xsb.CreateTable("t").FromStruct(struct {
+		C complex128 `db:"c"`
+	}{}).Build()
+	assert.ErrorContains(t, err, "no column type")
+
+	_, err = xsb.CreateTable("t").FromStruct(struct {
+		A int `db:"a" ddl:"bogus"`
+	}{}).Build()
+	assert.ErrorContains(t, err, "unknown ddl option")
+
+	_, err = xsb.CreateTable("t").FromStruct(42).Build()
+	assert.Error(t, err)
+}
+
+func TestAlterTable(t *testing.T) {
+	alter := func(dialect xsb.Dialect) []string {
+		stmts, err := xsb.AlterTable("users").
+			WithDialect(dialect).
+			AddColumn("age", xsb.Integer, xsb.NotNull(), xsb.Default(0)).
+			AddColumn("team_id", xsb.BigInt, xsb.References("teams", "id")).
+			DropColumn("nickname").
+			RenameColumn("name", "full_name").
+			Build()
+		require.NoError(t, err)
+		return stmts
+	}
+
+	assert.Equal(t, []string{
+		"ALTER TABLE users ADD COLUMN age INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE users ADD COLUMN team_id BIGINT REFERENCES teams (id)",
+		"ALTER TABLE users DROP COLUMN nickname",
+		"ALTER TABLE users RENAME COLUMN name TO full_name",
+	}, alter(xsb.PostgreSQL))
+	assert.Equal(t, []string{
+		"ALTER TABLE users ADD COLUMN age INT NOT NULL DEFAULT 0",
+		"ALTER TABLE users ADD COLUMN team_id BIGINT",
+		"ALTER TABLE users ADD FOREIGN KEY (team_id) REFERENCES teams (id)",
+		"ALTER TABLE users DROP COLUMN nickname",
+		"ALTER TABLE users RENAME COLUMN name TO full_name",
+	}, alter(xsb.MySQL))
+	assert.Equal(t, []string{
+		"ALTER TABLE users ADD age INT NOT NULL DEFAULT 0",
+		"ALTER TABLE users ADD team_id BIGINT REFERENCES teams (id)",
+		"ALTER TABLE users DROP COLUMN nickname",
+		"EXEC sp_rename 'users.name', 'full_name', 'COLUMN'",
+	}, alter(xsb.MSSQL))
+}
+
+func TestIndexes(t *testing.T) {
+	index := func(dialect xsb.Dialect) (string, error) {
+		return xsb.CreateIndex("users_email", "users", "email", "created_at DESC").
+			WithDialect(dialect).
+			Unique().
+			IfNotExists().
+			Where("deleted_at IS NULL").
+			Build()
+	}
+
+	query, err := index(xsb.PostgreSQL)
+	require.NoError(t, err)
+	assert.Equal(t, "CREATE UNIQUE INDEX IF NOT EXISTS users_email ON users (email, created_at DESC) WHERE deleted_at IS NULL", query)
+
+	query, err = index(xsb.MSSQL)
+	require.NoError(t, err)
+	assert.Equal(t, "IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'users_email' AND object_id = OBJECT_ID(N'users')) "+
+		"CREATE UNIQUE INDEX users_email ON users (email, created_at DESC) WHERE deleted_at IS NULL", query)
+
+	_, err = index(xsb.MySQL)
+	assert.Error(t, err)
+
+	query, err = xsb.CreateIndex("users_name", "users", "name").WithDialect(xsb.MySQL).Build()
+	require.NoError(t, err)
+	assert.Equal(t, "CREATE INDEX users_name ON users (name)", query)
+
+	query, err = xsb.DropIndex("users_name", "users").IfExists().Build()
+	require.NoError(t, err)
+	assert.Equal(t, "DROP INDEX IF EXISTS users_name", query)
+
+	query, err = xsb.DropIndex("users_name", "users").WithDialect(xsb.MySQL).Build()
+	require.NoError(t, err)
+	assert.Equal(t, "DROP INDEX users_name ON users", query)
+
+	query, err = xsb.DropTable("users").IfExists().Build()
+	require.NoError(t, err)
+	assert.Equal(t, "DROP TABLE IF EXISTS users", query)
+}
@@ -0,0 +1,456 @@
+package xsb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Expr is a condition or value built from structured parts rather than a SQL
+// string. Expressions render placeholders in the style of the builder they are
+// added to, so they can be nested freely and mixed with Where and Having. Add
+// them after the dialect is set. Functions taking a column accept a column
+// name or an Expr such as JSONText.
+type Expr interface {
+	build(b *Builder) (string, []interface{})
+}
+
+// comparison compares a column with a value
+type comparison struct {
+	column interface{}
+	op     string
+	value  interface{}
+}
+
+func (c comparison) build(b *Builder) (string, []interface{}) {
+	column, args := b.columnSQL(c.column)
+	if c.value == nil {
+		switch c.op {
+		case "=":
+			return column + " IS NULL", args
+		case "<>":
+			return column + " IS NOT NULL", args
+		}
+	}
+	operand, operandArgs := b.operand(c.value)
+	return fmt.Sprintf("%s %s %s", column, c.op, operand), append(args, operandArgs...)
+}
+
+// Eq matches rows where column equals value; a nil value matches NULL
+func Eq(column, value interface{}) Expr {
+	return comparison{column: column, op: "=", value: value}
+}
+
+// Neq matches rows where column differs from value; a nil value matches NOT NULL
+func Neq(column, value interface{}) Expr {
+	return comparison{column: column, op: "<>", value: value}
+}
+
+// Gt matches rows where column is greater than value
+func Gt(column, value interface{}) Expr {
+	return comparison{column: column, op: ">", value: value}
+}
+
+// Gte matches rows where column is greater than or equal to value
+func Gte(column, value interface{}) Expr {
+	return comparison{column: column, op: ">=", value: value}
+}
+
+// Lt matches rows where column is less than value
+func Lt(column, value interface{}) Expr {
+	return comparison{column: column, op: "<", value: value}
+}
+
+// Lte matches rows where column is less than or equal to value
+func Lte(column, value interface{}) Expr {
+	return comparison{column: column, op: "<=", value: value}
+}
+
+// Like matches rows where column matches the LIKE pattern
+func Like(column interface{}, pattern string) Expr {
+	return comparison{column: column, op: "LIKE", value: pattern}
+}
+
+// NotLike matches rows where column does not match the LIKE pattern
+func NotLike(column interface{}, pattern string) Expr {
+	return comparison{column: column, op: "NOT LIKE", value: pattern}
+}
+
+// inExpr matches a column against a list of values or a subquery
+type inExpr struct {
+	column interface{}
+	values []interface{}
+	not    bool
+}
+
+func (e inExpr) build(b *Builder) (string, []interface{}) {
+	op := "IN"
+	if e.not {
+		op = "NOT IN"
+	}
+
+	// An empty list matches nothing, or everything when negated
+	if len(e.values) == 0 {
+		if e.not {
+			return "1 = 1", nil
+		}
+		return "1 = 0", nil
+	}
+
+	column, args := b.columnSQL(e.column)
+	if len(e.values) == 1 {
+		if sub, ok := e.values[0].(*Builder); ok {
+			operand, operandArgs := b.operand(sub)
+			return fmt.Sprintf("%s %s %s", column, op, operand), append(args, operandArgs...)
+		}
+	}
+
+	placeholders := make([]string, len(e.values))
+	for i, v := range e.values {
+		operand, operandArgs := b.operand(v)
+		placeholders[i] = operand
+		args = append(args, operandArgs...)
+	}
+	return fmt.Sprintf("%s %s (%s)", column, op, strings.Join(placeholders, ", ")), args
+}
+
+// In matches rows where column is one of values. The values may be given as
+// a single slice or as a subquery builder.
+func In(column interface{}, values ...interface{}) Expr {
+	return inExpr{column: column, values: expandSlice(values)}
+}
+
+// NotIn matches rows where column is none of values
+func NotIn(column interface{}, values ...interface{}) Expr {
+	return inExpr{column: column, values: expandSlice(values), not: true}
+}
+
+// expandSlice expands a lone slice argument, other than []byte, into its elements
+func expandSlice(values []interface{}) []interface{} {
+	if len(values) != 1 {
+		return values
+	}
+	v := reflect.ValueOf(values[0])
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+		return values
+	}
+	expanded := make([]interface{}, v.Len())
+	for i := range expanded {
+		expanded[i] = v.Index(i).Interface()
+	}
+	return expanded
+}
+
+// nullExpr tests a column for NULL
+type nullExpr struct {
+	column interface{}
+	not    bool
+}
+
+func (e nullExpr) build(b *Builder) (string, []interface{}) {
+	column, args := b.columnSQL(e.column)
+	if e.not {
+		return column + " IS NOT NULL", args
+	}
+	return column + " IS NULL", args
+}
+
+// IsNull matches rows where column is NULL
+func IsNull(column interface{}) Expr {
+	return nullExpr{column: column}
+}
+
+// IsNotNull matches rows where column is not NULL
+func IsNotNull(column interface{}) Expr {
+	return nullExpr{column: column, not: true}
+}
+
+// betweenExpr tests a column against an inclusive range
+type betweenExpr struct {
+	column     interface{}
+	start, end interface{}
+	not        bool
+}
+
+func (e betweenExpr) build(b *Builder) (string, []interface{}) {
+	op := "BETWEEN"
+	if e.not {
+		op = "NOT BETWEEN"
+	}
+	column, args := b.columnSQL(e.column)
+	start, startArgs := b.operand(e.start)
+	end, endArgs := b.operand(e.end)
+	args = append(append(args, startArgs...), endArgs...)
+	return fmt.Sprintf("%s %s %s AND %s", column, op, start, end), args
+}
+
+// Between matches rows where column lies between start and end inclusive
+func Between(column, start, end interface{}) Expr {
+	return betweenExpr{column: column, start: start, end: end}
+}
+
+// NotBetween matches rows where column lies outside start and end
+func NotBetween(column, start, end interface{}) Expr {
+	return betweenExpr{column: column, start: start, end: end, not: true}
+}
+
+// existsExpr tests whether a subquery returns rows
+type existsExpr struct {
+	subquery *Builder
+	not      bool
+}
+
+func (e existsExpr) build(b *Builder) (string, []interface{}) {
+	operand, args := b.operand(e.subquery)
+	if e.not {
+		return "NOT EXISTS " + operand, args
+	}
+	return "EXISTS " + operand, args
+}
+
+// Exists matches when subquery returns at least one row
+func Exists(subquery *Builder) Expr {
+	return existsExpr{subquery: subquery}
+}
+
+// NotExists matches when subquery returns no rows
+func NotExists(subquery *Builder) Expr {
+	return existsExpr{subquery: subquery, not: true}
+}
+
+// group joins expressions with AND or OR
+type group struct {
+	op    string
+	exprs []Expr
+}
+
+func (g group) build(b *Builder) (string, []interface{}) {
+	var parts []string
+	var args []interface{}
+	for _, e := range g.exprs {
+		if e == nil {
+			continue
+		}
+		query, exprArgs := e.build(b)
+		if query == "" {
+			continue
+		}
+		// Mixed operators and raw fragments keep their own precedence
+		switch child := e.(type) {
+		case group:
+			if child.op != g.op && child.size() > 1 {
+				query = "(" + query + ")"
+			}
+		case RawExpr:
+			query = "(" + query + ")"
+		}
+		parts = append(parts, query)
+		args = append(args, exprArgs...)
+	}
+	return strings.Join(parts, " "+g.op+" "), args
+}
+
+// size returns the number of non-nil expressions in g
+func (g group) size() int {
+	n := 0
+	for _, e := range g.exprs {
+		if e != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// And matches rows matching every expression; nil expressions are skipped
+func And(exprs ...Expr) Expr {
+	return group{op: "AND", exprs: exprs}
+}
+
+// Or matches rows matching any expression; nil expressions are skipped
+func Or(exprs ...Expr) Expr {
+	return group{op: "OR", exprs: exprs}
+}
+
+// notExpr negates an expression
+type notExpr struct {
+	expr Expr
+}
+
+func (e notExpr) build(b *Builder) (string, []interface{}) {
+	query, args := e.expr.build(b)
+	if query == "" {
+		return "", nil
+	}
+	return "NOT (" + query + ")", args
+}
+
+// Not matches rows not matching expr
+func Not(expr Expr) Expr {
+	return notExpr{expr: expr}
+}
+
+// Raw returns a raw SQL fragment with ? placeholders, usable as an Expr or
+// as an operand, e.g. Eq("orders.user_id", Raw("users.id"))
+func Raw(sql string, args ...interface{}) RawExpr {
+	return RawExpr{Expr: sql, args: args}
+}
+
+func (r RawExpr) build(b *Builder) (string, []interface{}) {
+	return b.bind(r.Expr, r.args)
+}
+
+// operand renders a value on the right-hand side of a condition: a
+// subquery, an expression or a placeholder
+func (b *Builder) operand(value interface{}) (string, []interface{}) {
+	switch v := value.(type) {
+	case *Builder:
+		query, args := v.BuildSelect()
+		if v.err != nil && b.err == nil {
+			b.err = v.err
+		}
+		query, args = b.bind(query, args)
+		return "(" + query + ")", args
+	case Expr:
+		return v.build(b)
+	default:
+		return b.placeholder(), []interface{}{value}
+	}
+}
+
+// columnSQL renders the left-hand side of a condition: a column name or an
+// expression
+func (b *Builder) columnSQL(column interface{}) (string, []interface{}) {
+	switch c := column.(type) {
+	case string:
+		return c, nil
+	case Expr:
+		return c.build(b)
+	default:
+		if b.err == nil {
+			b.err = fmt.Errorf("unsupported column %T", column)
+		}
+		return "", nil
+	}
+}
+
+// bind converts the placeholders of SQL written elsewhere, such as a Where
+// condition, a Raw expression or a built subquery, into the builder's own,
+// which are numbered in the style of the dialect when the statement is built.
+// ? takes the next argument and $n the n-th, so args are reordered and
+// repeated to match. Placeholders inside quotes are left alone, and without
+// arguments the query is kept as is, e.g. PostgreSQL's ? operator.
+func (b *Builder) bind(query string, args []interface{}) (string, []interface{}) {
+	if len(args) == 0 {
+		return query, nil
+	}
+
+	var sb strings.Builder
+	var bound []interface{}
+	var quote byte
+	next := 0
+	arg := func(i int) {
+		if i < 0 || i >= len(args) {
+			if b.err == nil {
+				b.err = fmt.Errorf("placeholder %d out of range in %q with %d arguments", i+1, query, len(args))
+			}
+			return
+		}
+		sb.WriteString(b.placeholder())
+		bound = append(bound, args[i])
+	}
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?' || c == placeholderMark:
+			arg(next)
+			next++
+			continue
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			n := 0
+			for i+1 < len(query) && isDigit(query[i+1]) {
+				i++
+				n = n*10 + int(query[i]-'0')
+			}
+			arg(n - 1)
+			continue
+		}
+		sb.WriteByte(c)
+	}
+
+	// Arguments without placeholders are passed on, as Where always did
+	if len(bound) == 0 {
+		return query, args
+	}
+	return sb.String(), bound
+}
+
+// render numbers the builder's placeholders in order of appearance, in the
+// style of the dialect
+func (b *Builder) render(query string) string {
+	if strings.IndexByte(query, placeholderMark) < 0 {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] == placeholderMark {
+			n++
+			sb.WriteString(placeholderAt(b.dialect, n))
+			continue
+		}
+		sb.WriteByte(query[i])
+	}
+	return sb.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// wrapOr parenthesizes a top-level OR so it keeps its meaning when combined
+// with other conditions
+func wrapOr(e Expr, query string) string {
+	if g, ok := e.(group); ok && g.op == "OR" && g.size() > 1 {
+		return "(" + query + ")"
+	}
+	return query
+}
+
+// WhereExpr adds a condition expression to the WHERE clause with AND
+func (b *Builder) WhereExpr(e Expr) *Builder {
+	query, args := e.build(b)
+	if query == "" {
+		return b
+	}
+	return b.Where(wrapOr(e, query), args...)
+}
+
+// OrWhereExpr adds a condition expression to the WHERE clause with OR
+func (b *Builder) OrWhereExpr(e Expr) *Builder {
+	query, args := e.build(b)
+	if query == "" {
+		return b
+	}
+	return b.OrWhere(wrapOr(e, query), args...)
+}
+
+// HavingExpr adds a condition expression to the HAVING clause with AND
+func (b *Builder) HavingExpr(e Expr) *Builder {
+	query, args := e.build(b)
+	if query == "" {
+		return b
+	}
+	query = wrapOr(e, query)
+	if b.having != "" {
+		query = b.having + " AND " + query
+	}
+	b.having = query
+	b.havingArgs = append(b.havingArgs, args...)
+	return b
+}
@@ -0,0 +1,211 @@
+package xsb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xsb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpr(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(1, 0, 0)
+	filter := xsb.And(
+		xsb.Eq("status", "active"),
+		xsb.Or(xsb.Gt("age", 18), xsb.IsNull("age")),
+		xsb.In("role", []string{"admin", "dev"}),
+		xsb.Not(xsb.Like("email", "%@test.com")),
+		xsb.Between("created_at", from, to),
+	)
+	wantArgs := []interface{}{"active", 18, "admin", "dev", "%@test.com", from, to}
+
+	t.Run("PostgreSQL", func(t *testing.T) {
+		query, args := xsb.New().Table("users").Columns("id").WhereExpr(filter).Build()
+		assert.Equal(t, "SELECT id FROM users WHERE status = $1 AND (age > $2 OR age IS NULL) AND role IN ($3, $4) "+
+			"AND NOT (email LIKE $5) AND created_at BETWEEN $6 AND $7", query)
+		assert.Equal(t, wantArgs, args)
+	})
+
+	t.Run("MySQL", func(t *testing.T) {
+		query, args := xsb.New().WithDialect(xsb.MySQL).Table("users").Columns("id").WhereExpr(filter).Build()
+		assert.Equal(t, "SELECT id FROM users WHERE status = ? AND (age > ? OR age IS NULL) AND role IN (?, ?) "+
+			"AND NOT (email LIKE ?) AND created_at BETWEEN ? AND ?", query)
+		assert.Equal(t, wantArgs, args)
+	})
+
+	t.Run("Comparisons", func(t *testing.T) {
+		tests := []struct {
+			expr xsb.Expr
+			want string
+			args []interface{}
+		}{
+			{xsb.Eq("a", nil), "a IS NULL", nil},
+			{xsb.Neq("a", nil), "a IS NOT NULL", nil},
+			{xsb.Neq("a", 1), "a <> $1", []interface{}{1}},
+			{xsb.Gte("a", 1), "a >= $1", []interface{}{1}},
+			{xsb.Lt("a", 1), "a < $1", []interface{}{1}},
+			{xsb.Lte("a", 1), "a <= $1", []interface{}{1}},
+			{xsb.NotLike("a", "x%"), "a NOT LIKE $1", []interface{}{"x%"}},
+			{xsb.IsNotNull("a"), "a IS NOT NULL", nil},
+			{xsb.NotBetween("a", 1, 2), "a NOT BETWEEN $1 AND $2", []interface{}{1, 2}},
+			{xsb.In("a"), "1 = 0", nil},
+			{xsb.NotIn("a", []int{}), "1 = 1", nil},
+			{xsb.NotIn("a", 1, 2), "a NOT IN ($1, $2)", []interface{}{1, 2}},
+			{xsb.Eq("a", xsb.Raw("b")), "a = b", nil},
+			{xsb.Or(xsb.Eq("a", 1), xsb.Raw("lower(b) = ?", "x")), "(a = $1 OR (lower(b) = $2))", []interface{}{1, "x"}},
+			{xsb.And(nil, xsb.Eq("a", 1), xsb.And()), "a = $1", []interface{}{1}},
+		}
+		for _, tt := range tests {
+			query, args := xsb.New().Table("t").Columns("*").WhereExpr(tt.expr).Build()
+			assert.Equal(t, "SELECT * FROM t WHERE "+tt.want, query)
+			assert.Equal(t, tt.args, args)
+		}
+
+		// An empty expression adds no condition
+		query, _ := xsb.New().Table("t").Columns("*").WhereExpr(xsb.And()).Build()
+		assert.Equal(t, "SELECT * FROM t", query)
+	})
+
+	t.Run("Mixed With Where And Having", func(t *testing.T) {
+		query, args := xsb.New().
+			Table("scores").
+			Columns("team", "SUM(points)").
+			Where("deleted_at IS NULL").
+			WhereExpr(xsb.Or(xsb.Eq("season", 2024), xsb.Eq("season", 2025))).
+			OrWhereExpr(xsb.Eq("pinned", true)).
+			GroupBy("team").
+			HavingExpr(xsb.Gte("COUNT(*)", 5)).
+			HavingExpr(xsb.Lt("SUM(points)", 100)).
+			Build()
+		assert.Equal(t, "SELECT team, SUM(points) FROM scores WHERE deleted_at IS NULL AND (season = $1 OR season = $2) "+
+			"OR pinned = $3 GROUP BY team HAVING COUNT(*) >= $4 AND SUM(points) < $5", query)
+		assert.Equal(t, []interface{}{2024, 2025, true, 5, 100}, args)
+	})
+
+	t.Run("Subqueries", func(t *testing.T) {
+		bigSpenders := xsb.New().Table("orders").Columns("user_id").WhereExpr(xsb.Gt("total", 100))
+		query, args := xsb.New().
+			Table("users").
+			Columns("id").
+			WhereExpr(xsb.Eq("active", true)).
+			WhereExpr(xsb.In("id", bigSpenders)).
+			WhereExpr(xsb.NotExists(xsb.New().
+				WithDialect(xsb.MySQL).
+				Table("bans").
+				Columns("1").
+				Where("bans.user_id = users.id AND bans.until > ?", "2024-01-01"))).
+			WhereExpr(xsb.Gt("score", xsb.New().Table("users").Columns("AVG(score)"))).
+			Build()
+		assert.Equal(t, "SELECT id FROM users WHERE active = $1 "+
+			"AND id IN (SELECT user_id FROM orders WHERE total > $2) "+
+			"AND NOT EXISTS (SELECT 1 FROM bans WHERE bans.user_id = users.id AND bans.until > $3) "+
+			"AND score > (SELECT AVG(score) FROM users)", query)
+		assert.Equal(t, []interface{}{true, 100, "2024-01-01"}, args)
+
+		// A subquery in another dialect takes the outer dialect's placeholders
+		query, _ = xsb.New().
+			WithDialect(xsb.MySQL).
+			Table("users").
+			Columns("id").
+			WhereExpr(xsb.Exists(bigSpenders)).
+			Build()
+		assert.Equal(t, "SELECT id FROM users WHERE EXISTS (SELECT user_id FROM orders WHERE total > ?)", query)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		query, args := xsb.New().
+			Table("users").
+			Set("name", "Ann").
+			Set("visits", xsb.Raw("visits + ?", 1)).
+			WhereExpr(xsb.Eq("id", 7)).
+			BuildUpdate()
+		assert.Equal(t, "UPDATE users SET name = $1, visits = visits + $2 WHERE id = $3", query)
+		assert.Equal(t, []interface{}{"Ann", 1, 7}, args)
+	})
+
+	t.Run("MixedWithRaw", func(t *testing.T) {
+		query, args := xsb.New().
+			Table("users").
+			Where("status = $1", "active").
+			WhereExpr(xsb.Eq("age", 3)).
+			BuildSelect()
+		assert.Equal(t, "SELECT * FROM users WHERE status = $1 AND age = $2", query)
+		assert.Equal(t, []interface{}{"active", 3}, args)
+
+		// A bound ? takes the style of the dialect like every other placeholder
+		query, args = xsb.New().
+			WithDialect(xsb.PostgreSQL).
+			Table("users").
+			Where("a = ?", 1).
+			WhereExpr(xsb.Eq("b", 2)).
+			Where("c IN (?, ?)", 3, 4).
+			BuildSelect()
+		assert.Equal(t, "SELECT * FROM users WHERE a = $1 AND b = $2 AND c IN ($3, $4)", query)
+		assert.Equal(t, []interface{}{1, 2, 3, 4}, args)
+
+		query, _ = xsb.New().
+			WithDialect(xsb.MySQL).
+			Table("users").
+			Where("a = ?", 1).
+			WhereExpr(xsb.Eq("b", 2)).
+			BuildSelect()
+		assert.Equal(t, "SELECT * FROM users WHERE a = ? AND b = ?", query)
+
+		// Raw conditions are numbered on their own; $n refers to their own arguments
+		query, args = xsb.New().
+			Table("users").
+			WhereIn("id", 1, 2).
+			Where("x = $1 OR y = $1", 5).
+			WhereExpr(xsb.Gt("age", 18)).
+			Where("z BETWEEN $2 AND $1", 9, 0).
+			BuildSelect()
+		assert.Equal(t, "SELECT * FROM users WHERE id IN ($1, $2) AND x = $3 OR y = $4 AND age > $5 AND z BETWEEN $6 AND $7", query)
+		assert.Equal(t, []interface{}{1, 2, 5, 5, 18, 0, 9}, args)
+
+		// Numbers follow the statement, not the order clauses were added in
+		query, args = xsb.New().
+			Table("orders").
+			Columns("g", "COUNT(*)").
+			GroupBy("g").
+			HavingExpr(xsb.Gt("COUNT(*)", 1)).
+			WhereExpr(xsb.Eq("a", 2)).
+			Build()
+		assert.Equal(t, "SELECT g, COUNT(*) FROM orders WHERE a = $1 GROUP BY g HAVING COUNT(*) > $2", query)
+		assert.Equal(t, []interface{}{2, 1}, args)
+
+		// Quoted text and the ? operator without arguments are left alone
+		query, args = xsb.New().
+			Table("docs").
+			Where("tags ? 'x'").
+			Where("note <> '$1' AND id = $1", 4).
+			BuildSelect()
+		assert.Equal(t, "SELECT * FROM docs WHERE tags ? 'x' AND note <> '$1' AND id = $1", query)
+		assert.Equal(t, []interface{}{4}, args)
+
+		b := xsb.New().Table("users").Where("id = $2", 1)
+		assert.Error(t, b.Error())
+	})
+
+	t.Run("BuildTwice", func(t *testing.T) {
+		b := xsb.New().
+			Table("users").
+			Set("a", 1).
+			WhereExpr(xsb.Eq("id", 5))
+		for i := 0; i < 2; i++ {
+			query, args := b.BuildUpdate()
+			assert.Equal(t, "UPDATE users SET a = $1 WHERE id = $2", query)
+			assert.Equal(t, []interface{}{1, 5}, args)
+		}
+
+		b = xsb.New().
+			Table("cte").
+			WithRecursive("cte", xsb.New().Table("t").Where("p = $1", 1)).
+			WhereExpr(xsb.Eq("id", 2))
+		for i := 0; i < 2; i++ {
+			query, args := b.BuildSelect()
+			assert.Equal(t, "WITH RECURSIVE cte AS (SELECT * FROM t WHERE p = $1) SELECT * FROM cte WHERE id = $2", query)
+			assert.Equal(t, []interface{}{1, 2}, args)
+		}
+	})
+}
@@ -0,0 +1,246 @@
+package xsb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Window is the OVER clause of a window function. Frame is written as is,
+// e.g. "ROWS BETWEEN 2 PRECEDING AND CURRENT ROW".
+type Window struct {
+	PartitionBy []string
+	OrderBy     []string
+	Frame       string
+}
+
+// windowExpr is a window function call
+type windowExpr struct {
+	fn     interface{}
+	window Window
+}
+
+func (e windowExpr) build(b *Builder) (string, []interface{}) {
+	fn, args := b.columnSQL(e.fn)
+	var clauses []string
+	if len(e.window.PartitionBy) > 0 {
+		clauses = append(clauses, "PARTITION BY "+strings.Join(e.window.PartitionBy, ", "))
+	}
+	if len(e.window.OrderBy) > 0 {
+		clauses = append(clauses, "ORDER BY "+strings.Join(e.window.OrderBy, ", "))
+	}
+	if e.window.Frame != "" {
+		clauses = append(clauses, e.window.Frame)
+	}
+	return fmt.Sprintf("%s OVER (%s)", fn, strings.Join(clauses, " ")), args
+}
+
+// Over calls the window function fn, such as "ROW_NUMBER()" or
+// Raw("SUM(amount)"), over window
+func Over(fn interface{}, window Window) Expr {
+	return windowExpr{fn: fn, window: window}
+}
+
+// caseWhen is a branch of a CASE expression
+type caseWhen struct {
+	cond   Expr
+	result interface{}
+}
+
+// CaseExpr is a CASE expression, built with Case
+type CaseExpr struct {
+	whens   []caseWhen
+	els     interface{}
+	hasElse bool
+}
+
+// Case starts a CASE expression; add branches with When
+func Case() *CaseExpr {
+	return &CaseExpr{}
+}
+
+// When adds a branch returning result for rows matching cond. The result is
+// a value, or an expression such as Raw("price * 2").
+func (c *CaseExpr) When(cond Expr, result interface{}) *CaseExpr {
+	c.whens = append(c.whens, caseWhen{cond: cond, result: result})
+	return c
+}
+
+// Else sets the result for rows matching no branch, NULL by default
+func (c *CaseExpr) Else(result interface{}) *CaseExpr {
+	c.els, c.hasElse = result, true
+	return c
+}
+
+func (c *CaseExpr) build(b *Builder) (string, []interface{}) {
+	if len(c.whens) == 0 {
+		if b.err == nil {
+			b.err = fmt.Errorf("CASE expression has no WHEN branch")
+		}
+		return "", nil
+	}
+
+	var sb strings.Builder
+	var args []interface{}
+	sb.WriteString("CASE")
+	for _, w := range c.whens {
+		cond, condArgs := w.cond.build(b)
+		result, resultArgs := caseResult(b, w.result)
+		fmt.Fprintf(&sb, " WHEN %s THEN %s", cond, result)
+		args = append(append(args, condArgs...), resultArgs...)
+	}
+	if c.hasElse {
+		result, resultArgs := caseResult(b, c.els)
+		sb.WriteString(" ELSE " + result)
+		args = append(args, resultArgs...)
+	}
+	sb.WriteString(" END")
+	return sb.String(), args
+}
+
+// caseResult renders a result of a CASE expression; nil is NULL
+func caseResult(b *Builder, result interface{}) (string, []interface{}) {
+	if result == nil {
+		return "NULL", nil
+	}
+	return b.operand(result)
+}
+
+// jsonExpr extracts a value from a JSON column
+type jsonExpr struct {
+	column string
+	path   []string
+	text   bool
+}
+
+func (e jsonExpr) build(b *Builder) (string, []interface{}) {
+	if len(e.path) == 0 {
+		if b.err == nil {
+			b.err = fmt.Errorf("JSON path of %s is empty", e.column)
+		}
+		return e.column, nil
+	}
+
+	path := quoteLiteral(jsonPath(e.path))
+	switch b.dialect {
+	case PostgreSQL:
+		var sb strings.Builder
+		sb.WriteString(e.column)
+		for i, key := range e.path {
+			if e.text && i == len(e.path)-1 {
+				sb.WriteString("->>")
+			} else {
+				sb.WriteString("->")
+			}
+			if isIndex(key) {
+				sb.WriteString(key)
+			} else {
+				sb.WriteString(quoteLiteral(key))
+			}
+		}
+		return sb.String(), nil
+	case MySQL:
+		if e.text {
+			return fmt.Sprintf("JSON_UNQUOTE(JSON_EXTRACT(%s, %s))", e.column, path), nil
+		}
+		return fmt.Sprintf("JSON_EXTRACT(%s, %s)", e.column, path), nil
+	case MSSQL:
+		if e.text {
+			return fmt.Sprintf("JSON_VALUE(%s, %s)", e.column, path), nil
+		}
+		return fmt.Sprintf("JSON_QUERY(%s, %s)", e.column, path), nil
+	default:
+		return fmt.Sprintf("json_extract(%s, %s)", e.column, path), nil
+	}
+}
+
+// JSONPath extracts the JSON value at path from a JSON column. Path elements
+// are object keys, or array indexes if they are numbers. It renders as ->
+// on PostgreSQL, JSON_EXTRACT on MySQL, JSON_QUERY on MSSQL and json_extract
+// on SQLite, which returns scalars as SQL values.
+func JSONPath(column string, path ...string) Expr {
+	return jsonExpr{column: column, path: path}
+}
+
+// JSONText extracts the value at path from a JSON column as text, for
+// comparing and sorting. It renders as ->> on PostgreSQL, JSON_UNQUOTE on
+// MySQL, JSON_VALUE on MSSQL and json_extract on SQLite.
+func JSONText(column string, path ...string) Expr {
+	return jsonExpr{column: column, path: path, text: true}
+}
+
+// jsonPath returns path in SQL/JSON path syntax, e.g. $.items[0]."unit price"
+func jsonPath(path []string) string {
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, key := range path {
+		switch {
+		case isIndex(key):
+			sb.WriteString("[" + key + "]")
+		case isIdentifier(key):
+			sb.WriteString("." + key)
+		default:
+			key = strings.ReplaceAll(strings.ReplaceAll(key, `\`, `\\`), `"`, `\"`)
+			sb.WriteString(`."` + key + `"`)
+		}
+	}
+	return sb.String()
+}
+
+// quoteLiteral quotes s as an SQL string literal
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// isIndex reports whether a path element is an array index
+func isIndex(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if !isDigit(key[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isIdentifier reports whether a path element can be written unquoted
+func isIdentifier(key string) bool {
+	if key == "" || isDigit(key[0]) {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c != '_' && !isDigit(c) && (c|0x20 < 'a' || c|0x20 > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// SelectExpr adds an expression to the selected columns, named alias if it
+// is not empty. Call Columns first to select other columns too.
+func (b *Builder) SelectExpr(e Expr, alias string) *Builder {
+	query, args := e.build(b)
+	if alias != "" {
+		query += " AS " + alias
+	}
+	b.columns = append(b.columns, query)
+	b.selectArgs = append(b.selectArgs, args...)
+	return b
+}
+
+// OrderByExpr adds an expression to the ORDER BY clause, descending if desc
+// is set
+func (b *Builder) OrderByExpr(e Expr, desc bool) *Builder {
+	query, args := e.build(b)
+	if desc {
+		query += " DESC"
+	}
+	if b.orderBy != "" {
+		query = b.orderBy + ", " + query
+	}
+	b.orderBy = query
+	b.orderArgs = append(b.orderArgs, args...)
+	return b
+}
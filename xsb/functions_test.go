@@ -0,0 +1,125 @@
+package xsb_test
+
+import (
+	"testing"
+
+	"github.com/seefs001/xox/xsb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindow(t *testing.T) {
+	query, args := xsb.New().
+		Table("employees").
+		Columns("id", "dept").
+		SelectExpr(xsb.Over("ROW_NUMBER()", xsb.Window{PartitionBy: []string{"dept"}, OrderBy: []string{"salary DESC"}}), "rank").
+		SelectExpr(xsb.Over(xsb.Raw("SUM(salary)"), xsb.Window{
+			OrderBy: []string{"hired_at"},
+			Frame:   "ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW",
+		}), "running").
+		SelectExpr(xsb.Over("COUNT(*)", xsb.Window{}), "").
+		Build()
+	assert.Equal(t, "SELECT id, dept, ROW_NUMBER() OVER (PARTITION BY dept ORDER BY salary DESC) AS rank, "+
+		"SUM(salary) OVER (ORDER BY hired_at ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW) AS running, "+
+		"COUNT(*) OVER () FROM employees", query)
+	assert.Empty(t, args)
+
+	// Functions with arguments
+	query, args = xsb.New().
+		WithDialect(xsb.MySQL).
+		Table("prices").
+		SelectExpr(xsb.Over(xsb.Raw("LAG(price, ?)", 2), xsb.Window{OrderBy: []string{"day"}}), "prev").
+		Build()
+	assert.Equal(t, "SELECT LAG(price, ?) OVER (ORDER BY day) AS prev FROM prices", query)
+	assert.Equal(t, []interface{}{2}, args)
+}
+
+func TestCase(t *testing.T) {
+	grade := xsb.Case().
+		When(xsb.Gte("score", 90), "A").
+		When(xsb.And(xsb.Gte("score", 80), xsb.IsNotNull("reviewed_at")), "B").
+		Else(xsb.Raw("grade"))
+
+	query, args := xsb.New().
+		Table("results").
+		Columns("id").
+		SelectExpr(grade, "grade").
+		WhereExpr(xsb.Eq("term", 3)).
+		OrderByExpr(xsb.Case().When(xsb.Eq("status", "open"), 0).Else(1), false).
+		OrderByExpr(xsb.Case().When(xsb.IsNull("due"), nil), true).
+		Build()
+	assert.Equal(t, "SELECT id, CASE WHEN score >= $1 THEN $2 WHEN score >= $3 AND reviewed_at IS NOT NULL THEN $4 ELSE grade END AS grade "+
+		"FROM results WHERE term = $5 "+
+		"ORDER BY CASE WHEN status = $6 THEN $7 ELSE $8 END, CASE WHEN due IS NULL THEN NULL END DESC", query)
+	assert.Equal(t, []interface{}{90, "A", 80, "B", 3, "open", 0, 1}, args)
+
+	// Placeholders follow the query even when conditions are added first
+	query, args = xsb.New().
+		Table("results").
+		WhereExpr(xsb.Eq("term", 3)).
+		SelectExpr(xsb.Case().When(xsb.Gt("score", 50), "pass").Else("fail"), "outcome").
+		Build()
+	assert.Equal(t, "SELECT CASE WHEN score > $1 THEN $2 ELSE $3 END AS outcome FROM results WHERE term = $4", query)
+	assert.Equal(t, []interface{}{50, "pass", "fail", 3}, args)
+
+	// CASE works in conditions too
+	query, args = xsb.New().
+		WithDialect(xsb.SQLite).
+		Table("orders").
+		WhereExpr(xsb.Gt(xsb.Case().When(xsb.Eq("currency", "EUR"), xsb.Raw("total * ?", 1.1)).Else(xsb.Raw("total")), 100)).
+		BuildSelect()
+	assert.Equal(t, "SELECT * FROM orders WHERE CASE WHEN currency = ? THEN total * ? ELSE total END > ?", query)
+	assert.Equal(t, []interface{}{"EUR", 1.1, 100}, args)
+
+	b := xsb.New().Table("results").SelectExpr(xsb.Case(), "x")
+	assert.Error(t, b.Error())
+
+	// Replacing the columns or order drops their arguments
+	query, args = xsb.New().Table("results").SelectExpr(grade, "grade").Count().OrderByExpr(xsb.Case().When(xsb.Eq("a", 1), 2), false).OrderBy("id").Build()
+	assert.Equal(t, "SELECT COUNT(*) FROM results ORDER BY id", query)
+	assert.Empty(t, args)
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		dialect xsb.Dialect
+		want    string
+	}{
+		{xsb.PostgreSQL, "SELECT profile->'address'->'lines'->0 AS line FROM users WHERE profile->'address'->>'city' = $1 ORDER BY profile->>'age' DESC"},
+		{xsb.MySQL, "SELECT JSON_EXTRACT(profile, '$.address.lines[0]') AS line FROM users WHERE JSON_UNQUOTE(JSON_EXTRACT(profile, '$.address.city')) = ? ORDER BY JSON_UNQUOTE(JSON_EXTRACT(profile, '$.age')) DESC"},
+		{xsb.SQLite, "SELECT json_extract(profile, '$.address.lines[0]') AS line FROM users WHERE json_extract(profile, '$.address.city') = ? ORDER BY json_extract(profile, '$.age') DESC"},
+		{xsb.MSSQL, "SELECT JSON_QUERY(profile, '$.address.lines[0]') AS line FROM users WHERE JSON_VALUE(profile, '$.address.city') = ? ORDER BY JSON_VALUE(profile, '$.age') DESC"},
+	}
+	for _, tt := range tests {
+		query, args := xsb.New().
+			WithDialect(tt.dialect).
+			Table("users").
+			SelectExpr(xsb.JSONPath("profile", "address", "lines", "0"), "line").
+			WhereExpr(xsb.Eq(xsb.JSONText("profile", "address", "city"), "Oslo")).
+			OrderByExpr(xsb.JSONText("profile", "age"), true).
+			Build()
+		assert.Equal(t, tt.want, query)
+		assert.Equal(t, []interface{}{"Oslo"}, args)
+	}
+
+	// Keys that are not identifiers are quoted
+	query, _ := xsb.New().WithDialect(xsb.MySQL).Table("t").SelectExpr(xsb.JSONText("doc", `unit "price"`, "it's"), "").Build()
+	assert.Equal(t, `SELECT JSON_UNQUOTE(JSON_EXTRACT(doc, '$."unit \"price\""."it''s"')) FROM t`, query)
+	query, _ = xsb.New().Table("t").SelectExpr(xsb.JSONText("doc", "it's"), "").Build()
+	assert.Equal(t, "SELECT doc->>'it''s' FROM t", query)
+
+	// JSON values work with the other conditions
+	query, args := xsb.New().
+		Table("users").
+		WhereExpr(xsb.Or(
+			xsb.In(xsb.JSONText("profile", "role"), "admin", "owner"),
+			xsb.IsNull(xsb.JSONPath("profile", "role")),
+			xsb.Between(xsb.JSONText("profile", "age"), 18, 30),
+		)).
+		BuildSelect()
+	assert.Equal(t, "SELECT * FROM users WHERE (profile->>'role' IN ($1, $2) OR profile->'role' IS NULL OR profile->>'age' BETWEEN $3 AND $4)", query)
+	assert.Equal(t, []interface{}{"admin", "owner", 18, 30}, args)
+
+	require.Error(t, xsb.New().Table("t").WhereExpr(xsb.Eq(xsb.JSONText("doc"), 1)).Error())
+	require.Error(t, xsb.New().Table("t").WhereExpr(xsb.Eq(42, 1)).Error())
+}
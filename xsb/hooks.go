@@ -0,0 +1,285 @@
+package xsb
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seefs001/xox/xlog"
+)
+
+// Hook observes the statements run by a Builder or Repository. BeforeQuery
+// returns the context to run the statement with, so tracing hooks can start
+// a span in it; AfterQuery receives that context. For queries returning rows
+// duration covers running the query, not reading the rows.
+type Hook interface {
+	BeforeQuery(ctx context.Context, query string, args []interface{}) context.Context
+	AfterQuery(ctx context.Context, query string, args []interface{}, duration time.Duration, err error)
+}
+
+var globalHooks struct {
+	sync.RWMutex
+	hooks []Hook
+}
+
+// AddHook registers a hook for the statements of every Builder
+func AddHook(h Hook) {
+	globalHooks.Lock()
+	defer globalHooks.Unlock()
+	globalHooks.hooks = append(globalHooks.hooks, h)
+}
+
+// RemoveHook unregisters a hook added by AddHook
+func RemoveHook(h Hook) {
+	globalHooks.Lock()
+	defer globalHooks.Unlock()
+	for i, hook := range globalHooks.hooks {
+		if hook == h {
+			globalHooks.hooks = append(globalHooks.hooks[:i:i], globalHooks.hooks[i+1:]...)
+			return
+		}
+	}
+}
+
+// WithHooks adds hooks for the statements run by this builder, after the
+// global ones
+func (b *Builder) WithHooks(hooks ...Hook) *Builder {
+	b.hooks = append(b.hooks, hooks...)
+	return b
+}
+
+// observe runs a statement with logging and hooks
+func (b *Builder) observe(query string, args []interface{}, run func(ctx context.Context) error) error {
+	if b.logSQL {
+		xlog.Debugf("[SQL] %s %v", query, args)
+	}
+
+	globalHooks.RLock()
+	hooks := make([]Hook, 0, len(globalHooks.hooks)+len(b.hooks))
+	hooks = append(hooks, globalHooks.hooks...)
+	globalHooks.RUnlock()
+	hooks = append(hooks, b.hooks...)
+
+	ctx := b.ctx
+	for _, h := range hooks {
+		if next := h.BeforeQuery(ctx, query, args); next != nil {
+			ctx = next
+		}
+	}
+	start := time.Now()
+	err := run(ctx)
+	duration := time.Since(start)
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i].AfterQuery(ctx, query, args, duration, err)
+	}
+	return err
+}
+
+// runner returns the transaction of the builder if it has one, or db
+func (b *Builder) runner(db Querier) Querier {
+	if b.tx != nil {
+		return b.tx
+	}
+	return db
+}
+
+// execContext runs a statement on db with logging and hooks
+func (b *Builder) execContext(db Querier, query string, args []interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := b.observe(query, args, func(ctx context.Context) (err error) {
+		result, err = b.runner(db).ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
+
+// queryContext runs a query on db with logging and hooks
+func (b *Builder) queryContext(db Querier, query string, args []interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := b.observe(query, args, func(ctx context.Context) (err error) {
+		rows, err = b.runner(db).QueryContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+// queryRowContext runs a single row query on db with logging and hooks
+func (b *Builder) queryRowContext(db Querier, query string, args []interface{}) *sql.Row {
+	var row *sql.Row
+	b.observe(query, args, func(ctx context.Context) error {
+		row = b.runner(db).QueryRowContext(ctx, query, args...)
+		return row.Err()
+	})
+	return row
+}
+
+// SlowQueryHook logs statements taking at least Threshold as warnings with
+// xlog, including the request ID of the context
+type SlowQueryHook struct {
+	Threshold time.Duration
+}
+
+// NewSlowQueryHook creates a hook logging statements slower than threshold
+func NewSlowQueryHook(threshold time.Duration) *SlowQueryHook {
+	return &SlowQueryHook{Threshold: threshold}
+}
+
+func (h *SlowQueryHook) BeforeQuery(ctx context.Context, query string, args []interface{}) context.Context {
+	return ctx
+}
+
+func (h *SlowQueryHook) AfterQuery(ctx context.Context, query string, args []interface{}, duration time.Duration, err error) {
+	if duration < h.Threshold {
+		return
+	}
+	attrs := []any{"duration", duration, "sql", query, "args", args}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	xlog.WarnContext(ctx, "[SQL] slow query", attrs...)
+}
+
+// QueryStat is the aggregate of the statements with the same normalized SQL
+type QueryStat struct {
+	Query  string
+	Count  int64
+	Errors int64
+	Total  time.Duration
+	Min    time.Duration
+	Max    time.Duration
+}
+
+// Avg returns the mean duration
+func (s QueryStat) Avg() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// QueryStats is a hook collecting counts and latencies by normalized SQL
+type QueryStats struct {
+	mu    sync.Mutex
+	stats map[string]*QueryStat
+}
+
+// NewQueryStats creates an empty statistics collector
+func NewQueryStats() *QueryStats {
+	return &QueryStats{stats: make(map[string]*QueryStat)}
+}
+
+func (s *QueryStats) BeforeQuery(ctx context.Context, query string, args []interface{}) context.Context {
+	return ctx
+}
+
+func (s *QueryStats) AfterQuery(ctx context.Context, query string, args []interface{}, duration time.Duration, err error) {
+	normalized := NormalizeSQL(query)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stat, ok := s.stats[normalized]
+	if !ok {
+		stat = &QueryStat{Query: normalized, Min: duration}
+		s.stats[normalized] = stat
+	}
+	stat.Count++
+	stat.Total += duration
+	stat.Min = min(stat.Min, duration)
+	stat.Max = max(stat.Max, duration)
+	if err != nil {
+		stat.Errors++
+	}
+}
+
+// Snapshot returns the statistics collected so far, by descending total duration
+func (s *QueryStats) Snapshot() []QueryStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]QueryStat, 0, len(s.stats))
+	for _, stat := range s.stats {
+		stats = append(stats, *stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Total != stats[j].Total {
+			return stats[i].Total > stats[j].Total
+		}
+		return stats[i].Query < stats[j].Query
+	})
+	return stats
+}
+
+// Reset forgets the statistics collected so far
+func (s *QueryStats) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats = make(map[string]*QueryStat)
+}
+
+var (
+	reList = regexp.MustCompile(`\?(?:\s*,\s*\?)+`)
+	reRows = regexp.MustCompile(`\(\?\)(?:\s*,\s*\(\?\))+`)
+)
+
+// NormalizeSQL reduces a statement to its shape, so statements differing only
+// in values compare equal: literals and placeholders become ?, lists of them
+// collapse into one, and whitespace is collapsed.
+func NormalizeSQL(query string) string {
+	var sb strings.Builder
+	space := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		case c == '\'':
+			// A string literal; '' is an escaped quote
+			for i++; i < len(query); i++ {
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			c = '?'
+		case c == '"' || c == '`':
+			// A quoted identifier is kept as is
+			j := len(query)
+			if end := strings.IndexByte(query[i+1:], c); end >= 0 {
+				j = i + end + 2
+			}
+			if space && sb.Len() > 0 {
+				sb.WriteByte(' ')
+			}
+			space = false
+			sb.WriteString(query[i:j])
+			i = j - 1
+			continue
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]),
+			isDigit(c) && (i == 0 || !isIdentChar(query[i-1])):
+			for i+1 < len(query) && (isDigit(query[i+1]) || query[i+1] == '.') {
+				i++
+			}
+			c = '?'
+		}
+		if space && sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+		sb.WriteByte(c)
+	}
+
+	normalized := reList.ReplaceAllString(sb.String(), "?")
+	return reRows.ReplaceAllString(normalized, "(?)")
+}
+
+// isIdentChar reports whether c can be part of an identifier
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || (c|0x20 >= 'a' && c|0x20 <= 'z')
+}
@@ -0,0 +1,162 @@
+package xsb_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xlog"
+	"github.com/seefs001/xox/xsb"
+	"github.com/seefs001/xox/xsb/internal/fakedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type spanKey struct{}
+
+// recordingHook records the hook calls and tags the context with its name
+type recordingHook struct {
+	name  string
+	calls *[]string
+	errs  []error
+}
+
+func (h *recordingHook) BeforeQuery(ctx context.Context, query string, args []interface{}) context.Context {
+	*h.calls = append(*h.calls, h.name+" before "+query)
+	return context.WithValue(ctx, spanKey{}, h.name)
+}
+
+func (h *recordingHook) AfterQuery(ctx context.Context, query string, args []interface{}, duration time.Duration, err error) {
+	span, _ := ctx.Value(spanKey{}).(string)
+	*h.calls = append(*h.calls, h.name+" after "+query+" in "+span)
+	h.errs = append(h.errs, err)
+}
+
+func TestHooks(t *testing.T) {
+	db, fake := fakedb.Open(t)
+	fake.Respond("SELECT id, name FROM items", []string{"id", "name"}, []driver.Value{int64(1), "a"})
+
+	var calls []string
+	global := &recordingHook{name: "global", calls: &calls}
+	local := &recordingHook{name: "local", calls: &calls}
+	xsb.AddHook(global)
+	defer xsb.RemoveHook(global)
+
+	rows, err := xsb.New().Table("items").Columns("id", "name").WithHooks(local).Query(db)
+	require.NoError(t, err)
+	rows.Close()
+	assert.Equal(t, []string{
+		"global before SELECT id, name FROM items",
+		"local before SELECT id, name FROM items",
+		"local after SELECT id, name FROM items in local",
+		"global after SELECT id, name FROM items in local",
+	}, calls)
+
+	// Clones keep the hooks of the builder
+	calls = nil
+	b := xsb.New().Table("items").Set("name", "b").Where("id = $1", 1).WithHooks(local)
+	_, err = b.Clone().Exec(db)
+	require.NoError(t, err)
+	assert.Len(t, calls, 4)
+
+	// Repositories and batch inserts run through the hooks
+	xsb.RemoveHook(global)
+	calls = nil
+	repo := xsb.NewRepository[item](db, "items").WithHooks(local)
+	_, err = repo.FindByID(1)
+	require.NoError(t, err)
+	require.NoError(t, repo.Delete(1))
+	_, err = xsb.New().WithDialect(xsb.MySQL).Table("items").InsertMany([]item{{Name: "x"}}).WithHooks(local).ExecInsertMany(db)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"local before SELECT id, name FROM items WHERE id = $1",
+		"local after SELECT id, name FROM items WHERE id = $1 in local",
+		"local before DELETE FROM items WHERE id = $1",
+		"local after DELETE FROM items WHERE id = $1 in local",
+		"local before INSERT INTO items (name) VALUES (?)",
+		"local after INSERT INTO items (name) VALUES (?) in local",
+	}, calls)
+
+	// Errors reach AfterQuery; QueryRow reports query errors, not sql.ErrNoRows
+	local.errs = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = xsb.New().Table("items").Columns("id").WithContext(ctx).WithHooks(local).Query(db)
+	require.Error(t, err)
+	var id int64
+	err = xsb.New().Table("items").Columns("id").Where("id = ?", 9).WithHooks(local).QueryRow(db).Scan(&id)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	require.Len(t, local.errs, 2)
+	assert.ErrorIs(t, local.errs[0], context.Canceled)
+	assert.NoError(t, local.errs[1])
+}
+
+func TestSlowQueryHook(t *testing.T) {
+	var buf bytes.Buffer
+	xlog.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer xlog.SetLogConfig(xlog.LogConfig{IncludeFileAndLine: true, Level: slog.LevelDebug})
+
+	hook := xsb.NewSlowQueryHook(100 * time.Millisecond)
+	ctx := xlog.WithReqID(context.Background(), "req-42")
+	hook.AfterQuery(ctx, "SELECT 1", nil, 10*time.Millisecond, nil)
+	assert.Empty(t, buf.String())
+
+	hook.AfterQuery(ctx, "SELECT * FROM big", []interface{}{1}, 250*time.Millisecond, nil)
+	out := buf.String()
+	assert.Contains(t, out, "level=WARN")
+	assert.Contains(t, out, "slow query")
+	assert.Contains(t, out, "SELECT * FROM big")
+	assert.Contains(t, out, "duration=250ms")
+	assert.Contains(t, out, "req_id=req-42")
+}
+
+func TestQueryStats(t *testing.T) {
+	db, _ := fakedb.Open(t)
+	stats := xsb.NewQueryStats()
+
+	for _, ids := range [][]interface{}{{1}, {1, 2, 3}} {
+		_, err := xsb.New().Table("items").Columns("id").WhereIn("id", ids...).WithHooks(stats).Query(db)
+		require.NoError(t, err)
+	}
+	_, err := xsb.New().Table("items").Set("name", "x").Where("id = $1", 1).WithHooks(stats).Exec(db)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = xsb.New().Table("items").Set("name", "x").Where("id = $1", 2).WithContext(ctx).WithHooks(stats).Exec(db)
+	require.Error(t, err)
+
+	byQuery := make(map[string]xsb.QueryStat)
+	for _, s := range stats.Snapshot() {
+		byQuery[s.Query] = s
+	}
+	require.Len(t, byQuery, 2)
+	sel := byQuery["SELECT id FROM items WHERE id IN (?)"]
+	assert.Equal(t, int64(2), sel.Count)
+	assert.Zero(t, sel.Errors)
+	assert.LessOrEqual(t, sel.Min, sel.Max)
+	assert.Equal(t, sel.Total/2, sel.Avg())
+	upd := byQuery["UPDATE items SET name = ? WHERE id = ?"]
+	assert.Equal(t, int64(2), upd.Count)
+	assert.Equal(t, int64(1), upd.Errors)
+
+	stats.Reset()
+	assert.Empty(t, stats.Snapshot())
+}
+
+func TestNormalizeSQL(t *testing.T) {
+	tests := map[string]string{
+		"SELECT * FROM t WHERE id = 42":                             "SELECT * FROM t WHERE id = ?",
+		"SELECT  *\n\tFROM t WHERE name = 'it''s' AND x = -1.5":     "SELECT * FROM t WHERE name = ? AND x = -?",
+		"SELECT a1, t2.b FROM t2 WHERE c IN ($1, $2, $3)":           "SELECT a1, t2.b FROM t2 WHERE c IN (?)",
+		"INSERT INTO t (a, b) VALUES (?, ?), (?, ?), (?, ?)":        "INSERT INTO t (a, b) VALUES (?)",
+		`SELECT "col 1", ` + "`x 2`" + ` FROM t LIMIT 10 OFFSET 20`: `SELECT "col 1", ` + "`x 2`" + ` FROM t LIMIT ? OFFSET ?`,
+	}
+	for query, want := range tests {
+		assert.Equal(t, want, xsb.NormalizeSQL(query), query)
+	}
+}
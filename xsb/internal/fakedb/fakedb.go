@@ -0,0 +1,205 @@
+// Package fakedb is a database/sql driver for tests. It records the
+// statements it runs and answers them from canned results or a handler.
+package fakedb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Stmt is a statement run against a DB. Transactions are logged as BEGIN,
+// COMMIT and ROLLBACK.
+type Stmt struct {
+	Query string
+	Args  []driver.Value
+}
+
+// Result is what a DB answers to a statement. Err fails the statement.
+type Result struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	LastID       int64
+	RowsAffected int64
+	Err          error
+}
+
+// response is a canned result for statements containing match
+type response struct {
+	match  string
+	result Result
+}
+
+// DB is a fake database. A statement without a matching result returns no
+// rows and affects one.
+type DB struct {
+	mu        sync.Mutex
+	log       []Stmt
+	responses []response
+	handler   func(query string, args []driver.Value) Result
+}
+
+// Handle answers every statement with fn, including BEGIN, COMMIT and
+// ROLLBACK. Calls to fn are serialized.
+func (db *DB) Handle(fn func(query string, args []driver.Value) Result) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.handler = fn
+}
+
+// Respond adds a canned result for statements containing match
+func (db *DB) Respond(match string, columns []string, rows ...[]driver.Value) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.responses = append(db.responses, response{match: match, result: Result{Columns: columns, Rows: rows, RowsAffected: 1}})
+}
+
+// RespondID makes statements containing match report lastID as the inserted id
+func (db *DB) RespondID(match string, lastID int64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.responses = append(db.responses, response{match: match, result: Result{LastID: lastID, RowsAffected: 1}})
+}
+
+// Statements returns the statements run so far and forgets them
+func (db *DB) Statements() []Stmt {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	log := db.log
+	db.log = nil
+	return log
+}
+
+func (db *DB) run(query string, args []driver.Value) Result {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.log = append(db.log, Stmt{Query: query, Args: args})
+	if db.handler != nil {
+		return db.handler(query, args)
+	}
+	for _, r := range db.responses {
+		if strings.Contains(query, r.match) {
+			return r.result
+		}
+	}
+	return Result{RowsAffected: 1}
+}
+
+type fakeDriver struct {
+	mu  sync.Mutex
+	dbs map[string]*DB
+}
+
+var drv = &fakeDriver{dbs: make(map[string]*DB)}
+
+func init() {
+	sql.Register("fakedb", drv)
+}
+
+// db returns the database called name, creating it if needed
+func (d *fakeDriver) db(name string) *DB {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	db, ok := d.dbs[name]
+	if !ok {
+		db = &DB{}
+		d.dbs[name] = db
+	}
+	return db
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{db: d.db(name)}, nil
+}
+
+// Open opens a fake database private to the test
+func Open(t testing.TB) (*sql.DB, *DB) {
+	db, err := sql.Open("fakedb", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, drv.db(t.Name())
+}
+
+type fakeConn struct {
+	db *DB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	if r := c.db.run("BEGIN", nil); r.Err != nil {
+		return nil, r.Err
+	}
+	return &fakeTx{db: c.db}, nil
+}
+
+type fakeTx struct {
+	db *DB
+}
+
+func (tx *fakeTx) Commit() error {
+	return tx.db.run("COMMIT", nil).Err
+}
+
+func (tx *fakeTx) Rollback() error {
+	return tx.db.run("ROLLBACK", nil).Err
+}
+
+type fakeStmt struct {
+	db    *DB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	r := s.db.run(s.query, args)
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return fakeResult{lastID: r.LastID, rowsAffected: r.RowsAffected}, nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	r := s.db.run(s.query, args)
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return &fakeRows{cols: r.Columns, values: r.Rows}, nil
+}
+
+type fakeResult struct {
+	lastID       int64
+	rowsAffected int64
+}
+
+func (r fakeResult) LastInsertId() (int64, error) { return r.lastID, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+type fakeRows struct {
+	cols   []string
+	values [][]driver.Value
+	pos    int
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.pos])
+	r.pos++
+	return nil
+}
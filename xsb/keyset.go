@@ -0,0 +1,287 @@
+package xsb
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// keysetColumn is a column of a keyset and its sort direction
+type keysetColumn struct {
+	name string
+	desc bool
+}
+
+// parseKeyset splits column specs such as "created_at DESC" into names and directions
+func parseKeyset(columns []string) []keysetColumn {
+	keyset := make([]keysetColumn, len(columns))
+	for i, column := range columns {
+		fields := strings.Fields(column)
+		if len(fields) == 0 {
+			continue
+		}
+		keyset[i].name = fields[0]
+		if len(fields) > 1 {
+			keyset[i].desc = strings.EqualFold(fields[len(fields)-1], "DESC")
+		}
+	}
+	return keyset
+}
+
+// keysetExpr matches the rows that sort after values in the order of columns
+type keysetExpr struct {
+	columns []keysetColumn
+	values  []interface{}
+}
+
+func (e keysetExpr) build(b *Builder) (string, []interface{}) {
+	op := func(c keysetColumn) string {
+		if c.desc {
+			return "<"
+		}
+		return ">"
+	}
+
+	if e.rowValues(b.dialect) {
+		names := make([]string, len(e.columns))
+		placeholders := make([]string, len(e.columns))
+		for i, c := range e.columns {
+			names[i] = c.name
+			placeholders[i] = b.placeholder()
+		}
+		if len(e.columns) == 1 {
+			return fmt.Sprintf("%s %s %s", names[0], op(e.columns[0]), placeholders[0]), e.values
+		}
+		return fmt.Sprintf("(%s) %s (%s)", strings.Join(names, ", "), op(e.columns[0]), strings.Join(placeholders, ", ")), e.values
+	}
+
+	// (a > ?) OR (a = ? AND b < ?) OR ...
+	var terms []string
+	var args []interface{}
+	for i, c := range e.columns {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, fmt.Sprintf("%s = %s", e.columns[j].name, b.placeholder()))
+			args = append(args, e.values[j])
+		}
+		parts = append(parts, fmt.Sprintf("%s %s %s", c.name, op(c), b.placeholder()))
+		args = append(args, e.values[i])
+		terms = append(terms, "("+strings.Join(parts, " AND ")+")")
+	}
+	return strings.Join(terms, " OR "), args
+}
+
+// rowValues reports whether the comparison can use row values. They compare
+// column by column, which matches the sort order when every column sorts the
+// same way. MSSQL has no row values.
+func (e keysetExpr) rowValues(dialect Dialect) bool {
+	if len(e.columns) == 1 {
+		return true
+	}
+	for _, c := range e.columns[1:] {
+		if c.desc != e.columns[0].desc {
+			return false
+		}
+	}
+	return dialect != MSSQL
+}
+
+// After continues a keyset pagination from the row with the given values of
+// columns, which may end in ASC or DESC. Only rows sorting after it match,
+// and unless an order is already set the query is ordered by columns. Nil
+// values start at the first row.
+func (b *Builder) After(columns []string, values []interface{}) *Builder {
+	if len(columns) == 0 {
+		b.err = fmt.Errorf("keyset pagination needs at least one column")
+		return b
+	}
+	if b.orderBy == "" {
+		b.orderBy = strings.Join(columns, ", ")
+	}
+	if values == nil {
+		return b
+	}
+	if len(values) != len(columns) {
+		b.err = fmt.Errorf("keyset pagination got %d values for %d columns", len(values), len(columns))
+		return b
+	}
+
+	e := keysetExpr{columns: parseKeyset(columns), values: values}
+	expanded := !e.rowValues(b.dialect)
+	query, args := e.build(b)
+	if expanded {
+		query = "(" + query + ")"
+	}
+	b.groupWhere()
+	return b.Where(query, args...)
+}
+
+// groupWhere parenthesizes the WHERE clause, so that a condition ANDed to it
+// narrows every row it matches even if it ends in an OrWhere
+func (b *Builder) groupWhere() {
+	if b.whereClause != "" {
+		b.whereClause = "(" + b.whereClause + ")"
+	}
+}
+
+// AfterCursor is After with values decoded from a token made by EncodeCursor.
+// An empty token starts at the first row.
+func (b *Builder) AfterCursor(columns []string, token string) *Builder {
+	if token == "" {
+		return b.After(columns, nil)
+	}
+	values, err := DecodeCursor(token)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	return b.After(columns, values)
+}
+
+// cursorValue is a value in a cursor token, tagged with its type where JSON
+// would lose it
+type cursorValue struct {
+	Type  string      `json:"t,omitempty"`
+	Value interface{} `json:"v"`
+}
+
+// EncodeCursor encodes the keyset values of the last row of a page as an
+// opaque token for APIs. Integers, floats, strings, booleans, times, byte
+// slices and nil are supported.
+func EncodeCursor(values ...interface{}) (string, error) {
+	encoded := make([]cursorValue, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case time.Time:
+			encoded[i] = cursorValue{Type: "time", Value: v.Format(time.RFC3339Nano)}
+		case []byte:
+			encoded[i] = cursorValue{Type: "bytes", Value: base64.StdEncoding.EncodeToString(v)}
+		case nil, string, bool,
+			int, int8, int16, int32, int64,
+			uint, uint8, uint16, uint32, uint64,
+			float32, float64:
+			encoded[i] = cursorValue{Value: v}
+		default:
+			return "", fmt.Errorf("unsupported cursor value %T", value)
+		}
+	}
+
+	data, err := json.Marshal(encoded)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeCursor decodes a token made by EncodeCursor. Integers decode as int64.
+func DecodeCursor(token string) ([]interface{}, error) {
+	invalid := fmt.Errorf("invalid cursor %q", token)
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, invalid
+	}
+	var encoded []cursorValue
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	if err := dec.Decode(&encoded); err != nil {
+		return nil, invalid
+	}
+
+	values := make([]interface{}, len(encoded))
+	for i, e := range encoded {
+		switch e.Type {
+		case "time":
+			s, _ := e.Value.(string)
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, invalid
+			}
+			values[i] = t
+		case "bytes":
+			s, _ := e.Value.(string)
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, invalid
+			}
+			values[i] = b
+		case "":
+			if n, ok := e.Value.(json.Number); ok {
+				if v, err := n.Int64(); err == nil {
+					values[i] = v
+				} else if f, err := n.Float64(); err == nil {
+					values[i] = f
+				} else {
+					return nil, invalid
+				}
+				continue
+			}
+			values[i] = e.Value
+		default:
+			return nil, invalid
+		}
+	}
+	return values, nil
+}
+
+// Chunk walks the rows matched by b in order of the id column, size rows at
+// a time. See ChunkBy.
+func (b *Builder) Chunk(db Querier, size int, fn func(rows *sql.Rows) error) error {
+	return b.ChunkBy(db, "id", size, fn)
+}
+
+// ChunkBy walks the rows matched by b in order of the unique column key,
+// calling fn with each chunk of at most size rows. Each chunk is found by
+// key rather than OFFSET, so large tables are walked at constant cost per
+// chunk. The last call may receive no rows.
+func (b *Builder) ChunkBy(db Querier, key string, size int, fn func(rows *sql.Rows) error) error {
+	if size <= 0 {
+		return fmt.Errorf("chunk size must be positive, got %d", size)
+	}
+	if b.err != nil {
+		return b.err
+	}
+
+	var last interface{}
+	started := false
+	for {
+		// The key of the last row of this chunk, if it is a full one
+		bound := b.Clone().Columns(key).OrderBy(key).Limit(1).Offset(size - 1)
+		bound.groupWhere()
+		if started {
+			bound.WhereExpr(Gt(key, last))
+		}
+		query, args := bound.BuildSelect()
+		var next interface{}
+		err := b.queryRowContext(db, query, args).Scan(&next)
+		final := errors.Is(err, sql.ErrNoRows)
+		if err != nil && !final {
+			return err
+		}
+
+		chunk := b.Clone().OrderBy(key)
+		chunk.limit, chunk.offset = 0, 0
+		chunk.groupWhere()
+		if started {
+			chunk.WhereExpr(Gt(key, last))
+		}
+		if !final {
+			chunk.WhereExpr(Lte(key, next))
+		}
+		query, args = chunk.BuildSelect()
+		rows, err := b.queryContext(db, query, args)
+		if err != nil {
+			return err
+		}
+		err = fn(rows)
+		rows.Close()
+		if err != nil || final {
+			return err
+		}
+		last, started = next, true
+	}
+}
@@ -0,0 +1,271 @@
+package xsb_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xsb"
+	"github.com/seefs001/xox/xsb/internal/fakedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAfter(t *testing.T) {
+	query, args := xsb.New().
+		Table("users").
+		Columns("id", "name").
+		WhereExpr(xsb.Eq("active", true)).
+		After([]string{"id"}, []interface{}{int64(40)}).
+		Limit(20).
+		Build()
+	assert.Equal(t, "SELECT id, name FROM users WHERE (active = $1) AND id > $2 ORDER BY id LIMIT 20", query)
+	assert.Equal(t, []interface{}{true, int64(40)}, args)
+
+	// The keyset condition applies to every row of an OrWhere
+	query, args = xsb.New().
+		Table("users").
+		Columns("id").
+		Where("role = ?", "admin").
+		OrWhere("role = ?", "owner").
+		After([]string{"id"}, []interface{}{int64(40)}).
+		Build()
+	assert.Equal(t, "SELECT id FROM users WHERE (role = $1 OR role = $2) AND id > $3 ORDER BY id", query)
+	assert.Equal(t, []interface{}{"admin", "owner", int64(40)}, args)
+
+	at := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		dialect xsb.Dialect
+		columns []string
+		want    string
+		args    []interface{}
+	}{
+		{xsb.PostgreSQL, []string{"created_at DESC", "id DESC"},
+			"SELECT * FROM posts WHERE (created_at, id) < ($1, $2) ORDER BY created_at DESC, id DESC",
+			[]interface{}{at, 7}},
+		{xsb.SQLite, []string{"created_at", "id ASC"},
+			"SELECT * FROM posts WHERE (created_at, id) > (?, ?) ORDER BY created_at, id ASC",
+			[]interface{}{at, 7}},
+		{xsb.MySQL, []string{"created_at DESC", "id"},
+			"SELECT * FROM posts WHERE ((created_at < ?) OR (created_at = ? AND id > ?)) ORDER BY created_at DESC, id",
+			[]interface{}{at, at, 7}},
+		{xsb.MSSQL, []string{"created_at", "id"},
+			"SELECT * FROM posts WHERE ((created_at > ?) OR (created_at = ? AND id > ?)) ORDER BY created_at, id",
+			[]interface{}{at, at, 7}},
+	}
+	for _, tt := range tests {
+		query, args := xsb.New().
+			WithDialect(tt.dialect).
+			Table("posts").
+			Columns("*").
+			After(tt.columns, []interface{}{at, 7}).
+			Build()
+		assert.Equal(t, tt.want, query)
+		assert.Equal(t, tt.args, args)
+	}
+
+	// Nil values start at the first row; an explicit order is kept
+	query, args = xsb.New().Table("posts").Columns("*").OrderBy("id DESC").After([]string{"id DESC"}, nil).Build()
+	assert.Equal(t, "SELECT * FROM posts ORDER BY id DESC", query)
+	assert.Empty(t, args)
+
+	assert.Error(t, xsb.New().Table("posts").After([]string{"a", "b"}, []interface{}{1}).Error())
+	assert.Error(t, xsb.New().Table("posts").After(nil, nil).Error())
+}
+
+func TestCursor(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 30, 0, 500, time.UTC)
+	token, err := xsb.EncodeCursor(at, 42, "b/c", []byte{0, 1}, nil, 1.5, true)
+	require.NoError(t, err)
+	assert.NotContains(t, token, "b/c", "tokens are opaque")
+
+	values, err := xsb.DecodeCursor(token)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{at, int64(42), "b/c", []byte{0, 1}, nil, 1.5, true}, values)
+
+	_, err = xsb.EncodeCursor(struct{}{})
+	assert.Error(t, err)
+	for _, bad := range []string{"%%%", "bm90IGpzb24", "W3sidCI6Inh4IiwidiI6MX1d"} {
+		_, err = xsb.DecodeCursor(bad)
+		assert.Error(t, err, bad)
+	}
+
+	// AfterCursor is After with the decoded values
+	token, err = xsb.EncodeCursor(int64(40))
+	require.NoError(t, err)
+	query, args := xsb.New().Table("users").Columns("id").AfterCursor([]string{"id"}, token).Build()
+	assert.Equal(t, "SELECT id FROM users WHERE id > $1 ORDER BY id", query)
+	assert.Equal(t, []interface{}{int64(40)}, args)
+
+	query, _ = xsb.New().Table("users").Columns("id").AfterCursor([]string{"id"}, "").Build()
+	assert.Equal(t, "SELECT id FROM users ORDER BY id", query)
+	assert.Error(t, xsb.New().Table("users").AfterCursor([]string{"id"}, "bogus!").Error())
+}
+
+var (
+	limitPattern  = regexp.MustCompile(`LIMIT (\d+)`)
+	offsetPattern = regexp.MustCompile(`OFFSET (\d+)`)
+)
+
+// itemsTable answers queries on a table of items with ids 1 to n, filtered
+// by "id > ?" and "id <= ?" conditions, LIMIT and OFFSET
+func itemsTable(n int64) func(query string, args []driver.Value) fakedb.Result {
+	return func(query string, args []driver.Value) fakedb.Result {
+		lo, hi := int64(0), int64(math.MaxInt64)
+		next := 0
+		if strings.Contains(query, "id > ") {
+			lo = args[next].(int64)
+			next++
+		}
+		if strings.Contains(query, "id <= ") {
+			hi = args[next].(int64)
+		}
+
+		var rows [][]driver.Value
+		for id := lo + 1; id <= n && id <= hi; id++ {
+			rows = append(rows, []driver.Value{id, fmt.Sprintf("item %d", id)})
+		}
+		if m := offsetPattern.FindStringSubmatch(query); m != nil {
+			offset, _ := strconv.Atoi(m[1])
+			rows = rows[min(offset, len(rows)):]
+		}
+		if m := limitPattern.FindStringSubmatch(query); m != nil {
+			limit, _ := strconv.Atoi(m[1])
+			rows = rows[:min(limit, len(rows))]
+		}
+
+		if strings.HasPrefix(query, "SELECT id FROM") {
+			for i := range rows {
+				rows[i] = rows[i][:1]
+			}
+			return fakedb.Result{Columns: []string{"id"}, Rows: rows}
+		}
+		return fakedb.Result{Columns: []string{"id", "name"}, Rows: rows}
+	}
+}
+
+type item struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestChunk(t *testing.T) {
+	db, fake := fakedb.Open(t)
+	fake.Handle(itemsTable(5))
+
+	var chunks [][]int64
+	err := xsb.New().Table("items").Columns("id", "name").Chunk(db, 2, func(rows *sql.Rows) error {
+		var items []item
+		if err := xsb.ScanAll(rows, &items); err != nil {
+			return err
+		}
+		var ids []int64
+		for _, it := range items {
+			ids = append(ids, it.ID)
+		}
+		chunks = append(chunks, ids)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, [][]int64{{1, 2}, {3, 4}, {5}}, chunks)
+
+	var queries []string
+	for _, stmt := range fake.Statements() {
+		queries = append(queries, stmt.Query)
+	}
+	assert.Equal(t, []string{
+		"SELECT id FROM items ORDER BY id LIMIT 1 OFFSET 1",
+		"SELECT id, name FROM items WHERE id <= $1 ORDER BY id",
+		"SELECT id FROM items WHERE id > $1 ORDER BY id LIMIT 1 OFFSET 1",
+		"SELECT id, name FROM items WHERE id > $1 AND id <= $2 ORDER BY id",
+		"SELECT id FROM items WHERE id > $1 ORDER BY id LIMIT 1 OFFSET 1",
+		"SELECT id, name FROM items WHERE id > $1 ORDER BY id",
+	}, queries)
+
+	// The chunk bounds apply to every row of an OrWhere
+	db, fake = fakedb.Open(t)
+	fake.Handle(itemsTable(3))
+	err = xsb.New().
+		Table("items").
+		Columns("id", "name").
+		Where("name IS NOT NULL").
+		OrWhere("name = ''").
+		Chunk(db, 2, func(rows *sql.Rows) error { return nil })
+	require.NoError(t, err)
+	queries = nil
+	for _, stmt := range fake.Statements() {
+		queries = append(queries, stmt.Query)
+	}
+	assert.Equal(t, []string{
+		"SELECT id FROM items WHERE (name IS NOT NULL OR name = '') ORDER BY id LIMIT 1 OFFSET 1",
+		"SELECT id, name FROM items WHERE (name IS NOT NULL OR name = '') AND id <= $1 ORDER BY id",
+		"SELECT id FROM items WHERE (name IS NOT NULL OR name = '') AND id > $1 ORDER BY id LIMIT 1 OFFSET 1",
+		"SELECT id, name FROM items WHERE (name IS NOT NULL OR name = '') AND id > $1 ORDER BY id",
+	}, queries)
+
+	stop := errors.New("stop")
+	err = xsb.New().Table("items").Columns("id").Chunk(db, 2, func(rows *sql.Rows) error { return stop })
+	assert.ErrorIs(t, err, stop)
+	assert.Error(t, xsb.New().Table("items").Chunk(db, 0, nil))
+}
+
+func TestRepository_Chunk(t *testing.T) {
+	db, fake := fakedb.Open(t)
+	fake.Handle(itemsTable(5))
+	repo := xsb.NewRepository[item](db, "items")
+
+	var sizes []int
+	err := repo.Chunk(nil, 2, func(items []item) error {
+		sizes = append(sizes, len(items))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 2, 1}, sizes)
+
+	var queries []string
+	for _, stmt := range fake.Statements() {
+		queries = append(queries, stmt.Query)
+	}
+	assert.Equal(t, []string{
+		"SELECT id, name FROM items ORDER BY id LIMIT 2",
+		"SELECT id, name FROM items WHERE id > $1 ORDER BY id LIMIT 2",
+		"SELECT id, name FROM items WHERE id > $1 ORDER BY id LIMIT 2",
+	}, queries)
+
+	db, fake = fakedb.Open(t)
+	fake.Handle(itemsTable(3))
+	repo = xsb.NewRepository[item](db, "items")
+	filter := func(b *xsb.Builder) *xsb.Builder {
+		return b.Where("name IS NOT NULL").OrWhere("name = ''")
+	}
+	require.NoError(t, repo.Chunk(filter, 2, func(items []item) error { return nil }))
+	queries = nil
+	for _, stmt := range fake.Statements() {
+		queries = append(queries, stmt.Query)
+	}
+	assert.Equal(t, []string{
+		"SELECT id, name FROM items WHERE name IS NOT NULL OR name = '' ORDER BY id LIMIT 2",
+		"SELECT id, name FROM items WHERE (name IS NOT NULL OR name = '') AND id > $1 ORDER BY id LIMIT 2",
+	}, queries)
+
+	var names []string
+	for it, err := range repo.Iter(nil, 2) {
+		require.NoError(t, err)
+		names = append(names, it.Name)
+		if len(names) == 3 {
+			break
+		}
+	}
+	assert.Equal(t, []string{"item 1", "item 2", "item 3"}, names)
+
+	for _, err := range repo.Iter(nil, 0) {
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,98 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/seefs001/xox/xcli"
+)
+
+// Command returns an xcli command named "migrate" with the subcommands up,
+// down, to <version> and status, which print their results to out:
+//
+//	app.AddCommand(migrator.Command(os.Stdout))
+func (m *Migrator) Command(out io.Writer) *xcli.Command {
+	// printVersion reports the version reached by a subcommand
+	printVersion := func(ctx context.Context) error {
+		version, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "version %d\n", version)
+		return nil
+	}
+
+	return &xcli.Command{
+		Name:        "migrate",
+		Description: "Apply or revert schema migrations",
+		Run: func(ctx context.Context, cmd *xcli.Command, args []string) error {
+			return fmt.Errorf("usage: migrate up|down|to <version>|status")
+		},
+		SubCommands: map[string]*xcli.Command{
+			"up": {
+				Name:        "up",
+				Description: "Apply all pending migrations",
+				Run: func(ctx context.Context, cmd *xcli.Command, args []string) error {
+					if err := m.Up(ctx); err != nil {
+						return err
+					}
+					return printVersion(ctx)
+				},
+			},
+			"down": {
+				Name:        "down",
+				Description: "Revert the latest applied migration",
+				Run: func(ctx context.Context, cmd *xcli.Command, args []string) error {
+					if err := m.Down(ctx); err != nil {
+						return err
+					}
+					return printVersion(ctx)
+				},
+			},
+			"to": {
+				Name:        "to",
+				Description: "Migrate up or down to a version",
+				Run: func(ctx context.Context, cmd *xcli.Command, args []string) error {
+					if len(args) != 1 {
+						return fmt.Errorf("usage: migrate to <version>")
+					}
+					version, err := strconv.ParseInt(args[0], 10, 64)
+					if err != nil {
+						return fmt.Errorf("invalid version %q", args[0])
+					}
+					if err := m.To(ctx, version); err != nil {
+						return err
+					}
+					return printVersion(ctx)
+				},
+			},
+			"status": {
+				Name:        "status",
+				Description: "List migrations and whether they are applied",
+				Run: func(ctx context.Context, cmd *xcli.Command, args []string) error {
+					statuses, err := m.Status(ctx)
+					if err != nil {
+						return err
+					}
+					w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+					for _, s := range statuses {
+						state := "pending"
+						switch {
+						case s.Missing:
+							state = "applied " + s.AppliedAt.Format(time.RFC3339) + " (missing)"
+						case s.Applied:
+							state = "applied " + s.AppliedAt.Format(time.RFC3339)
+						}
+						fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, state)
+					}
+					return w.Flush()
+				},
+			},
+		},
+	}
+}
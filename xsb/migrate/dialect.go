@@ -0,0 +1,143 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/seefs001/xox/xlog"
+	"github.com/seefs001/xox/xsb"
+)
+
+// lockPollInterval is how often a held lock is retried
+const lockPollInterval = 100 * time.Millisecond
+
+// placeholder returns the n-th query parameter in the style xsb uses
+func placeholder(dialect xsb.Dialect, n int) string {
+	if dialect == xsb.PostgreSQL {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+// createTable creates the migrations table if it does not exist
+func (m *Migrator) createTable(ctx context.Context, conn *sql.Conn) error {
+	err := xsb.CreateTable(m.options.Table).
+		WithDialect(m.dialect).
+		IfNotExists().
+		Column("version", xsb.BigInt, xsb.PrimaryKey()).
+		Column("name", xsb.Varchar(255), xsb.NotNull()).
+		Column("applied_at", xsb.Timestamp, xsb.NotNull()).
+		Exec(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+	return nil
+}
+
+// lockName identifies the lock of a migrations table
+func lockName(table string) string {
+	return "xsb_migrate:" + table
+}
+
+// lockTable is the table SQLite, which has no advisory locks, uses as a lock.
+// A process that dies while migrating leaves its row behind; deleting it
+// releases the lock.
+func lockTable(table string) string {
+	return table + "_lock"
+}
+
+// lock acquires the migration lock on conn, waiting up to LockTimeout for
+// another process to release it. The lock belongs to the session, so it must
+// be released on the same connection.
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) error {
+	if m.dialect == xsb.SQLite {
+		err := xsb.CreateTable(lockTable(m.options.Table)).
+			WithDialect(xsb.SQLite).
+			IfNotExists().
+			Column("id", xsb.Integer, xsb.PrimaryKey()).
+			Column("locked_at", xsb.Timestamp, xsb.NotNull()).
+			Exec(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("failed to create lock table: %w", err)
+		}
+	}
+
+	deadline := time.Now().Add(m.options.LockTimeout)
+	for {
+		ok, err := m.tryLock(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("failed to lock migrations: %w", err)
+		}
+		if ok {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return ErrLocked
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// tryLock attempts to take the migration lock without waiting
+func (m *Migrator) tryLock(ctx context.Context, conn *sql.Conn) (bool, error) {
+	name := lockName(m.options.Table)
+
+	switch m.dialect {
+	case xsb.MySQL:
+		var ok sql.NullInt64
+		err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", name).Scan(&ok)
+		return ok.Valid && ok.Int64 == 1, err
+	case xsb.SQLite:
+		query := fmt.Sprintf("INSERT OR IGNORE INTO %s (id, locked_at) VALUES (1, ?)", lockTable(m.options.Table))
+		result, err := conn.ExecContext(ctx, query, time.Now().UTC())
+		if err != nil {
+			return false, err
+		}
+		n, err := result.RowsAffected()
+		return n == 1, err
+	case xsb.MSSQL:
+		var status int
+		query := "DECLARE @result int; EXEC @result = sp_getapplock @Resource = ?, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = 0; SELECT @result"
+		err := conn.QueryRowContext(ctx, query, name).Scan(&status)
+		return status >= 0, err
+	default:
+		var ok bool
+		err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", advisoryKey(name)).Scan(&ok)
+		return ok, err
+	}
+}
+
+// unlock releases the migration lock held by conn
+func (m *Migrator) unlock(ctx context.Context, conn *sql.Conn) {
+	name := lockName(m.options.Table)
+
+	var err error
+	switch m.dialect {
+	case xsb.MySQL:
+		_, err = conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", name)
+	case xsb.SQLite:
+		_, err = conn.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = 1", lockTable(m.options.Table)))
+	case xsb.MSSQL:
+		_, err = conn.ExecContext(ctx, "EXEC sp_releaseapplock @Resource = ?, @LockOwner = 'Session'", name)
+	default:
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", advisoryKey(name))
+	}
+	if err != nil {
+		xlog.Warnf("migrate: failed to release lock: %v", err)
+	}
+}
+
+// advisoryKey maps a lock name to a PostgreSQL advisory lock key
+func advisoryKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
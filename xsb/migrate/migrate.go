@@ -0,0 +1,464 @@
+// Package migrate applies versioned schema migrations to databases queried
+// with xsb. Migrations are Go functions or SQL scripts; the versions applied
+// are recorded in a migrations table, and a dialect-specific lock keeps two
+// processes from migrating the same database at once.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seefs001/xox/xerror"
+	"github.com/seefs001/xox/xlog"
+	"github.com/seefs001/xox/xsb"
+)
+
+var (
+	ErrInvalidMigration = xerror.New("invalid migration")
+	ErrDuplicateVersion = xerror.New("duplicate migration version")
+	ErrUnknownVersion   = xerror.New("unknown migration version")
+	ErrIrreversible     = xerror.New("migration has no down step")
+	ErrMissingMigration = xerror.New("applied migration is not registered")
+	ErrLocked           = xerror.New("migrations are locked by another process")
+)
+
+// Func is a migration step. It runs in the transaction that records the
+// migration, so a failing step leaves no trace. Note that MySQL commits DDL
+// statements implicitly.
+type Func func(ctx context.Context, tx *sql.Tx) error
+
+// Migration is a versioned schema change
+type Migration struct {
+	// Version orders migrations; it must be positive and unique
+	Version int64
+	Name    string
+	Up      Func
+	// Down reverts Up; nil makes the migration irreversible
+	Down Func
+}
+
+// Status describes a migration and whether it is applied
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Missing reports a version recorded in the database with no registered
+	// migration
+	Missing bool
+}
+
+// Options configures a Migrator
+type Options struct {
+	// Table is the name of the migrations table
+	Table string
+	// LockTimeout is how long to wait for another process to release the lock
+	LockTimeout time.Duration
+}
+
+// Option configures Options
+type Option func(*Options)
+
+// DefaultOptions returns the default options
+func DefaultOptions() Options {
+	return Options{
+		Table:       "schema_migrations",
+		LockTimeout: 30 * time.Second,
+	}
+}
+
+// WithTable sets the name of the migrations table
+func WithTable(table string) Option {
+	return func(o *Options) {
+		o.Table = table
+	}
+}
+
+// WithLockTimeout sets how long to wait for the migration lock
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.LockTimeout = timeout
+	}
+}
+
+// Migrator applies and reverts registered migrations
+type Migrator struct {
+	db         *sql.DB
+	dialect    xsb.Dialect
+	options    Options
+	migrations []Migration // sorted by version
+}
+
+// New returns a Migrator for db, which speaks dialect
+func New(db *sql.DB, dialect xsb.Dialect, opts ...Option) *Migrator {
+	options := DefaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return &Migrator{db: db, dialect: dialect, options: options}
+}
+
+// Add registers migrations
+func (m *Migrator) Add(migrations ...Migration) error {
+	for _, mig := range migrations {
+		if mig.Version <= 0 || mig.Up == nil {
+			return fmt.Errorf("%w: version %d needs a positive version and an up step", ErrInvalidMigration, mig.Version)
+		}
+		if _, ok := m.find(mig.Version); ok {
+			return fmt.Errorf("%w: %d", ErrDuplicateVersion, mig.Version)
+		}
+		i := sort.Search(len(m.migrations), func(i int) bool { return m.migrations[i].Version > mig.Version })
+		m.migrations = append(m.migrations, Migration{})
+		copy(m.migrations[i+1:], m.migrations[i:])
+		m.migrations[i] = mig
+	}
+	return nil
+}
+
+// sqlFile matches migration script names such as "0001_create_users.up.sql"
+var sqlFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// AddFS registers the SQL migrations in dir of fsys. Scripts are named
+// "<version>_<name>.up.sql" and "<version>_<name>.down.sql"; the down script
+// is optional. Other files are ignored.
+func (m *Migrator) AddFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	scripts := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := sqlFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidMigration, entry.Name(), err)
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		mig, ok := scripts[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: match[2]}
+			scripts[version] = mig
+		} else if mig.Name != match[2] {
+			return fmt.Errorf("%w: %d", ErrDuplicateVersion, version)
+		}
+		if match[3] == "up" {
+			mig.Up = SQL(string(data))
+		} else {
+			mig.Down = SQL(string(data))
+		}
+	}
+
+	for _, mig := range scripts {
+		if mig.Up == nil {
+			return fmt.Errorf("%w: %d_%s has no up script", ErrInvalidMigration, mig.Version, mig.Name)
+		}
+		if err := m.Add(*mig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SQL returns a migration step that executes the statements of script in order
+func SQL(script string) Func {
+	stmts := splitStatements(script)
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, stmt := range stmts {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// splitStatements splits a script at the semicolons outside of quotes,
+// comments and PostgreSQL dollar-quoted bodies
+func splitStatements(script string) []string {
+	var stmts []string
+	add := func(stmt string) {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+
+	start := 0
+	for i := 0; i < len(script); i++ {
+		// skip moves i to the last byte of the first end found after from
+		skip := func(from int, end string) {
+			if j := strings.Index(script[from:], end); j >= 0 {
+				i = from + j + len(end) - 1
+			} else {
+				i = len(script)
+			}
+		}
+
+		switch c := script[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			// A doubled quote closes and reopens the string, which is the same
+			skip(i+1, string(c))
+		case strings.HasPrefix(script[i:], "--"):
+			skip(i, "\n")
+		case strings.HasPrefix(script[i:], "/*"):
+			skip(i+2, "*/")
+		case c == '$':
+			if tag := dollarTag.FindString(script[i:]); tag != "" {
+				skip(i+len(tag), tag)
+			}
+		case c == ';':
+			add(script[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(script) {
+		add(script[start:])
+	}
+	return stmts
+}
+
+// dollarTag matches the opening of a PostgreSQL dollar-quoted string
+var dollarTag = regexp.MustCompile(`^\$[A-Za-z_]*\$`)
+
+// find returns the registered migration with version
+func (m *Migrator) find(version int64) (Migration, bool) {
+	i := sort.Search(len(m.migrations), func(i int) bool { return m.migrations[i].Version >= version })
+	if i < len(m.migrations) && m.migrations[i].Version == version {
+		return m.migrations[i], true
+	}
+	return Migration{}, false
+}
+
+// Up applies all pending migrations in version order
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.run(ctx, func(conn *sql.Conn, applied map[int64]Status) error {
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down reverts the latest applied migration. It does nothing if none is
+// applied.
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.run(ctx, func(conn *sql.Conn, applied map[int64]Status) error {
+		var latest int64
+		for version := range applied {
+			latest = max(latest, version)
+		}
+		if latest == 0 {
+			return nil
+		}
+		return m.revert(ctx, conn, latest)
+	})
+}
+
+// To migrates to version: pending migrations up to version are applied and
+// applied migrations above it are reverted, newest first. Version 0 reverts
+// every migration.
+func (m *Migrator) To(ctx context.Context, version int64) error {
+	if _, ok := m.find(version); !ok && version != 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.run(ctx, func(conn *sql.Conn, applied map[int64]Status) error {
+		revert := make([]int64, 0, len(applied))
+		for v := range applied {
+			if v > version {
+				revert = append(revert, v)
+			}
+		}
+		sort.Slice(revert, func(i, j int) bool { return revert[i] > revert[j] })
+		for _, v := range revert {
+			if err := m.revert(ctx, conn, v); err != nil {
+				return err
+			}
+		}
+
+		for _, mig := range m.migrations {
+			if mig.Version > version {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Status lists the registered migrations and the applied versions that are
+// not registered, in version order
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := m.createTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		status, ok := applied[mig.Version]
+		if !ok {
+			status = Status{Version: mig.Version}
+		}
+		status.Name = mig.Name
+		statuses = append(statuses, status)
+		delete(applied, mig.Version)
+	}
+	for _, status := range applied {
+		status.Missing = true
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Version returns the highest applied version, or 0 if none is applied
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var version int64
+	for _, status := range statuses {
+		if status.Applied {
+			version = max(version, status.Version)
+		}
+	}
+	return version, nil
+}
+
+// run calls fn on a connection holding the migration lock, with the applied
+// migrations by version
+func (m *Migrator) run(ctx context.Context, fn func(conn *sql.Conn, applied map[int64]Status) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := m.lock(ctx, conn); err != nil {
+		return err
+	}
+	defer m.unlock(context.WithoutCancel(ctx), conn)
+
+	if err := m.createTable(ctx, conn); err != nil {
+		return err
+	}
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, applied)
+}
+
+// applied reads the migrations table
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]Status, error) {
+	query, args := xsb.New().
+		WithDialect(m.dialect).
+		Table(m.options.Table).
+		Columns("version", "name", "applied_at").
+		OrderBy("version").
+		BuildSelect()
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations table: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]Status)
+	for rows.Next() {
+		status := Status{Applied: true}
+		if err := rows.Scan(&status.Version, &status.Name, &status.AppliedAt); err != nil {
+			return nil, fmt.Errorf("failed to read migrations table: %w", err)
+		}
+		applied[status.Version] = status
+	}
+	return applied, rows.Err()
+}
+
+// revert runs the down step of the applied migration with version
+func (m *Migrator) revert(ctx context.Context, conn *sql.Conn, version int64) error {
+	mig, ok := m.find(version)
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrMissingMigration, version)
+	}
+	if mig.Down == nil {
+		return fmt.Errorf("%w: %d_%s", ErrIrreversible, mig.Version, mig.Name)
+	}
+	return m.apply(ctx, conn, mig, false)
+}
+
+// apply runs a step of mig and records the result in one transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration, up bool) error {
+	step, direction := mig.Up, "up"
+	if !up {
+		step, direction = mig.Down, "down"
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := step(ctx, tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d_%s %s failed: %w", mig.Version, mig.Name, direction, err)
+	}
+
+	if up {
+		// BuildInsert would add RETURNING id for PostgreSQL
+		query := fmt.Sprintf("INSERT INTO %s (version, name, applied_at) VALUES (%s, %s, %s)",
+			m.options.Table, placeholder(m.dialect, 1), placeholder(m.dialect, 2), placeholder(m.dialect, 3))
+		_, err = tx.ExecContext(ctx, query, mig.Version, mig.Name, time.Now().UTC())
+	} else {
+		query, args := xsb.New().
+			WithDialect(m.dialect).
+			Table(m.options.Table).
+			Where("version = "+placeholder(m.dialect, 1), mig.Version).
+			BuildDelete()
+		_, err = tx.ExecContext(ctx, query, args...)
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record migration %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	xlog.Infof("migrate: %s %d_%s", direction, mig.Version, mig.Name)
+	return nil
+}
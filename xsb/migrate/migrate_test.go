@@ -0,0 +1,285 @@
+package migrate_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/seefs001/xox/xcli"
+	"github.com/seefs001/xox/xsb"
+	"github.com/seefs001/xox/xsb/internal/fakedb"
+	"github.com/seefs001/xox/xsb/migrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// schema is the state of a database as the migrator sees it. It understands
+// the bookkeeping statements of the migrator and logs every other statement.
+type schema struct {
+	locked  bool
+	applied map[int64]appliedRow
+	ddl     []string
+	log     []string
+
+	// the state at BEGIN, restored by ROLLBACK
+	savedApplied map[int64]appliedRow
+	savedLog     int
+}
+
+type appliedRow struct {
+	name string
+	at   time.Time
+}
+
+// run answers the statements of the migrator on a fakedb.DB
+func (s *schema) run(q string, args []driver.Value) fakedb.Result {
+	switch {
+	case q == "BEGIN":
+		s.savedApplied = make(map[int64]appliedRow, len(s.applied))
+		for k, v := range s.applied {
+			s.savedApplied[k] = v
+		}
+		s.savedLog = len(s.log)
+	case q == "ROLLBACK":
+		s.applied = s.savedApplied
+		s.log = s.log[:s.savedLog]
+	case q == "COMMIT":
+	case strings.Contains(q, "pg_try_advisory_lock"):
+		ok := !s.locked
+		s.locked = true
+		return fakedb.Result{Columns: []string{"ok"}, Rows: [][]driver.Value{{ok}}}
+	case strings.Contains(q, "pg_advisory_unlock"):
+		s.locked = false
+		return fakedb.Result{Columns: []string{"ok"}, Rows: [][]driver.Value{{true}}}
+	case strings.HasPrefix(q, "INSERT OR IGNORE INTO schema_migrations_lock"):
+		if s.locked {
+			return fakedb.Result{}
+		}
+		s.locked = true
+		return fakedb.Result{RowsAffected: 1}
+	case strings.HasPrefix(q, "DELETE FROM schema_migrations_lock"):
+		s.locked = false
+		return fakedb.Result{RowsAffected: 1}
+	case strings.HasPrefix(q, "CREATE TABLE IF NOT EXISTS schema_migrations"):
+		s.ddl = append(s.ddl, q)
+	case strings.HasPrefix(q, "SELECT version, name, applied_at FROM schema_migrations"):
+		rows := fakedb.Result{Columns: []string{"version", "name", "applied_at"}}
+		for version, row := range s.applied {
+			rows.Rows = append(rows.Rows, []driver.Value{version, row.name, row.at})
+		}
+		sort.Slice(rows.Rows, func(i, j int) bool { return rows.Rows[i][0].(int64) < rows.Rows[j][0].(int64) })
+		return rows
+	case strings.HasPrefix(q, "INSERT INTO schema_migrations"):
+		s.applied[args[0].(int64)] = appliedRow{name: args[1].(string), at: args[2].(time.Time)}
+		return fakedb.Result{RowsAffected: 1}
+	case strings.HasPrefix(q, "DELETE FROM schema_migrations"):
+		delete(s.applied, args[0].(int64))
+		return fakedb.Result{RowsAffected: 1}
+	case strings.Contains(q, "fail"):
+		return fakedb.Result{Err: fmt.Errorf("syntax error near %q", q)}
+	default:
+		s.log = append(s.log, q)
+	}
+	return fakedb.Result{}
+}
+
+// setupDB opens a fake database private to the test
+func setupDB(t *testing.T) (*sql.DB, *schema) {
+	db, fake := fakedb.Open(t)
+	state := &schema{applied: make(map[int64]appliedRow)}
+	fake.Handle(state.run)
+	return db, state
+}
+
+// exec returns a migration step that executes query
+func exec(query string) migrate.Func {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query)
+		return err
+	}
+}
+
+var scripts = fstest.MapFS{
+	"migrations/0002_add_email.up.sql": {Data: []byte(`
+ALTER TABLE users ADD COLUMN email TEXT;
+-- a comment; not a statement
+CREATE FUNCTION email_domain(email TEXT) RETURNS TEXT AS $$
+	SELECT split_part(email, '@', 2);
+$$ LANGUAGE sql;
+`)},
+	"migrations/0002_add_email.down.sql": {Data: []byte("DROP FUNCTION email_domain; ALTER TABLE users DROP COLUMN email")},
+	"migrations/0003_seed.up.sql":        {Data: []byte("INSERT INTO users (name) VALUES ('a;b');")},
+	"migrations/0003_seed.down.sql":      {Data: []byte("DELETE FROM users;")},
+	"migrations/README.md":               {Data: []byte("not a migration")},
+}
+
+// newMigrator returns a migrator with a Go migration and the scripts
+func newMigrator(t *testing.T, db *sql.DB, opts ...migrate.Option) *migrate.Migrator {
+	m := migrate.New(db, xsb.PostgreSQL, opts...)
+	require.NoError(t, m.Add(migrate.Migration{
+		Version: 1,
+		Name:    "create_users",
+		Up:      exec("CREATE TABLE users (id BIGINT, name TEXT)"),
+		Down:    exec("DROP TABLE users"),
+	}))
+	require.NoError(t, m.AddFS(scripts, "migrations"))
+	return m
+}
+
+func TestMigrator(t *testing.T) {
+	db, state := setupDB(t)
+	m := newMigrator(t, db)
+	ctx := context.Background()
+
+	t.Run("Up", func(t *testing.T) {
+		require.NoError(t, m.Up(ctx))
+		assert.Equal(t, []string{
+			"CREATE TABLE users (id BIGINT, name TEXT)",
+			"ALTER TABLE users ADD COLUMN email TEXT",
+			"-- a comment; not a statement\nCREATE FUNCTION email_domain(email TEXT) RETURNS TEXT AS $$\n\tSELECT split_part(email, '@', 2);\n$$ LANGUAGE sql",
+			"INSERT INTO users (name) VALUES ('a;b')",
+		}, state.log)
+		assert.Equal(t, []string{
+			"CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)",
+		}, state.ddl[:1])
+		assert.False(t, state.locked)
+
+		statuses, err := m.Status(ctx)
+		require.NoError(t, err)
+		require.Len(t, statuses, 3)
+		for i, name := range []string{"create_users", "add_email", "seed"} {
+			assert.Equal(t, int64(i+1), statuses[i].Version)
+			assert.Equal(t, name, statuses[i].Name)
+			assert.True(t, statuses[i].Applied)
+			assert.WithinDuration(t, time.Now(), statuses[i].AppliedAt, time.Minute)
+		}
+
+		// Nothing is pending
+		require.NoError(t, m.Up(ctx))
+		assert.Len(t, state.log, 4)
+	})
+
+	t.Run("Down", func(t *testing.T) {
+		require.NoError(t, m.Down(ctx))
+		assert.Equal(t, "DELETE FROM users", state.log[len(state.log)-1])
+		version, err := m.Version(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), version)
+	})
+
+	t.Run("To", func(t *testing.T) {
+		state.log = nil
+		require.NoError(t, m.To(ctx, 1))
+		assert.Equal(t, []string{"DROP FUNCTION email_domain", "ALTER TABLE users DROP COLUMN email"}, state.log)
+
+		require.NoError(t, m.To(ctx, 3))
+		version, err := m.Version(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), version)
+
+		require.NoError(t, m.To(ctx, 0))
+		assert.Equal(t, "DROP TABLE users", state.log[len(state.log)-1])
+		assert.Empty(t, state.applied)
+
+		assert.ErrorIs(t, m.To(ctx, 7), migrate.ErrUnknownVersion)
+		require.NoError(t, m.Down(ctx))
+	})
+}
+
+func TestMigrator_Errors(t *testing.T) {
+	db, state := setupDB(t)
+	ctx := context.Background()
+
+	t.Run("Registration", func(t *testing.T) {
+		m := newMigrator(t, db)
+		assert.ErrorIs(t, m.Add(migrate.Migration{Version: 2, Up: exec("SELECT 1")}), migrate.ErrDuplicateVersion)
+		assert.ErrorIs(t, m.Add(migrate.Migration{Version: 0, Up: exec("SELECT 1")}), migrate.ErrInvalidMigration)
+		assert.ErrorIs(t, m.Add(migrate.Migration{Version: 9}), migrate.ErrInvalidMigration)
+
+		missingUp := fstest.MapFS{"m/0001_x.down.sql": {Data: []byte("SELECT 1")}}
+		assert.ErrorIs(t, migrate.New(db, xsb.PostgreSQL).AddFS(missingUp, "m"), migrate.ErrInvalidMigration)
+	})
+
+	t.Run("Failed Migration Is Rolled Back", func(t *testing.T) {
+		m := migrate.New(db, xsb.PostgreSQL)
+		require.NoError(t, m.Add(
+			migrate.Migration{Version: 1, Name: "ok", Up: exec("CREATE TABLE a (id BIGINT)")},
+			migrate.Migration{Version: 2, Name: "broken", Up: migrate.SQL("CREATE TABLE b (id BIGINT); CREATE TABLE fail")},
+		))
+
+		err := m.Up(ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "migration 2_broken up failed")
+		assert.Equal(t, []string{"CREATE TABLE a (id BIGINT)"}, state.log)
+		assert.Len(t, state.applied, 1)
+		assert.False(t, state.locked)
+
+		// Version 1 has no down step
+		assert.ErrorIs(t, m.Down(ctx), migrate.ErrIrreversible)
+	})
+
+	t.Run("Missing Migration", func(t *testing.T) {
+		m := migrate.New(db, xsb.PostgreSQL)
+		statuses, err := m.Status(ctx)
+		require.NoError(t, err)
+		require.Len(t, statuses, 1)
+		assert.True(t, statuses[0].Missing)
+		assert.Equal(t, "ok", statuses[0].Name)
+		assert.ErrorIs(t, m.Down(ctx), migrate.ErrMissingMigration)
+	})
+
+	t.Run("Locked", func(t *testing.T) {
+		state.locked = true
+		defer func() { state.locked = false }()
+
+		m := newMigrator(t, db, migrate.WithLockTimeout(150*time.Millisecond))
+		start := time.Now()
+		assert.ErrorIs(t, m.Up(ctx), migrate.ErrLocked)
+		assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+	})
+}
+
+func TestMigrator_SQLite(t *testing.T) {
+	db, state := setupDB(t)
+	m := migrate.New(db, xsb.SQLite)
+	require.NoError(t, m.AddFS(scripts, "migrations"))
+
+	require.NoError(t, m.To(context.Background(), 2))
+	assert.Equal(t, []string{
+		"CREATE TABLE IF NOT EXISTS schema_migrations_lock (id INTEGER PRIMARY KEY, locked_at TIMESTAMP NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)",
+	}, state.ddl)
+	assert.Contains(t, state.applied, int64(2))
+	assert.False(t, state.locked)
+
+	require.NoError(t, m.Down(context.Background()))
+	assert.Empty(t, state.applied)
+}
+
+func TestCommand(t *testing.T) {
+	db, _ := setupDB(t)
+	m := newMigrator(t, db)
+
+	run := func(args ...string) string {
+		var out bytes.Buffer
+		app := xcli.NewApp("app")
+		app.AddCommand(m.Command(&out))
+		require.NoError(t, app.Run(context.Background(), append([]string{"app", "migrate"}, args...)))
+		return out.String()
+	}
+
+	assert.Equal(t, "version 1\n", run("to", "1"))
+	status := run("status")
+	assert.Regexp(t, `VERSION\s+NAME\s+STATUS\n`, status)
+	assert.Regexp(t, `1\s+create_users\s+applied \d{4}-`, status)
+	assert.Regexp(t, `3\s+seed\s+pending\n`, status)
+	assert.Equal(t, "version 3\n", run("up"))
+	assert.Equal(t, "version 2\n", run("down"))
+}
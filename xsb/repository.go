@@ -0,0 +1,474 @@
+package xsb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"reflect"
+	"strings"
+)
+
+// Querier runs statements and queries; *sql.DB, *sql.Tx and *sql.Conn implement it
+type Querier interface {
+	Execer
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Filter narrows a query built by a Repository, e.g. with Where, OrderBy or Limit
+type Filter func(b *Builder) *Builder
+
+// Page is one page of a paginated query
+type Page[T any] struct {
+	Items   []T
+	Total   int64
+	Page    int
+	PerPage int
+}
+
+// Pages returns the number of pages
+func (p Page[T]) Pages() int {
+	if p.PerPage <= 0 {
+		return 0
+	}
+	return int((p.Total + int64(p.PerPage) - 1) / int64(p.PerPage))
+}
+
+// Repository reads and writes values of the struct type T in one table.
+// Columns come from the db tags of T; the primary key is the field tagged
+// ddl:"pk", or "id" if there is none.
+type Repository[T any] struct {
+	db      Querier
+	dialect Dialect
+	table   string
+	key     string
+	fields  []structField
+	ctx     context.Context
+	hooks   []Hook
+	err     error
+}
+
+// NewRepository creates a repository for table that runs queries on db
+func NewRepository[T any](db Querier, table string) *Repository[T] {
+	r := &Repository[T]{
+		db:      db,
+		dialect: PostgreSQL,
+		table:   table,
+		key:     "id",
+		ctx:     context.Background(),
+	}
+
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() != reflect.Struct {
+		r.err = fmt.Errorf("repository: %s is not a struct", t)
+		return r
+	}
+	r.fields = structFields(t)
+	if len(r.fields) == 0 {
+		r.err = fmt.Errorf("repository: %s has no db tags", t)
+	}
+	for _, f := range r.fields {
+		if f.primaryKey {
+			r.key = f.column
+			break
+		}
+	}
+	return r
+}
+
+// clone returns a shallow copy, so the With methods leave a shared repository untouched
+func (r *Repository[T]) clone() *Repository[T] {
+	c := *r
+	return &c
+}
+
+// WithDialect returns a copy of the repository using dialect
+func (r *Repository[T]) WithDialect(dialect Dialect) *Repository[T] {
+	c := r.clone()
+	c.dialect = dialect
+	return c
+}
+
+// WithContext returns a copy of the repository running queries with ctx
+func (r *Repository[T]) WithContext(ctx context.Context) *Repository[T] {
+	c := r.clone()
+	c.ctx = ctx
+	return c
+}
+
+// WithTx returns a copy of the repository running queries in tx
+func (r *Repository[T]) WithTx(tx *sql.Tx) *Repository[T] {
+	c := r.clone()
+	c.db = tx
+	return c
+}
+
+// WithHooks returns a copy of the repository running queries with hooks, after
+// the global ones
+func (r *Repository[T]) WithHooks(hooks ...Hook) *Repository[T] {
+	c := r.clone()
+	c.hooks = append(append([]Hook(nil), r.hooks...), hooks...)
+	return c
+}
+
+// WithKey returns a copy of the repository using column as the primary key
+func (r *Repository[T]) WithKey(column string) *Repository[T] {
+	c := r.clone()
+	c.key = column
+	return c
+}
+
+// Table returns the table name
+func (r *Repository[T]) Table() string {
+	return r.table
+}
+
+// Columns returns the mapped columns in field order
+func (r *Repository[T]) Columns() []string {
+	columns := make([]string, len(r.fields))
+	for i, f := range r.fields {
+		columns[i] = f.column
+	}
+	return columns
+}
+
+// builder starts a query on the table
+func (r *Repository[T]) builder() *Builder {
+	return New().WithDialect(r.dialect).WithContext(r.ctx).WithHooks(r.hooks...).Table(r.table)
+}
+
+// selectBuilder starts a SELECT of the mapped columns narrowed by filter
+func (r *Repository[T]) selectBuilder(filter Filter) *Builder {
+	b := r.builder().Columns(r.Columns()...)
+	if filter != nil {
+		b = filter(b)
+	}
+	return b
+}
+
+// keyField returns the primary key field of v, or an invalid value if T has none
+func (r *Repository[T]) keyField(v reflect.Value) reflect.Value {
+	for _, f := range r.fields {
+		if f.column == r.key {
+			field, _ := fieldByIndex(v, f.index, true)
+			return field
+		}
+	}
+	return reflect.Value{}
+}
+
+// query runs the SELECT built by b and scans every row
+func (r *Repository[T]) query(b *Builder) ([]T, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	query, args := b.BuildSelect()
+	rows, err := b.queryContext(r.db, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]T, 0)
+	if err := ScanAll(rows, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// FindByID returns the row whose primary key is id, or sql.ErrNoRows
+func (r *Repository[T]) FindByID(id interface{}) (T, error) {
+	var zero T
+	if r.err != nil {
+		return zero, r.err
+	}
+
+	b := r.selectBuilder(nil)
+	b.Where(r.key+" = "+b.placeholder(), id)
+	items, err := r.query(b)
+	if err != nil {
+		return zero, err
+	}
+	if len(items) == 0 {
+		return zero, sql.ErrNoRows
+	}
+	return items[0], nil
+}
+
+// FindAll returns the rows matching filter; a nil filter returns every row
+func (r *Repository[T]) FindAll(filter Filter) ([]T, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.query(r.selectBuilder(filter))
+}
+
+// Count returns the number of rows matching filter. Ordering and paging set
+// by filter are ignored.
+func (r *Repository[T]) Count(filter Filter) (int64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	b := r.builder()
+	if filter != nil {
+		b = filter(b)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	b.orderBy, b.orderArgs, b.limit, b.offset = "", nil, 0, 0
+
+	var n int64
+	query, args := b.Count().BuildSelect()
+	err := b.queryRowContext(r.db, query, args).Scan(&n)
+	return n, err
+}
+
+// Paginate returns one page of the rows matching filter along with their total count
+func (r *Repository[T]) Paginate(filter Filter, page, perPage int) (Page[T], error) {
+	if page < 1 {
+		page = 1
+	}
+	result := Page[T]{Page: page, PerPage: perPage}
+	if r.err != nil {
+		return result, r.err
+	}
+
+	total, err := r.Count(filter)
+	if err != nil {
+		return result, err
+	}
+	items, err := r.query(r.selectBuilder(filter).Paginate(page, perPage))
+	if err != nil {
+		return result, err
+	}
+	result.Items, result.Total = items, total
+	return result, nil
+}
+
+// errStop ends a Chunk early from Iter
+var errStop = errors.New("stop")
+
+// Chunk calls fn with the rows matching filter, size at a time in key order.
+// Chunks continue after the last key seen rather than using OFFSET, so large
+// tables are walked at constant cost per chunk. Ordering and paging set by
+// filter are replaced.
+func (r *Repository[T]) Chunk(filter Filter, size int, fn func(items []T) error) error {
+	if r.err != nil {
+		return r.err
+	}
+	if size <= 0 {
+		return fmt.Errorf("repository: chunk size must be positive, got %d", size)
+	}
+
+	var last []interface{}
+	for {
+		b := r.selectBuilder(filter)
+		b.orderBy, b.orderArgs, b.offset = "", nil, 0
+		items, err := r.query(b.After([]string{r.key}, last).Limit(size))
+		if err != nil || len(items) == 0 {
+			return err
+		}
+		if err := fn(items); err != nil {
+			return err
+		}
+		if len(items) < size {
+			return nil
+		}
+
+		key := r.keyField(reflect.ValueOf(&items[len(items)-1]).Elem())
+		if !key.IsValid() {
+			return fmt.Errorf("repository: %T has no field for key %s", items[0], r.key)
+		}
+		last = []interface{}{key.Interface()}
+	}
+}
+
+// Iter returns an iterator over the rows matching filter, read size at a
+// time as by Chunk. Iteration stops at the first error, which is yielded.
+func (r *Repository[T]) Iter(filter Filter, size int) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		err := r.Chunk(filter, size, func(items []T) error {
+			for _, item := range items {
+				if !yield(item, nil) {
+					return errStop
+				}
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStop) {
+			var zero T
+			yield(zero, err)
+		}
+	}
+}
+
+// Insert inserts entity, skipping zero-valued fields so the database fills in
+// their defaults. A zero integer primary key is set to the generated id.
+func (r *Repository[T]) Insert(entity *T) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	b := r.builder().FromStruct(entity)
+	if len(b.columns) == 0 {
+		return fmt.Errorf("repository: no values to insert into %s", r.table)
+	}
+	query, args := b.BuildInsert()
+	key := r.keyField(reflect.ValueOf(entity).Elem())
+
+	// BuildInsert returns id for PostgreSQL; return the actual key instead
+	if r.dialect == PostgreSQL {
+		query = strings.TrimSuffix(query, " RETURNING id")
+		if key.IsValid() {
+			query += " RETURNING " + r.key
+			return b.queryRowContext(r.db, query, args).Scan(key.Addr().Interface())
+		}
+	}
+
+	result, err := b.execContext(r.db, query, args)
+	if err != nil {
+		return err
+	}
+	if !key.IsValid() || !key.IsZero() {
+		return nil
+	}
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if id, err := result.LastInsertId(); err == nil {
+			key.SetInt(id)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if id, err := result.LastInsertId(); err == nil {
+			key.SetUint(uint64(id))
+		}
+	}
+	return nil
+}
+
+// InsertMany inserts entities with as few statements as the dialect allows.
+// On PostgreSQL the generated keys are set on entities whose key is zero.
+func (r *Repository[T]) InsertMany(entities []T) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(entities) == 0 {
+		return nil
+	}
+
+	b := r.builder().InsertMany(entities)
+	first := r.keyField(reflect.ValueOf(&entities[0]).Elem())
+	if r.dialect != PostgreSQL || !first.IsValid() {
+		_, err := b.ExecInsertMany(r.db)
+		return err
+	}
+
+	// Keys are returned in the type of the key field, e.g. strings for UUIDs
+	keys := reflect.New(reflect.SliceOf(first.Type()))
+	if err := b.Returning(r.key).ExecInsertManyInto(r.db, keys.Interface()); err != nil {
+		return err
+	}
+	keys = keys.Elem()
+	if keys.Len() != len(entities) {
+		return fmt.Errorf("repository: inserted %d rows into %s but got %d keys", len(entities), r.table, keys.Len())
+	}
+	for i := range entities {
+		key := r.keyField(reflect.ValueOf(&entities[i]).Elem())
+		if key.IsZero() {
+			key.Set(keys.Index(i))
+		}
+	}
+	return nil
+}
+
+// Update writes every mapped field of entity to the row with its primary key
+func (r *Repository[T]) Update(entity *T) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	v := reflect.ValueOf(entity).Elem()
+	key := r.keyField(v)
+	if !key.IsValid() {
+		return fmt.Errorf("repository: %s has no field for key %s", v.Type(), r.key)
+	}
+
+	b := r.builder()
+	for _, f := range r.fields {
+		if f.column != r.key {
+			b.Set(f.column, fieldValue(v, f.index))
+		}
+	}
+	b.Where(r.key+" = "+b.placeholder(), key.Interface())
+
+	query, args := b.BuildUpdate()
+	_, err := b.execContext(r.db, query, args)
+	return err
+}
+
+// Delete deletes the row whose primary key is id
+func (r *Repository[T]) Delete(id interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	b := r.builder()
+	b.Where(r.key+" = "+b.placeholder(), id)
+	query, args := b.BuildDelete()
+	_, err := b.execContext(r.db, query, args)
+	return err
+}
+
+// Upsert inserts entity, or updates every other mapped field of the row with
+// the same primary key. MSSQL is not supported.
+func (r *Repository[T]) Upsert(entity *T) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	v := reflect.ValueOf(entity).Elem()
+	b := r.builder()
+	var updates []UpdateClause
+	var keyValue interface{}
+	for _, f := range r.fields {
+		value := fieldValue(v, f.index)
+		b.columns = append(b.columns, f.column)
+		b.values = append(b.values, value)
+		if f.column == r.key {
+			keyValue = value
+		} else {
+			updates = append(updates, UpdateClause{Column: f.column, Value: value})
+		}
+	}
+
+	var query string
+	var args []interface{}
+	switch r.dialect {
+	case PostgreSQL, SQLite:
+		query, args = b.BuildInsert()
+		query = strings.TrimSuffix(query, " RETURNING id")
+		query += " ON CONFLICT (" + r.key + ") DO "
+		if len(updates) == 0 {
+			query += "NOTHING"
+			break
+		}
+		sets := make([]string, len(updates))
+		for i, u := range updates {
+			sets[i] = fmt.Sprintf("%s = excluded.%s", u.Column, u.Column)
+		}
+		query += "UPDATE SET " + strings.Join(sets, ", ")
+	case MySQL:
+		// Setting the key to itself makes an existing row a no-op
+		if len(updates) == 0 {
+			updates = []UpdateClause{{Column: r.key, Value: keyValue}}
+		}
+		query, args = b.OnDuplicateKeyUpdate(updates).BuildInsert()
+	default:
+		return fmt.Errorf("repository: Upsert is not supported for this dialect")
+	}
+
+	_, err := b.execContext(r.db, query, args)
+	return err
+}
@@ -0,0 +1,236 @@
+package xsb_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xsb"
+	"github.com/seefs001/xox/xsb/internal/fakedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type member struct {
+	ID       int64          `db:"id"`
+	Name     string         `db:"name"`
+	Nickname sql.NullString `db:"nickname"`
+	Age      *int           `db:"age"`
+	timestamps
+}
+
+var memberColumns = []string{"id", "name", "nickname", "age", "created_at", "deleted_at"}
+
+func TestRepository_Find(t *testing.T) {
+	joined := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("FindByID", func(t *testing.T) {
+		db, fake := fakedb.Open(t)
+		fake.Respond("FROM members", memberColumns,
+			[]driver.Value{int64(7), []byte("Ann"), "annie", int64(30), joined, nil})
+
+		m, err := xsb.NewRepository[member](db, "members").FindByID(7)
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), m.ID)
+		assert.Equal(t, "Ann", m.Name)
+		assert.Equal(t, sql.NullString{String: "annie", Valid: true}, m.Nickname)
+		require.NotNil(t, m.Age)
+		assert.Equal(t, 30, *m.Age)
+		assert.Equal(t, joined, m.CreatedAt)
+		assert.Nil(t, m.DeletedAt)
+
+		stmts := fake.Statements()
+		require.Len(t, stmts, 1)
+		assert.Equal(t, "SELECT id, name, nickname, age, created_at, deleted_at FROM members WHERE id = $1", stmts[0].Query)
+		assert.Equal(t, []driver.Value{int64(7)}, stmts[0].Args)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		db, _ := fakedb.Open(t)
+		_, err := xsb.NewRepository[member](db, "members").FindByID(7)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("FindAll", func(t *testing.T) {
+		db, fake := fakedb.Open(t)
+		fake.Respond("FROM members", memberColumns,
+			[]driver.Value{int64(1), "Ann", nil, nil, joined, nil},
+			[]driver.Value{int64(2), "Bob", nil, int64(41), joined, joined})
+
+		repo := xsb.NewRepository[member](db, "members").WithDialect(xsb.MySQL)
+		members, err := repo.FindAll(func(b *xsb.Builder) *xsb.Builder {
+			return b.Where("age > ?", 18).OrderBy("name")
+		})
+		require.NoError(t, err)
+		require.Len(t, members, 2)
+		assert.Equal(t, "Ann", members[0].Name)
+		assert.False(t, members[0].Nickname.Valid)
+		assert.Nil(t, members[0].Age)
+		assert.Equal(t, "Bob", members[1].Name)
+		require.NotNil(t, members[1].DeletedAt)
+		assert.Equal(t, joined, *members[1].DeletedAt)
+
+		stmts := fake.Statements()
+		require.Len(t, stmts, 1)
+		assert.Equal(t, "SELECT id, name, nickname, age, created_at, deleted_at FROM members WHERE age > ? ORDER BY name", stmts[0].Query)
+
+		// No rows is an empty slice rather than nil
+		members, err = xsb.NewRepository[member](db, "archived").FindAll(nil)
+		require.NoError(t, err)
+		assert.NotNil(t, members)
+		assert.Empty(t, members)
+	})
+
+	t.Run("Count And Paginate", func(t *testing.T) {
+		db, fake := fakedb.Open(t)
+		fake.Respond("COUNT(*)", []string{"count"}, []driver.Value{int64(25)})
+		fake.Respond("FROM members", memberColumns,
+			[]driver.Value{int64(11), "Kim", nil, nil, joined, nil})
+
+		repo := xsb.NewRepository[member](db, "members").WithDialect(xsb.SQLite)
+		adults := func(b *xsb.Builder) *xsb.Builder {
+			return b.Where("age >= ?", 18).OrderBy("id")
+		}
+
+		n, err := repo.Count(adults)
+		require.NoError(t, err)
+		assert.Equal(t, int64(25), n)
+
+		page, err := repo.Paginate(adults, 2, 10)
+		require.NoError(t, err)
+		assert.Equal(t, int64(25), page.Total)
+		assert.Equal(t, 2, page.Page)
+		assert.Equal(t, 3, page.Pages())
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, "Kim", page.Items[0].Name)
+
+		var queries []string
+		for _, stmt := range fake.Statements() {
+			queries = append(queries, stmt.Query)
+		}
+		assert.Equal(t, []string{
+			"SELECT COUNT(*) FROM members WHERE age >= ?",
+			"SELECT COUNT(*) FROM members WHERE age >= ?",
+			"SELECT id, name, nickname, age, created_at, deleted_at FROM members WHERE age >= ? ORDER BY id LIMIT 10 OFFSET 10",
+		}, queries)
+	})
+}
+
+func TestRepository_Write(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	age := 30
+
+	t.Run("Insert Returning", func(t *testing.T) {
+		db, fake := fakedb.Open(t)
+		fake.Respond("INSERT INTO members", []string{"id"}, []driver.Value{int64(42)})
+
+		m := member{Name: "Ann", Age: &age}
+		require.NoError(t, xsb.NewRepository[member](db, "members").Insert(&m))
+		assert.Equal(t, int64(42), m.ID)
+
+		stmts := fake.Statements()
+		require.Len(t, stmts, 1)
+		assert.Equal(t, "INSERT INTO members (name, age) VALUES ($1, $2) RETURNING id", stmts[0].Query)
+		assert.Equal(t, []driver.Value{"Ann", int64(30)}, stmts[0].Args)
+	})
+
+	t.Run("Insert LastInsertId", func(t *testing.T) {
+		db, fake := fakedb.Open(t)
+		fake.RespondID("INSERT INTO members", 9)
+
+		m := member{Name: "Ann"}
+		require.NoError(t, xsb.NewRepository[member](db, "members").WithDialect(xsb.SQLite).Insert(&m))
+		assert.Equal(t, int64(9), m.ID)
+
+		stmts := fake.Statements()
+		require.Len(t, stmts, 1)
+		assert.Equal(t, "INSERT INTO members (name) VALUES (?)", stmts[0].Query)
+
+		// An explicit key is kept
+		m = member{ID: 3, Name: "Bob"}
+		require.NoError(t, xsb.NewRepository[member](db, "members").WithDialect(xsb.MySQL).Insert(&m))
+		assert.Equal(t, int64(3), m.ID)
+
+		assert.Error(t, xsb.NewRepository[member](db, "members").Insert(&member{}))
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		db, fake := fakedb.Open(t)
+		m := member{ID: 5, Name: "Ann", Age: &age}
+		m.CreatedAt = created
+		require.NoError(t, xsb.NewRepository[member](db, "members").Update(&m))
+
+		stmts := fake.Statements()
+		require.Len(t, stmts, 1)
+		assert.Equal(t, "UPDATE members SET name = $1, nickname = $2, age = $3, created_at = $4, deleted_at = $5 WHERE id = $6", stmts[0].Query)
+		assert.Equal(t, []driver.Value{"Ann", nil, int64(30), created, nil, int64(5)}, stmts[0].Args)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		db, fake := fakedb.Open(t)
+		require.NoError(t, xsb.NewRepository[member](db, "members").Delete(5))
+
+		stmts := fake.Statements()
+		require.Len(t, stmts, 1)
+		assert.Equal(t, "DELETE FROM members WHERE id = $1", stmts[0].Query)
+		assert.Equal(t, []driver.Value{int64(5)}, stmts[0].Args)
+	})
+
+	t.Run("Upsert", func(t *testing.T) {
+		type tag struct {
+			Slug  string `db:"slug" ddl:"pk"`
+			Label string `db:"label"`
+		}
+
+		db, fake := fakedb.Open(t)
+		repo := xsb.NewRepository[tag](db, "tags")
+		value := &tag{Slug: "go", Label: "Go"}
+
+		require.NoError(t, repo.Upsert(value))
+		require.NoError(t, repo.WithDialect(xsb.SQLite).Upsert(value))
+		require.NoError(t, repo.WithDialect(xsb.MySQL).Upsert(value))
+		assert.Error(t, repo.WithDialect(xsb.MSSQL).Upsert(value))
+
+		stmts := fake.Statements()
+		require.Len(t, stmts, 3)
+		assert.Equal(t, "INSERT INTO tags (slug, label) VALUES ($1, $2) ON CONFLICT (slug) DO UPDATE SET label = excluded.label", stmts[0].Query)
+		assert.Equal(t, "INSERT INTO tags (slug, label) VALUES (?, ?) ON CONFLICT (slug) DO UPDATE SET label = excluded.label", stmts[1].Query)
+		assert.Equal(t, "INSERT INTO tags (slug, label) VALUES (?, ?) ON DUPLICATE KEY UPDATE label = ?", stmts[2].Query)
+		assert.Equal(t, []driver.Value{"go", "Go", "Go"}, stmts[2].Args)
+
+		// The key from the struct tag is used for lookups too
+		_, err := repo.FindByID("go")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		assert.Equal(t, "SELECT slug, label FROM tags WHERE slug = $1", fake.Statements()[0].Query)
+	})
+}
+
+func TestRepository_TxAndContext(t *testing.T) {
+	db, fake := fakedb.Open(t)
+	repo := xsb.NewRepository[member](db, "members")
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, repo.WithTx(tx).Delete(1))
+	require.NoError(t, tx.Commit())
+
+	var queries []string
+	for _, stmt := range fake.Statements() {
+		queries = append(queries, stmt.Query)
+	}
+	assert.Equal(t, []string{"BEGIN", "DELETE FROM members WHERE id = $1", "COMMIT"}, queries)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = repo.WithContext(ctx).FindAll(nil)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// The With methods leave the original repository untouched
+	_, err = repo.FindAll(nil)
+	assert.NoError(t, err)
+
+	_, err = xsb.NewRepository[int](db, "numbers").FindAll(nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,539 @@
+package xsb
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+// timeFormats are the layouts tried when a time is read as text, as SQLite
+// and MySQL without parseTime return them
+var timeFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// converters holds the functions registered with RegisterConverter by type
+var converters sync.Map
+
+// RegisterConverter registers fn to convert values read from the database
+// into fields of type T, or *T. Types implementing sql.Scanner do not need
+// one. Register converters before the first scan of a struct using T.
+func RegisterConverter[T any](fn func(src interface{}) (T, error)) {
+	converters.Store(reflect.TypeOf((*T)(nil)).Elem(), func(src interface{}) (interface{}, error) {
+		return fn(src)
+	})
+}
+
+// converterFor returns the converter registered for t
+func converterFor(t reflect.Type) (func(interface{}) (interface{}, error), bool) {
+	fn, ok := converters.Load(t)
+	if !ok {
+		return nil, false
+	}
+	return fn.(func(interface{}) (interface{}, error)), true
+}
+
+// structField is a struct field mapped to a column by its db tag
+type structField struct {
+	column     string
+	index      []int
+	primaryKey bool
+}
+
+// typeInfo is the column mapping of a struct type
+type typeInfo struct {
+	// fields are the tagged columns of the struct itself, as written by inserts and updates
+	fields []structField
+	// columns finds scan targets by column name, including prefixed columns of nested structs
+	columns map[string][]int
+	// loose finds scan targets by normalized column or field name
+	loose map[string][]int
+}
+
+// typeCache holds the typeInfo of each struct type scanned or written
+var typeCache sync.Map
+
+// typeInfoOf returns the cached column mapping of the struct type t
+func typeInfoOf(t reflect.Type) *typeInfo {
+	if info, ok := typeCache.Load(t); ok {
+		return info.(*typeInfo)
+	}
+	info := &typeInfo{
+		columns: make(map[string][]int),
+		loose:   make(map[string][]int),
+	}
+	info.add(t, "", nil, map[reflect.Type]bool{t: true})
+	actual, _ := typeCache.LoadOrStore(t, info)
+	return actual.(*typeInfo)
+}
+
+// add maps the fields of t, reached through index, with column names starting with prefix
+func (info *typeInfo) add(t reflect.Type, prefix string, index []int, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		path := append(append([]int(nil), index...), i)
+		base := field.Type
+		if base.Kind() == reflect.Ptr {
+			base = base.Elem()
+		}
+
+		if field.Anonymous && tag == "" && base.Kind() == reflect.Struct {
+			// Unexported embedded pointers cannot be allocated
+			if field.IsExported() || field.Type.Kind() == reflect.Struct {
+				info.add(base, prefix, path, visiting)
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		if tag != "" && isNested(base) {
+			if !visiting[base] {
+				visiting[base] = true
+				info.add(base, prefix+tag+".", path, visiting)
+				delete(visiting, base)
+			}
+			continue
+		}
+
+		if tag == "" {
+			if _, ok := info.loose[normalize(prefix+field.Name)]; !ok {
+				info.loose[normalize(prefix+field.Name)] = path
+			}
+			continue
+		}
+
+		column := prefix + tag
+		info.columns[column] = path
+		info.loose[normalize(column)] = path
+		if prefix == "" {
+			f := structField{column: tag, index: path}
+			for _, opt := range strings.Split(field.Tag.Get("ddl"), ";") {
+				if strings.TrimSpace(opt) == "pk" {
+					f.primaryKey = true
+				}
+			}
+			info.fields = append(info.fields, f)
+		}
+	}
+}
+
+// isNested reports whether a tagged field of type t is a struct whose own
+// tagged fields are scanned from prefixed columns, rather than a single value
+func isNested(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t == timeType || reflect.PointerTo(t).Implements(scannerType) {
+		return false
+	}
+	if _, ok := converterFor(t); ok {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if tag := field.Tag.Get("db"); tag != "" && tag != "-" {
+			return true
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && isNested(field.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize folds a column or field name for loose matching, so created_at matches CreatedAt
+func normalize(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// structFields returns the tagged columns of the struct type t, including
+// those of embedded structs without a tag
+func structFields(t reflect.Type) []structField {
+	return typeInfoOf(t).fields
+}
+
+// fieldByIndex returns the field of v at index. Nil pointers on the way are
+// allocated if alloc is set; otherwise the field is reported missing.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// fieldValue returns the value of the field of v at index, or nil if it is
+// behind a nil pointer
+func fieldValue(v reflect.Value, index []int) interface{} {
+	field, ok := fieldByIndex(v, index, false)
+	if !ok {
+		return nil
+	}
+	return field.Interface()
+}
+
+// isScalar reports whether values of t are scanned from a single column
+// rather than mapped field by field
+func isScalar(t reflect.Type) bool {
+	if _, ok := converterFor(t); ok {
+		return true
+	}
+	return t.Kind() != reflect.Struct || t == timeType || reflect.PointerTo(t).Implements(scannerType)
+}
+
+// rowScanner maps the columns of a result set onto a destination type
+type rowScanner struct {
+	columns []string
+	paths   [][]int
+	scalar  bool
+	values  []interface{}
+	ptrs    []interface{}
+}
+
+// newRowScanner plans how the columns of rows map onto values of type t
+func newRowScanner(rows *sql.Rows, t reflect.Type) (*rowScanner, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &rowScanner{
+		columns: columns,
+		values:  make([]interface{}, len(columns)),
+		ptrs:    make([]interface{}, len(columns)),
+	}
+	for i := range s.values {
+		s.ptrs[i] = &s.values[i]
+	}
+
+	if isScalar(t) {
+		if len(columns) != 1 {
+			return nil, fmt.Errorf("scanning into %s needs exactly one column, got %d", t, len(columns))
+		}
+		s.scalar = true
+		return s, nil
+	}
+
+	info := typeInfoOf(t)
+	s.paths = make([][]int, len(columns))
+	for i, column := range columns {
+		if path, ok := info.columns[column]; ok {
+			s.paths[i] = path
+		} else if path, ok := info.loose[normalize(column)]; ok {
+			s.paths[i] = path
+		}
+	}
+	return s, nil
+}
+
+// scan reads the current row into v. Columns without a matching field are ignored.
+func (s *rowScanner) scan(rows *sql.Rows, v reflect.Value) error {
+	if err := rows.Scan(s.ptrs...); err != nil {
+		return err
+	}
+	if s.scalar {
+		return setField(v, s.values[0])
+	}
+
+	for i, path := range s.paths {
+		if path == nil {
+			continue
+		}
+		// NULL leaves nil nested pointers alone, e.g. the missing side of a LEFT JOIN
+		field, ok := fieldByIndex(v, path, s.values[i] != nil)
+		if !ok || !field.CanSet() {
+			continue
+		}
+		if err := setField(field, s.values[i]); err != nil {
+			return fmt.Errorf("column %s: %w", s.columns[i], err)
+		}
+	}
+	return nil
+}
+
+// ScanAll scans every row into dest, a pointer to a slice of structs, struct
+// pointers or single-column values, and closes rows. Columns are matched to
+// fields by db tag, then by name ignoring case and underscores. Embedded
+// structs are flattened; a tagged struct field such as `db:"user"` receives
+// the columns prefixed with its tag, e.g. "user.id". NULL leaves fields
+// zero, pointers are allocated, sql.Scanner and registered converters are
+// used, and text is parsed into numbers and times.
+func ScanAll(rows *sql.Rows, dest interface{}) error {
+	defer rows.Close()
+
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("ScanAll: dest must be a pointer to a slice, got %T", dest)
+	}
+	slice := v.Elem()
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr && !isScalar(elemType.Elem())
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+
+	s, err := newRowScanner(rows, elemType)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		elem := reflect.New(elemType)
+		if err := s.scan(rows, elem.Elem()); err != nil {
+			return err
+		}
+		if isPtr {
+			slice.Set(reflect.Append(slice, elem))
+		} else {
+			slice.Set(reflect.Append(slice, elem.Elem()))
+		}
+	}
+	return rows.Err()
+}
+
+// ScanOne scans the first row into dest, a pointer to a struct or value, and
+// closes rows. It returns sql.ErrNoRows if there are no rows.
+func ScanOne(rows *sql.Rows, dest interface{}) error {
+	defer rows.Close()
+
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("ScanOne: dest must be a non-nil pointer, got %T", dest)
+	}
+	s, err := newRowScanner(rows, v.Elem().Type())
+	if err != nil {
+		return err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := s.scan(rows, v.Elem()); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// setField stores a scanned value in field. NULL leaves the field zero,
+// pointers are allocated and registered converters and sql.Scanner are used
+// before the built-in conversions.
+func setField(field reflect.Value, value interface{}) error {
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	if fn, ok := converterFor(field.Type()); ok {
+		converted, err := fn(value)
+		if err != nil {
+			return err
+		}
+		if cv := reflect.ValueOf(converted); cv.IsValid() {
+			field.Set(cv)
+		} else {
+			field.Set(reflect.Zero(field.Type()))
+		}
+		return nil
+	}
+	if field.CanAddr() {
+		if scanner, ok := field.Addr().Interface().(sql.Scanner); ok {
+			return scanner.Scan(value)
+		}
+	}
+	if field.Kind() == reflect.Ptr {
+		elem := reflect.New(field.Type().Elem())
+		if err := setField(elem.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	}
+	return convertValue(field, value)
+}
+
+// convertValue stores value in field, converting between the types drivers
+// return and the kind of the field
+func convertValue(field reflect.Value, value interface{}) error {
+	// Many drivers return numbers and times as text
+	var text string
+	isText := false
+	switch v := value.(type) {
+	case string:
+		text, isText = v, true
+	case []byte:
+		text, isText = string(v), true
+	}
+	fail := func() error {
+		return fmt.Errorf("cannot store %T in %s", value, field.Type())
+	}
+
+	if field.Type() == timeType {
+		switch v := value.(type) {
+		case time.Time:
+			field.Set(reflect.ValueOf(v))
+			return nil
+		case int64:
+			field.Set(reflect.ValueOf(time.Unix(v, 0).UTC()))
+			return nil
+		}
+		if !isText {
+			return fail()
+		}
+		t, err := parseTime(text)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch field.Kind() {
+	case reflect.String:
+		switch v := value.(type) {
+		case time.Time:
+			field.SetString(v.Format(time.RFC3339Nano))
+		case int64, float64, bool:
+			field.SetString(fmt.Sprint(v))
+		default:
+			if !isText {
+				return fail()
+			}
+			field.SetString(text)
+		}
+	case reflect.Bool:
+		switch v := value.(type) {
+		case bool:
+			field.SetBool(v)
+		case int64:
+			field.SetBool(v != 0)
+		default:
+			if !isText {
+				return fail()
+			}
+			b, err := strconv.ParseBool(text)
+			if err != nil {
+				return err
+			}
+			field.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch v := value.(type) {
+		case int64:
+			n = v
+		case float64:
+			n = int64(v)
+		case bool:
+			if v {
+				n = 1
+			}
+		default:
+			if !isText {
+				return fail()
+			}
+			var err error
+			if n, err = strconv.ParseInt(text, 10, 64); err != nil {
+				return err
+			}
+		}
+		if field.OverflowInt(n) {
+			return fmt.Errorf("value %d overflows %s", n, field.Type())
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		switch v := value.(type) {
+		case int64:
+			if v < 0 {
+				return fmt.Errorf("value %d overflows %s", v, field.Type())
+			}
+			n = uint64(v)
+		case float64:
+			n = uint64(v)
+		default:
+			if !isText {
+				return fail()
+			}
+			var err error
+			if n, err = strconv.ParseUint(text, 10, 64); err != nil {
+				return err
+			}
+		}
+		if field.OverflowUint(n) {
+			return fmt.Errorf("value %d overflows %s", n, field.Type())
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		switch v := value.(type) {
+		case float64:
+			field.SetFloat(v)
+		case int64:
+			field.SetFloat(float64(v))
+		default:
+			if !isText {
+				return fail()
+			}
+			f, err := strconv.ParseFloat(text, field.Type().Bits())
+			if err != nil {
+				return err
+			}
+			field.SetFloat(f)
+		}
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.Uint8 || !isText {
+			return fail()
+		}
+		field.SetBytes([]byte(text))
+	case reflect.Interface:
+		if !rv.Type().AssignableTo(field.Type()) {
+			return fail()
+		}
+		field.Set(rv)
+	default:
+		if !rv.Type().ConvertibleTo(field.Type()) {
+			return fail()
+		}
+		field.Set(rv.Convert(field.Type()))
+	}
+	return nil
+}
+
+// parseTime parses a time stored as text
+func parseTime(text string) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	for _, layout := range timeFormats {
+		if t, err := time.ParseInLocation(layout, text, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as a time", text)
+}
@@ -0,0 +1,165 @@
+package xsb_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seefs001/xox/xsb"
+	"github.com/seefs001/xox/xsb/internal/fakedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type team struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+// Audit is exported so that a nil *Audit can be allocated while scanning
+type Audit struct {
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type player struct {
+	ID       int64 `db:"id"`
+	FullName string
+	Nickname sql.NullString `db:"nickname"`
+	Rating   *float64       `db:"rating"`
+	Active   bool           `db:"active"`
+	Team     *team          `db:"team"`
+	Captain  team           `db:"captain"`
+	*Audit
+}
+
+// labels is stored as comma separated text
+type labels []string
+
+func init() {
+	xsb.RegisterConverter(func(src interface{}) (labels, error) {
+		switch v := src.(type) {
+		case string:
+			return strings.Split(v, ","), nil
+		case []byte:
+			return strings.Split(string(v), ","), nil
+		}
+		return nil, fmt.Errorf("cannot convert %T to labels", src)
+	})
+}
+
+func selectRows(t *testing.T, db *sql.DB) *sql.Rows {
+	rows, err := db.Query("SELECT")
+	require.NoError(t, err)
+	return rows
+}
+
+func TestScanAll(t *testing.T) {
+	t.Run("Structs", func(t *testing.T) {
+		db, fake := fakedb.Open(t)
+		fake.Respond("SELECT",
+			[]string{"id", "full_name", "nickname", "rating", "active", "team.id", "team.name", "captain.name", "created_at", "unknown"},
+			[]driver.Value{int64(1), "Ann Lee", "annie", 4.5, int64(1), int64(10), "Reds", "Kim", "2024-05-01 12:30:00", "x"},
+			[]driver.Value{int64(2), []byte("Bob Stone"), nil, nil, int64(0), nil, nil, nil, []byte("2024-05-02T08:00:00Z"), nil})
+
+		var players []player
+		require.NoError(t, xsb.ScanAll(selectRows(t, db), &players))
+		require.Len(t, players, 2)
+
+		ann := players[0]
+		assert.Equal(t, int64(1), ann.ID)
+		assert.Equal(t, "Ann Lee", ann.FullName)
+		assert.Equal(t, sql.NullString{String: "annie", Valid: true}, ann.Nickname)
+		require.NotNil(t, ann.Rating)
+		assert.Equal(t, 4.5, *ann.Rating)
+		assert.True(t, ann.Active)
+		assert.Equal(t, &team{ID: 10, Name: "Reds"}, ann.Team)
+		assert.Equal(t, team{Name: "Kim"}, ann.Captain)
+		require.NotNil(t, ann.Audit)
+		assert.Equal(t, time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC), ann.CreatedAt)
+
+		bob := players[1]
+		assert.Equal(t, "Bob Stone", bob.FullName)
+		assert.False(t, bob.Nickname.Valid)
+		assert.Nil(t, bob.Rating)
+		assert.False(t, bob.Active)
+		assert.Nil(t, bob.Team, "a NULL joined row leaves the pointer nil")
+		assert.Equal(t, time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC), bob.CreatedAt)
+	})
+
+	t.Run("Pointers And Text Values", func(t *testing.T) {
+		type row struct {
+			Count   uint16  `db:"count"`
+			Ratio   float32 `db:"ratio"`
+			Enabled bool    `db:"enabled"`
+			Code    string  `db:"code"`
+			Data    []byte  `db:"data"`
+			Tags    labels  `db:"tags"`
+			Seen    *time.Time
+		}
+
+		db, fake := fakedb.Open(t)
+		fake.Respond("SELECT",
+			[]string{"count", "ratio", "enabled", "code", "data", "tags", "seen"},
+			[]driver.Value{[]byte("42"), []byte("0.5"), []byte("true"), int64(7), "raw", "a,b", int64(1700000000)})
+
+		var rows []*row
+		require.NoError(t, xsb.ScanAll(selectRows(t, db), &rows))
+		require.Len(t, rows, 1)
+		assert.Equal(t, uint16(42), rows[0].Count)
+		assert.Equal(t, float32(0.5), rows[0].Ratio)
+		assert.True(t, rows[0].Enabled)
+		assert.Equal(t, "7", rows[0].Code)
+		assert.Equal(t, []byte("raw"), rows[0].Data)
+		assert.Equal(t, labels{"a", "b"}, rows[0].Tags)
+		require.NotNil(t, rows[0].Seen)
+		assert.Equal(t, time.Unix(1700000000, 0).UTC(), *rows[0].Seen)
+	})
+
+	t.Run("Single Column", func(t *testing.T) {
+		db, fake := fakedb.Open(t)
+		fake.Respond("SELECT", []string{"id"}, []driver.Value{int64(3)}, []driver.Value{[]byte("4")})
+
+		var ids []int64
+		require.NoError(t, xsb.ScanAll(selectRows(t, db), &ids))
+		assert.Equal(t, []int64{3, 4}, ids)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		db, fake := fakedb.Open(t)
+		fake.Respond("SELECT", []string{"id", "name"}, []driver.Value{"abc", "x"})
+
+		var players []player
+		err := xsb.ScanAll(selectRows(t, db), &players)
+		assert.ErrorContains(t, err, "column id")
+
+		var ids []int64
+		assert.ErrorContains(t, xsb.ScanAll(selectRows(t, db), &ids), "exactly one column")
+		assert.Error(t, xsb.ScanAll(selectRows(t, db), players))
+	})
+}
+
+func TestScanOne(t *testing.T) {
+	db, fake := fakedb.Open(t)
+	fake.Respond("SELECT", []string{"id", "name"},
+		[]driver.Value{int64(1), "Reds"},
+		[]driver.Value{int64(2), "Blues"})
+
+	var tm team
+	require.NoError(t, xsb.ScanOne(selectRows(t, db), &tm))
+	assert.Equal(t, team{ID: 1, Name: "Reds"}, tm)
+
+	rows, err := db.Query("empty")
+	require.NoError(t, err)
+	assert.ErrorIs(t, xsb.ScanOne(rows, &tm), sql.ErrNoRows)
+
+	// Builder.Scan maps the current row the same way
+	rows = selectRows(t, db)
+	defer rows.Close()
+	require.True(t, rows.Next())
+	require.True(t, rows.Next())
+	require.NoError(t, xsb.New().Scan(rows, &tm))
+	assert.Equal(t, team{ID: 2, Name: "Blues"}, tm)
+}
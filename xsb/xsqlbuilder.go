@@ -61,7 +61,6 @@ type Builder struct {
 	unions               []*Builder
 	unionAll             bool
 	debug                bool
-	paramCount           int
 	ctes                 []CTE
 	distinct             bool
 	forUpdate            bool
@@ -74,6 +73,11 @@ type Builder struct {
 	err                  error
 	logSQL               bool
 	allowEmptyWhere      bool
+	rows                 [][]interface{}
+	batchSize            int
+	hooks                []Hook
+	selectArgs           []interface{}
+	orderArgs            []interface{}
 }
 
 // New creates a new Builder instance with PostgreSQL as default dialect
@@ -117,6 +121,7 @@ func (b *Builder) Table(name string) *Builder {
 // Columns sets the columns for the query
 func (b *Builder) Columns(cols ...string) *Builder {
 	b.columns = cols
+	b.selectArgs = nil
 	return b
 }
 
@@ -128,6 +133,7 @@ func (b *Builder) Values(values ...interface{}) *Builder {
 
 // Where adds a WHERE clause to the query
 func (b *Builder) Where(condition string, args ...interface{}) *Builder {
+	condition, args = b.bind(condition, args)
 	if b.whereClause == "" {
 		b.whereClause = condition
 	} else {
@@ -142,6 +148,7 @@ func (b *Builder) OrWhere(condition string, args ...interface{}) *Builder {
 	if b.whereClause == "" {
 		return b.Where(condition, args...)
 	}
+	condition, args = b.bind(condition, args)
 	b.whereClause += " OR " + condition
 	b.whereArgs = append(b.whereArgs, args...)
 	return b
@@ -160,6 +167,7 @@ func (b *Builder) WhereIn(column string, values ...interface{}) *Builder {
 // OrderBy adds an ORDER BY clause to the query
 func (b *Builder) OrderBy(clause string) *Builder {
 	b.orderBy = clause
+	b.orderArgs = nil
 	return b
 }
 
@@ -205,6 +213,7 @@ func (b *Builder) GroupBy(clause string) *Builder {
 
 // Having adds a HAVING clause to the query
 func (b *Builder) Having(condition string, args ...interface{}) *Builder {
+	condition, args = b.bind(condition, args)
 	b.having = condition
 	b.havingArgs = append(b.havingArgs, args...)
 	return b
@@ -230,7 +239,7 @@ func (b *Builder) Union(other *Builder) *Builder {
 
 // CTE adds a Common Table Expression (WITH clause)
 func (b *Builder) CTE(name string, subquery *Builder) *Builder {
-	subQuerySQL, subQueryArgs := subquery.BuildSelect()
+	subQuerySQL, subQueryArgs := b.bind(subquery.BuildSelect())
 	cte := CTE{
 		Name:  name,
 		Query: subQuerySQL,
@@ -259,10 +268,6 @@ func (b *Builder) BuildSelect() (string, []interface{}) {
 
 	if len(b.ctes) > 0 {
 		query.WriteString("WITH ")
-		if strings.HasPrefix(b.ctes[0].Name, "RECURSIVE") {
-			query.WriteString("RECURSIVE ")
-			b.ctes[0].Name = strings.TrimPrefix(b.ctes[0].Name, "RECURSIVE ")
-		}
 		var cteStrings []string
 		for _, cte := range b.ctes {
 			cteStrings = append(cteStrings, fmt.Sprintf("%s AS (%s)", cte.Name, cte.Query))
@@ -283,6 +288,7 @@ func (b *Builder) BuildSelect() (string, []interface{}) {
 
 	if len(b.columns) > 0 {
 		query.WriteString(strings.Join(b.columns, ", "))
+		args = append(args, b.selectArgs...)
 	} else {
 		query.WriteString("*")
 	}
@@ -328,6 +334,7 @@ func (b *Builder) BuildSelect() (string, []interface{}) {
 	if b.orderBy != "" {
 		query.WriteString(" ORDER BY ")
 		query.WriteString(b.orderBy)
+		args = append(args, b.orderArgs...)
 	}
 
 	if b.limit > 0 {
@@ -344,7 +351,7 @@ func (b *Builder) BuildSelect() (string, []interface{}) {
 			if b.unionAll {
 				unionType = "UNION ALL"
 			}
-			unionQuery, unionArgs := union.BuildSelect()
+			unionQuery, unionArgs := b.bind(union.BuildSelect())
 			query.WriteString(" ")
 			query.WriteString(unionType)
 			query.WriteString(" ")
@@ -353,7 +360,7 @@ func (b *Builder) BuildSelect() (string, []interface{}) {
 		}
 	}
 
-	return query.String(), args
+	return b.render(query.String()), args
 }
 
 // BuildInsert builds an INSERT query
@@ -383,8 +390,9 @@ func (b *Builder) BuildInsert() (string, []interface{}) {
 	if len(b.values) > 0 {
 		if len(b.values) == 1 {
 			if raw, ok := b.values[0].(RawExpr); ok {
-				query.WriteString(raw.Expr)
-				args = append(args, raw.args...)
+				expr, rawArgs := raw.build(b)
+				query.WriteString(expr)
+				args = append(args, rawArgs...)
 			} else {
 				query.WriteString("VALUES (")
 				placeholders := make([]string, len(b.values))
@@ -407,39 +415,47 @@ func (b *Builder) BuildInsert() (string, []interface{}) {
 		}
 	}
 
-	// Handle ON DUPLICATE KEY UPDATE for MySQL
-	if len(b.onDuplicateKeyUpdate) > 0 && b.dialect == MySQL {
-		query.WriteString(" ON DUPLICATE KEY UPDATE ")
-
-		// Iterate through the slice to preserve order
-		updates := make([]string, 0, len(b.onDuplicateKeyUpdate))
-		for _, update := range b.onDuplicateKeyUpdate {
-			updates = append(updates, fmt.Sprintf("%s = %s", update.Column, b.placeholder()))
-			args = append(args, update.Value)
-		}
-
-		query.WriteString(strings.Join(updates, ", "))
-	} else if len(b.upsertColumns) > 0 && b.dialect == PostgreSQL {
-		query.WriteString(" ON CONFLICT (")
-		query.WriteString(strings.Join(b.upsertColumns, ", "))
-		query.WriteString(") DO UPDATE SET ")
-		updates := make([]string, 0, len(b.upsertValues))
-
-		// Iterate through the slice to preserve order
-		for _, update := range b.upsertValues {
-			updates = append(updates, fmt.Sprintf("%s = %s", update.Column, b.placeholder()))
-			args = append(args, update.Value)
-		}
-
-		query.WriteString(strings.Join(updates, ", "))
-	}
+	conflict, conflictArgs := b.conflictClause()
+	query.WriteString(conflict)
+	args = append(args, conflictArgs...)
 
 	// **Always Append "RETURNING id" for PostgreSQL Inserts**
 	if b.dialect == PostgreSQL {
 		query.WriteString(" RETURNING id")
 	}
 
-	return query.String(), args
+	return b.render(query.String()), args
+}
+
+// conflictClause renders the ON DUPLICATE KEY UPDATE clause for MySQL or the
+// ON CONFLICT clause for PostgreSQL, if one is set. RawExpr values, such as
+// excluded.name or VALUES(name), are written as is.
+func (b *Builder) conflictClause() (string, []interface{}) {
+	var updates []UpdateClause
+	var clause string
+	switch {
+	case len(b.onDuplicateKeyUpdate) > 0 && b.dialect == MySQL:
+		clause, updates = " ON DUPLICATE KEY UPDATE ", b.onDuplicateKeyUpdate
+	case len(b.upsertColumns) > 0 && b.dialect == PostgreSQL:
+		clause, updates = " ON CONFLICT ("+strings.Join(b.upsertColumns, ", ")+") DO UPDATE SET ", b.upsertValues
+	default:
+		return "", nil
+	}
+
+	// Iterate through the slice to preserve order
+	var args []interface{}
+	sets := make([]string, 0, len(updates))
+	for _, update := range updates {
+		if raw, ok := update.Value.(RawExpr); ok {
+			expr, rawArgs := raw.build(b)
+			sets = append(sets, fmt.Sprintf("%s = %s", update.Column, expr))
+			args = append(args, rawArgs...)
+			continue
+		}
+		sets = append(sets, fmt.Sprintf("%s = %s", update.Column, b.placeholder()))
+		args = append(args, update.Value)
+	}
+	return clause + strings.Join(sets, ", "), args
 }
 
 // BuildUpdate builds an UPDATE query
@@ -455,8 +471,9 @@ func (b *Builder) BuildUpdate() (string, []interface{}) {
 	for _, clause := range b.updateClauses {
 		switch v := clause.Value.(type) {
 		case RawExpr:
-			setClauses = append(setClauses, fmt.Sprintf("%s = %s", clause.Column, v.Expr))
-			args = append(args, v.args...)
+			expr, rawArgs := v.build(b)
+			setClauses = append(setClauses, fmt.Sprintf("%s = %s", clause.Column, expr))
+			args = append(args, rawArgs...)
 		default:
 			setClauses = append(setClauses, fmt.Sprintf("%s = %s", clause.Column, b.placeholder()))
 			args = append(args, v)
@@ -476,7 +493,7 @@ func (b *Builder) BuildUpdate() (string, []interface{}) {
 		query.WriteString(strings.Join(b.returning, ", "))
 	}
 
-	return query.String(), args
+	return b.render(query.String()), args
 }
 
 // BuildDelete builds a DELETE query
@@ -493,71 +510,26 @@ func (b *Builder) BuildDelete() (string, []interface{}) {
 		args = append(args, b.whereArgs...)
 	}
 
-	return query.String(), args
+	return b.render(query.String()), args
 }
 
 // Scan scans the result into the provided struct
 func (b *Builder) Scan(rows *sql.Rows, dest interface{}) error {
-	columns, err := rows.Columns()
-	if err != nil {
-		return err
-	}
-
-	values := make([]interface{}, len(columns))
-	scanArgs := make([]interface{}, len(columns))
-	for i := range values {
-		scanArgs[i] = &values[i]
-	}
-
-	err = rows.Scan(scanArgs...)
-	if err != nil {
-		return err
-	}
-
-	destValue := reflect.ValueOf(dest).Elem()
-	for i, column := range columns {
-		field := destValue.FieldByName(column)
-		if field.IsValid() && field.CanSet() {
-			value := values[i]
-			if value != nil {
-				field.Set(reflect.ValueOf(value).Convert(field.Type()))
-			}
-		}
-	}
-
-	return nil
+	return b.MapToStruct(rows, dest)
 }
 
-// MapToStruct maps a row to a struct
+// MapToStruct maps the current row to the struct dest points to. Columns are
+// matched to fields as described for ScanAll.
 func (b *Builder) MapToStruct(rows *sql.Rows, dest interface{}) error {
-	columns, err := rows.Columns()
-	if err != nil {
-		return err
-	}
-
-	values := make([]interface{}, len(columns))
-	scanArgs := make([]interface{}, len(columns))
-	for i := range values {
-		scanArgs[i] = &values[i]
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("dest must be a non-nil pointer, got %T", dest)
 	}
-
-	err = rows.Scan(scanArgs...)
+	s, err := newRowScanner(rows, v.Elem().Type())
 	if err != nil {
 		return err
 	}
-
-	destValue := reflect.ValueOf(dest).Elem()
-	for i, column := range columns {
-		field := destValue.FieldByName(column)
-		if field.IsValid() && field.CanSet() {
-			value := values[i]
-			if value != nil {
-				field.Set(reflect.ValueOf(value).Convert(field.Type()))
-			}
-		}
-	}
-
-	return nil
+	return s.scan(rows, v.Elem())
 }
 
 // Debug returns a string representation of the query for debugging purposes
@@ -649,7 +621,6 @@ func (b *Builder) Clone() *Builder {
 		unions:               make([]*Builder, len(b.unions)),
 		unionAll:             b.unionAll,
 		debug:                b.debug,
-		paramCount:           b.paramCount,
 		ctes:                 make([]CTE, len(b.ctes)),
 		distinct:             b.distinct,
 		forUpdate:            b.forUpdate,
@@ -662,7 +633,13 @@ func (b *Builder) Clone() *Builder {
 		err:                  b.err,
 		logSQL:               b.logSQL,
 		allowEmptyWhere:      b.allowEmptyWhere,
+		rows:                 make([][]interface{}, len(b.rows)),
+		batchSize:            b.batchSize,
+		hooks:                append([]Hook(nil), b.hooks...),
+		selectArgs:           append([]interface{}(nil), b.selectArgs...),
+		orderArgs:            append([]interface{}(nil), b.orderArgs...),
 	}
+	copy(newBuilder.rows, b.rows)
 	copy(newBuilder.columns, b.columns)
 	copy(newBuilder.values, b.values)
 	copy(newBuilder.joins, b.joins)
@@ -693,15 +670,11 @@ func (b *Builder) FromStruct(s interface{}) *Builder {
 		return b
 	}
 
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		value := v.Field(i)
-
-		if tag := field.Tag.Get("db"); tag != "" && tag != "-" {
-			if !value.IsZero() {
-				b.columns = append(b.columns, tag)
-				b.values = append(b.values, value.Interface())
-			}
+	for _, f := range structFields(t) {
+		value, ok := fieldByIndex(v, f.index, false)
+		if ok && !value.IsZero() {
+			b.columns = append(b.columns, f.column)
+			b.values = append(b.values, value.Interface())
 		}
 	}
 
@@ -715,27 +688,13 @@ func (b *Builder) Exec(db *sql.DB) (sql.Result, error) {
 	}
 
 	query, args := b.Build()
-	if b.logSQL {
-		xlog.Debugf("[SQL] %s %v", query, args)
-	}
-
-	if b.tx != nil {
-		return b.tx.ExecContext(b.ctx, query, args...)
-	}
-	return db.ExecContext(b.ctx, query, args...)
+	return b.execContext(db, query, args)
 }
 
 // QueryRow executes the query and returns a single row
 func (b *Builder) QueryRow(db *sql.DB) *sql.Row {
 	query, args := b.Build()
-	if b.logSQL {
-		xlog.Debugf("[SQL] %s %v", query, args)
-	}
-
-	if b.tx != nil {
-		return b.tx.QueryRowContext(b.ctx, query, args...)
-	}
-	return db.QueryRowContext(b.ctx, query, args...)
+	return b.queryRowContext(db, query, args)
 }
 
 // Query executes the query and returns multiple rows
@@ -745,14 +704,7 @@ func (b *Builder) Query(db *sql.DB) (*sql.Rows, error) {
 	}
 
 	query, args := b.Build()
-	if b.logSQL {
-		xlog.Debugf("[SQL] %s %v", query, args)
-	}
-
-	if b.tx != nil {
-		return b.tx.QueryContext(b.ctx, query, args...)
-	}
-	return db.QueryContext(b.ctx, query, args...)
+	return b.queryContext(db, query, args)
 }
 
 // WithTransaction wraps the builder with a transaction
@@ -787,6 +739,7 @@ func (b *Builder) BuildSQL() string {
 // Count adds a COUNT(*) to the query
 func (b *Builder) Count() *Builder {
 	b.columns = []string{"COUNT(*)"}
+	b.selectArgs = nil
 	return b
 }
 
@@ -860,6 +813,7 @@ func (b *Builder) HavingRaw(raw string, args ...interface{}) *Builder {
 // OrderByRaw adds a raw ORDER BY clause
 func (b *Builder) OrderByRaw(raw string) *Builder {
 	b.orderBy = raw
+	b.orderArgs = nil
 	return b
 }
 
@@ -910,7 +864,7 @@ func (b *Builder) Upsert(conflictColumns []string, updates []UpdateClause) *Buil
 
 // WithRecursive adds a WITH RECURSIVE clause for recursive CTEs
 func (b *Builder) WithRecursive(name string, subquery *Builder) *Builder {
-	subQuerySQL, subQueryArgs := subquery.BuildSelect()
+	subQuerySQL, subQueryArgs := b.bind(subquery.BuildSelect())
 	cte := CTE{
 		Name:  "RECURSIVE " + name,
 		Query: subQuerySQL,
@@ -933,12 +887,21 @@ func (b *Builder) Explain() *Builder {
 	return b
 }
 
-// placeholder returns the dialect-specific placeholder for the given index
+// placeholderMark stands for a placeholder until the statement is built
+const placeholderMark = '\x00'
+
+// placeholder returns a placeholder for the next argument. Placeholders are
+// numbered in order of appearance when the statement is built, whatever order
+// the clauses were added in.
 func (b *Builder) placeholder() string {
-	b.paramCount++
-	switch b.dialect {
+	return string(placeholderMark)
+}
+
+// placeholderAt returns the dialect-specific placeholder for parameter n
+func placeholderAt(dialect Dialect, n int) string {
+	switch dialect {
 	case PostgreSQL:
-		return fmt.Sprintf("$%d", b.paramCount)
+		return fmt.Sprintf("$%d", n)
 	default:
 		return "?"
 	}
@@ -958,13 +921,13 @@ func (b *Builder) MustBuild() string {
 
 // WhereExists adds a WHERE EXISTS subquery
 func (b *Builder) WhereExists(subquery *Builder) *Builder {
-	subQuerySQL, subQueryArgs := subquery.BuildSelect()
+	subQuerySQL, subQueryArgs := b.bind(subquery.BuildSelect())
 	return b.Where("EXISTS ("+subQuerySQL+")", subQueryArgs...)
 }
 
 // WhereNotExists adds a WHERE NOT EXISTS subquery
 func (b *Builder) WhereNotExists(subquery *Builder) *Builder {
-	subQuerySQL, subQueryArgs := subquery.BuildSelect()
+	subQuerySQL, subQueryArgs := b.bind(subquery.BuildSelect())
 	return b.Where("NOT EXISTS ("+subQuerySQL+")", subQueryArgs...)
 }
 
@@ -995,18 +958,10 @@ func (b *Builder) InsertGetId(db *sql.DB) (int64, error) {
 	var err error
 	var id int64
 
-	if b.tx != nil {
-		if b.dialect == PostgreSQL && len(b.values) != 1 {
-			err = b.tx.QueryRow(query, args...).Scan(&id)
-		} else {
-			result, err = b.tx.Exec(query, args...)
-		}
+	if b.dialect == PostgreSQL && len(b.values) != 1 {
+		err = b.queryRowContext(db, query, args).Scan(&id)
 	} else {
-		if b.dialect == PostgreSQL && len(b.values) != 1 {
-			err = db.QueryRow(query, args...).Scan(&id)
-		} else {
-			result, err = db.Exec(query, args...)
-		}
+		result, err = b.execContext(db, query, args)
 	}
 
 	if err != nil {
@@ -1108,14 +1063,7 @@ func (b *Builder) InsertMap(m map[string]interface{}) *Builder {
 func (b *Builder) First(db *sql.DB) (*sql.Row, error) {
 	b.Limit(1)
 	query, args := b.BuildSelect()
-	if b.logSQL {
-		xlog.Debugf("[SQL] %s %v", query, args)
-	}
-
-	if b.tx != nil {
-		return b.tx.QueryRowContext(b.ctx, query, args...), nil
-	}
-	return db.QueryRowContext(b.ctx, query, args...), nil
+	return b.queryRowContext(db, query, args), nil
 }
 
 func (b *Builder) MustExec(db *sql.DB) sql.Result {
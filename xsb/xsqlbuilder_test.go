@@ -98,7 +98,7 @@ func TestComplexQueries(t *testing.T) {
 			})
 
 		query, args := builder.BuildInsert()
-		expectedQuery := "WITH recent_orders_cte AS (SELECT user_id, total_amount FROM recent_orders WHERE order_date > ?) INSERT INTO user_stats (user_id, order_count, total_spent) SELECT user_id, COUNT(*), SUM(total_amount) FROM recent_orders_cte GROUP BY user_id RETURNING id"
+		expectedQuery := "WITH recent_orders_cte AS (SELECT user_id, total_amount FROM recent_orders WHERE order_date > $1) INSERT INTO user_stats (user_id, order_count, total_spent) SELECT user_id, COUNT(*), SUM(total_amount) FROM recent_orders_cte GROUP BY user_id RETURNING id"
 		assert.Equal(t, expectedQuery, query)
 		assert.Equal(t, []interface{}{"2023-01-01"}, args)
 	})
@@ -141,7 +141,7 @@ func TestAdvancedFeatures(t *testing.T) {
 			Where("id = ?", 1)
 
 		query, args := builder.Build()
-		expectedQuery := "WITH RECURSIVE cte AS (SELECT id, name, manager_id FROM employees UNION SELECT e.id, e.name, e.manager_id FROM employees e INNER JOIN cte c ON e.manager_id = c.id) SELECT id, name, manager_id FROM cte WHERE id = ?"
+		expectedQuery := "WITH RECURSIVE cte AS (SELECT id, name, manager_id FROM employees UNION SELECT e.id, e.name, e.manager_id FROM employees e INNER JOIN cte c ON e.manager_id = c.id) SELECT id, name, manager_id FROM cte WHERE id = $1"
 		assert.Equal(t, expectedQuery, query)
 		assert.Equal(t, []interface{}{1}, args)
 	})